@@ -1,6 +1,10 @@
 package automation
 
 import (
+	"fmt"
+	"tg_modem/engine"
+	"tg_modem/engine/at"
+
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/godbus/dbus/v5"
 )
@@ -9,8 +13,16 @@ import (
 type AutomationParams struct {
 	Bot         *tgbotapi.BotAPI
 	AdminChatID int64
-	Conn        *dbus.Conn
-	ModemPath   dbus.ObjectPath
+	// Conn 上所有调制解调器的信号都会被处理
+	Conn *dbus.Conn
+	// Engine 为当前使用的引擎, 用于在多调制解调器时标注通知来源
+	Engine engine.Engine
+	// AT 为可选的 AT 端口, 在没有 D-Bus 连接时用其 URC 代替 D-Bus 信号
+	AT *at.Handler
+	// SmsRetention 为短信推送成功后调制解调器上副本的保留策略
+	SmsRetention SmsRetention
+	// Otp 为验证码识别的配置
+	Otp OtpConfig
 }
 
 // Automation 定义了自动化任务必须实现的接口
@@ -29,3 +41,42 @@ func Register(a Automation) {
 func GetAll() []Automation {
 	return registry
 }
+
+// modemLabel 在有多个调制解调器时返回标注通知来源的一行文字, 否则为空
+func modemLabel(params AutomationParams, path dbus.ObjectPath) string {
+	multi, ok := params.Engine.(engine.MultiModem)
+	if !ok {
+		return ""
+	}
+	modems, err := multi.ListModems()
+	if err != nil || len(modems) < 2 {
+		return ""
+	}
+	for i, m := range modems {
+		if m.Path == string(path) {
+			return fmt.Sprintf("\n*Modem:* %d. `%s` %s", i+1, m.ID, m.Model)
+		}
+	}
+	return ""
+}
+
+// modemEngine 返回只操作 path 对应调制解调器的引擎, 找不到或 path 为空时为 params.Engine
+func modemEngine(params AutomationParams, path dbus.ObjectPath) engine.Engine {
+	multi, ok := params.Engine.(engine.MultiModem)
+	if !ok || path == "" {
+		return params.Engine
+	}
+	modems, err := multi.ListModems()
+	if err != nil {
+		return params.Engine
+	}
+	for _, m := range modems {
+		if m.Path != string(path) {
+			continue
+		}
+		if scoped, err := multi.ForModem(m.ID); err == nil {
+			return scoped
+		}
+	}
+	return params.Engine
+}
@@ -1,8 +1,12 @@
 package automation
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"tg_modem/engine/at"
+	"tg_modem/storage"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/godbus/dbus/v5"
@@ -11,6 +15,9 @@ import (
 const (
 	voiceIface = "org.freedesktop.ModemManager1.Modem.Voice"
 	callIface  = "org.freedesktop.ModemManager1.Call"
+
+	// +CLIP 会随每次 RING 重复上报, 在此时间内只通知一次
+	clipDedupWindow = 30 * time.Second
 )
 
 func init() {
@@ -21,9 +28,12 @@ func init() {
 type CallListener struct{}
 
 // Start 开始监听 D-Bus 上的来电 "CallAdded" 信号
+// 没有 D-Bus 连接时改为监听 AT 端口上的 +CLIP
 func (c *CallListener) Start(params AutomationParams) error {
+	if params.Conn == nil {
+		return c.startAT(params)
+	}
 	err := params.Conn.AddMatchSignal(
-		dbus.WithMatchObjectPath(params.ModemPath),
 		dbus.WithMatchInterface(voiceIface),
 	)
 	if err != nil {
@@ -50,14 +60,14 @@ func (c *CallListener) Start(params AutomationParams) error {
 			}
 
 			log.Printf("检测到新来电: %s", callPath)
-			c.processCall(params, callPath)
+			c.processCall(params, sig.Path, callPath)
 		}
 	}()
 
 	return nil
 }
 
-func (c *CallListener) processCall(params AutomationParams, callPath dbus.ObjectPath) {
+func (c *CallListener) processCall(params AutomationParams, modemPath, callPath dbus.ObjectPath) {
 	callObj := params.Conn.Object(mmService, callPath)
 	numberVar, err := callObj.GetProperty(callIface + ".Number")
 	if err != nil {
@@ -65,12 +75,47 @@ func (c *CallListener) processCall(params AutomationParams, callPath dbus.Object
 		return
 	}
 
-	number := numberVar.Value().(string)
+	c.notify(params, numberVar.Value().(string), modemLabel(params, modemPath))
+}
+
+// startAT 通过 AT 端口的 URC 监听来电
+func (c *CallListener) startAT(params AutomationParams) error {
+	if params.AT == nil {
+		return errors.New("来电监听器需要 D-Bus 连接或 AT 端口")
+	}
+	events, _ := params.AT.Subscribe()
+
+	log.Println("自动化任务：来电监听器已启动 (AT)")
+
+	go func() {
+		var lastNumber string
+		var lastNotified time.Time
+		for ev := range events {
+			clip, ok := ev.(at.CallerIDEvent)
+			if !ok {
+				continue
+			}
+			if clip.Number == lastNumber && time.Since(lastNotified) < clipDedupWindow {
+				continue
+			}
+			lastNumber, lastNotified = clip.Number, time.Now()
+
+			log.Printf("检测到新来电 (AT): %s", clip.Number)
+			c.notify(params, clip.Number, "")
+		}
+	}()
+
+	return nil
+}
+
+// notify 推送来电通知, label 为可选的调制解调器标注
+func (c *CallListener) notify(params AutomationParams, number, label string) {
+	from := storage.Default().DescribeNumber(number)
 	if number == "" {
-		number = "未知号码"
+		from = "`未知号码`"
 	}
 
-	notificationText := fmt.Sprintf("📞 *来电提醒*\n*来自:* `%s`", number)
+	notificationText := "📞 *来电提醒*\n*来自:* " + from + label
 	msg := tgbotapi.NewMessage(params.AdminChatID, notificationText)
 	msg.ParseMode = "Markdown"
 	params.Bot.Send(msg)
@@ -0,0 +1,137 @@
+package automation
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"tg_modem/engine/pdu"
+	"time"
+)
+
+// concatTimeout 为等待长短信其余分段的时间, 超时后按不完整短信推送已收到的部分
+const concatTimeout = 5 * time.Minute
+
+// missingPart 替代长短信中未收到的分段
+const missingPart = "(…)"
+
+// smsPart 为长短信的一个分段
+type smsPart struct {
+	msg *pdu.Message
+	ref string // 调制解调器上的位置, 删除时使用
+}
+
+// pendingSms 为正在等待其余分段的长短信
+type pendingSms struct {
+	total int
+	parts map[int]smsPart
+	timer *time.Timer
+}
+
+// assembledSms 为重组后的长短信
+type assembledSms struct {
+	Sender    string
+	SMSC      string
+	Timestamp time.Time // 第一个收到的分段的时间戳
+	Text      string
+	Refs      []string
+	Received  int
+	Total     int
+}
+
+// Partial 表示有分段在超时前没有收到
+func (a *assembledSms) Partial() bool {
+	return a.Received < a.Total
+}
+
+// concatBuffer 按发件人和参考号缓存长短信的分段, 收齐或超时后交给 flush
+type concatBuffer struct {
+	mu      sync.Mutex
+	pending map[string]*pendingSms
+	timeout time.Duration
+	flush   func(*assembledSms)
+}
+
+func newConcatBuffer(timeout time.Duration, flush func(*assembledSms)) *concatBuffer {
+	return &concatBuffer{pending: make(map[string]*pendingSms), timeout: timeout, flush: flush}
+}
+
+// Add 加入一条收到的短信, 普通短信立即交给 flush
+func (b *concatBuffer) Add(msg *pdu.Message, ref string) {
+	c := msg.Concat
+	if c == nil || c.Total < 2 || c.Seq < 1 || c.Seq > c.Total {
+		b.flush(assemble(map[int]smsPart{1: {msg, ref}}, 1))
+		return
+	}
+
+	key := fmt.Sprintf("%s/%d/%d", msg.Sender, c.Ref, c.Total)
+	b.mu.Lock()
+	p, ok := b.pending[key]
+	if !ok {
+		p = &pendingSms{total: c.Total, parts: make(map[int]smsPart)}
+		p.timer = time.AfterFunc(b.timeout, func() { b.expire(key, p) })
+		b.pending[key] = p
+	}
+	if _, dup := p.parts[c.Seq]; dup {
+		log.Printf("长短信 %s 的第 %d 段重复, 忽略", key, c.Seq)
+	} else {
+		p.parts[c.Seq] = smsPart{msg, ref}
+	}
+	complete := len(p.parts) == p.total
+	if complete {
+		p.timer.Stop()
+		delete(b.pending, key)
+	}
+	b.mu.Unlock()
+
+	if complete {
+		b.flush(assemble(p.parts, p.total))
+	} else {
+		log.Printf("收到长短信 %s 的第 %d/%d 段, 等待其余分段", key, c.Seq, c.Total)
+	}
+}
+
+// expire 在超时后交出不完整的长短信
+func (b *concatBuffer) expire(key string, p *pendingSms) {
+	b.mu.Lock()
+	if b.pending[key] != p {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.pending, key)
+	b.mu.Unlock()
+
+	log.Printf("长短信 %s 等待超时, 只收到 %d/%d 段", key, len(p.parts), p.total)
+	b.flush(assemble(p.parts, p.total))
+}
+
+// assemble 按序号拼接分段, 缺失的分段以 missingPart 占位
+func assemble(parts map[int]smsPart, total int) *assembledSms {
+	seqs := make([]int, 0, len(parts))
+	for seq := range parts {
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+
+	first := parts[seqs[0]].msg
+	a := &assembledSms{
+		Sender:    first.Sender,
+		SMSC:      first.SMSC,
+		Timestamp: first.Timestamp,
+		Received:  len(parts),
+		Total:     total,
+	}
+	var text strings.Builder
+	for seq := 1; seq <= total; seq++ {
+		part, ok := parts[seq]
+		if !ok {
+			text.WriteString(missingPart)
+			continue
+		}
+		text.WriteString(part.msg.Text)
+		a.Refs = append(a.Refs, part.ref)
+	}
+	a.Text = text.String()
+	return a
+}
@@ -0,0 +1,128 @@
+package automation
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"tg_modem/engine/pdu"
+)
+
+// part returns one part of a concatenated message from +8613800138000.
+func part(ref, total, seq int, text string) *pdu.Message {
+	return &pdu.Message{
+		Sender:    "+8613800138000",
+		Text:      text,
+		Timestamp: time.Date(2024, 9, 2, 10, 0, seq, 0, time.UTC),
+		Concat:    &pdu.Concat{Ref: ref, Total: total, Seq: seq},
+	}
+}
+
+// newTestBuffer returns a buffer with a short timeout and the channel it
+// flushes to.
+func newTestBuffer() (*concatBuffer, chan *assembledSms) {
+	flushed := make(chan *assembledSms, 10)
+	return newConcatBuffer(100*time.Millisecond, func(a *assembledSms) { flushed <- a }), flushed
+}
+
+// nextAssembled waits for the next flushed message.
+func nextAssembled(t *testing.T, flushed <-chan *assembledSms) *assembledSms {
+	t.Helper()
+	select {
+	case a := <-flushed:
+		return a
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing flushed")
+		return nil
+	}
+}
+
+// expectNoFlush fails if a message has been flushed.
+func expectNoFlush(t *testing.T, flushed <-chan *assembledSms) {
+	t.Helper()
+	select {
+	case a := <-flushed:
+		t.Fatalf("unexpected flush %+v", a)
+	default:
+	}
+}
+
+func TestConcatBufferOutOfOrder(t *testing.T) {
+	b, flushed := newTestBuffer()
+	b.Add(part(7, 3, 3, "three"), "3")
+	b.Add(part(7, 3, 1, "one "), "1")
+	expectNoFlush(t, flushed)
+	// The same reference from another sender is another message.
+	other := part(7, 3, 2, "other")
+	other.Sender = "10086"
+	b.Add(other, "x")
+	expectNoFlush(t, flushed)
+	b.Add(part(7, 3, 2, "two "), "2")
+
+	a := nextAssembled(t, flushed)
+	if a.Text != "one two three" || a.Partial() {
+		t.Errorf("assembled %q, partial %v", a.Text, a.Partial())
+	}
+	if want := []string{"1", "2", "3"}; !slices.Equal(a.Refs, want) {
+		t.Errorf("refs = %v, want %v", a.Refs, want)
+	}
+	if a.Sender != "+8613800138000" || !a.Timestamp.Equal(part(7, 3, 1, "").Timestamp) {
+		t.Errorf("sender %q, timestamp %v, want those of the first part", a.Sender, a.Timestamp)
+	}
+}
+
+func TestConcatBufferDuplicatePart(t *testing.T) {
+	b, flushed := newTestBuffer()
+	b.Add(part(9, 2, 1, "hello "), "1")
+	b.Add(part(9, 2, 1, "hello "), "1b")
+	expectNoFlush(t, flushed)
+	b.Add(part(9, 2, 2, "world"), "2")
+
+	a := nextAssembled(t, flushed)
+	if a.Text != "hello world" || a.Received != 2 || a.Total != 2 {
+		t.Errorf("assembled %q, %d/%d parts", a.Text, a.Received, a.Total)
+	}
+	if want := []string{"1", "2"}; !slices.Equal(a.Refs, want) {
+		t.Errorf("refs = %v, want %v", a.Refs, want)
+	}
+	// The late copy of the first part starts a new message, not a second flush.
+	b.Add(part(9, 2, 1, "hello "), "1c")
+	expectNoFlush(t, flushed)
+}
+
+func TestConcatBufferTimeout(t *testing.T) {
+	b, flushed := newTestBuffer()
+	b.Add(part(1, 4, 1, "one "), "1")
+	b.Add(part(1, 4, 3, " three"), "3")
+
+	a := nextAssembled(t, flushed)
+	if !a.Partial() || a.Received != 2 || a.Total != 4 {
+		t.Errorf("partial %v, %d/%d parts", a.Partial(), a.Received, a.Total)
+	}
+	if want := "one " + missingPart + " three" + missingPart; a.Text != want {
+		t.Errorf("assembled %q, want %q", a.Text, want)
+	}
+	if want := []string{"1", "3"}; !slices.Equal(a.Refs, want) {
+		t.Errorf("refs = %v, want %v", a.Refs, want)
+	}
+
+	// A part arriving after the timeout is not merged into the flushed message.
+	b.Add(part(1, 4, 2, "two"), "2")
+	if a := nextAssembled(t, flushed); a.Received != 1 || a.Text != missingPart+"two"+missingPart+missingPart {
+		t.Errorf("late part assembled %q, %d parts", a.Text, a.Received)
+	}
+}
+
+func TestConcatBufferSinglePart(t *testing.T) {
+	b, flushed := newTestBuffer()
+	b.Add(&pdu.Message{Sender: "10086", Text: "hi"}, "5")
+	// Invalid concatenation headers are treated as single messages.
+	b.Add(part(2, 3, 4, "bad"), "6")
+	b.Add(part(2, 1, 1, "one"), "7")
+	for _, want := range []string{"hi", "bad", "one"} {
+		a := nextAssembled(t, flushed)
+		if a.Text != want || a.Partial() {
+			t.Errorf("assembled %q, partial %v, want %q", a.Text, a.Partial(), want)
+		}
+	}
+}
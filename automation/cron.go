@@ -0,0 +1,157 @@
+package automation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSearchLimit 为查找下一次执行时间的范围, 超出时认为表达式永远不会匹配 (如 2 月 30 日)
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+// Cron 为标准的 5 段 cron 表达式: 分 时 日 月 周, 按本地时间匹配
+type Cron struct {
+	minute, hour, dom, month, dow uint64 // 每一位表示一个允许的值
+	// domAny 和 dowAny 表示日或周为 "*"; 两者都有限制时满足其一即可
+	domAny, dowAny bool
+}
+
+// cronField 为 cron 表达式一段的取值范围
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"分钟", 0, 59},
+	{"小时", 0, 23},
+	{"日期", 1, 31},
+	{"月份", 1, 12},
+	{"星期", 0, 7}, // 0 和 7 都表示周日
+}
+
+// ParseCron 解析 cron 表达式, 每段支持 "*"、数值、范围 "1-5"、列表 "1,3,5" 和步长 "*/15"
+func ParseCron(spec string) (*Cron, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron 表达式应为 5 段 (分 时 日 月 周), 实际为 %d 段", len(fields))
+	}
+	var bits [5]uint64
+	for i, field := range fields {
+		b, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, err
+		}
+		bits[i] = b
+	}
+	// 7 与 0 同为周日
+	if bits[4]&(1<<7) != 0 {
+		bits[4] = bits[4]&^(1<<7) | 1
+	}
+	return &Cron{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, f cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("%s的步长 %q 无效", f.name, stepPart)
+			}
+			step = n
+		}
+
+		lo, hi := f.min, f.max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			a, b, _ := strings.Cut(rangePart, "-")
+			var errA, errB error
+			lo, errA = strconv.Atoi(a)
+			hi, errB = strconv.Atoi(b)
+			if errA != nil || errB != nil || lo > hi {
+				return 0, fmt.Errorf("%s的范围 %q 无效", f.name, rangePart)
+			}
+		default:
+			n, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("%s %q 无效", f.name, rangePart)
+			}
+			lo = n
+			if !hasStep {
+				hi = n
+			}
+		}
+		if lo < f.min || hi > f.max {
+			return 0, fmt.Errorf("%s %q 超出范围 %d-%d", f.name, part, f.min, f.max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// Next 返回 t 之后第一个匹配的时间 (精确到分钟), 永远不会匹配时返回零值
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronSearchLimit)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = cronAdvance(t, t.Year(), t.Month()+1, 1, 0)
+			continue
+		}
+		if !c.matchDay(t) {
+			t = cronAdvance(t, t.Year(), t.Month(), t.Day()+1, 0)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = cronAdvance(t, t.Year(), t.Month(), t.Day(), t.Hour()+1)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// cronAdvance 返回 t 所在时区中指定的整点. 夏令时跳过的整点会被 time.Date
+// 归一化到跳变之前, 可能不晚于 t, 此时顺延一小时以免 Next 原地打转
+func cronAdvance(t time.Time, year int, month time.Month, day, hour int) time.Time {
+	next := time.Date(year, month, day, hour, 0, 0, 0, t.Location())
+	if !next.After(t) {
+		next = next.Add(time.Hour)
+	}
+	return next
+}
+
+// matchDay 按 cron 的惯例匹配日期: 日和周都有限制时满足其一即可
+func (c *Cron) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
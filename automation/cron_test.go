@@ -0,0 +1,128 @@
+package automation
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	tests := []struct {
+		spec string
+		from string
+		want string // empty if the expression never matches
+	}{
+		{"* * * * *", "2024-09-02 10:07", "2024-09-02 10:08"},
+		{"*/15 * * * *", "2024-09-02 10:07", "2024-09-02 10:15"},
+		{"*/15 * * * *", "2024-09-02 10:45", "2024-09-02 11:00"},
+		{"5/15 * * * *", "2024-09-02 10:21", "2024-09-02 10:35"},
+		{"5/15 * * * *", "2024-09-02 10:50", "2024-09-02 11:05"},
+		{"0,30 * * * *", "2024-09-02 10:00", "2024-09-02 10:30"},
+		{"0 9-17/4 * * *", "2024-09-02 10:00", "2024-09-02 13:00"},
+		{"0 9-17/4 * * *", "2024-09-02 17:00", "2024-09-03 09:00"},
+		{"30 8 * * *", "2024-12-31 09:00", "2025-01-01 08:30"},
+		{"0 0 1 */3 *", "2024-02-15 00:00", "2024-04-01 00:00"},
+		// Weekdays only; 2024-09-07 is a Saturday.
+		{"0 9 * * 1-5", "2024-09-07 10:00", "2024-09-09 09:00"},
+		// 0 and 7 are both Sunday.
+		{"0 0 * * 7", "2024-09-02 00:00", "2024-09-08 00:00"},
+		{"0 0 * * 0", "2024-09-02 00:00", "2024-09-08 00:00"},
+		{"0 0 * * 6-7", "2024-09-08 00:00", "2024-09-14 00:00"},
+		// With both day of month and day of week restricted either matches:
+		// the 10th is a Tuesday, the 13th a Friday.
+		{"0 0 10 * 5", "2024-09-06 00:00", "2024-09-10 00:00"},
+		{"0 0 10 * 5", "2024-09-10 00:00", "2024-09-13 00:00"},
+		{"0 0 10 * 5", "2024-09-13 00:00", "2024-09-20 00:00"},
+		// Day of month alone is not widened by the "*" day of week.
+		{"0 0 31 * *", "2024-09-01 00:00", "2024-10-31 00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+		{"0 0 30 2 *", "2024-01-01 00:00", ""},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.spec)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", tt.spec, err)
+			continue
+		}
+		got := c.Next(at(tt.from))
+		if tt.want == "" {
+			if !got.IsZero() {
+				t.Errorf("%q after %s = %v, want never", tt.spec, tt.from, got)
+			}
+			continue
+		}
+		if want := at(tt.want); !got.Equal(want) {
+			t.Errorf("%q after %s = %v, want %v", tt.spec, tt.from, got, want)
+		}
+	}
+}
+
+func TestCronNextAcrossDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	// On 2024-03-10 clocks jump from 02:00 EST to 03:00 EDT.
+	from := time.Date(2024, 3, 10, 1, 0, 0, 0, loc)
+
+	hourly, _ := ParseCron("0 * * * *")
+	if got, want := hourly.Next(from), time.Date(2024, 3, 10, 3, 0, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("hourly after %v = %v, want %v", from, got, want)
+	}
+	if got := hourly.Next(from).Sub(from); got != time.Hour {
+		t.Errorf("hourly runs %v apart across the gap, want 1h", got)
+	}
+
+	// 02:30 does not exist that day, so the job next runs a day later.
+	skipped, _ := ParseCron("30 2 * * *")
+	if got, want := skipped.Next(from), time.Date(2024, 3, 11, 2, 30, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("02:30 daily after %v = %v, want %v", from, got, want)
+	}
+
+	// On 2024-09-08 Santiago skips midnight itself.
+	santiago, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Skip(err)
+	}
+	from = time.Date(2024, 9, 7, 12, 0, 0, 0, santiago)
+	daily, _ := ParseCron("0 0 * * *")
+	if got, want := daily.Next(from), time.Date(2024, 9, 9, 0, 0, 0, 0, santiago); !got.Equal(want) {
+		t.Errorf("midnight daily after %v = %v, want %v", from, got, want)
+	}
+	morning, _ := ParseCron("30 1 * * *")
+	if got, want := morning.Next(from), time.Date(2024, 9, 8, 1, 30, 0, 0, santiago); !got.Equal(want) {
+		t.Errorf("01:30 daily after %v = %v, want %v", from, got, want)
+	}
+}
+
+func TestParseCronRejects(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"-1 * * * *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1-x * * * *",
+		"50-60 * * * *",
+	} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q) succeeded", spec)
+		}
+	}
+}
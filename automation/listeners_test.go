@@ -0,0 +1,274 @@
+package automation
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tg_modem/engine/at"
+	"tg_modem/engine/at/atsim"
+	"tg_modem/engine/dbus_mbim/fakemm"
+	"tg_modem/storage"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/godbus/dbus/v5"
+)
+
+// fakeTelegram is a stub Bot API server recording every sendMessage.
+type fakeTelegram struct {
+	mu     sync.Mutex
+	sent   chan string
+	lastID int
+}
+
+// newFakeTelegram starts a stub Bot API server and returns a bot talking to it.
+func newFakeTelegram(t *testing.T) (*fakeTelegram, *tgbotapi.BotAPI) {
+	t.Helper()
+	tg := &fakeTelegram{sent: make(chan string, 10)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/getMe"):
+			fmt.Fprint(w, `{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"bot","username":"bot"}}`)
+		case strings.HasSuffix(r.URL.Path, "/sendMessage"):
+			r.ParseForm()
+			tg.mu.Lock()
+			tg.lastID++
+			id := tg.lastID
+			tg.mu.Unlock()
+			tg.sent <- r.PostForm.Get("text")
+			fmt.Fprintf(w, `{"ok":true,"result":{"message_id":%d,"chat":{"id":%s}}}`, id, r.PostForm.Get("chat_id"))
+		default:
+			fmt.Fprint(w, `{"ok":true,"result":true}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("T", srv.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tg, bot
+}
+
+// next waits for the next message sent to Telegram.
+func (tg *fakeTelegram) next(t *testing.T) string {
+	t.Helper()
+	select {
+	case text := <-tg.sent:
+		return text
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message sent to Telegram")
+		return ""
+	}
+}
+
+// expectNone fails if a message is sent to Telegram within a short while.
+func (tg *fakeTelegram) expectNone(t *testing.T) {
+	t.Helper()
+	select {
+	case text := <-tg.sent:
+		t.Fatalf("unexpected message sent to Telegram: %q", text)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// newFakeModem starts a fake ModemManager with one modem and returns
+// automation params connected to it. It skips the test when dbus-daemon is
+// missing.
+func newFakeModem(t *testing.T) (*fakemm.Modem, *fakeTelegram, AutomationParams) {
+	t.Helper()
+	bus, err := fakemm.StartBus()
+	if errors.Is(err, fakemm.ErrNoDaemon) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bus.Close() })
+
+	mm, err := fakemm.Start(bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mm.Close() })
+	modem := mm.AddModem(fakemm.ModemConfig{Model: "FM350-GL"})
+
+	conn, err := bus.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	tg, bot := newFakeTelegram(t)
+	return modem, tg, AutomationParams{Bot: bot, AdminChatID: 42, Conn: conn}
+}
+
+// waitMessages waits until the modem holds n messages.
+func waitMessages(t *testing.T, modem *fakemm.Modem, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(modem.Messages()) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("modem holds %d messages, want %d", len(modem.Messages()), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSmsListenerNotifiesOnAdded(t *testing.T) {
+	modem, tg, params := newFakeModem(t)
+	s := &SmsListener{}
+	if err := s.Start(params); err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages created locally are announced with received=false.
+	var out dbus.ObjectPath
+	err := params.Conn.Object(mmService, modem.Path).Call(messagingIface+".Create", 0, map[string]dbus.Variant{
+		"Number": dbus.MakeVariant("10086"),
+		"Text":   dbus.MakeVariant("CXLL"),
+	}).Store(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modem.AddSms("+8613800138000", "晚上一起吃饭")
+
+	text := tg.next(t)
+	if !strings.Contains(text, "新短信") || !strings.Contains(text, "+8613800138000") || !strings.Contains(text, "晚上一起吃饭") {
+		t.Errorf("notification = %q", text)
+	}
+	tg.expectNone(t)
+
+	// The default retention deletes the delivered message from the modem.
+	waitMessages(t, modem, 1)
+	if got := modem.Messages(); got[0] != out {
+		t.Errorf("modem kept %v, want the sent message %s", got, out)
+	}
+}
+
+func TestSmsListenerKeepRetention(t *testing.T) {
+	modem, tg, params := newFakeModem(t)
+	params.SmsRetention = SmsRetention{Keep: true}
+	s := &SmsListener{}
+	if err := s.Start(params); err != nil {
+		t.Fatal(err)
+	}
+
+	modem.AddSms("10010", "话费余额 12.34 元")
+	if text := tg.next(t); !strings.Contains(text, "12.34") {
+		t.Errorf("notification = %q", text)
+	}
+	tg.expectNone(t)
+	if n := len(modem.Messages()); n != 1 {
+		t.Errorf("modem holds %d messages, want 1", n)
+	}
+}
+
+func TestSmsListenerRearm(t *testing.T) {
+	modem, tg, params := newFakeModem(t)
+	// Received while nobody was listening, e.g. while the modem was gone.
+	modem.AddSmsAt("+8613800138000", "错过的短信", time.Now().Add(-time.Hour))
+	var out dbus.ObjectPath
+	err := params.Conn.Object(mmService, modem.Path).Call(messagingIface+".Create", 0, map[string]dbus.Variant{
+		"Number": dbus.MakeVariant("10086"),
+		"Text":   dbus.MakeVariant("CXLL"),
+	}).Store(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &SmsListener{}
+	if err := s.Rearm(params, modem.Path); err != nil {
+		t.Fatal(err)
+	}
+	if text := tg.next(t); !strings.Contains(text, "错过的短信") {
+		t.Errorf("notification = %q", text)
+	}
+	tg.expectNone(t)
+	waitMessages(t, modem, 1)
+}
+
+func TestCallListenerNotifiesOnCallAdded(t *testing.T) {
+	modem, tg, params := newFakeModem(t)
+	c := &CallListener{}
+	if err := c.Start(params); err != nil {
+		t.Fatal(err)
+	}
+
+	modem.AddCall("+8613800138000")
+	text := tg.next(t)
+	if !strings.Contains(text, "来电提醒") || !strings.Contains(text, "+8613800138000") {
+		t.Errorf("notification = %q", text)
+	}
+
+	modem.AddCall("")
+	if text := tg.next(t); !strings.Contains(text, "未知号码") {
+		t.Errorf("notification for a hidden number = %q", text)
+	}
+}
+
+func TestCallListenerShowsContactName(t *testing.T) {
+	modem, tg, params := newFakeModem(t)
+	store, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	storage.SetDefault(store)
+	defer storage.SetDefault(nil)
+	if err := store.SetContacts([]storage.Contact{{Name: "张三", Number: "+8613800138000"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &CallListener{}
+	if err := c.Start(params); err != nil {
+		t.Fatal(err)
+	}
+	modem.AddCall("+8613800138000")
+	if text := tg.next(t); !strings.Contains(text, "张三 `+8613800138000`") {
+		t.Errorf("notification = %q, want the contact name", text)
+	}
+}
+
+// howAreYou is an SMS-DELIVER from +31641600986 reading "How are you?".
+const howAreYou = "07911326040000F0040B911346610089F60000208062917314080CC8F71D14969741F977FD07"
+
+func TestSmsListenerReplaysStoredATMessages(t *testing.T) {
+	sim, err := atsim.New()
+	if err != nil {
+		t.Skipf("atsim unavailable: %v", err)
+	}
+	h := at.NewHandler(sim.Port())
+	t.Cleanup(func() {
+		h.Close()
+		sim.Close()
+	})
+	// Received while the bot was not running, so no +CMTI will follow.
+	sim.StoreSms(howAreYou)
+
+	tg, bot := newFakeTelegram(t)
+	s := &SmsListener{}
+	if err := s.Start(AutomationParams{Bot: bot, AdminChatID: 42, AT: h}); err != nil {
+		t.Fatal(err)
+	}
+
+	text := tg.next(t)
+	if !strings.Contains(text, "+31641600986") || !strings.Contains(text, "How are you?") {
+		t.Errorf("notification = %q", text)
+	}
+	tg.expectNone(t)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sim.StoredSms()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("modem still holds messages %v, want the delivered one deleted", sim.StoredSms())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
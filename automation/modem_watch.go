@@ -0,0 +1,73 @@
+package automation
+
+import (
+	"fmt"
+	"log"
+	"tg_modem/engine"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/godbus/dbus/v5"
+)
+
+func init() {
+	Register(&ModemWatch{})
+}
+
+// Rearmer 为调制解调器重新出现后需要重新初始化的自动化任务
+type Rearmer interface {
+	Rearm(params AutomationParams, modemPath dbus.ObjectPath) error
+}
+
+// ModemWatch 在调制解调器断开、重新接入或 ModemManager 重启时通知管理员,
+// 并让其他自动化任务重新初始化
+type ModemWatch struct{}
+
+// Start 开始监听引擎上报的调制解调器事件, 引擎不支持时不做任何事
+func (w *ModemWatch) Start(params AutomationParams) error {
+	watcher, ok := params.Engine.(engine.ModemWatcher)
+	if !ok {
+		return nil
+	}
+	events, err := watcher.WatchModems()
+	if err != nil {
+		return fmt.Errorf("无法监听调制解调器热插拔: %w", err)
+	}
+
+	log.Println("自动化任务：调制解调器热插拔监听已启动")
+
+	go func() {
+		for ev := range events {
+			var text string
+			switch ev.Type {
+			case engine.ModemRemoved:
+				text = fmt.Sprintf("⚠️ *调制解调器已断开*\n`%s` %s", ev.Modem.ID, ev.Modem.Model)
+			case engine.ModemAdded:
+				text = fmt.Sprintf("✅ *调制解调器已接入*\n`%s` %s", ev.Modem.ID, ev.Modem.Model)
+				w.rearm(params, dbus.ObjectPath(ev.Modem.Path))
+			case engine.ManagerStopped:
+				text = "⚠️ *ModemManager 已停止*\n等待服务恢复..."
+			case engine.ManagerStarted:
+				text = "✅ *ModemManager 已重新启动*"
+			default:
+				continue
+			}
+			msg := tgbotapi.NewMessage(params.AdminChatID, text)
+			msg.ParseMode = "Markdown"
+			params.Bot.Send(msg)
+		}
+	}()
+
+	return nil
+}
+
+func (w *ModemWatch) rearm(params AutomationParams, modemPath dbus.ObjectPath) {
+	for _, task := range GetAll() {
+		r, ok := task.(Rearmer)
+		if !ok {
+			continue
+		}
+		if err := r.Rearm(params, modemPath); err != nil {
+			log.Printf("重新初始化自动化任务失败 (%s): %v", modemPath, err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package automation
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"tg_modem/engine/dbus_mbim"
+	"tg_modem/engine/dbus_mbim/fakemm"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// rearmRecorder records the modems the registered tasks are rearmed for.
+type rearmRecorder struct {
+	paths chan dbus.ObjectPath
+}
+
+func (r *rearmRecorder) Start(AutomationParams) error { return nil }
+
+func (r *rearmRecorder) Rearm(_ AutomationParams, modemPath dbus.ObjectPath) error {
+	r.paths <- modemPath
+	return nil
+}
+
+var rearmed = &rearmRecorder{paths: make(chan dbus.ObjectPath, 10)}
+
+func init() {
+	Register(rearmed)
+}
+
+func TestModemWatch(t *testing.T) {
+	bus, err := fakemm.StartBus()
+	if errors.Is(err, fakemm.ErrNoDaemon) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+	mm, err := fakemm.Start(bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modem := mm.AddModem(fakemm.ModemConfig{Model: "FM350-GL", EquipmentIdentifier: "350000000000001"})
+
+	conn, err := bus.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	eng := &dbus_mbim.DBusMBIMEngine{Conn: conn}
+	if err := eng.Init(); err != nil {
+		t.Fatal(err)
+	}
+	tg, bot := newFakeTelegram(t)
+	w := &ModemWatch{}
+	if err := w.Start(AutomationParams{Bot: bot, AdminChatID: 42, Conn: conn, Engine: eng}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unplugged and plugged in again: the tasks are rearmed for the new path.
+	mm.RemoveModem(modem)
+	if text := tg.next(t); !strings.Contains(text, "调制解调器已断开") || !strings.Contains(text, "350000000000001") {
+		t.Errorf("notification = %q", text)
+	}
+	modem = mm.AddModem(fakemm.ModemConfig{Model: "FM350-GL", EquipmentIdentifier: "350000000000001"})
+	if text := tg.next(t); !strings.Contains(text, "调制解调器已接入") || !strings.Contains(text, "FM350-GL") {
+		t.Errorf("notification = %q", text)
+	}
+	select {
+	case path := <-rearmed.paths:
+		if path != modem.Path {
+			t.Errorf("rearmed for %s, want %s", path, modem.Path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tasks not rearmed")
+	}
+
+	// ModemManager restarts: its modems are rescanned and the tasks rearmed.
+	mm.Close()
+	if text := tg.next(t); !strings.Contains(text, "ModemManager 已停止") {
+		t.Errorf("notification = %q", text)
+	}
+	mm, err = fakemm.Start(bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mm.Close()
+	modem = mm.AddModem(fakemm.ModemConfig{Model: "FM350-GL"})
+	if text := tg.next(t); !strings.Contains(text, "ModemManager 已重新启动") {
+		t.Errorf("notification = %q", text)
+	}
+	if text := tg.next(t); !strings.Contains(text, "调制解调器已接入") {
+		t.Errorf("notification = %q", text)
+	}
+	select {
+	case path := <-rearmed.paths:
+		if path != modem.Path {
+			t.Errorf("rearmed for %s, want %s", path, modem.Path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tasks not rearmed after the restart")
+	}
+	tg.expectNone(t)
+	if got := eng.GetModemPath(); got != modem.Path {
+		t.Errorf("engine uses %s, want %s", got, modem.Path)
+	}
+}
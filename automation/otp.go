@@ -0,0 +1,88 @@
+package automation
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// OtpConfig 为验证码识别的配置
+type OtpConfig struct {
+	// Patterns 为额外的识别规则, 优先于内置规则。第一个捕获组为验证码, 没有捕获组时为整个匹配。
+	Patterns []*regexp.Regexp
+	// TTL 为推送后删除含验证码的通知的时间, 为 0 时不删除
+	TTL time.Duration
+}
+
+// builtinOtpPatterns 识别常见的中英文验证码短信
+var builtinOtpPatterns = []*regexp.Regexp{
+	// 验证码：123456 / 验证码为 123456 / 动态密码是 1234
+	regexp.MustCompile(`(?:验证码|校验码|动态码|动态密码|确认码|激活码|安全码|认证码|登录码)[^0-9A-Za-z]{0,12}?([0-9]{4,8})(?:[^0-9]|$)`),
+	// 123456 是您的验证码 / 123456（登录验证码）
+	regexp.MustCompile(`(?:^|[^0-9])([0-9]{4,8})[^0-9A-Za-z]{0,8}(?:验证码|校验码|动态码|动态密码)`),
+	// G-123456 is your Google verification code
+	regexp.MustCompile(`\bG-([0-9]{6})\b`),
+	// Your code is 123456 / verification code: 1234 / OTP 123456
+	regexp.MustCompile(`(?i)\b(?:code|otp|passcode|pin)\b[^0-9A-Za-z]{0,3}(?:is\b)?[^0-9A-Za-z]{0,3}([0-9]{4,8})\b`),
+	// 123456 is your verification code
+	regexp.MustCompile(`(?i)\b([0-9]{4,8}) is your\b`),
+}
+
+// ParseOtpPatterns 解析额外的验证码识别规则, 每行一个正则表达式
+func ParseOtpPatterns(s string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		re, err := regexp.Compile(line)
+		if err != nil {
+			return nil, fmt.Errorf("无效的验证码识别规则 %q: %w", line, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// findOtp 返回短信中的验证码, 没有时为空
+func findOtp(cfg OtpConfig, text string) string {
+	for _, patterns := range [][]*regexp.Regexp{cfg.Patterns, builtinOtpPatterns} {
+		for _, re := range patterns {
+			m := re.FindStringSubmatch(text)
+			switch {
+			case m == nil:
+			case len(m) > 1 && m[1] != "":
+				return m[1]
+			case m[0] != "":
+				return strings.TrimSpace(m[0])
+			}
+		}
+	}
+	return ""
+}
+
+// withOtp 在通知开头以等宽字体单独显示验证码, 点击即可复制
+func withOtp(code, notification string) string {
+	if code == "" {
+		return notification
+	}
+	return "🔑 *验证码:* `" + strings.ReplaceAll(code, "`", "'") + "`\n\n" + notification
+}
+
+// expireOtpNotification 在 params.Otp.TTL 后删除推送的验证码通知。等待删除的通知不持久化,
+// 机器人重启后不会再删除。
+func expireOtpNotification(params AutomationParams, chatID int64, messageID int) {
+	if params.Otp.TTL <= 0 {
+		return
+	}
+	time.AfterFunc(params.Otp.TTL, func() {
+		if _, err := params.Bot.Request(tgbotapi.NewDeleteMessage(chatID, messageID)); err != nil {
+			log.Printf("删除验证码通知 %d 失败: %v", messageID, err)
+		}
+	})
+}
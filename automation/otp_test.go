@@ -0,0 +1,85 @@
+package automation
+
+import "testing"
+
+func TestFindOtp(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		// Chinese.
+		{"【某银行】您的验证码：123456，5分钟内有效。", "123456"},
+		{"您的验证码为 8848，请勿泄露。", "8848"},
+		{"【饿了么】您的动态密码是 654321", "654321"},
+		{"123456 是您的登录验证码，请勿告诉他人。", "123456"},
+		{"【淘宝】987654（登录验证码），10分钟内有效。", "987654"},
+		{"校验码 20240901，切勿转发。", "20240901"},
+		// English.
+		{"G-482913 is your Google verification code.", "482913"},
+		{"Your code is 4821", "4821"},
+		{"Your verification code: 739201. Don't share it.", "739201"},
+		{"Use OTP 55667 to sign in", "55667"},
+		{"PIN: 9876", "9876"},
+		{"582104 is your Instagram code.", "582104"},
+		// Not verification codes.
+		{"", ""},
+		{"您尾号1234的储蓄卡消费人民币 12000.00 元，余额 56789.12 元。", ""},
+		{"您本月已使用流量 2048MB，剩余 1024MB。", ""},
+		{"请拨打客服电话 95588 或 13800138000 咨询。", ""},
+		{"您预约的 2024-09-01 14:30 的门诊已确认。", ""},
+		{"Your order #123456 has shipped.", ""},
+		{"Call 13800138000 for details.", ""},
+		{"Meeting moved to 2024-09-01.", ""},
+		{"Your code will be sent shortly.", ""},
+		{"验证码 12 位数无效", ""},
+		{"验证码 123456789", ""},
+	}
+	for _, tt := range tests {
+		if got := findOtp(OtpConfig{}, tt.text); got != tt.want {
+			t.Errorf("findOtp(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestFindOtpCustomPatterns(t *testing.T) {
+	patterns, err := ParseOtpPatterns(`
+		口令\s*([A-Z0-9]{6})
+		ABC-\d{4}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := OtpConfig{Patterns: patterns}
+	tests := []struct {
+		text string
+		want string
+	}{
+		// The first capture group.
+		{"登录口令 X7K9Q2", "X7K9Q2"},
+		// The whole match without a capture group.
+		{"Reference ABC-1234", "ABC-1234"},
+		// Custom patterns take precedence over the built-in ones.
+		{"口令 A1B2C3, 验证码 123456", "A1B2C3"},
+		// The built-in patterns still apply.
+		{"验证码 123456", "123456"},
+		{"你好", ""},
+	}
+	for _, tt := range tests {
+		if got := findOtp(cfg, tt.text); got != tt.want {
+			t.Errorf("findOtp(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	if _, err := ParseOtpPatterns("验证码(\\d+"); err == nil {
+		t.Error("invalid pattern accepted")
+	}
+}
+
+func TestWithOtp(t *testing.T) {
+	if got := withOtp("", "短信"); got != "短信" {
+		t.Errorf("withOtp without code = %q", got)
+	}
+	if got, want := withOtp("12`34", "短信"), "🔑 *验证码:* `12'34`\n\n短信"; got != want {
+		t.Errorf("withOtp = %q, want %q", got, want)
+	}
+}
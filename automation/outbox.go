@@ -0,0 +1,380 @@
+package automation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"tg_modem/storage"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	outboxPollInterval = 5 * time.Second
+	outboxMinBackoff   = 5 * time.Second
+	outboxMaxBackoff   = 10 * time.Minute
+	// maxDeleteAttempts 次删除失败后放弃删除调制解调器上的短信
+	maxDeleteAttempts = 5
+)
+
+func init() {
+	Register(&Outbox{})
+}
+
+// SmsRetention 为短信推送成功后调制解调器上副本的保留策略
+type SmsRetention struct {
+	Keep bool          // 永久保留
+	For  time.Duration // 保留一段时间后删除, 为 0 时立即删除
+}
+
+// ParseSmsRetention 解析保留策略: "delete" (默认, 立即删除)、"keep" 或保留天数如 "7d"
+func ParseSmsRetention(s string) (SmsRetention, error) {
+	switch s {
+	case "", "delete":
+		return SmsRetention{}, nil
+	case "keep":
+		return SmsRetention{Keep: true}, nil
+	}
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		if days, err := strconv.Atoi(n); err == nil && days > 0 {
+			return SmsRetention{For: time.Duration(days) * 24 * time.Hour}, nil
+		}
+	}
+	return SmsRetention{}, fmt.Errorf("无效的短信保留策略 %q, 可选 delete、keep 或天数 (如 7d)", s)
+}
+
+// outboxWake 在有新短信入队时唤醒推送队列
+var outboxWake = make(chan struct{}, 1)
+
+// Outbox 将收到的短信可靠地推送到 Telegram: 短信先持久化, 推送失败时按指数退避重试
+// (重启后继续), 推送成功后才按保留策略删除调制解调器上的短信
+type Outbox struct{}
+
+// Start 启动推送队列, 未启用持久化时不做任何事, 短信监听器改为直接推送
+func (o *Outbox) Start(params AutomationParams) error {
+	store := storage.Default()
+	if store == nil {
+		return nil
+	}
+
+	log.Println("自动化任务：短信推送队列已启动")
+
+	go func() {
+		ticker := time.NewTicker(outboxPollInterval)
+		defer ticker.Stop()
+		for {
+			o.flush(params, store)
+			select {
+			case <-outboxWake:
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// flush 处理所有到期的条目
+func (o *Outbox) flush(params AutomationParams, store *storage.Store) {
+	items, err := store.DueOutbox(time.Now())
+	if err != nil {
+		log.Printf("读取短信推送队列失败: %v", err)
+		return
+	}
+	for _, item := range items {
+		if item.Delivered {
+			o.deleteModemCopy(params, store, item)
+		} else {
+			o.deliver(params, store, item)
+		}
+	}
+}
+
+func (o *Outbox) deliver(params AutomationParams, store *storage.Store, item storage.OutboxItem) {
+	chats := item.Chats
+	if len(chats) == 0 {
+		chats = []int64{params.AdminChatID}
+	}
+	var remaining []int64
+	var lastErr error
+	for _, chatID := range chats {
+		msg := tgbotapi.NewMessage(chatID, item.Text)
+		if !item.Plain {
+			msg.ParseMode = "Markdown"
+		}
+		msg.DisableNotification = item.Silent
+		sent, err := params.Bot.Send(msg)
+		if err != nil && chatID != params.AdminChatID && unreachableChat(err) {
+			log.Printf("无法推送短信 #%d 到聊天 %d, 放弃: %v", item.ID, chatID, err)
+			continue
+		}
+		if err != nil {
+			remaining = append(remaining, chatID)
+			lastErr = err
+			continue
+		}
+		// 记录通知对应的短信, 回复通知即可回复短信
+		if err := store.LinkMessage(chatID, sent.MessageID, item.ID); err != nil {
+			log.Printf("记录短信 #%d 的通知消息失败: %v", item.ID, err)
+		}
+		if item.Otp {
+			expireOtpNotification(params, chatID, sent.MessageID)
+		}
+	}
+	if lastErr != nil {
+		log.Printf("推送短信 #%d 失败 (第 %d 次): %v", item.ID, item.Attempts+1, lastErr)
+		store.UpdateOutbox(item.ID, func(i *storage.OutboxItem) {
+			i.Chats = remaining
+			i.Attempts++
+			i.LastError = lastErr.Error()
+			i.NextAttempt = time.Now().Add(retryDelay(lastErr, i.Attempts))
+			// 短信内容导致 Markdown 解析失败时改为纯文本, 不再等待
+			var apiErr *tgbotapi.Error
+			if errors.As(lastErr, &apiErr) && apiErr.Code == 400 && !i.Plain {
+				i.Plain = true
+				i.NextAttempt = time.Now()
+			}
+		})
+		return
+	}
+
+	log.Printf("已推送短信 #%d", item.ID)
+	if params.SmsRetention.Keep {
+		store.RemoveOutbox(item.ID)
+		return
+	}
+	deleteAt := time.Now().Add(params.SmsRetention.For)
+	store.UpdateOutbox(item.ID, func(i *storage.OutboxItem) {
+		i.Delivered = true
+		i.Attempts = 0
+		i.LastError = ""
+		i.DeleteAt = deleteAt
+	})
+	if params.SmsRetention.For == 0 {
+		item.Delivered = true
+		item.Attempts = 0
+		o.deleteModemCopy(params, store, item)
+	}
+}
+
+// deleteModemCopy 删除调制解调器上已推送的短信 (长短信为所有分段)。删除前核对发件人和内容,
+// 避免短信位置在 ModemManager 重启或手动删除后被其他短信占用时误删。
+func (o *Outbox) deleteModemCopy(params AutomationParams, store *storage.Store, item storage.OutboxItem) {
+	record, err := store.GetSms(item.ID)
+	if err != nil {
+		log.Printf("读取归档短信 #%d 失败: %v", item.ID, err)
+		store.RemoveOutbox(item.ID)
+		return
+	}
+
+	var remaining []string
+	var lastErr error
+	for _, ref := range item.SmsRefs {
+		match, err := modemCopyMatches(params, dbus.ObjectPath(item.ModemPath), ref, record)
+		if err == nil && !match {
+			log.Printf("调制解调器上的短信 #%d (%s) 已不存在, 无需删除", item.ID, ref)
+			continue
+		}
+		if err == nil {
+			err = deleteModemSms(params, dbus.ObjectPath(item.ModemPath), ref)
+		}
+		if err != nil {
+			remaining = append(remaining, ref)
+			lastErr = err
+			continue
+		}
+		log.Printf("已成功处理并删除短信: #%d (%s)", item.ID, ref)
+	}
+
+	if lastErr == nil {
+		store.RemoveOutbox(item.ID)
+		return
+	}
+	if item.Attempts+1 >= maxDeleteAttempts {
+		log.Printf("删除短信 #%d 失败, 放弃删除: %v", item.ID, lastErr)
+		store.RemoveOutbox(item.ID)
+		return
+	}
+	log.Printf("删除短信 #%d 失败, 稍后重试: %v", item.ID, lastErr)
+	store.UpdateOutbox(item.ID, func(i *storage.OutboxItem) {
+		i.SmsRefs = remaining
+		i.Attempts++
+		i.LastError = lastErr.Error()
+		i.DeleteAt = time.Now().Add(backoff(i.Attempts))
+	})
+}
+
+// modemCopyMatches 判断调制解调器上 ref 指向的短信是否仍属于归档的那条,
+// 长短信的分段只包含部分内容
+func modemCopyMatches(params AutomationParams, modemPath dbus.ObjectPath, ref string, record *storage.SmsRecord) (bool, error) {
+	if modemPath != "" {
+		if params.Conn == nil {
+			return false, errors.New("没有 D-Bus 连接")
+		}
+		smsObj := params.Conn.Object(mmService, dbus.ObjectPath(ref))
+		numberVar, err := smsObj.GetProperty(smsIface + ".Number")
+		if err != nil {
+			return false, err
+		}
+		textVar, err := smsObj.GetProperty(smsIface + ".Text")
+		if err != nil {
+			return false, err
+		}
+		number, _ := numberVar.Value().(string)
+		text, _ := textVar.Value().(string)
+		return number == record.Number && strings.Contains(record.Text, text), nil
+	}
+
+	if params.AT == nil {
+		return false, errors.New("没有 AT 端口")
+	}
+	index, err := strconv.Atoi(ref)
+	if err != nil {
+		return false, nil
+	}
+	sms, err := params.AT.ReadSms(context.Background(), index)
+	if err != nil {
+		return false, err
+	}
+	return sms.Sender == record.Number && strings.Contains(record.Text, sms.Text), nil
+}
+
+// deleteModemSms 删除调制解调器上的一条短信, modemPath 为空时 smsRef 为 AT 存储序号
+func deleteModemSms(params AutomationParams, modemPath dbus.ObjectPath, smsRef string) error {
+	if modemPath != "" {
+		if params.Conn == nil {
+			return errors.New("没有 D-Bus 连接")
+		}
+		return params.Conn.Object(mmService, modemPath).Call(messagingIface+".Delete", 0, dbus.ObjectPath(smsRef)).Store()
+	}
+	if params.AT == nil {
+		return errors.New("没有 AT 端口")
+	}
+	index, err := strconv.Atoi(smsRef)
+	if err != nil {
+		return fmt.Errorf("无效的短信序号 %q", smsRef)
+	}
+	return params.AT.DeleteSms(context.Background(), index)
+}
+
+// queueSms 归档收到的短信并放入推送队列, notification 为推送的内容, smsRefs 为短信
+// (长短信的各分段) 在调制解调器上的位置。未启用持久化时直接推送, 推送成功后按保留策略
+// 删除调制解调器上的短信。
+func queueSms(params AutomationParams, record *storage.SmsRecord, notification string, modemPath dbus.ObjectPath, smsRefs []string) {
+	otp := findOtp(params.Otp, record.Text)
+	store := storage.Default()
+	if store == nil {
+		deliverDirect(params, withOtp(otp, notification), otp != "", modemPath, smsRefs)
+		return
+	}
+
+	record.Modem, record.SIM = storage.Identify(modemEngine(params, modemPath))
+	rules := matchRules(store, record, time.Now())
+	record.Tags = rules.Tags
+	id, added, err := store.AddIncomingSms(record)
+	if err != nil {
+		log.Printf("归档短信失败, 直接推送: %v", err)
+		deliverDirect(params, withOtp(otp, notification), otp != "", modemPath, smsRefs)
+		return
+	}
+	if !added {
+		// 调制解调器重新出现后再次上报的短信, 只更新其位置
+		err := store.UpdateOutbox(id, func(i *storage.OutboxItem) {
+			i.ModemPath = string(modemPath)
+			i.SmsRefs = smsRefs
+		})
+		if err == nil {
+			log.Printf("短信 #%d 已在推送队列中, 更新位置为 %s", id, strings.Join(smsRefs, ","))
+		}
+		return
+	}
+
+	rules.run(params, modemPath, record)
+
+	item := &storage.OutboxItem{
+		ID:          id,
+		Text:        withOtp(otp, rules.decorate(notification)),
+		Silent:      rules.Mute,
+		Otp:         otp != "",
+		Chats:       rules.chats(params.AdminChatID),
+		ModemPath:   string(modemPath),
+		SmsRefs:     smsRefs,
+		NextAttempt: time.Now(),
+	}
+	if len(item.Chats) == 0 {
+		// 规则丢弃了通知, 直接按保留策略处理调制解调器上的短信
+		log.Printf("短信 #%d 按规则不推送", id)
+		if params.SmsRetention.Keep {
+			return
+		}
+		item.Delivered = true
+		item.DeleteAt = time.Now().Add(params.SmsRetention.For)
+	}
+	err = store.PutOutbox(item)
+	if err != nil {
+		log.Printf("短信 #%d 加入推送队列失败, 直接推送: %v", id, err)
+		deliverDirect(params, item.Text, item.Otp, modemPath, smsRefs)
+		return
+	}
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// deliverDirect 在没有推送队列时直接推送, 失败时短信保留在调制解调器上
+func deliverDirect(params AutomationParams, notification string, otp bool, modemPath dbus.ObjectPath, smsRefs []string) {
+	refs := strings.Join(smsRefs, ",")
+	msg := tgbotapi.NewMessage(params.AdminChatID, notification)
+	msg.ParseMode = "Markdown"
+	sent, err := params.Bot.Send(msg)
+	if err != nil {
+		log.Printf("推送短信 %s 失败, 保留在调制解调器上: %v", refs, err)
+		return
+	}
+	if otp {
+		expireOtpNotification(params, sent.Chat.ID, sent.MessageID)
+	}
+	// 没有持久化时无法延后删除, 只有立即删除的策略会删除
+	if params.SmsRetention.Keep || params.SmsRetention.For > 0 {
+		return
+	}
+	for _, ref := range smsRefs {
+		if err := deleteModemSms(params, modemPath, ref); err != nil {
+			log.Printf("删除短信 %s 失败: %v", ref, err)
+		} else {
+			log.Printf("已成功处理并删除短信: %s", ref)
+		}
+	}
+}
+
+// retryDelay 返回推送失败后的等待时间, Telegram 要求限流时按其给出的时间等待
+func retryDelay(err error, attempts int) time.Duration {
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return time.Duration(apiErr.RetryAfter) * time.Second
+	}
+	return backoff(attempts)
+}
+
+// unreachableChat 判断推送失败是否因为机器人不在该聊天中, 重试也不会成功
+func unreachableChat(err error) bool {
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.Code == 403 || strings.Contains(apiErr.Message, "chat not found")
+}
+
+// backoff 返回第 attempts 次失败后的指数退避时间
+func backoff(attempts int) time.Duration {
+	d := outboxMinBackoff
+	for i := 1; i < attempts && d < outboxMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, outboxMaxBackoff)
+}
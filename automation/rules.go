@@ -0,0 +1,255 @@
+package automation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"tg_modem/storage"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	webhookTimeout = 10 * time.Second
+	// autoReplyCooldown 内不重复自动回复同一号码, 避免与对方的自动回复互相触发
+	autoReplyCooldown = 10 * time.Minute
+)
+
+var (
+	webhookClient = &http.Client{Timeout: webhookTimeout}
+
+	// rulePatterns 缓存编译后的正则表达式
+	rulePatterns sync.Map
+
+	autoReplies     = make(map[string]time.Time)
+	autoRepliesLock sync.Mutex
+
+	// tagPattern 限制标签的字符, 标签会原样出现在 Markdown 通知中
+	tagPattern = regexp.MustCompile(`^[\p{L}\p{N}-]+$`)
+)
+
+// ruleOutcome 为所有匹配的规则合并后的动作
+type ruleOutcome struct {
+	Matched  []uint64
+	Forward  []int64
+	Drop     bool
+	Mute     bool
+	Tags     []string
+	Replies  []string
+	Webhooks []string
+}
+
+// ValidateRule 检查规则的条件和动作是否有效
+func ValidateRule(r *storage.Rule) error {
+	for _, p := range []string{r.From, r.Text} {
+		if _, err := rulePattern(p); err != nil {
+			return fmt.Errorf("无效的正则表达式 %q: %w", p, err)
+		}
+	}
+	if r.Window != "" {
+		if _, _, err := parseWindow(r.Window); err != nil {
+			return err
+		}
+	}
+	for _, tag := range r.Tags {
+		if !tagPattern.MatchString(tag) {
+			return fmt.Errorf("标签 %q 只能包含文字、数字和 -", tag)
+		}
+	}
+	if r.Webhook != "" {
+		u, err := url.Parse(r.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("无效的 webhook 地址 %q", r.Webhook)
+		}
+	}
+	if len(r.Forward) == 0 && !r.Drop && r.Reply == "" && len(r.Tags) == 0 && !r.Mute && r.Webhook == "" {
+		return errors.New("规则至少需要一个动作")
+	}
+	return nil
+}
+
+// rulePattern 返回编译后的正则表达式, 空表达式为 nil
+func rulePattern(p string) (*regexp.Regexp, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if re, ok := rulePatterns.Load(p); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return nil, err
+	}
+	rulePatterns.Store(p, re)
+	return re, nil
+}
+
+// parseWindow 解析时间段 "HH:MM-HH:MM", 返回起止时刻距零点的分钟数。结束早于开始时跨过零点。
+func parseWindow(s string) (int, int, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if ok {
+		start, errStart := time.Parse("15:04", from)
+		end, errEnd := time.Parse("15:04", to)
+		if errStart == nil && errEnd == nil {
+			return start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute(), nil
+		}
+	}
+	return 0, 0, fmt.Errorf("无效的时间段 %q, 格式应为 22:00-07:00", s)
+}
+
+// inWindow 判断 t 的本地时刻是否在时间段内
+func inWindow(window string, t time.Time) bool {
+	start, end, err := parseWindow(window)
+	if err != nil {
+		return false
+	}
+	t = t.Local()
+	m := t.Hour()*60 + t.Minute()
+	if start <= end {
+		return m >= start && m < end
+	}
+	return m >= start || m < end
+}
+
+// ruleMatches 判断短信是否满足规则的所有条件, now 用于判断时间段
+func ruleMatches(r *storage.Rule, record *storage.SmsRecord, now time.Time) bool {
+	for _, c := range []struct{ pattern, value string }{{r.From, record.Number}, {r.Text, record.Text}} {
+		re, err := rulePattern(c.pattern)
+		if err != nil || (re != nil && !re.MatchString(c.value)) {
+			return false
+		}
+	}
+	if r.Modem != "" {
+		key := strings.ToLower(r.Modem)
+		if !strings.HasSuffix(strings.ToLower(record.Modem), key) && !strings.HasSuffix(strings.ToLower(record.SIM), key) {
+			return false
+		}
+	}
+	if r.Window != "" && !inWindow(r.Window, now) {
+		return false
+	}
+	return true
+}
+
+// matchRules 按顺序检查所有规则并合并匹配规则的动作
+func matchRules(store *storage.Store, record *storage.SmsRecord, now time.Time) ruleOutcome {
+	var outcome ruleOutcome
+	rules, err := store.Rules()
+	if err != nil {
+		log.Printf("读取短信规则失败: %v", err)
+		return outcome
+	}
+	for _, r := range rules {
+		if !ruleMatches(&r, record, now) {
+			continue
+		}
+		outcome.Matched = append(outcome.Matched, r.ID)
+		for _, chatID := range r.Forward {
+			if !slices.Contains(outcome.Forward, chatID) {
+				outcome.Forward = append(outcome.Forward, chatID)
+			}
+		}
+		outcome.Drop = outcome.Drop || r.Drop
+		outcome.Mute = outcome.Mute || r.Mute
+		for _, tag := range r.Tags {
+			if !slices.Contains(outcome.Tags, tag) {
+				outcome.Tags = append(outcome.Tags, tag)
+			}
+		}
+		if r.Reply != "" {
+			outcome.Replies = append(outcome.Replies, r.Reply)
+		}
+		if r.Webhook != "" {
+			outcome.Webhooks = append(outcome.Webhooks, r.Webhook)
+		}
+	}
+	if len(outcome.Matched) > 0 {
+		log.Printf("来自 %s 的短信匹配规则 %v", record.Number, outcome.Matched)
+	}
+	return outcome
+}
+
+// chats 返回需要推送通知的聊天, 未被丢弃时管理员在最前
+func (o *ruleOutcome) chats(adminChatID int64) []int64 {
+	var chats []int64
+	if !o.Drop {
+		chats = append(chats, adminChatID)
+	}
+	for _, chatID := range o.Forward {
+		if !slices.Contains(chats, chatID) {
+			chats = append(chats, chatID)
+		}
+	}
+	return chats
+}
+
+// decorate 在通知开头加上标签
+func (o *ruleOutcome) decorate(notification string) string {
+	if len(o.Tags) == 0 {
+		return notification
+	}
+	return "🏷 #" + strings.Join(o.Tags, " #") + "\n" + notification
+}
+
+// run 在后台执行自动回复和 webhook 动作
+func (o *ruleOutcome) run(params AutomationParams, modemPath dbus.ObjectPath, record *storage.SmsRecord) {
+	for _, text := range o.Replies {
+		go autoReply(params, modemPath, record, text)
+	}
+	for _, u := range o.Webhooks {
+		go callWebhook(u, record)
+	}
+}
+
+// autoReply 由收到短信的调制解调器回复对方, 字母发件人 (如银行、运营商的服务号) 无法回复
+func autoReply(params AutomationParams, modemPath dbus.ObjectPath, record *storage.SmsRecord, text string) {
+	number := record.Number
+	if strings.TrimLeft(number, "+0123456789") != "" || number == "" {
+		log.Printf("发件人 %q 不是电话号码, 不自动回复", number)
+		return
+	}
+	autoRepliesLock.Lock()
+	if last, ok := autoReplies[number]; ok && time.Since(last) < autoReplyCooldown {
+		autoRepliesLock.Unlock()
+		log.Printf("%v 内已自动回复过 %s, 跳过", autoReplyCooldown, number)
+		return
+	}
+	autoReplies[number] = time.Now()
+	autoRepliesLock.Unlock()
+
+	eng := modemEngine(params, modemPath)
+	err := eng.SendSms(number, text)
+	storage.ArchiveSent(eng, number, text, err)
+	if err != nil {
+		log.Printf("自动回复 %s 失败: %v", number, err)
+		return
+	}
+	log.Printf("已自动回复 %s", number)
+}
+
+// callWebhook 以 JSON 格式 POST 归档的短信记录
+func callWebhook(u string, record *storage.SmsRecord) {
+	body, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("编码 webhook 内容失败: %v", err)
+		return
+	}
+	resp, err := webhookClient.Post(u, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("调用 webhook %s 失败: %v", u, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Printf("webhook %s 返回 %s", u, resp.Status)
+	}
+}
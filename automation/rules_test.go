@@ -0,0 +1,169 @@
+package automation
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"tg_modem/storage"
+)
+
+// localTime returns hh:mm local time on a fixed day.
+func localTime(hh, mm int) time.Time {
+	return time.Date(2024, 9, 2, hh, mm, 0, 0, time.Local)
+}
+
+func TestInWindow(t *testing.T) {
+	tests := []struct {
+		window string
+		hh, mm int
+		want   bool
+	}{
+		{"09:00-18:00", 9, 0, true},
+		{"09:00-18:00", 17, 59, true},
+		{"09:00-18:00", 18, 0, false},
+		{"09:00-18:00", 8, 59, false},
+		// Crossing midnight.
+		{"22:00-07:00", 22, 0, true},
+		{"22:00-07:00", 23, 59, true},
+		{"22:00-07:00", 0, 0, true},
+		{"22:00-07:00", 6, 59, true},
+		{"22:00-07:00", 7, 0, false},
+		{"22:00-07:00", 21, 59, false},
+		{"22:00-07:00", 12, 0, false},
+		{"22:00", 22, 0, false},
+		{"25:00-07:00", 1, 0, false},
+	}
+	for _, tt := range tests {
+		if got := inWindow(tt.window, localTime(tt.hh, tt.mm)); got != tt.want {
+			t.Errorf("inWindow(%q, %02d:%02d) = %v, want %v", tt.window, tt.hh, tt.mm, got, tt.want)
+		}
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	record := &storage.SmsRecord{
+		Number: "+8613800138000",
+		Text:   "您的验证码为 123456",
+		Modem:  "350000000000001",
+		SIM:    "8986001234567890123F",
+	}
+	tests := []struct {
+		name string
+		rule storage.Rule
+		now  time.Time
+		want bool
+	}{
+		{"no conditions", storage.Rule{}, localTime(12, 0), true},
+		{"sender", storage.Rule{From: `^\+86138`}, localTime(12, 0), true},
+		{"other sender", storage.Rule{From: `^10086$`}, localTime(12, 0), false},
+		{"text", storage.Rule{Text: `验证码`}, localTime(12, 0), true},
+		{"other text", storage.Rule{Text: `流量`}, localTime(12, 0), false},
+		{"sender and text", storage.Rule{From: `^\+86`, Text: `流量`}, localTime(12, 0), false},
+		{"invalid pattern", storage.Rule{Text: `(`}, localTime(12, 0), false},
+		{"modem suffix", storage.Rule{Modem: "0001"}, localTime(12, 0), true},
+		{"full modem ID", storage.Rule{Modem: "350000000000001"}, localTime(12, 0), true},
+		{"SIM suffix", storage.Rule{Modem: "0123f"}, localTime(12, 0), true},
+		{"modem prefix", storage.Rule{Modem: "35000"}, localTime(12, 0), false},
+		{"other SIM", storage.Rule{Modem: "9999"}, localTime(12, 0), false},
+		{"in window", storage.Rule{Window: "22:00-07:00"}, localTime(1, 30), true},
+		{"outside window", storage.Rule{Window: "22:00-07:00"}, localTime(12, 0), false},
+		{"all conditions", storage.Rule{From: `^\+86`, Text: `\d{6}`, Modem: "0001", Window: "09:00-18:00"}, localTime(12, 0), true},
+	}
+	for _, tt := range tests {
+		if got := ruleMatches(&tt.rule, record, tt.now); got != tt.want {
+			t.Errorf("%s: ruleMatches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchRules(t *testing.T) {
+	store, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	rules := []storage.Rule{
+		{From: `^10086$`, Drop: true, Tags: []string{"运营商"}},
+		{Text: `流量`, Forward: []int64{100, 200}, Tags: []string{"运营商", "流量"}},
+		{Text: `流量`, Forward: []int64{200}, Mute: true, Webhook: "https://example.com/hook"},
+		{From: `^95588$`, Reply: "收到"},
+	}
+	for i := range rules {
+		if err := store.AddRule(&rules[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	o := matchRules(store, &storage.SmsRecord{Number: "10086", Text: "您的流量已用完"}, time.Now())
+	if want := []uint64{rules[0].ID, rules[1].ID, rules[2].ID}; !slices.Equal(o.Matched, want) {
+		t.Errorf("matched %v, want %v", o.Matched, want)
+	}
+	if !o.Drop || !o.Mute {
+		t.Errorf("drop = %v, mute = %v, want both", o.Drop, o.Mute)
+	}
+	if want := []int64{100, 200}; !slices.Equal(o.Forward, want) {
+		t.Errorf("forward = %v, want %v", o.Forward, want)
+	}
+	if want := []string{"运营商", "流量"}; !slices.Equal(o.Tags, want) {
+		t.Errorf("tags = %v, want %v", o.Tags, want)
+	}
+	if len(o.Replies) != 0 || len(o.Webhooks) != 1 {
+		t.Errorf("replies = %v, webhooks = %v", o.Replies, o.Webhooks)
+	}
+	// Dropped: only the forward targets are notified.
+	if got, want := o.chats(42), []int64{100, 200}; !slices.Equal(got, want) {
+		t.Errorf("chats = %v, want %v", got, want)
+	}
+	if got, want := o.decorate("短信"), "🏷 #运营商 #流量\n短信"; got != want {
+		t.Errorf("decorate = %q, want %q", got, want)
+	}
+
+	o = matchRules(store, &storage.SmsRecord{Number: "+8613800138000", Text: "流量"}, time.Now())
+	if got, want := o.chats(42), []int64{42, 100, 200}; !slices.Equal(got, want) {
+		t.Errorf("chats = %v, want the admin first", got)
+	}
+
+	o = matchRules(store, &storage.SmsRecord{Number: "+8613800138000", Text: "你好"}, time.Now())
+	if len(o.Matched) != 0 || o.Drop {
+		t.Errorf("outcome = %+v, want no rule matched", o)
+	}
+	if got, want := o.chats(42), []int64{42}; !slices.Equal(got, want) {
+		t.Errorf("chats = %v, want %v", got, want)
+	}
+}
+
+func TestValidateRule(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  storage.Rule
+		valid bool
+	}{
+		{"drop", storage.Rule{From: `^10086$`, Drop: true}, true},
+		{"forward", storage.Rule{Forward: []int64{100}}, true},
+		{"reply", storage.Rule{Reply: "收到"}, true},
+		{"tags", storage.Rule{Tags: []string{"银行", "bank-1"}}, true},
+		{"mute", storage.Rule{Window: "22:00-07:00", Mute: true}, true},
+		{"webhook", storage.Rule{Webhook: "https://example.com/hook"}, true},
+		{"no action", storage.Rule{From: `^10086$`}, false},
+		{"only conditions", storage.Rule{Text: `流量`, Modem: "0001", Window: "22:00-07:00"}, false},
+		{"invalid sender pattern", storage.Rule{From: `(`, Drop: true}, false},
+		{"invalid text pattern", storage.Rule{Text: `[`, Drop: true}, false},
+		{"invalid window", storage.Rule{Window: "22:00", Drop: true}, false},
+		{"window out of range", storage.Rule{Window: "24:00-07:00", Drop: true}, false},
+		{"tag with a space", storage.Rule{Tags: []string{"a b"}}, false},
+		{"tag with markdown", storage.Rule{Tags: []string{"a_b"}}, false},
+		{"webhook scheme", storage.Rule{Webhook: "ftp://example.com/hook"}, false},
+		{"webhook without host", storage.Rule{Webhook: "https:///hook"}, false},
+	}
+	for _, tt := range tests {
+		err := ValidateRule(&tt.rule)
+		if tt.valid && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: accepted", tt.name)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package automation
+
+import (
+	"fmt"
+	"log"
+	"tg_modem/engine"
+	"tg_modem/storage"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const schedulerPollInterval = 15 * time.Second
+
+func init() {
+	Register(&Scheduler{})
+}
+
+// schedulerWake 在定时短信有变化时唤醒调度器
+var schedulerWake = make(chan struct{}, 1)
+
+// WakeScheduler 通知调度器重新检查到期的定时短信
+func WakeScheduler() {
+	select {
+	case schedulerWake <- struct{}{}:
+	default:
+	}
+}
+
+// Scheduler 按时发送保存在数据库中的定时短信 (如保号短信、查询余额), 并向管理员报告结果。
+// 机器人停止期间错过的短信在启动后补发一次。
+type Scheduler struct{}
+
+// Start 启动调度器, 未启用持久化时不做任何事
+func (s *Scheduler) Start(params AutomationParams) error {
+	store := storage.Default()
+	if store == nil {
+		return nil
+	}
+
+	log.Println("自动化任务：定时短信调度器已启动")
+
+	go func() {
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for {
+			next := s.runDue(params, store)
+			wait := schedulerPollInterval
+			if !next.IsZero() {
+				wait = min(wait, time.Until(next))
+			}
+			timer.Reset(wait)
+			select {
+			case <-schedulerWake:
+			case <-timer.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// runDue 发送所有到期的定时短信, 返回尚未到期的定时短信中最早的发送时间
+func (s *Scheduler) runDue(params AutomationParams, store *storage.Store) time.Time {
+	schedules, err := store.Schedules()
+	if err != nil {
+		log.Printf("读取定时短信失败: %v", err)
+		return time.Time{}
+	}
+	now := time.Now()
+	var next time.Time
+	for _, sc := range schedules {
+		switch {
+		case sc.NextRun.IsZero():
+		case sc.NextRun.After(now):
+			if next.IsZero() || sc.NextRun.Before(next) {
+				next = sc.NextRun
+			}
+		default:
+			s.run(params, store, sc, now)
+		}
+	}
+	return next
+}
+
+// run 发送一条定时短信。发送前先推进下一次时间或删除一次性的定时短信,
+// 发送过程中机器人重启也不会重复发送。
+func (s *Scheduler) run(params AutomationParams, store *storage.Store, sc storage.Schedule, now time.Time) {
+	var next time.Time
+	if sc.Cron != "" {
+		cron, err := ParseCron(sc.Cron)
+		if err != nil {
+			log.Printf("定时短信 #%d 的 cron 表达式无效, 停止发送: %v", sc.ID, err)
+		} else {
+			next = cron.Next(now)
+		}
+		err = store.UpdateSchedule(sc.ID, func(i *storage.Schedule) {
+			i.NextRun = next
+			i.LastRun = now
+		})
+		if err != nil {
+			log.Printf("更新定时短信 #%d 失败: %v", sc.ID, err)
+			return
+		}
+	} else if err := store.RemoveSchedule(sc.ID); err != nil {
+		log.Printf("删除定时短信 #%d 失败: %v", sc.ID, err)
+		return
+	}
+
+	eng, err := scheduleEngine(params.Engine, sc.Modem)
+	if err == nil {
+		err = eng.SendSms(sc.Number, sc.Text)
+		storage.ArchiveSent(eng, sc.Number, sc.Text, err)
+	}
+
+	var report string
+	if err != nil {
+		log.Printf("定时短信 #%d 发送到 %s 失败: %v", sc.ID, sc.Number, err)
+		report = fmt.Sprintf("❌ 定时短信 #%d 发送到 %s 失败: %s", sc.ID, sc.Number, err.Error())
+	} else {
+		log.Printf("定时短信 #%d 已发送到 %s", sc.ID, sc.Number)
+		report = fmt.Sprintf("⏰ 定时短信 #%d 已发送到 %s", sc.ID, sc.Number)
+	}
+	switch {
+	case sc.Cron == "":
+	case next.IsZero():
+		report += "\n该定时短信不会再发送, 请用 /unschedule 删除"
+	default:
+		report += "\n下次发送: " + next.Format("2006-01-02 15:04")
+	}
+	if sc.Cron != "" {
+		lastError := ""
+		if err != nil {
+			lastError = err.Error()
+		}
+		store.UpdateSchedule(sc.ID, func(i *storage.Schedule) {
+			i.LastError = lastError
+		})
+	}
+
+	params.Bot.Send(tgbotapi.NewMessage(params.AdminChatID, report))
+}
+
+// scheduleEngine 返回发送定时短信的引擎: 创建时使用的调制解调器, 单调制解调器的引擎直接使用 eng
+func scheduleEngine(eng engine.Engine, modem string) (engine.Engine, error) {
+	multi, ok := eng.(engine.MultiModem)
+	if !ok || modem == "" {
+		return eng, nil
+	}
+	return multi.ForModem(modem)
+}
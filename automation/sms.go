@@ -1,10 +1,15 @@
 package automation
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"tg_modem/engine/at"
+	"tg_modem/engine/dbus_mbim"
+	"tg_modem/storage"
 
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/godbus/dbus/v5"
 )
 
@@ -12,6 +17,8 @@ const (
 	mmService      = "org.freedesktop.ModemManager1"
 	messagingIface = "org.freedesktop.ModemManager1.Modem.Messaging"
 	smsIface       = "org.freedesktop.ModemManager1.Sms"
+
+	smsStateReceived = 3 // MM_SMS_STATE_RECEIVED
 )
 
 func init() {
@@ -19,12 +26,19 @@ func init() {
 }
 
 // SmsListener 实现了监听新短信的自动化任务
-type SmsListener struct{}
+type SmsListener struct {
+	// concat 缓存通过 AT 端口收到的长短信分段。ModemManager 收齐所有分段后才导出长短信,
+	// D-Bus 上收到的都是完整的短信。
+	concat *concatBuffer
+}
 
 // Start 开始监听 D-Bus 上的短信 "Added" 信号
+// 没有 D-Bus 连接时改为监听 AT 端口上的 +CMTI
 func (s *SmsListener) Start(params AutomationParams) error {
+	if params.Conn == nil {
+		return s.startAT(params)
+	}
 	err := params.Conn.AddMatchSignal(
-		dbus.WithMatchObjectPath(params.ModemPath),
 		dbus.WithMatchInterface(messagingIface),
 	)
 	if err != nil {
@@ -51,17 +65,48 @@ func (s *SmsListener) Start(params AutomationParams) error {
 			if !ok {
 				continue
 			}
+			// received 为 false 表示是本机创建 (发出) 的短信
+			if len(sig.Body) > 1 {
+				if received, ok := sig.Body[1].(bool); ok && !received {
+					continue
+				}
+			}
 
 			log.Printf("检测到新短信: %s", smsPath)
-			s.processSms(params, smsPath)
+			s.processSms(params, sig.Path, smsPath)
 		}
 	}()
 
 	return nil
 }
 
-// processSms 处理单条新短信：推送并删除
-func (s *SmsListener) processSms(params AutomationParams, smsPath dbus.ObjectPath) {
+// Rearm 在调制解调器重新出现后补发断开期间收到但错过 "Added" 信号的短信。
+// 信号匹配规则不绑定调制解调器路径, 无需重新添加。
+func (s *SmsListener) Rearm(params AutomationParams, modemPath dbus.ObjectPath) error {
+	if params.Conn == nil {
+		return nil
+	}
+	var smsPaths []dbus.ObjectPath
+	modemObj := params.Conn.Object(mmService, modemPath)
+	if err := modemObj.Call(messagingIface+".List", 0).Store(&smsPaths); err != nil {
+		return fmt.Errorf("无法列出短信: %w", err)
+	}
+	for _, smsPath := range smsPaths {
+		stateVar, err := params.Conn.Object(mmService, smsPath).GetProperty(smsIface + ".State")
+		if err != nil {
+			continue
+		}
+		if state, _ := stateVar.Value().(uint32); state != smsStateReceived {
+			continue
+		}
+		log.Printf("补发调制解调器上的短信: %s", smsPath)
+		s.processSms(params, modemPath, smsPath)
+	}
+	return nil
+}
+
+// processSms 处理单条新短信：归档并放入推送队列
+func (s *SmsListener) processSms(params AutomationParams, modemPath, smsPath dbus.ObjectPath) {
 	smsObj := params.Conn.Object(mmService, smsPath)
 
 	// 获取短信内容
@@ -89,25 +134,106 @@ func (s *SmsListener) processSms(params AutomationParams, smsPath dbus.ObjectPat
 	} else {
 		RefStr = Ref.String()
 	}
-	// 推送通知
-	notificationText := fmt.Sprintf("*新短信*\n*来自:* `%s`\n*内容:*\n%s\n*时间:* %s\n*Ref*: %s",
-		numberVar.Value().(string),
-		textVar.Value().(string),
-		timeS,
-		RefStr,
-	)
-	msg := tgbotapi.NewMessage(params.AdminChatID, notificationText)
-	msg.ParseMode = "Markdown"
-	_, err = params.Bot.Send(msg)
+	record := &storage.SmsRecord{
+		Direction: storage.Incoming,
+		Number:    numberVar.Value().(string),
+		Text:      textVar.Value().(string),
+		State:     storage.StateReceived,
+	}
+	if ts, ok := Time.Value().(string); ok && ts != "" {
+		if record.Timestamp, err = dbus_mbim.ParseTimestamp(ts); err != nil {
+			log.Printf("短信 %s: %v", smsPath, err)
+		}
+	}
+	if smsc, err := smsObj.GetProperty(smsIface + ".SMSC"); err == nil {
+		record.SMSC, _ = smsc.Value().(string)
+	}
+	notificationText := smsNotificationText(record.Number, record.Text, timeS, RefStr) + modemLabel(params, modemPath)
+	queueSms(params, record, notificationText, modemPath, []string{string(smsPath)})
+}
+
+// startAT 通过 AT 端口的 +CMTI 监听新短信, 读取后放入推送队列
+func (s *SmsListener) startAT(params AutomationParams) error {
+	if params.AT == nil {
+		return errors.New("短信监听器需要 D-Bus 连接或 AT 端口")
+	}
+	events, _ := params.AT.Subscribe()
+	s.concat = newConcatBuffer(concatTimeout, func(sms *assembledSms) {
+		s.queueAssembled(params, sms)
+	})
+
+	log.Println("自动化任务：短信监听器已启动 (AT)")
+
+	go s.replayStoredAT(params)
+	go func() {
+		for ev := range events {
+			cmti, ok := ev.(at.NewSmsEvent)
+			if !ok {
+				continue
+			}
+			log.Printf("检测到新短信 (AT): %s,%d", cmti.Storage, cmti.Index)
+			s.processAT(params, cmti.Index)
+		}
+	}()
+
+	return nil
+}
+
+// replayStoredAT 补发启动前已在调制解调器上的短信, 程序未运行时收到的短信不会再有 +CMTI。
+// 启用持久化时重复的短信由 AddIncomingSms 去重; 否则若保留推送过的短信, 已读的短信多半推送过,
+// 只补发未读的。
+func (s *SmsListener) replayStoredAT(params AutomationParams) {
+	list, err := params.AT.ListSms(context.Background())
 	if err != nil {
-		log.Printf("已成功处理并删除短信: %s", smsPath)
+		log.Printf("无法读取调制解调器上的短信: %v", err)
 		return
 	}
-	modemObj := params.Conn.Object(mmService, params.ModemPath)
-	//    调用 Delete 方法，并把短信的路径作为参数传入
-	if err := modemObj.Call(messagingIface+".Delete", 0, smsPath).Store(); err != nil {
-		log.Printf("删除短信 %s 失败: %v", smsPath, err)
-	} else {
-		log.Printf("已成功处理并删除短信: %s", smsPath)
+	keepsCopies := params.SmsRetention.Keep || params.SmsRetention.For > 0
+	for _, sms := range list {
+		if sms.Message == nil {
+			continue // 发出的短信
+		}
+		if sms.Status == at.SmsReceivedRead && keepsCopies && storage.Default() == nil {
+			continue
+		}
+		log.Printf("补发调制解调器上的短信 (AT): %d", sms.Index)
+		s.concat.Add(sms.Message, strconv.Itoa(sms.Index))
+	}
+}
+
+func (s *SmsListener) processAT(params AutomationParams, index int) {
+	ctx := context.Background()
+	sms, err := params.AT.ReadSms(ctx, index)
+	if err != nil {
+		log.Printf("无法读取短信 %d: %v", index, err)
+		return
+	}
+
+	s.concat.Add(sms, strconv.Itoa(index))
+}
+
+// queueAssembled 将 (重组后的) 短信放入推送队列, 不完整的长短信在通知中注明
+func (s *SmsListener) queueAssembled(params AutomationParams, sms *assembledSms) {
+	record := &storage.SmsRecord{
+		Direction: storage.Incoming,
+		Number:    sms.Sender,
+		Text:      sms.Text,
+		Timestamp: sms.Timestamp,
+		SMSC:      sms.SMSC,
+		State:     storage.StateReceived,
+	}
+	notificationText := smsNotificationText(sms.Sender, sms.Text, sms.Timestamp.Local().Format("2006-01-02 15:04:05"), "")
+	if sms.Partial() {
+		notificationText += fmt.Sprintf("\n⚠️ *不完整的长短信:* 只收到 %d/%d 段", sms.Received, sms.Total)
 	}
+	queueSms(params, record, notificationText, "", sms.Refs)
+}
+
+func smsNotificationText(number, text, timestamp, ref string) string {
+	return fmt.Sprintf("*新短信*\n*来自:* %s\n*内容:*\n%s\n*时间:* %s\n*Ref*: %s",
+		storage.Default().DescribeNumber(number),
+		text,
+		timestamp,
+		ref,
+	)
 }
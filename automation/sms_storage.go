@@ -0,0 +1,129 @@
+package automation
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"tg_modem/engine"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	storageCheckInterval = 10 * time.Minute
+	// storageWarnPercent 为提醒的用量百分比, 存储满后网络会拒收新短信
+	storageWarnPercent = 80
+)
+
+func init() {
+	Register(&StorageMonitor{warned: make(map[string]int)})
+}
+
+// StorageMonitor 定期检查收到的短信存入的存储, 用量接近或达到容量时提醒管理员
+type StorageMonitor struct {
+	mu sync.Mutex
+	// warned 记录每个调制解调器的存储已提醒的等级 (1 接近满, 2 已满), 用量回落后重新提醒
+	warned map[string]int
+}
+
+// storageTarget 为一个需要检查的调制解调器
+type storageTarget struct {
+	key   string // 区分调制解调器
+	label string // 通知中的调制解调器说明, 单调制解调器时为空
+	eng   engine.SmsStorageManager
+}
+
+// Start 启动检查, 引擎不支持短信存储时不做任何事
+func (m *StorageMonitor) Start(params AutomationParams) error {
+	if _, ok := params.Engine.(engine.SmsStorageManager); !ok {
+		return nil
+	}
+
+	log.Println("自动化任务：短信存储监控已启动")
+
+	go func() {
+		ticker := time.NewTicker(storageCheckInterval)
+		defer ticker.Stop()
+		for {
+			m.check(params)
+			<-ticker.C
+		}
+	}()
+	return nil
+}
+
+// targets 返回需要检查的调制解调器, 多调制解调器时逐个检查
+func (m *StorageMonitor) targets(params AutomationParams) []storageTarget {
+	multi, ok := params.Engine.(engine.MultiModem)
+	if !ok {
+		return []storageTarget{{eng: params.Engine.(engine.SmsStorageManager)}}
+	}
+	modems, err := multi.ListModems()
+	if err != nil {
+		log.Printf("无法列出调制解调器: %v", err)
+		return nil
+	}
+	var targets []storageTarget
+	for i, modem := range modems {
+		scoped, err := multi.ForModem(modem.ID)
+		if err != nil {
+			continue
+		}
+		mgr, ok := scoped.(engine.SmsStorageManager)
+		if !ok {
+			continue
+		}
+		t := storageTarget{key: modem.ID, eng: mgr}
+		if len(modems) > 1 {
+			t.label = fmt.Sprintf("\n*Modem:* %d. `%s` %s", i+1, modem.ID, modem.Model)
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+func (m *StorageMonitor) check(params AutomationParams) {
+	for _, t := range m.targets(params) {
+		storages, err := t.eng.SmsStorages()
+		if err != nil {
+			log.Printf("检查短信存储失败: %v", err)
+			continue
+		}
+		for _, s := range storages {
+			// 只有收到的短信存入的存储会导致拒收, 容量未知时无法判断
+			if !s.Default || s.Total <= 0 || s.Used < 0 {
+				continue
+			}
+			level := 0
+			switch {
+			case s.Used >= s.Total:
+				level = 2
+			case s.Used*100 >= s.Total*storageWarnPercent:
+				level = 1
+			}
+
+			key := t.key + "/" + s.Name
+			m.mu.Lock()
+			prev := m.warned[key]
+			m.warned[key] = level
+			m.mu.Unlock()
+			if level <= prev {
+				continue
+			}
+
+			title := "⚠️ *短信存储即将用满*"
+			if level == 2 {
+				title = "🚫 *短信存储已满*"
+			}
+			log.Printf("短信存储 %s 用量 %d/%d", s.Name, s.Used, s.Total)
+			text := fmt.Sprintf("%s\n%s: %d/%d (%d%%)%s\n\n存储满后新短信会被网络拒收, 请用 /sms 和 /deletesms 删除短信, 或用 /smsstorage 切换存储。",
+				title, engine.DescribeSmsStorage(s.Name), s.Used, s.Total, s.Used*100/s.Total, t.label)
+			msg := tgbotapi.NewMessage(params.AdminChatID, text)
+			msg.ParseMode = "Markdown"
+			if _, err := params.Bot.Send(msg); err != nil {
+				log.Printf("发送短信存储提醒失败: %v", err)
+			}
+		}
+	}
+}
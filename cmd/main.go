@@ -5,6 +5,7 @@ import (
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 	"tg_modem/automation"
 	_ "tg_modem/automation"
 	"tg_modem/commands"
@@ -13,6 +14,8 @@ import (
 	"tg_modem/engine/at"
 	"tg_modem/engine/dbus_mbim"
 	_ "tg_modem/engine/dbus_mbim"
+	"tg_modem/storage"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
@@ -38,20 +41,73 @@ func main() {
 		log.Fatalf("无效的 ADMIN_CHAT_ID: %v", err)
 	}
 
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = "tg_modem.db"
+	}
+
+	smsRetention, err := automation.ParseSmsRetention(os.Getenv("SMS_RETENTION"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	otpPatterns, err := automation.ParseOtpPatterns(os.Getenv("OTP_PATTERNS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var otpTTL time.Duration
+	if s := os.Getenv("OTP_TTL"); s != "" {
+		otpTTL, err = time.ParseDuration(s)
+		if err != nil || otpTTL < 0 {
+			log.Fatalf("无效的 OTP_TTL %q, 应为时长如 10m", s)
+		}
+	}
+
+	if s := os.Getenv("BULK_SMS_INTERVAL"); s != "" {
+		interval, err := time.ParseDuration(s)
+		if err != nil || interval < time.Second {
+			log.Fatalf("无效的 BULK_SMS_INTERVAL %q, 应为不小于 1s 的时长", s)
+		}
+		commands.BulkSmsInterval = interval
+	}
+
+	if s := os.Getenv("DEFAULT_COUNTRY_CODE"); s != "" {
+		code := strings.TrimPrefix(s, "+")
+		if code == "" || len(code) > 3 || code[0] == '0' || strings.Trim(code, "0123456789") != "" {
+			log.Fatalf("无效的 DEFAULT_COUNTRY_CODE %q, 应为电话区号如 86", s)
+		}
+		storage.DefaultCountryCode = code
+	}
+
+	engineName := os.Getenv("ENGINE")
+	if engineName == "" {
+		engineName = "dbus_mbim"
+	}
+
 	// 2. 初始化引擎
-	eng := engine.Get("dbus_mbim")
+	eng := engine.Get(engineName)
 	if eng == nil {
-		log.Fatal("无法找到 'dbus_mbim' 引擎")
+		log.Fatalf("无法找到 '%s' 引擎", engineName)
 	}
 
+	atHandler := at.NewHandler(atPortStr)
+	if s, ok := eng.(engine.ATSetter); ok {
+		log.Printf("Setting AT Handler:%s", atPortStr)
+		s.SetATHandler(atHandler)
+	}
 	if err := eng.Init(); err != nil {
 		log.Fatalf("引擎初始化失败: %v", err)
 	}
-	if s, ok := eng.(engine.ATSetter); ok {
-		log.Printf("Setting AT Handler:%s", atPortStr)
-		s.SetATHandler(at.NewHandler(atPortStr))
+	log.Printf("Modem 引擎 %s 初始化成功", engineName)
+
+	// 短信归档等持久化数据, 打开失败时机器人仍可运行
+	if store, err := storage.Open(dbPath); err != nil {
+		log.Printf("WARN: 无法打开数据库, 短信归档已禁用: %v", err)
+	} else {
+		defer store.Close()
+		storage.SetDefault(store)
+		log.Printf("数据库已打开: %s", dbPath)
 	}
-	log.Println("Modem 引擎初始化成功")
 
 	// 3. 初始化 Telegram Bot
 	bot, err := tgbotapi.NewBotAPI(botToken)
@@ -61,17 +117,18 @@ func main() {
 	bot.Debug = false
 	log.Printf("已授权为机器人: %s", bot.Self.UserName)
 	setupTelegramCommands(bot, adminChatID)
-	// Add
-	dbusEngine, ok := eng.(*dbus_mbim.DBusMBIMEngine)
-	if !ok {
-		log.Fatal("自动化任务需要 'dbus_mbim' 引擎, 但加载了其他类型")
-	}
 
 	autoParams := automation.AutomationParams{
-		Bot:         bot,
-		AdminChatID: adminChatID,
-		Conn:        dbusEngine.Conn,
-		ModemPath:   dbusEngine.GetModemPath(), // 需要为 DBusMBIMEngine 添加一个 Getter
+		Bot:          bot,
+		AdminChatID:  adminChatID,
+		AT:           atHandler,
+		Engine:       eng,
+		SmsRetention: smsRetention,
+		Otp:          automation.OtpConfig{Patterns: otpPatterns, TTL: otpTTL},
+	}
+	// 非 D-Bus 引擎下 Conn 为空, 自动化任务改用 AT 端口
+	if dbusEngine, ok := eng.(*dbus_mbim.DBusMBIMEngine); ok {
+		autoParams.Conn = dbusEngine.Conn
 	}
 
 	for _, task := range automation.GetAll() {
@@ -88,7 +145,18 @@ func main() {
 	log.Println("开始监听 Telegram 更新...")
 
 	for update := range updates {
-		if update.Message == nil || !update.Message.IsCommand() {
+		if update.CallbackQuery != nil {
+			handleCallback(bot, update, eng, adminChatID)
+			continue
+		}
+		if update.Message == nil {
+			continue
+		}
+		if !update.Message.IsCommand() {
+			// 管理员回复短信通知时, 将回复的内容作为短信发出
+			if update.Message.Chat.ID == adminChatID {
+				commands.HandleReply(bot, update, eng)
+			}
 			continue
 		}
 
@@ -106,8 +174,28 @@ func main() {
 		}
 
 		log.Printf("收到来自 %d 的命令: /%s, 参数: %s", update.Message.Chat.ID, cmdName, update.Message.CommandArguments())
-		go cmd.Handler(bot, update, eng)
+		target, err := commands.SelectEngine(&update, cmd, eng)
+		if err != nil {
+			bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "选择调制解调器失败: "+err.Error()))
+			continue
+		}
+		go cmd.Handler(bot, update, target)
+	}
+}
+
+// handleCallback 分发内联按钮的回调, 目前所有按钮都只对管理员开放
+func handleCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine, adminChatID int64) {
+	query := update.CallbackQuery
+	if query.Message == nil || query.Message.Chat.ID != adminChatID {
+		bot.Request(tgbotapi.NewCallback(query.ID, "无权执行此操作。"))
+		return
+	}
+	handler, data, ok := commands.GetCallback(query.Data)
+	if !ok {
+		bot.Request(tgbotapi.NewCallback(query.ID, ""))
+		return
 	}
+	go handler(bot, update, eng, data)
 }
 
 func setupTelegramCommands(bot *tgbotapi.BotAPI, adminChatID int64) {
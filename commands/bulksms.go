@@ -0,0 +1,325 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"tg_modem/engine"
+	"tg_modem/storage"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// BulkSmsInterval 为群发时每条短信之间的默认间隔, 避免被运营商限制
+var BulkSmsInterval = 5 * time.Second
+
+const (
+	// maxBulkRecipients 限制一次群发的收件人数, 使进度消息不超出 Telegram 的消息长度
+	maxBulkRecipients = 50
+	// minBulkInterval 为群发间隔的下限
+	minBulkInterval = time.Second
+	// bulkErrorLimit 为进度中显示的错误信息的最大字符数
+	bulkErrorLimit = 40
+)
+
+const bulkSmsUsage = "用法: `/bulksms [-i <间隔>] <收件人> <内容>`\n" +
+	"收件人为以逗号分隔的号码、联系人名称或 `#组名`, 如 `10086,张三,#家人`\n" +
+	"间隔如 `10s`、`1m`, 默认 %s; 内容可以换行"
+
+const smsGroupUsage = "用法:\n" +
+	"`/smsgroup set <组名> <号码,联系人,...>` - 创建或替换收件人组\n" +
+	"`/smsgroup list` - 查看收件人组\n" +
+	"`/smsgroup del <组名>` - 删除收件人组"
+
+// bulkResult 为一个收件人的发送结果
+type bulkResult struct {
+	recipient string
+	done      bool
+	err       error
+}
+
+// bulkJobs 为进行中的群发, 停止按钮通过 token 引用它, 群发结束时删除
+var bulkJobs = newTokenStore[context.CancelFunc](0)
+
+func init() {
+	Register(Command{
+		Name:        "bulksms",
+		Handler:     handleBulkSms,
+		AdminOnly:   true,
+		Description: "[-i 间隔] <号码,号码|#组名> <内容> - 向多个号码群发短信",
+	})
+	Register(Command{
+		Name:        "smsgroup",
+		Handler:     handleSmsGroup,
+		AdminOnly:   true,
+		Description: "<set|list|del> - 管理群发短信的收件人组",
+		Global:      true,
+	})
+	RegisterCallback("bulksms", handleBulkSmsCallback)
+}
+
+func handleBulkSms(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine) {
+	usage := fmt.Sprintf(bulkSmsUsage, BulkSmsInterval)
+	argsText := update.Message.CommandArguments()
+	interval := BulkSmsInterval
+	if args, rest := cutFields(argsText, 2); len(args) == 2 && args[0] == "-i" {
+		d, err := parseInterval(args[1])
+		if err != nil {
+			reply(bot, update, "❌ "+err.Error()+"\n\n"+usage)
+			return
+		}
+		interval, argsText = d, rest
+	}
+	args, text := cutFields(argsText, 1)
+	if len(args) < 1 || strings.TrimSpace(text) == "" {
+		reply(bot, update, usage)
+		return
+	}
+	recipients, err := resolveRecipients(args[0])
+	if err != nil {
+		reply(bot, update, "❌ "+err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	token := bulkJobs.Add(cancel)
+	defer func() {
+		bulkJobs.Remove(token)
+		cancel()
+	}()
+
+	results := make([]bulkResult, len(recipients))
+	for i, r := range recipients {
+		results[i].recipient = r
+	}
+	chatID := update.Message.Chat.ID
+	stop := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⏹ 停止", "bulksms:"+token),
+	))
+	status := tgbotapi.NewMessage(chatID, renderBulkProgress(results, interval, false, false))
+	status.ReplyMarkup = stop
+	msg, err := bot.Send(status)
+	if err != nil {
+		log.Printf("发送群发进度失败: %v", err)
+	}
+
+	stopped := false
+	for i := range results {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(interval):
+			}
+		}
+		if ctx.Err() != nil {
+			stopped = true
+			break
+		}
+		err := eng.SendSms(results[i].recipient, text)
+		storage.ArchiveSent(eng, results[i].recipient, text, err)
+		if err != nil {
+			log.Printf("群发短信到 %s 失败: %v", results[i].recipient, err)
+		}
+		results[i].done, results[i].err = true, err
+		if i+1 < len(results) {
+			bot.Send(tgbotapi.NewEditMessageTextAndMarkup(chatID, msg.MessageID, renderBulkProgress(results, interval, false, false), stop))
+		}
+	}
+	bot.Send(tgbotapi.NewEditMessageText(chatID, msg.MessageID, renderBulkProgress(results, interval, true, stopped)))
+}
+
+// parseInterval 解析群发间隔, 可为时长 (如 10s) 或秒数
+func parseInterval(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("无效的间隔 %s", inlineCode(s))
+		}
+		d = time.Duration(n) * time.Second
+	}
+	if d < minBulkInterval {
+		return 0, fmt.Errorf("间隔不能小于 %s", minBulkInterval)
+	}
+	return d, nil
+}
+
+// splitRecipients 按逗号或分号分隔收件人
+func splitRecipients(s string) []string {
+	var out []string
+	for _, r := range strings.FieldsFunc(s, func(c rune) bool {
+		return c == ',' || c == '，' || c == ';' || c == '；'
+	}) {
+		if r = strings.TrimSpace(r); r != "" {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// resolveRecipients 展开收件人中的 #组名, 将联系人名称解析为号码并去掉重复的号码
+func resolveRecipients(s string) ([]string, error) {
+	var recipients []string
+	for _, r := range splitRecipients(s) {
+		members := []string{r}
+		if name, ok := strings.CutPrefix(r, "#"); ok {
+			store := storage.Default()
+			if store == nil {
+				return nil, errors.New("未启用数据库, 无法使用收件人组")
+			}
+			g, err := store.Group(name)
+			if err == storage.ErrNotFound {
+				return nil, fmt.Errorf("收件人组 %s 不存在, 见 /smsgroup list", inlineCode(name))
+			}
+			if err != nil {
+				return nil, fmt.Errorf("读取收件人组失败: %w", err)
+			}
+			members = g.Members
+		}
+		for _, m := range members {
+			m, err := resolveRecipient(m)
+			if err != nil {
+				return nil, err
+			}
+			if !slices.Contains(recipients, m) {
+				recipients = append(recipients, m)
+			}
+		}
+	}
+	if len(recipients) == 0 {
+		return nil, errors.New("没有收件人")
+	}
+	if len(recipients) > maxBulkRecipients {
+		return nil, fmt.Errorf("收件人过多 (%d), 一次最多 %d 个", len(recipients), maxBulkRecipients)
+	}
+	return recipients, nil
+}
+
+// renderBulkProgress 显示群发进度和每个收件人的结果。不使用 Markdown, 错误信息原样显示。
+func renderBulkProgress(results []bulkResult, interval time.Duration, finished, stopped bool) string {
+	sent, failed := 0, 0
+	for _, r := range results {
+		switch {
+		case r.done && r.err == nil:
+			sent++
+		case r.done:
+			failed++
+		}
+	}
+
+	var builder strings.Builder
+	switch {
+	case stopped:
+		builder.WriteString(fmt.Sprintf("⏹ 群发已停止: 成功 %d, 失败 %d, 未发送 %d\n", sent, failed, len(results)-sent-failed))
+	case finished:
+		builder.WriteString(fmt.Sprintf("✅ 群发完成: 成功 %d, 失败 %d\n", sent, failed))
+	default:
+		builder.WriteString(fmt.Sprintf("📤 正在群发短信 %d/%d, 间隔 %s\n", sent+failed, len(results), interval))
+	}
+	builder.WriteString("\n")
+
+	next := true
+	for _, r := range results {
+		switch {
+		case r.done && r.err == nil:
+			builder.WriteString("✅ " + r.recipient)
+		case r.done:
+			errText := []rune(r.err.Error())
+			if len(errText) > bulkErrorLimit {
+				errText = append(errText[:bulkErrorLimit], '…')
+			}
+			builder.WriteString("❌ " + r.recipient + ": " + string(errText))
+		case next && !finished:
+			builder.WriteString("⏳ " + r.recipient)
+			next = false
+		default:
+			builder.WriteString("▫️ " + r.recipient)
+		}
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// handleBulkSmsCallback 处理停止按钮, data 为群发的 token
+func handleBulkSmsCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine, data string) {
+	query := update.CallbackQuery
+	cancel, ok := bulkJobs.Get(data)
+	if !ok {
+		bot.Request(tgbotapi.NewCallback(query.ID, "群发已结束"))
+		return
+	}
+	cancel()
+	bot.Request(tgbotapi.NewCallback(query.ID, "正在停止群发..."))
+}
+
+func handleSmsGroup(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine) {
+	store := storage.Default()
+	if store == nil {
+		reply(bot, update, "未启用数据库, 无法使用收件人组。")
+		return
+	}
+
+	args, rest := cutFields(update.Message.CommandArguments(), 2)
+	if len(args) == 0 {
+		reply(bot, update, smsGroupUsage)
+		return
+	}
+	name := ""
+	if len(args) > 1 {
+		name = strings.TrimPrefix(args[1], "#")
+	}
+	switch {
+	case args[0] == "list":
+		listGroups(bot, update, store)
+	case args[0] == "set" && name != "":
+		members := splitRecipients(rest)
+		if len(members) == 0 {
+			reply(bot, update, smsGroupUsage)
+			return
+		}
+		if len(members) > maxBulkRecipients {
+			reply(bot, update, fmt.Sprintf("收件人过多 (%d), 一个组最多 %d 个。", len(members), maxBulkRecipients))
+			return
+		}
+		if err := store.SetGroup(&storage.Group{Name: name, Members: members}); err != nil {
+			log.Printf("保存收件人组失败: %v", err)
+			reply(bot, update, "保存收件人组失败: "+err.Error())
+			return
+		}
+		reply(bot, update, fmt.Sprintf("✅ 收件人组 %s 已保存, 共 %d 个号码。群发时使用 %s", inlineCode(name), len(members), inlineCode("#"+name)))
+	case (args[0] == "del" || args[0] == "delete") && name != "":
+		switch err := store.RemoveGroup(name); err {
+		case nil:
+			reply(bot, update, fmt.Sprintf("✅ 收件人组 %s 已删除。", inlineCode(name)))
+		case storage.ErrNotFound:
+			reply(bot, update, fmt.Sprintf("收件人组 %s 不存在。", inlineCode(name)))
+		default:
+			reply(bot, update, "删除收件人组失败: "+err.Error())
+		}
+	default:
+		reply(bot, update, smsGroupUsage)
+	}
+}
+
+func listGroups(bot *tgbotapi.BotAPI, update tgbotapi.Update, store *storage.Store) {
+	groups, err := store.Groups()
+	if err != nil {
+		reply(bot, update, "读取收件人组失败: "+err.Error())
+		return
+	}
+	if len(groups) == 0 {
+		reply(bot, update, "没有收件人组。使用 `/smsgroup set <组名> <号码,号码,...>` 创建。")
+		return
+	}
+	var builder strings.Builder
+	builder.WriteString("👥 *收件人组*\n\n")
+	for _, g := range groups {
+		builder.WriteString(fmt.Sprintf("%s (%d): %s\n", inlineCode("#"+g.Name), len(g.Members), inlineCode(strings.Join(g.Members, ","))))
+	}
+	reply(bot, update, builder.String())
+}
@@ -2,8 +2,7 @@ package commands
 
 import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/godbus/dbus/v5"
-	"sync"
+	"strings"
 	"tg_modem/engine"
 )
 
@@ -16,12 +15,10 @@ type Command struct {
 	Handler     CommandHandler
 	AdminOnly   bool
 	Description string
+	// Global 表示命令不针对某个调制解调器, 不解析 @modem 参数, 收到的是默认引擎
+	Global bool
 }
 
-var (
-	userSmsCache = make(map[int64]map[string]dbus.ObjectPath)
-	cacheMutex   = &sync.Mutex{}
-)
 var commandRegistry = make(map[string]Command)
 
 // Register 用于注册一个命令
@@ -42,3 +39,20 @@ func Get(name string) (Command, bool) {
 func GetAll() map[string]Command {
 	return commandRegistry
 }
+
+// CallbackHandler 定义了内联按钮回调处理函数的签名, data 为去掉前缀后的回调数据
+type CallbackHandler func(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine, data string)
+
+var callbackRegistry = make(map[string]CallbackHandler)
+
+// RegisterCallback 注册以 "prefix:" 开头的回调数据的处理函数
+func RegisterCallback(prefix string, handler CallbackHandler) {
+	callbackRegistry[prefix] = handler
+}
+
+// GetCallback 返回回调数据对应的处理函数和去掉前缀后的数据
+func GetCallback(data string) (CallbackHandler, string, bool) {
+	prefix, rest, _ := strings.Cut(data, ":")
+	handler, ok := callbackRegistry[prefix]
+	return handler, rest, ok
+}
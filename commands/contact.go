@@ -0,0 +1,366 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/quotedprintable"
+	"net/http"
+	"net/url"
+	"strings"
+	"tg_modem/engine"
+	"tg_modem/storage"
+	"time"
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// maxVCardSize 限制导入的 vCard 文件大小
+	maxVCardSize = 1 << 20
+	// contactListChunk 为联系人列表每条消息的最大长度, 避免超出 Telegram 的消息长度
+	contactListChunk = 3500
+	maxContactName   = 64
+)
+
+const contactUsage = "用法:\n" +
+	"`/contact add <号码> <名称>` - 添加或修改联系人\n" +
+	"`/contact del <号码|名称>` - 删除联系人\n" +
+	"`/contact list` - 查看通讯录\n" +
+	"`/contact import` - 回复一个 .vcf 文件, 导入其中的联系人\n\n" +
+	"发送短信时可以用联系人名称代替号码, 如 `/sendsms 张三 晚上见`"
+
+var vcardClient = &http.Client{Timeout: 30 * time.Second}
+
+func init() {
+	Register(Command{
+		Name:        "contact",
+		Handler:     handleContact,
+		AdminOnly:   true,
+		Description: "<add|del|list|import> - 管理通讯录",
+		Global:      true,
+	})
+}
+
+func handleContact(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine) {
+	store := storage.Default()
+	if store == nil {
+		reply(bot, update, "未启用数据库, 无法使用通讯录。")
+		return
+	}
+
+	sub, args := cutFields(update.Message.CommandArguments(), 1)
+	if len(sub) == 0 {
+		reply(bot, update, contactUsage)
+		return
+	}
+	switch sub[0] {
+	case "add":
+		addContact(bot, update, store, args)
+	case "del", "delete":
+		deleteContact(bot, update, store, strings.TrimSpace(args))
+	case "list":
+		listContacts(bot, update, store)
+	case "import":
+		importContacts(bot, update, store)
+	default:
+		reply(bot, update, contactUsage)
+	}
+}
+
+func addContact(bot *tgbotapi.BotAPI, update tgbotapi.Update, store *storage.Store, args string) {
+	fields, name := cutFields(args, 1)
+	name = strings.Join(strings.Fields(name), " ")
+	if len(fields) == 0 || name == "" || !looksLikeNumber(fields[0]) {
+		reply(bot, update, contactUsage)
+		return
+	}
+	if err := validateContactName(name); err != nil {
+		reply(bot, update, "❌ "+err.Error())
+		return
+	}
+	contacts := []storage.Contact{{Name: name, Number: fields[0]}}
+	if err := store.SetContacts(contacts); err != nil {
+		log.Printf("保存联系人失败: %v", err)
+		reply(bot, update, "保存联系人失败: "+err.Error())
+		return
+	}
+	reply(bot, update, "✅ 已保存联系人 "+storage.Default().DescribeNumber(contacts[0].Number))
+}
+
+// validateContactName 检查联系人名称, 名称会出现在以逗号分隔的群发收件人中
+func validateContactName(name string) error {
+	switch {
+	case len([]rune(name)) > maxContactName:
+		return fmt.Errorf("名称过长, 最多 %d 个字符", maxContactName)
+	case strings.ContainsAny(name, ",，;；"):
+		return errors.New("名称不能包含逗号或分号")
+	case strings.ContainsAny(name[:1], "#@-"):
+		return errors.New("名称不能以 # @ - 开头")
+	case looksLikeNumber(name):
+		return errors.New("名称不能是号码")
+	}
+	return nil
+}
+
+func deleteContact(bot *tgbotapi.BotAPI, update tgbotapi.Update, store *storage.Store, arg string) {
+	if arg == "" {
+		reply(bot, update, contactUsage)
+		return
+	}
+	number := arg
+	if !looksLikeNumber(arg) {
+		contacts, err := store.FindContacts(arg)
+		if err != nil {
+			reply(bot, update, "读取通讯录失败: "+err.Error())
+			return
+		}
+		var exact []storage.Contact
+		for _, c := range contacts {
+			if strings.EqualFold(c.Name, arg) {
+				exact = append(exact, c)
+			}
+		}
+		switch len(exact) {
+		case 0:
+			reply(bot, update, "找不到联系人 "+inlineCode(arg))
+			return
+		case 1:
+			number = exact[0].Number
+		default:
+			reply(bot, update, fmt.Sprintf("%s 有 %d 个号码, 请用号码删除: %s", inlineCode(arg), len(exact), contactNumbers(exact)))
+			return
+		}
+	}
+
+	described := storage.Default().DescribeNumber(storage.NormalizeNumber(number))
+	switch err := store.RemoveContact(number); err {
+	case nil:
+		reply(bot, update, "✅ 已删除联系人 "+described)
+	case storage.ErrNotFound:
+		reply(bot, update, "通讯录中没有号码 "+inlineCode(number))
+	default:
+		reply(bot, update, "删除联系人失败: "+err.Error())
+	}
+}
+
+func listContacts(bot *tgbotapi.BotAPI, update tgbotapi.Update, store *storage.Store) {
+	contacts, err := store.Contacts()
+	if err != nil {
+		reply(bot, update, "读取通讯录失败: "+err.Error())
+		return
+	}
+	if len(contacts) == 0 {
+		reply(bot, update, "通讯录为空。使用 `/contact add <号码> <名称>` 添加或 `/contact import` 导入。")
+		return
+	}
+
+	// 联系人较多时分成多条消息发送
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("📇 *通讯录* (%d)\n\n", len(contacts)))
+	for _, c := range contacts {
+		line := storage.Default().DescribeNumber(c.Number) + "\n"
+		if builder.Len()+len(line) > contactListChunk {
+			reply(bot, update, builder.String())
+			builder.Reset()
+		}
+		builder.WriteString(line)
+	}
+	reply(bot, update, builder.String())
+}
+
+// importContacts 导入被回复的 vCard 文件中的联系人
+func importContacts(bot *tgbotapi.BotAPI, update tgbotapi.Update, store *storage.Store) {
+	replied := update.Message.ReplyToMessage
+	if replied == nil || replied.Document == nil {
+		reply(bot, update, "请回复一个 .vcf 文件并发送 `/contact import`。")
+		return
+	}
+	if replied.Document.FileSize > maxVCardSize {
+		reply(bot, update, "文件过大, 最多 1MB。")
+		return
+	}
+
+	fileURL, err := bot.GetFileDirectURL(replied.Document.FileID)
+	var data []byte
+	if err == nil {
+		var resp *http.Response
+		resp, err = vcardClient.Get(fileURL)
+		if err == nil {
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("HTTP %s", resp.Status)
+			} else {
+				data, err = io.ReadAll(io.LimitReader(resp.Body, maxVCardSize))
+			}
+			resp.Body.Close()
+		}
+	}
+	if err != nil {
+		err = withoutURL(err)
+		log.Printf("下载 vCard 文件失败: %v", err)
+		reply(bot, update, "下载文件失败: "+err.Error())
+		return
+	}
+
+	contacts, skipped := parseVCards(string(data))
+	if len(contacts) == 0 {
+		reply(bot, update, "文件中没有带号码的联系人。")
+		return
+	}
+	if err := store.SetContacts(contacts); err != nil {
+		log.Printf("导入联系人失败: %v", err)
+		reply(bot, update, "导入联系人失败: "+err.Error())
+		return
+	}
+	text := fmt.Sprintf("✅ 已导入 %d 个号码", len(contacts))
+	if skipped > 0 {
+		text += fmt.Sprintf(", 跳过 %d 个没有名称或名称无效的联系人", skipped)
+	}
+	reply(bot, update, text)
+}
+
+// withoutURL 去掉请求错误中的 URL: 文件下载链接和 Bot API 的地址中都含有 bot token
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// parseVCards 解析 vCard (2.1/3.0/4.0) 文件, 每个号码为一个联系人, 返回联系人和跳过的 vCard 数
+func parseVCards(data string) ([]storage.Contact, int) {
+	// 展开折行: 以空白开头的行接在上一行后面, quoted-printable 以 = 结尾的行接下一行
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		if n := len(lines); n > 0 {
+			last := lines[n-1]
+			if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+				lines[n-1] = last + line[1:]
+				continue
+			}
+			if strings.HasSuffix(last, "=") && strings.Contains(strings.ToUpper(last), "QUOTED-PRINTABLE") {
+				lines[n-1] = last[:len(last)-1] + "=\n" + line
+				continue
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	var (
+		contacts         []storage.Contact
+		skipped          int
+		name, structured string
+		numbers          []string
+	)
+	for _, line := range lines {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		params := strings.Split(key, ";")
+		prop := strings.ToUpper(params[0])
+		if i := strings.LastIndex(prop, "."); i >= 0 {
+			prop = prop[i+1:] // 分组前缀, 如 item1.TEL
+		}
+		switch prop {
+		case "BEGIN":
+			name, structured, numbers = "", "", nil
+		case "FN":
+			name = vcardValue(params[1:], value)
+		case "N":
+			// 姓;名;中间名;前缀;后缀
+			parts := strings.Split(vcardValue(params[1:], value), ";")
+			for len(parts) < 2 {
+				parts = append(parts, "")
+			}
+			if isASCII(parts[0] + parts[1]) {
+				structured = strings.TrimSpace(parts[1] + " " + parts[0])
+			} else {
+				structured = strings.TrimSpace(parts[0] + parts[1])
+			}
+		case "TEL":
+			if number := strings.TrimSpace(strings.TrimPrefix(value, "tel:")); number != "" {
+				numbers = append(numbers, number)
+			}
+		case "END":
+			if name == "" {
+				name = structured
+			}
+			// 名称中的逗号和分号会被当作群发收件人的分隔符
+			name = strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+				return unicode.IsSpace(r) || strings.ContainsRune(",，;；", r)
+			}), " ")
+			if len(numbers) == 0 {
+				continue
+			}
+			if name == "" || validateContactName(name) != nil {
+				skipped++
+				continue
+			}
+			for _, number := range numbers {
+				contacts = append(contacts, storage.Contact{Name: name, Number: number})
+			}
+		}
+	}
+	return contacts, skipped
+}
+
+// vcardValue 解码 quoted-printable 编码的值并去掉转义
+func vcardValue(params []string, value string) string {
+	for _, p := range params {
+		if p = strings.ToUpper(p); p == "ENCODING=QUOTED-PRINTABLE" || p == "QUOTED-PRINTABLE" {
+			if decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(value))); err == nil {
+				value = string(decoded)
+			}
+			break
+		}
+	}
+	return strings.NewReplacer(`\,`, ",", `\;`, ";", `\\`, `\`, `\n`, " ").Replace(value)
+}
+
+// isASCII 判断名称是否为拉丁字母, 拉丁字母的名称为 "名 姓", 中文等为 "姓名"
+func isASCII(s string) bool {
+	for _, r := range s {
+		if r > unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
+
+// looksLikeNumber 判断收件人是号码而不是联系人名称
+func looksLikeNumber(s string) bool {
+	s = strings.TrimPrefix(s, "+")
+	return s != "" && strings.Trim(s, "0123456789-() ") == ""
+}
+
+// resolveRecipient 将收件人解析为号码: 号码原样返回, 否则按名称查找联系人
+func resolveRecipient(s string) (string, error) {
+	store := storage.Default()
+	if looksLikeNumber(s) || store == nil {
+		return s, nil
+	}
+	contacts, err := store.FindContacts(s)
+	if err != nil {
+		return "", fmt.Errorf("读取通讯录失败: %w", err)
+	}
+	switch len(contacts) {
+	case 0:
+		return "", fmt.Errorf("找不到联系人 %s", inlineCode(s))
+	case 1:
+		return contacts[0].Number, nil
+	}
+	return "", fmt.Errorf("%s 匹配多个联系人, 请用号码或完整的名称: %s", inlineCode(s), contactNumbers(contacts))
+}
+
+// contactNumbers 列出联系人的名称和号码
+func contactNumbers(contacts []storage.Contact) string {
+	var items []string
+	for _, c := range contacts {
+		items = append(items, inlineCode(c.Name+" "+c.Number))
+	}
+	return strings.Join(items, ", ")
+}
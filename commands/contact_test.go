@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"tg_modem/storage"
+)
+
+func TestWithoutURLHidesToken(t *testing.T) {
+	timeout := errors.New("i/o timeout")
+	err := fmt.Errorf("getFile: %w", &url.Error{
+		Op:  "Get",
+		URL: "https://api.telegram.org/file/bot123456:SECRET/documents/file_1.vcf",
+		Err: timeout,
+	})
+
+	got := withoutURL(err)
+	if strings.Contains(got.Error(), "SECRET") {
+		t.Fatalf("withoutURL(%v) = %v, still contains the token", err, got)
+	}
+	if !errors.Is(got, timeout) {
+		t.Errorf("withoutURL(%v) = %v, want the underlying error", err, got)
+	}
+
+	plain := errors.New("HTTP 404 Not Found")
+	if got := withoutURL(plain); got != plain {
+		t.Errorf("withoutURL(%v) = %v, want it unchanged", plain, got)
+	}
+}
+
+func TestParseVCards(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []storage.Contact
+		skipped int
+	}{
+		{
+			name: "folded lines",
+			data: "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Alice Wonder\r\n land\r\nTEL;TYPE=CELL:+86 138\r\n\t0013 8000\r\nEND:VCARD\r\n",
+			want: []storage.Contact{{Name: "Alice Wonderland", Number: "+86 1380013 8000"}},
+		},
+		{
+			name: "quoted-printable",
+			data: "BEGIN:VCARD\nVERSION:2.1\n" +
+				"N;CHARSET=UTF-8;ENCODING=QUOTED-PRINTABLE:=E6=9D=8E;=E5=9B=9B;;;\n" +
+				"FN;CHARSET=UTF-8;ENCODING=QUOTED-PRINTABLE:=E5=BC=A0=E4=B8=\n=89\n" +
+				"TEL;CELL:13800138000\nEND:VCARD\n",
+			want: []storage.Contact{{Name: "张三", Number: "13800138000"}},
+		},
+		{
+			name: "structured name only",
+			data: "BEGIN:VCARD\nVERSION:2.1\nN;ENCODING=QUOTED-PRINTABLE:=E6=9D=8E;=E5=9B=9B;;;\nTEL:10086\nEND:VCARD\n" +
+				"BEGIN:VCARD\nVERSION:3.0\nN:Smith;John;;;\nTEL:10010\nEND:VCARD\n",
+			want: []storage.Contact{{Name: "李四", Number: "10086"}, {Name: "John Smith", Number: "10010"}},
+		},
+		{
+			name: "grouped properties",
+			data: "BEGIN:VCARD\nVERSION:3.0\nFN:Bob\n" +
+				"item1.TEL;type=pref:+1 (555) 010-0000\nitem1.X-ABLabel:work\n" +
+				"TEL;VALUE=uri;TYPE=home:tel:+15550100001\nEND:VCARD\n",
+			want: []storage.Contact{{Name: "Bob", Number: "+1 (555) 010-0000"}, {Name: "Bob", Number: "+15550100001"}},
+		},
+		{
+			name: "separators in names",
+			data: "BEGIN:VCARD\nVERSION:3.0\nFN:Li\\, Lei；Han\nTEL:13800138000\nEND:VCARD\n",
+			want: []storage.Contact{{Name: "Li Lei Han", Number: "13800138000"}},
+		},
+		{
+			name: "without number or name",
+			data: "BEGIN:VCARD\nVERSION:3.0\nFN:Nobody\nEND:VCARD\n" +
+				"BEGIN:VCARD\nVERSION:3.0\nTEL:13800138000\nEND:VCARD\n",
+			skipped: 1,
+		},
+	}
+	for _, tt := range tests {
+		got, skipped := parseVCards(tt.data)
+		if len(got) != len(tt.want) || skipped != tt.skipped {
+			t.Errorf("%s: got %+v, %d skipped, want %+v, %d skipped", tt.name, got, skipped, tt.want, tt.skipped)
+			continue
+		}
+		for i := range got {
+			if got[i].Name != tt.want[i].Name || got[i].Number != tt.want[i].Number {
+				t.Errorf("%s: contact %d = %+v, want %+v", tt.name, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
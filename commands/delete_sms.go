@@ -26,19 +26,14 @@ func handleDeleteSms(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.En
 
 	chatID := update.Message.Chat.ID
 
-	// 从缓存中查找短信路径
-	cacheMutex.Lock()
-	userMessages, ok := userSmsCache[chatID]
-	if !ok || len(userMessages) == 0 {
-		cacheMutex.Unlock()
-		msg := tgbotapi.NewMessage(chatID, "未找到短信列表缓存. 请先运行 /sms 命令。")
-		bot.Send(msg)
+	// 短信 ID 为存储序号或 D-Bus 路径的结尾, 重新读取列表以找到对应的标识
+	result, err := eng.ListSms()
+	if err != nil {
+		log.Printf("读取短信失败: %v", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "读取短信失败: "+err.Error()))
 		return
 	}
-
-	smsPath, found := userMessages[smsID]
-	cacheMutex.Unlock() // 尽快释放锁
-
+	smsPath, found := result.Messages[smsID]
 	if !found {
 		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("无效的短信ID: %s. 请运行 /sms 查看可用ID。", smsID))
 		bot.Send(msg)
@@ -46,7 +41,7 @@ func handleDeleteSms(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.En
 	}
 
 	// 执行删除操作
-	err := eng.DeleteSms(smsPath)
+	err = eng.DeleteSms(smsPath)
 	var replyText string
 	if err != nil {
 		log.Printf("删除短信 %s (ID: %s) 失败: %v", smsPath, smsID, err)
@@ -54,13 +49,6 @@ func handleDeleteSms(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.En
 	} else {
 		log.Printf("成功删除短信 %s (ID: %s)", smsPath, smsID)
 		replyText = fmt.Sprintf("✅ 短信 ID %s 已成功删除。", smsID)
-
-		// 从缓存中移除已删除的短信，防止重复删除
-		cacheMutex.Lock()
-		if userMessages, ok := userSmsCache[chatID]; ok {
-			delete(userMessages, smsID)
-		}
-		cacheMutex.Unlock()
 	}
 
 	msg := tgbotapi.NewMessage(chatID, replyText)
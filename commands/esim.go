@@ -1,10 +1,13 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"tg_modem/engine"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
@@ -14,7 +17,7 @@ func init() {
 		Name:        "esim",
 		Handler:     handleEsim,
 		AdminOnly:   true,
-		Description: "[AT] eSIM配置管理",
+		Description: "[AT] eSIM配置管理 <info|list|enable|disable|delete|nickname|download|notifications>",
 	})
 }
 
@@ -32,21 +35,42 @@ func handleEsim(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine)
 	switch subcommand {
 	case "info":
 		handleEsimInfo(bot, update, atEngine)
-	// Download is too complex for a simple AT command handler for now.
-	// case "switch":
-	// 	if len(args) < 2 {
-	// 		reply(bot, update, "用法: /esim switch <ICCID>")
-	// 		return
-	// 	}
-	// 	handleEsimSwitch(bot, update, atEngine, args[1])
-	// case "delete":
-	// 	if len(args) < 2 {
-	// 		reply(bot, update, "用法: /esim delete <ICCID>")
-	// 		return
-	// 	}
-	// 	handleEsimDelete(bot, update, atEngine, args[1])
+	case "list":
+		handleEsimList(bot, update, atEngine)
+	case "enable", "disable", "delete":
+		if len(args) < 2 || strings.TrimSpace(args[1]) == "" {
+			reply(bot, update, fmt.Sprintf("用法: /esim %s <ICCID>", subcommand))
+			return
+		}
+		handleEsimProfileOp(bot, update, atEngine, subcommand, strings.TrimSpace(args[1]))
+	case "nickname":
+		var iccid, nickname string
+		if len(args) == 2 {
+			iccid, nickname, _ = strings.Cut(strings.TrimSpace(args[1]), " ")
+		}
+		if iccid == "" {
+			reply(bot, update, "用法: /esim nickname <ICCID> [昵称]")
+			return
+		}
+		handleEsimNickname(bot, update, atEngine, iccid, strings.TrimSpace(nickname))
+	case "download":
+		var code, confirmation string
+		if len(args) == 2 {
+			code, confirmation, _ = strings.Cut(strings.TrimSpace(args[1]), " ")
+		}
+		if code == "" {
+			reply(bot, update, "用法: /esim download <激活码 LPA:1$...> [确认码]")
+			return
+		}
+		handleEsimDownload(bot, update, atEngine, code, strings.TrimSpace(confirmation))
+	case "notifications":
+		var sub []string
+		if len(args) == 2 {
+			sub = strings.Fields(args[1])
+		}
+		handleEsimNotifications(bot, update, atEngine, sub)
 	default:
-		reply(bot, update, "未知的esim子命令")
+		reply(bot, update, "未知的esim子命令\n用法: /esim <info|list|enable|disable|delete|nickname|download|notifications>")
 	}
 }
 
@@ -65,21 +89,238 @@ func handleEsimInfo(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.ATE
 
 	status, err := eng.GetEsimStatus()
 	if err != nil {
-		log.Println("查询eSIM Status失败:%v", err)
+		log.Printf("查询eSIM Status失败: %v", err)
 	} else {
 		builder.WriteString(fmt.Sprintf("eSIM状态: %s\n", status))
 	}
 
 	eid, err := eng.GetEsimEID()
 	if err != nil {
-		log.Println("查询eSIM EID失败:%v", err)
+		log.Printf("查询eSIM EID失败: %v", err)
 	} else {
 		builder.WriteString(fmt.Sprintf("EID: %s\n", eid))
 	}
 
+	info, err := eng.GetEuiccInfo()
+	if err != nil {
+		log.Printf("查询eUICC信息失败: %v", err)
+	} else {
+		if info.FirmwareVersion != "" {
+			builder.WriteString(fmt.Sprintf("eUICC固件: %s (SVN %s)\n", info.FirmwareVersion, info.SVN))
+		}
+		if info.FreeNonVolatileMemory >= 0 {
+			builder.WriteString(fmt.Sprintf("剩余空间: %d KB\n", info.FreeNonVolatileMemory/1024))
+		}
+	}
+
+	bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, builder.String()))
+}
+
+func handleEsimList(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.ATEngine) {
+	msg, _ := bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "⏳ 正在读取 eSIM Profile 列表..."))
+
+	profiles, err := eng.ListEsimProfiles()
+	if err != nil {
+		log.Printf("读取eSIM Profile列表失败: %v", err)
+		bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, "❌ 读取失败: "+err.Error()))
+		return
+	}
+	if len(profiles) == 0 {
+		bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, "eUICC 上没有任何 Profile。"))
+		return
+	}
+
+	var builder strings.Builder
+	builder.WriteString("📋 eSIM Profiles:\n")
+	for _, p := range profiles {
+		state := "⚪️ 已禁用"
+		if p.Enabled {
+			state = "🟢 已启用"
+		}
+		name := p.Nickname
+		if name == "" {
+			name = p.Name
+		}
+		builder.WriteString(fmt.Sprintf("\n%s %s\n", state, name))
+		builder.WriteString(fmt.Sprintf("ICCID: %s\n", p.ICCID))
+		if p.ServiceProvider != "" {
+			builder.WriteString(fmt.Sprintf("运营商: %s\n", p.ServiceProvider))
+		}
+		if p.Class != "" && p.Class != "operational" {
+			builder.WriteString(fmt.Sprintf("类型: %s\n", p.Class))
+		}
+	}
 	bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, builder.String()))
 }
 
+func handleEsimProfileOp(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.ATEngine, op, iccid string) {
+	actions := map[string]struct {
+		name string
+		fn   func(string) error
+	}{
+		"enable":  {"启用", eng.EnableEsimProfile},
+		"disable": {"禁用", eng.DisableEsimProfile},
+		"delete":  {"删除", eng.DeleteEsimProfile},
+	}
+	action := actions[op]
+
+	msg, _ := bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("⏳ 正在%s Profile %s...", action.name, iccid)))
+	if err := action.fn(iccid); err != nil {
+		log.Printf("%s eSIM Profile %s 失败: %v", action.name, iccid, err)
+		bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, fmt.Sprintf("❌ %s失败: %s", action.name, err.Error())))
+		return
+	}
+	bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, fmt.Sprintf("✅ 已%s Profile %s", action.name, iccid)))
+}
+
+func handleEsimNickname(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.ATEngine, iccid, nickname string) {
+	msg, _ := bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "⏳ 正在设置 Profile 昵称..."))
+	if err := eng.SetEsimNickname(iccid, nickname); err != nil {
+		log.Printf("设置 eSIM Profile %s 昵称失败: %v", iccid, err)
+		bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, "❌ 设置失败: "+err.Error()))
+		return
+	}
+	bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, fmt.Sprintf("✅ Profile %s 的昵称已设置为 \"%s\"", iccid, nickname)))
+}
+
+// esimDownloadTimeout 限制整个下载流程 (含 SM-DP+ 网络请求) 的耗时
+const esimDownloadTimeout = 5 * time.Minute
+
+var downloadStageText = map[engine.DownloadStage]string{
+	engine.DownloadAuthenticateServer: "正在验证 SM-DP+ 服务器",
+	engine.DownloadAuthenticateClient: "正在验证 eUICC",
+	engine.DownloadPrepare:            "正在准备下载",
+	engine.DownloadFetchPackage:       "正在获取 Profile 数据包",
+	engine.DownloadInstall:            "正在安装 Profile",
+	engine.DownloadNotify:             "正在通知 SM-DP+ 安装结果",
+}
+
+func handleEsimDownload(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.ATEngine, code, confirmation string) {
+	msg, _ := bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "⏳ 正在开始下载 eSIM Profile..."))
+
+	ctx, cancel := context.WithTimeout(context.Background(), esimDownloadTimeout)
+	defer cancel()
+
+	progress := func(p engine.DownloadProgress) {
+		text := fmt.Sprintf("⏳ [%d/%d] %s...", p.Stage, len(downloadStageText), downloadStageText[p.Stage])
+		if p.Profile != nil {
+			text += fmt.Sprintf("\nProfile: %s (%s)\nICCID: %s", p.Profile.Name, p.Profile.ServiceProvider, p.Profile.ICCID)
+		}
+		bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, text))
+	}
+
+	profile, err := eng.DownloadEsimProfile(ctx, code, confirmation, progress)
+	if err != nil {
+		log.Printf("下载 eSIM Profile 失败: %v", err)
+		bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, "❌ 下载失败: "+err.Error()))
+		return
+	}
+	text := fmt.Sprintf("✅ Profile 已安装\n名称: %s\n运营商: %s\nICCID: %s\n\n使用 /esim enable %s 启用。",
+		profile.Name, profile.ServiceProvider, profile.ICCID, profile.ICCID)
+	bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, text))
+}
+
+var notificationOperationText = map[string]string{
+	"install": "安装",
+	"enable":  "启用",
+	"disable": "禁用",
+	"delete":  "删除",
+}
+
+// handleEsimNotifications 处理 /esim notifications [list|process [seq]|remove <seq>]
+func handleEsimNotifications(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.ATEngine, args []string) {
+	action := "list"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	var seq int
+	hasSeq := false
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			reply(bot, update, "无效的通知序号: "+args[1])
+			return
+		}
+		seq, hasSeq = n, true
+	}
+
+	switch action {
+	case "list":
+		msg, _ := bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "⏳ 正在读取 eSIM 待发送通知..."))
+		list, err := eng.ListEsimNotifications()
+		if err != nil {
+			log.Printf("读取eSIM通知失败: %v", err)
+			bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, "❌ 读取失败: "+err.Error()))
+			return
+		}
+		if len(list) == 0 {
+			bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, "没有待发送的 eSIM 通知。"))
+			return
+		}
+		var builder strings.Builder
+		builder.WriteString("📮 待发送的 eSIM 通知:\n")
+		for _, n := range list {
+			op := notificationOperationText[n.Operation]
+			if op == "" {
+				op = n.Operation
+			}
+			builder.WriteString(fmt.Sprintf("\n#%d %s ICCID: %s\nSM-DP+: %s\n", n.Seq, op, n.ICCID, n.Address))
+		}
+		builder.WriteString("\n使用 /esim notifications process [序号] 发送, remove <序号> 删除。")
+		bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, builder.String()))
+
+	case "process":
+		msg, _ := bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "⏳ 正在发送 eSIM 通知..."))
+		var seqs []int
+		if hasSeq {
+			seqs = []int{seq}
+		} else {
+			list, err := eng.ListEsimNotifications()
+			if err != nil {
+				log.Printf("读取eSIM通知失败: %v", err)
+				bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, "❌ 读取失败: "+err.Error()))
+				return
+			}
+			for _, n := range list {
+				seqs = append(seqs, n.Seq)
+			}
+		}
+		if len(seqs) == 0 {
+			bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, "没有待发送的 eSIM 通知。"))
+			return
+		}
+
+		var builder strings.Builder
+		for _, s := range seqs {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			err := eng.ProcessEsimNotification(ctx, s)
+			cancel()
+			if err != nil {
+				log.Printf("发送eSIM通知 %d 失败: %v", s, err)
+				builder.WriteString(fmt.Sprintf("❌ #%d: %s\n", s, err.Error()))
+			} else {
+				builder.WriteString(fmt.Sprintf("✅ #%d 已发送并移除\n", s))
+			}
+		}
+		bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, builder.String()))
+
+	case "remove":
+		if !hasSeq {
+			reply(bot, update, "用法: /esim notifications remove <序号>")
+			return
+		}
+		if err := eng.RemoveEsimNotification(seq); err != nil {
+			log.Printf("删除eSIM通知 %d 失败: %v", seq, err)
+			reply(bot, update, "❌ 删除失败: "+err.Error())
+			return
+		}
+		reply(bot, update, fmt.Sprintf("✅ 通知 #%d 已删除。", seq))
+
+	default:
+		reply(bot, update, "用法: /esim notifications [list|process [序号]|remove <序号>]")
+	}
+}
+
 func reply(bot *tgbotapi.BotAPI, update tgbotapi.Update, text string) {
 	msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
 	msg.ParseMode = "Markdown"
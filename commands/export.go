@@ -0,0 +1,348 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"tg_modem/engine"
+	"tg_modem/storage"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const smsExportUsage = "格式错误. 请使用: `/smsexport [格式] [起始时间]`\n" +
+	"格式: `csv` (默认)、`json` (每行一条的 JSON Lines) 或 `xml` (Android \"SMS Backup & Restore\" 可导入)\n" +
+	"起始时间如 `7d`、`12h` 或 `2024-01-31`"
+
+// 导出的短信来源
+const (
+	exportFromArchive = "archive"
+	exportFromModem   = "modem"
+)
+
+// exportedSms 为导出的一条短信, 来自归档或调制解调器上的短信
+type exportedSms struct {
+	Timestamp time.Time         `json:"timestamp,omitzero"` // 调制解调器上发出的短信没有时间戳
+	Direction storage.Direction `json:"direction"`
+	Number    string            `json:"number"`
+	Name      string            `json:"name,omitempty"` // 联系人名称
+	Text      string            `json:"text"`
+	State     string            `json:"state,omitempty"`
+	SMSC      string            `json:"smsc,omitempty"`
+	Modem     string            `json:"modem,omitempty"`
+	SIM       string            `json:"sim,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+	Source    string            `json:"source"`
+}
+
+// smsExporters 将短信编码为各格式的文件, key 为格式名称
+var smsExporters = map[string]struct {
+	ext    string
+	encode func([]exportedSms) ([]byte, error)
+}{
+	"csv":  {"csv", exportCsv},
+	"json": {"jsonl", exportJsonLines},
+	"xml":  {"xml", exportBackupXml},
+}
+
+func init() {
+	Register(Command{
+		Name:        "smsexport",
+		Handler:     handleSmsExport,
+		AdminOnly:   true,
+		Description: "[csv|json|xml] [起始时间] - 导出短信为文件",
+	})
+}
+
+func handleSmsExport(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine) {
+	format := "csv"
+	var since time.Time
+	for _, arg := range strings.Fields(update.Message.CommandArguments()) {
+		arg = strings.ToLower(arg)
+		if arg == "jsonl" {
+			arg = "json"
+		}
+		if _, ok := smsExporters[arg]; ok {
+			format = arg
+			continue
+		}
+		t, err := parseSince(arg)
+		if err != nil || !since.IsZero() {
+			reply(bot, update, smsExportUsage)
+			return
+		}
+		since = t
+	}
+
+	messages, notes := collectSmsForExport(eng, since)
+	if len(messages) == 0 {
+		text := "没有可导出的短信。"
+		if len(notes) > 0 {
+			text += "\n" + strings.Join(notes, "\n")
+		}
+		bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, text))
+		return
+	}
+
+	exporter := smsExporters[format]
+	data, err := exporter.encode(messages)
+	if err != nil {
+		log.Printf("导出短信失败: %v", err)
+		bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "导出短信失败: "+err.Error()))
+		return
+	}
+
+	fromModem, undated := 0, 0
+	for _, m := range messages {
+		if m.Source == exportFromModem {
+			fromModem++
+		}
+		if m.Timestamp.IsZero() {
+			undated++
+		}
+	}
+	caption := fmt.Sprintf("📦 共导出 %d 条短信 (归档 %d 条, 调制解调器上未归档的 %d 条)", len(messages), len(messages)-fromModem, fromModem)
+	if !since.IsZero() {
+		caption += "\n自 " + since.Format("2006-01-02 15:04")
+	}
+	if undated > 0 {
+		caption += fmt.Sprintf("\n其中 %d 条调制解调器上发出的短信没有时间", undated)
+		if !since.IsZero() {
+			caption += ", 不按起始时间筛选"
+		}
+		if format == "xml" {
+			caption += ", 以导出时间代替"
+		}
+	}
+	if len(notes) > 0 {
+		caption += "\n" + strings.Join(notes, "\n")
+	}
+	doc := tgbotapi.NewDocument(update.Message.Chat.ID, tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("sms-%s.%s", time.Now().Format("20060102-150405"), exporter.ext),
+		Bytes: data,
+	})
+	doc.Caption = caption
+	if _, err := bot.Send(doc); err != nil {
+		log.Printf("发送导出文件失败: %v", err)
+		bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "发送导出文件失败: "+err.Error()))
+	}
+}
+
+// collectSmsForExport 合并归档的短信和调制解调器上的短信, 已归档的短信只导出一次, 按时间排序。
+// 某个来源读取失败时仍导出另一个来源, 失败原因在 notes 中。
+func collectSmsForExport(eng engine.Engine, since time.Time) ([]exportedSms, []string) {
+	var (
+		messages []exportedSms
+		notes    []string
+	)
+	// 调制解调器上发出的短信没有时间戳, 只按号码和内容比较
+	key := func(direction storage.Direction, number, text string, t time.Time) string {
+		if direction == storage.Outgoing {
+			t = time.Time{}
+		}
+		return fmt.Sprintf("%s|%s|%d|%s", direction, storage.NormalizeNumber(number), t.Unix(), text)
+	}
+	var (
+		archived = make(map[string]bool)
+		// byContent 为所有归档短信不含时间的 key; untimed 只包括归档时没有短信中心时间戳、
+		// 以归档时间代替的短信。任一方没有真实的时间戳时只按号码和内容比较
+		byContent = make(map[string]bool)
+		untimed   = make(map[string]bool)
+	)
+
+	if store := storage.Default(); store != nil {
+		records, _, err := store.QuerySms(storage.SmsQuery{Since: since})
+		if err != nil {
+			log.Printf("读取短信归档失败: %v", err)
+			notes = append(notes, "⚠️ 读取短信归档失败: "+err.Error())
+		}
+		for _, r := range records {
+			archived[key(r.Direction, r.Number, r.Text, r.Timestamp)] = true
+			content := key(r.Direction, r.Number, r.Text, time.Time{})
+			byContent[content] = true
+			if r.Timestamp.Equal(r.Archived) {
+				untimed[content] = true
+			}
+			messages = append(messages, exportedSms{
+				Timestamp: r.Timestamp,
+				Direction: r.Direction,
+				Number:    r.Number,
+				Name:      storage.Default().ContactName(r.Number),
+				Text:      r.Text,
+				State:     r.State,
+				SMSC:      r.SMSC,
+				Modem:     r.Modem,
+				SIM:       r.SIM,
+				Tags:      r.Tags,
+				Source:    exportFromArchive,
+			})
+		}
+	}
+
+	list, err := eng.ListSms()
+	if err != nil {
+		log.Printf("读取短信失败: %v", err)
+		notes = append(notes, "⚠️ 读取调制解调器上的短信失败: "+err.Error())
+	} else {
+		modem, sim := storage.Identify(eng)
+		for _, sms := range list.Sms {
+			direction, state := storage.Incoming, storage.StateReceived
+			if sms.Outgoing {
+				direction, state = storage.Outgoing, ""
+			}
+			// 没有时间戳的短信无法按起始时间筛选, 全部导出
+			if !sms.Timestamp.IsZero() && sms.Timestamp.Before(since) {
+				continue
+			}
+			content := key(direction, sms.Number, sms.Text, time.Time{})
+			if archived[key(direction, sms.Number, sms.Text, sms.Timestamp)] || untimed[content] || (sms.Timestamp.IsZero() && byContent[content]) {
+				continue
+			}
+			messages = append(messages, exportedSms{
+				Timestamp: sms.Timestamp,
+				Direction: direction,
+				Number:    sms.Number,
+				Name:      storage.Default().ContactName(sms.Number),
+				Text:      sms.Text,
+				State:     state,
+				Modem:     modem,
+				SIM:       sim,
+				Source:    exportFromModem,
+			})
+		}
+	}
+
+	// 没有时间戳的短信排在最后
+	sort.SliceStable(messages, func(i, j int) bool {
+		ti, tj := messages[i].Timestamp, messages[j].Timestamp
+		if ti.IsZero() || tj.IsZero() {
+			return !ti.IsZero() && tj.IsZero()
+		}
+		return ti.Before(tj)
+	})
+	return messages, notes
+}
+
+func exportCsv(messages []exportedSms) ([]byte, error) {
+	var buf bytes.Buffer
+	// BOM 使 Excel 以 UTF-8 打开
+	buf.WriteString("\ufeff")
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"timestamp", "direction", "number", "name", "text", "state", "smsc", "modem", "sim", "tags", "source"})
+	for _, m := range messages {
+		timestamp := ""
+		if !m.Timestamp.IsZero() {
+			timestamp = m.Timestamp.Local().Format(time.RFC3339)
+		}
+		w.Write([]string{
+			timestamp,
+			string(m.Direction),
+			m.Number,
+			m.Name,
+			m.Text,
+			m.State,
+			m.SMSC,
+			m.Modem,
+			m.SIM,
+			strings.Join(m.Tags, " "),
+			m.Source,
+		})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func exportJsonLines(messages []exportedSms) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	for _, m := range messages {
+		if err := enc.Encode(m); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// backupSms 为 SMS Backup & Restore 备份文件中的一条短信, 字段同 Android 的短信数据库
+type backupSms struct {
+	XMLName       xml.Name `xml:"sms"`
+	Protocol      int      `xml:"protocol,attr"`
+	Address       string   `xml:"address,attr"`
+	Date          int64    `xml:"date,attr"` // 毫秒
+	Type          int      `xml:"type,attr"` // 1 收件箱, 2 已发送, 5 发送失败
+	Subject       string   `xml:"subject,attr"`
+	Body          string   `xml:"body,attr"`
+	Toa           string   `xml:"toa,attr"`
+	ScToa         string   `xml:"sc_toa,attr"`
+	ServiceCenter string   `xml:"service_center,attr"`
+	Read          int      `xml:"read,attr"`
+	Status        int      `xml:"status,attr"` // -1 无, 0 已送达, 64 失败
+	Locked        int      `xml:"locked,attr"`
+	DateSent      int64    `xml:"date_sent,attr"`
+	ReadableDate  string   `xml:"readable_date,attr"`
+	ContactName   string   `xml:"contact_name,attr"`
+}
+
+type backupSmses struct {
+	XMLName xml.Name    `xml:"smses"`
+	Count   int         `xml:"count,attr"`
+	Sms     []backupSms `xml:"sms"`
+}
+
+// exportBackupXml 生成 SMS Backup & Restore 的备份文件。备份中每条短信都必须有时间,
+// 没有时间戳的短信 (调制解调器上发出的) 以导出时间代替。
+func exportBackupXml(messages []exportedSms) ([]byte, error) {
+	exported := time.Now()
+	backup := backupSmses{Count: len(messages)}
+	for _, m := range messages {
+		if m.Timestamp.IsZero() {
+			m.Timestamp = exported
+		}
+		b := backupSms{
+			Address:       m.Number,
+			Date:          m.Timestamp.UnixMilli(),
+			Type:          1,
+			Subject:       "null",
+			Body:          m.Text,
+			Toa:           "null",
+			ScToa:         "null",
+			ServiceCenter: "null",
+			Read:          1,
+			Status:        -1,
+			ReadableDate:  m.Timestamp.Local().Format("Jan 2, 2006 3:04:05 PM"),
+			ContactName:   "(Unknown)",
+		}
+		if m.SMSC != "" {
+			b.ServiceCenter = m.SMSC
+		}
+		if m.Name != "" {
+			b.ContactName = m.Name
+		}
+		if m.Direction == storage.Outgoing {
+			b.Type = 2
+			switch m.State {
+			case storage.StateDelivered:
+				b.Status = 0
+			case storage.StateFailed:
+				b.Type, b.Status = 5, 64
+			}
+		} else {
+			b.DateSent = b.Date
+		}
+		backup.Sms = append(backup.Sms, b)
+	}
+
+	data, err := xml.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	header := "<?xml version='1.0' encoding='UTF-8' standalone='yes' ?>\n"
+	return append([]byte(header), append(data, '\n')...), nil
+}
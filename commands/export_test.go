@@ -0,0 +1,129 @@
+package commands
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tg_modem/engine"
+	"tg_modem/storage"
+)
+
+// smsListEngine is an engine whose only working method is ListSms.
+type smsListEngine struct {
+	engine.Engine
+	sms []engine.SmsMessage
+}
+
+func (e smsListEngine) ListSms() (*engine.SmsListResult, error) {
+	return &engine.SmsListResult{Sms: e.sms}, nil
+}
+
+func TestExportKeepsUndatedModemSms(t *testing.T) {
+	received := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	eng := smsListEngine{sms: []engine.SmsMessage{
+		{ID: "1", Number: "+8613800138000", Text: "sent from the modem", Outgoing: true},
+		{ID: "2", Number: "+8613800138000", Text: "old", Timestamp: received.Add(-48 * time.Hour)},
+		{ID: "3", Number: "+8613800138000", Text: "new", Timestamp: received},
+	}}
+
+	messages, notes := collectSmsForExport(eng, received.Add(-time.Hour))
+	if len(notes) > 0 {
+		t.Fatalf("notes = %v", notes)
+	}
+	if len(messages) != 2 || messages[0].Text != "new" || messages[1].Text != "sent from the modem" {
+		t.Fatalf("exported %+v, want the new and the undated message, undated last", messages)
+	}
+	if messages[1].Direction != storage.Outgoing {
+		t.Errorf("direction = %q, want outgoing", messages[1].Direction)
+	}
+
+	data, err := exportCsv(messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(string(data), "\ufeff"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows[2][0] != "" {
+		t.Errorf("CSV timestamp of the undated message = %q, want empty", rows[2][0])
+	}
+
+	data, err = exportJsonLines(messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var undated map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &undated); err != nil {
+		t.Fatal(err)
+	}
+	if ts, ok := undated["timestamp"]; ok {
+		t.Errorf("JSON timestamp of the undated message = %v, want it omitted", ts)
+	}
+
+	before := time.Now().UnixMilli()
+	data, err = exportBackupXml(messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now().UnixMilli()
+	var backup backupSmses
+	if err := xml.Unmarshal(data, &backup); err != nil {
+		t.Fatal(err)
+	}
+	if backup.Sms[0].Date != received.UnixMilli() {
+		t.Errorf("XML date = %d, want %d", backup.Sms[0].Date, received.UnixMilli())
+	}
+	if d := backup.Sms[1].Date; d < before || d > after {
+		t.Errorf("XML date of the undated message = %d, want the export time", d)
+	}
+}
+
+func TestExportDeduplicatesArchivedSmsWithoutTimestamp(t *testing.T) {
+	store, err := storage.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	storage.SetDefault(store)
+	defer storage.SetDefault(nil)
+
+	received := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	for _, r := range []storage.SmsRecord{
+		// Archived without an SMSC timestamp, so with the archive time instead.
+		{Direction: storage.Incoming, Number: "+8613800138000", Text: "no timestamp"},
+		{Direction: storage.Incoming, Number: "+8613800138000", Text: "dated", Timestamp: received},
+		{Direction: storage.Incoming, Number: "+8613800138000", Text: "same text", Timestamp: received},
+	} {
+		if err := store.AddSms(&r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eng := smsListEngine{sms: []engine.SmsMessage{
+		{ID: "1", Number: "+8613800138000", Text: "no timestamp", Timestamp: received.Add(-time.Minute)},
+		{ID: "2", Number: "+8613800138000", Text: "dated"},
+		{ID: "3", Number: "+8613800138000", Text: "dated", Timestamp: received},
+		// The same text at another time is another message.
+		{ID: "4", Number: "+8613800138000", Text: "same text", Timestamp: received.Add(time.Hour)},
+	}}
+
+	messages, notes := collectSmsForExport(eng, time.Time{})
+	if len(notes) > 0 {
+		t.Fatalf("notes = %v", notes)
+	}
+	var fromModem []string
+	for _, m := range messages {
+		if m.Source == exportFromModem {
+			fromModem = append(fromModem, m.Text)
+		}
+	}
+	if len(messages) != 4 || len(fromModem) != 1 || fromModem[0] != "same text" {
+		t.Errorf("exported %d messages, %v from the modem, want only the later \"same text\"", len(messages), fromModem)
+	}
+}
@@ -12,6 +12,7 @@ func init() {
 		Handler:     handleGetID,
 		AdminOnly:   false,
 		Description: "获取你当前的 Chat ID",
+		Global:      true,
 	})
 }
 
@@ -19,6 +19,7 @@ func init() {
 		Handler:     handleHelp,
 		AdminOnly:   false,
 		Description: "显示此帮助信息",
+		Global:      true,
 	})
 }
 
@@ -0,0 +1,248 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"tg_modem/engine"
+	"tg_modem/storage"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	historyPageSize = 10
+	// historyTextLimit 为列表中每条短信显示的最大字符数, 避免超出 Telegram 的消息长度
+	historyTextLimit = 300
+	// maxHistoryQueries 为保留翻页条件的查询数, 更早的查询翻页时提示过期
+	maxHistoryQueries = 50
+)
+
+// historyQuery 为一次查询的条件, 翻页按钮通过 token 引用它
+type historyQuery struct {
+	title string
+	query storage.SmsQuery
+}
+
+var historyQueries = newTokenStore[historyQuery](maxHistoryQueries)
+
+func init() {
+	Register(Command{
+		Name:        "smshistory",
+		Handler:     handleSmsHistory,
+		AdminOnly:   true,
+		Description: "[号码] [起始时间] - 查看归档的短信记录",
+		Global:      true,
+	})
+	Register(Command{
+		Name:        "smssearch",
+		Handler:     handleSmsSearch,
+		AdminOnly:   true,
+		Description: "<内容> - 搜索归档的短信",
+		Global:      true,
+	})
+	RegisterCallback("history", handleHistoryCallback)
+}
+
+func handleSmsHistory(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine) {
+	if storage.Default() == nil {
+		reply(bot, update, "未启用短信归档。")
+		return
+	}
+
+	var q storage.SmsQuery
+	for _, arg := range strings.Fields(update.Message.CommandArguments()) {
+		if q.Since.IsZero() {
+			if since, err := parseSince(arg); err == nil {
+				q.Since = since
+				continue
+			}
+		}
+		if q.Number == "" {
+			q.Number = arg
+			continue
+		}
+		reply(bot, update, "格式错误. 请使用: /smshistory [号码] [起始时间]\n起始时间可为 `7d`、`12h` 或 `2024-01-31`")
+		return
+	}
+
+	title := "📚 *短信记录*"
+	var filters []string
+	if q.Number != "" {
+		filters = append(filters, "号码 "+inlineCode(q.Number))
+	}
+	if !q.Since.IsZero() {
+		filters = append(filters, "自 "+q.Since.Format("2006-01-02 15:04"))
+	}
+	if len(filters) > 0 {
+		title += "\n" + strings.Join(filters, ", ")
+	}
+	sendHistory(bot, update.Message.Chat.ID, historyQuery{title: title, query: q})
+}
+
+func handleSmsSearch(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine) {
+	if storage.Default() == nil {
+		reply(bot, update, "未启用短信归档。")
+		return
+	}
+	text := strings.TrimSpace(update.Message.CommandArguments())
+	if text == "" {
+		reply(bot, update, "格式错误. 请使用: /smssearch <内容>")
+		return
+	}
+	sendHistory(bot, update.Message.Chat.ID, historyQuery{
+		title: "🔍 *搜索:* " + inlineCode(text),
+		query: storage.SmsQuery{Text: text},
+	})
+}
+
+// sendHistory 发送查询结果的第一页
+func sendHistory(bot *tgbotapi.BotAPI, chatID int64, hq historyQuery) {
+	token := historyQueries.Add(hq)
+
+	text, markup, err := renderHistory(hq, token, 0)
+	if err != nil {
+		log.Printf("查询短信记录失败: %v", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "查询短信记录失败: "+err.Error()))
+		return
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "Markdown"
+	if markup != nil {
+		msg.ReplyMarkup = *markup
+	}
+	bot.Send(msg)
+}
+
+// handleHistoryCallback 处理翻页按钮, data 格式为 "<token>:<页码>"
+func handleHistoryCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine, data string) {
+	query := update.CallbackQuery
+	token, pageStr, _ := strings.Cut(data, ":")
+	page, err := strconv.Atoi(pageStr)
+	if err != nil {
+		bot.Request(tgbotapi.NewCallback(query.ID, ""))
+		return
+	}
+
+	hq, ok := historyQueries.Get(token)
+	if !ok {
+		bot.Request(tgbotapi.NewCallback(query.ID, "查询已过期, 请重新执行命令"))
+		return
+	}
+
+	text, markup, err := renderHistory(hq, token, page)
+	if err != nil {
+		log.Printf("查询短信记录失败: %v", err)
+		bot.Request(tgbotapi.NewCallback(query.ID, "查询失败: "+err.Error()))
+		return
+	}
+	bot.Request(tgbotapi.NewCallback(query.ID, ""))
+
+	var edit tgbotapi.EditMessageTextConfig
+	if markup != nil {
+		edit = tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, text, *markup)
+	} else {
+		edit = tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	}
+	edit.ParseMode = "Markdown"
+	bot.Send(edit)
+}
+
+// renderHistory 生成某一页的内容和翻页按钮, 只有一页时按钮为 nil
+func renderHistory(hq historyQuery, token string, page int) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	q := hq.query
+	q.Offset = page * historyPageSize
+	q.Limit = historyPageSize
+	records, total, err := storage.Default().QuerySms(q)
+	if err != nil {
+		return "", nil, err
+	}
+	if total == 0 {
+		return hq.title + "\n\n没有符合条件的短信。", nil, nil
+	}
+
+	pages := (total + historyPageSize - 1) / historyPageSize
+	var builder strings.Builder
+	builder.WriteString(hq.title)
+	builder.WriteString(fmt.Sprintf("\n第 %d/%d 页, 共 %d 条\n\n", page+1, pages, total))
+	for _, r := range records {
+		builder.WriteString(formatSmsRecord(r))
+	}
+
+	if pages == 1 {
+		return builder.String(), nil, nil
+	}
+	var row []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("⬅️ 上一页", fmt.Sprintf("history:%s:%d", token, page-1)))
+	}
+	if page+1 < pages {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("下一页 ➡️", fmt.Sprintf("history:%s:%d", token, page+1)))
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(row)
+	return builder.String(), &markup, nil
+}
+
+var smsStateLabels = map[string]string{
+	storage.StateSent:      "✅ 已发送",
+	storage.StateDelivered: "📬 已送达",
+	storage.StateFailed:    "❌ 发送失败",
+}
+
+// formatSmsRecord 以列表形式显示一条归档短信
+func formatSmsRecord(r storage.SmsRecord) string {
+	icon := "📥"
+	if r.Direction == storage.Outgoing {
+		icon = "📤"
+	}
+	line := fmt.Sprintf("%s *#%d* %s %s", icon, r.ID, storage.Default().DescribeNumber(r.Number), r.Timestamp.Local().Format("2006-01-02 15:04"))
+	if label := smsStateLabels[r.State]; label != "" {
+		line += " " + label
+	}
+	if r.Modem != "" {
+		line += " 📟 `" + lastRunes(r.Modem, 4) + "`"
+	}
+	if len(r.Tags) > 0 {
+		line += " 🏷 #" + strings.Join(r.Tags, " #")
+	}
+
+	text := []rune(r.Text)
+	if len(text) > historyTextLimit {
+		text = append(text[:historyTextLimit], '…')
+	}
+	return fmt.Sprintf("%s\n```\n%s\n```\n", line, strings.ReplaceAll(string(text), "`", "'"))
+}
+
+// parseSince 解析起始时间: 相对时间如 "7d"、"12h", 或本地日期 "2024-01-31"、"2024-01-31T08:00"
+func parseSince(s string) (time.Time, error) {
+	now := time.Now()
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		if days, err := strconv.Atoi(n); err == nil && days > 0 {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("无法识别的时间: %s", s)
+}
+
+// inlineCode 将用户输入显示为 Markdown 行内代码
+func inlineCode(s string) string {
+	return "`" + strings.ReplaceAll(s, "`", "'") + "`"
+}
+
+func lastRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[len(r)-n:])
+}
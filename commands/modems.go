@@ -0,0 +1,158 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"tg_modem/engine"
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var (
+	// chatModems 记录每个聊天通过 /use 选择的调制解调器 ID
+	chatModems     = make(map[int64]string)
+	chatModemMutex = &sync.Mutex{}
+)
+
+func init() {
+	Register(Command{
+		Name:        "modems",
+		Handler:     handleModems,
+		AdminOnly:   true,
+		Description: "列出所有调制解调器",
+		Global:      true,
+	})
+	Register(Command{
+		Name:        "use",
+		Handler:     handleUse,
+		AdminOnly:   true,
+		Description: "[modem] - 选择后续命令操作的调制解调器, 不带参数时恢复默认",
+		Global:      true,
+	})
+}
+
+func handleModems(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine) {
+	multi, ok := eng.(engine.MultiModem)
+	if !ok {
+		reply(bot, update, "当前引擎只支持单个调制解调器。")
+		return
+	}
+	modems, err := multi.ListModems()
+	if err != nil {
+		log.Printf("列出调制解调器失败: %v", err)
+		reply(bot, update, "列出调制解调器失败: "+err.Error())
+		return
+	}
+	if len(modems) == 0 {
+		reply(bot, update, "未找到任何调制解调器。")
+		return
+	}
+
+	selected := selectedModem(update.Message.Chat.ID)
+	var builder strings.Builder
+	builder.WriteString("📟 *调制解调器列表*\n")
+	for i, m := range modems {
+		current := m.Current
+		if selected != "" {
+			current = m.ID == selected
+		}
+		builder.WriteString(fmt.Sprintf("%d. `%s` %s", i+1, m.ID, strings.TrimSpace(m.Manufacturer+" "+m.Model)))
+		if m.State != "" || m.Operator != "" {
+			builder.WriteString(fmt.Sprintf(" (%s)", strings.Trim(m.State+", "+m.Operator, ", ")))
+		}
+		if current {
+			builder.WriteString(" ⬅️ 当前")
+		}
+		builder.WriteString("\n")
+	}
+	builder.WriteString("\n使用 /use <序号或ID> 切换, 或在命令后加 `@序号` 临时指定, 如 `/status @2`")
+	reply(bot, update, builder.String())
+}
+
+func handleUse(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine) {
+	chatID := update.Message.Chat.ID
+	key := strings.TrimSpace(update.Message.CommandArguments())
+	if key == "" {
+		chatModemMutex.Lock()
+		delete(chatModems, chatID)
+		chatModemMutex.Unlock()
+		reply(bot, update, "已恢复使用默认调制解调器。")
+		return
+	}
+
+	multi, ok := eng.(engine.MultiModem)
+	if !ok {
+		reply(bot, update, "当前引擎只支持单个调制解调器。")
+		return
+	}
+	modems, err := multi.ListModems()
+	if err != nil {
+		reply(bot, update, "列出调制解调器失败: "+err.Error())
+		return
+	}
+	m, err := engine.FindModem(modems, key)
+	if err != nil {
+		reply(bot, update, err.Error())
+		return
+	}
+
+	chatModemMutex.Lock()
+	chatModems[chatID] = m.ID
+	chatModemMutex.Unlock()
+	reply(bot, update, fmt.Sprintf("✅ 后续命令将操作调制解调器 `%s` (%s)", m.ID, m.Model))
+}
+
+func selectedModem(chatID int64) string {
+	chatModemMutex.Lock()
+	defer chatModemMutex.Unlock()
+	return chatModems[chatID]
+}
+
+// SelectEngine 返回命令应操作的引擎: 优先使用参数开头的 @modem (会从消息中移除),
+// 其次为该聊天通过 /use 选择的调制解调器, 都没有时为默认引擎
+func SelectEngine(update *tgbotapi.Update, cmd Command, eng engine.Engine) (engine.Engine, error) {
+	if cmd.Global {
+		return eng, nil
+	}
+	key := takeModemArg(update.Message)
+	if key == "" {
+		key = selectedModem(update.Message.Chat.ID)
+	}
+	if key == "" {
+		return eng, nil
+	}
+	multi, ok := eng.(engine.MultiModem)
+	if !ok {
+		return nil, errors.New("当前引擎只支持单个调制解调器")
+	}
+	return multi.ForModem(key)
+}
+
+// takeModemArg 取出并移除命令参数中开头的 "@modem"
+func takeModemArg(msg *tgbotapi.Message) string {
+	args := msg.CommandArguments()
+	if !strings.HasPrefix(args, "@") {
+		return ""
+	}
+	end := strings.IndexFunc(args, unicode.IsSpace)
+	if end < 0 {
+		end = len(args)
+	}
+	key := args[1:end]
+	if key == "" {
+		return ""
+	}
+
+	command := msg.Text[:len(msg.Text)-len(args)]
+	rest := strings.TrimLeftFunc(args[end:], unicode.IsSpace)
+	if rest == "" {
+		msg.Text = strings.TrimRightFunc(command, unicode.IsSpace)
+	} else {
+		msg.Text = command + rest
+	}
+	return key
+}
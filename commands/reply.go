@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"tg_modem/engine"
+	"tg_modem/engine/pdu"
+	"tg_modem/storage"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// HandleReply 处理对短信通知和 /sms 回复提示的回复: 将回复的文字作为短信发送给该短信的号码,
+// 并由收到该短信的调制解调器发出。被回复的消息不是短信通知时返回 false。
+func HandleReply(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine) bool {
+	msg := update.Message
+	if msg.ReplyToMessage == nil || msg.Text == "" {
+		return false
+	}
+	// 回复 /sms 列表的回复提示
+	if target, ok := smsReplyFor(msg.Chat.ID, msg.ReplyToMessage.MessageID); ok {
+		go sendReply(bot, update, target.eng, &storage.SmsRecord{Number: target.number})
+		return true
+	}
+	store := storage.Default()
+	if store == nil {
+		return false
+	}
+	record, err := store.LinkedSms(msg.Chat.ID, msg.ReplyToMessage.MessageID)
+	if err != nil {
+		return false
+	}
+
+	go sendReply(bot, update, eng, record)
+	return true
+}
+
+func sendReply(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine, record *storage.SmsRecord) {
+	chatID := update.Message.Chat.ID
+	number, text := record.Number, update.Message.Text
+	info := pdu.Analyze(text)
+
+	status := tgbotapi.NewMessage(chatID, fmt.Sprintf("⏳ 正在回复 %s (%d 条短信)...", number, info.Segments))
+	status.ReplyToMessageID = update.Message.MessageID
+	msg, _ := bot.Send(status)
+
+	target, err := replyEngine(eng, record)
+	if err != nil {
+		bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, fmt.Sprintf("❌ 收到该短信的调制解调器 %s 不可用: %s", record.Modem, err.Error())))
+		return
+	}
+
+	err = target.SendSms(number, text)
+	sent := storage.ArchiveSent(target, number, text, err)
+	if err != nil {
+		log.Printf("回复短信失败: %v", err)
+		bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID, fmt.Sprintf("❌ 回复 %s 失败: %s", number, err.Error())))
+		return
+	}
+	bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID,
+		fmt.Sprintf("✅ 已回复 %s\n共 %d 条短信 (%s, %d 字符)", number, info.Segments, info.Encoding, info.Units)))
+
+	// 回复这条确认消息可以继续与该号码对话
+	if sent != nil {
+		if err := storage.Default().LinkMessage(chatID, msg.MessageID, sent.ID); err != nil {
+			log.Printf("记录回复消息失败: %v", err)
+		}
+	}
+}
+
+// replyEngine 返回收到该短信的调制解调器的引擎, 单调制解调器的引擎直接使用 eng
+func replyEngine(eng engine.Engine, record *storage.SmsRecord) (engine.Engine, error) {
+	multi, ok := eng.(engine.MultiModem)
+	if !ok || record.Modem == "" {
+		return eng, nil
+	}
+	return multi.ForModem(record.Modem)
+}
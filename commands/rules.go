@@ -0,0 +1,245 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"tg_modem/automation"
+	"tg_modem/engine"
+	"tg_modem/storage"
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const rulesUsage = "用法:\n" +
+	"`/rules list` - 查看规则\n" +
+	"`/rules del <ID>` - 删除规则\n" +
+	"`/rules add [条件...] <动作...>` - 添加规则\n\n" +
+	"条件 (都满足时执行动作):\n" +
+	"`from=<正则>` 发件人, `text=<正则>` 内容, `modem=<ID>` 调制解调器或 SIM 卡 (可只写结尾), `time=22:00-07:00` 时间段\n\n" +
+	"动作:\n" +
+	"`forward=<聊天ID>[,<聊天ID>]` 同时推送到其他聊天, `drop` 不推送给管理员, `reply=<内容>` 自动回复短信, " +
+	"`tag=<标签>[,<标签>]` 添加标签, `mute` 静默推送, `webhook=<URL>` POST 到 webhook\n\n" +
+	"含空格的值用双引号括起, 如 `/rules add text=\"验证码\" tag=otp mute`"
+
+func init() {
+	Register(Command{
+		Name:        "rules",
+		Handler:     handleRules,
+		AdminOnly:   true,
+		Description: "<add|list|del> - 管理收到短信时的处理规则",
+		Global:      true,
+	})
+}
+
+func handleRules(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine) {
+	store := storage.Default()
+	if store == nil {
+		reply(bot, update, "未启用数据库, 无法使用短信规则。")
+		return
+	}
+
+	sub, args := cutFields(update.Message.CommandArguments(), 1)
+	if len(sub) == 0 {
+		reply(bot, update, rulesUsage)
+		return
+	}
+	switch sub[0] {
+	case "add":
+		addRule(bot, update, store, args)
+	case "list":
+		listRules(bot, update, store)
+	case "del", "delete":
+		deleteRule(bot, update, store, args)
+	default:
+		reply(bot, update, rulesUsage)
+	}
+}
+
+func addRule(bot *tgbotapi.BotAPI, update tgbotapi.Update, store *storage.Store, args string) {
+	tokens, err := splitQuoted(args)
+	if err == nil {
+		var rule *storage.Rule
+		rule, err = parseRule(tokens)
+		if err == nil {
+			err = automation.ValidateRule(rule)
+		}
+		if err == nil {
+			if err := store.AddRule(rule); err != nil {
+				log.Printf("保存短信规则失败: %v", err)
+				reply(bot, update, "保存规则失败: "+err.Error())
+				return
+			}
+			reply(bot, update, "✅ 已添加规则\n"+formatRule(*rule))
+			return
+		}
+	}
+	reply(bot, update, "❌ "+inlineCode(err.Error())+"\n\n"+rulesUsage)
+}
+
+// parseRule 将 key=value 形式的参数解析为规则
+func parseRule(tokens []string) (*storage.Rule, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("缺少规则内容")
+	}
+	r := &storage.Rule{}
+	for _, token := range tokens {
+		key, value, hasValue := strings.Cut(token, "=")
+		if hasValue && value == "" {
+			return nil, fmt.Errorf("%s 缺少值", key)
+		}
+		switch key {
+		case "from":
+			r.From = value
+		case "text":
+			r.Text = value
+		case "modem":
+			r.Modem = strings.TrimPrefix(value, "@")
+		case "time":
+			r.Window = value
+		case "forward":
+			for _, s := range strings.Split(value, ",") {
+				chatID, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("无效的聊天 ID %q", s)
+				}
+				r.Forward = append(r.Forward, chatID)
+			}
+		case "reply":
+			r.Reply = value
+		case "tag":
+			for _, tag := range strings.Split(value, ",") {
+				if tag = strings.TrimPrefix(strings.TrimSpace(tag), "#"); tag != "" {
+					r.Tags = append(r.Tags, tag)
+				}
+			}
+		case "webhook":
+			r.Webhook = value
+		case "drop":
+			r.Drop = true
+		case "mute":
+			r.Mute = true
+		default:
+			return nil, fmt.Errorf("未知的条件或动作 %q", key)
+		}
+		if !hasValue && key != "drop" && key != "mute" {
+			return nil, fmt.Errorf("%s 缺少值", key)
+		}
+	}
+	return r, nil
+}
+
+// splitQuoted 按空白分隔参数, 双引号内的空白不分隔, 引号内可用 \" 表示引号本身
+func splitQuoted(s string) ([]string, error) {
+	var (
+		tokens  []string
+		current strings.Builder
+		inQuote bool
+		started bool
+	)
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case inQuote && c == '\\' && i+1 < len(runes) && runes[i+1] == '"':
+			current.WriteRune('"')
+			i++
+		case c == '"':
+			inQuote = !inQuote
+			started = true
+		case !inQuote && unicode.IsSpace(c):
+			if started {
+				tokens = append(tokens, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(c)
+			started = true
+		}
+	}
+	if inQuote {
+		return nil, errors.New("引号不成对")
+	}
+	if started {
+		tokens = append(tokens, current.String())
+	}
+	return tokens, nil
+}
+
+func listRules(bot *tgbotapi.BotAPI, update tgbotapi.Update, store *storage.Store) {
+	rules, err := store.Rules()
+	if err != nil {
+		reply(bot, update, "读取规则失败: "+err.Error())
+		return
+	}
+	if len(rules) == 0 {
+		reply(bot, update, "没有短信规则, 所有短信都推送给管理员。使用 `/rules add` 添加。")
+		return
+	}
+	var builder strings.Builder
+	builder.WriteString("📋 *短信规则* (按顺序执行, 匹配的规则的动作都会执行)\n\n")
+	for _, r := range rules {
+		builder.WriteString(formatRule(r))
+		builder.WriteString("\n\n")
+	}
+	reply(bot, update, builder.String())
+}
+
+// formatRule 以 "条件 → 动作" 的形式显示一条规则
+func formatRule(r storage.Rule) string {
+	var conditions, actions []string
+	for _, c := range []struct{ key, value string }{
+		{"from", r.From}, {"text", r.Text}, {"modem", r.Modem}, {"time", r.Window},
+	} {
+		if c.value != "" {
+			conditions = append(conditions, c.key+"="+inlineCode(c.value))
+		}
+	}
+	if len(conditions) == 0 {
+		conditions = append(conditions, "所有短信")
+	}
+
+	if len(r.Forward) > 0 {
+		ids := make([]string, len(r.Forward))
+		for i, id := range r.Forward {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		actions = append(actions, "forward="+inlineCode(strings.Join(ids, ",")))
+	}
+	if r.Drop {
+		actions = append(actions, "drop")
+	}
+	if r.Reply != "" {
+		actions = append(actions, "reply="+inlineCode(r.Reply))
+	}
+	if len(r.Tags) > 0 {
+		actions = append(actions, "tag="+inlineCode(strings.Join(r.Tags, ",")))
+	}
+	if r.Mute {
+		actions = append(actions, "mute")
+	}
+	if r.Webhook != "" {
+		actions = append(actions, "webhook="+inlineCode(r.Webhook))
+	}
+	return fmt.Sprintf("*#%d* %s → %s", r.ID, strings.Join(conditions, " "), strings.Join(actions, " "))
+}
+
+func deleteRule(bot *tgbotapi.BotAPI, update tgbotapi.Update, store *storage.Store, args string) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(args), "#"), 10, 64)
+	if err != nil {
+		reply(bot, update, "请提供要删除的规则ID.\n用法: `/rules del <ID>`, ID 见 `/rules list`")
+		return
+	}
+	switch err := store.RemoveRule(id); err {
+	case nil:
+		reply(bot, update, fmt.Sprintf("✅ 规则 #%d 已删除。", id))
+	case storage.ErrNotFound:
+		reply(bot, update, fmt.Sprintf("规则 #%d 不存在。", id))
+	default:
+		reply(bot, update, "删除规则失败: "+err.Error())
+	}
+}
@@ -0,0 +1,232 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"tg_modem/automation"
+	"tg_modem/engine"
+	"tg_modem/storage"
+	"time"
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// scheduleTextLimit 为 /schedules 中每条短信显示的最大字符数
+const scheduleTextLimit = 100
+
+const scheduleUsage = "用法: /schedulesms <时间|cron> <号码> <内容>\n" +
+	"时间可为 `30m`、`2h`、`1d` 后, 今天或明天的 `09:30`, 或 `2024-01-31T09:30`\n" +
+	"cron 为 5 段表达式 (分 时 日 月 周), 如每月 1 日 9 点: `0 9 1 * *`"
+
+func init() {
+	Register(Command{
+		Name:        "schedulesms",
+		Handler:     handleScheduleSms,
+		AdminOnly:   true,
+		Description: "<时间|cron> <号码> <内容> - 定时或定期发送短信",
+	})
+	Register(Command{
+		Name:        "schedules",
+		Handler:     handleSchedules,
+		AdminOnly:   true,
+		Description: "- 查看定时短信",
+		Global:      true,
+	})
+	Register(Command{
+		Name:        "unschedule",
+		Handler:     handleUnschedule,
+		AdminOnly:   true,
+		Description: "<ID> - 删除定时短信",
+		Global:      true,
+	})
+}
+
+func handleScheduleSms(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine) {
+	store := storage.Default()
+	if store == nil {
+		reply(bot, update, "未启用数据库, 无法使用定时短信。")
+		return
+	}
+
+	sc, err := parseSchedule(update.Message.CommandArguments(), time.Now())
+	if err != nil {
+		reply(bot, update, "❌ "+err.Error()+"\n"+scheduleUsage)
+		return
+	}
+	if bindsModem(eng) {
+		sc.Modem, sc.SIM = storage.Identify(eng)
+	}
+	if err := store.AddSchedule(sc); err != nil {
+		log.Printf("保存定时短信失败: %v", err)
+		reply(bot, update, "保存定时短信失败: "+err.Error())
+		return
+	}
+	automation.WakeScheduler()
+
+	rule := "一次"
+	if sc.Cron != "" {
+		rule = inlineCode(sc.Cron)
+	}
+	text := fmt.Sprintf("✅ 已添加定时短信 *#%d*\n号码: %s\n规则: %s\n下次发送: %s",
+		sc.ID, inlineCode(sc.Number), rule, sc.NextRun.Format("2006-01-02 15:04"))
+	if sc.Modem != "" {
+		text += "\n📟 `" + sc.Modem + "`"
+	}
+	reply(bot, update, text)
+}
+
+// parseSchedule 解析 "<时间|cron> <号码> <内容>", 前 5 段能解析为 cron 表达式时为定期发送
+func parseSchedule(args string, now time.Time) (*storage.Schedule, error) {
+	if fields, text := cutFields(args, 6); len(fields) == 6 && text != "" {
+		spec := strings.Join(fields[:5], " ")
+		if cron, err := automation.ParseCron(spec); err == nil {
+			next := cron.Next(now)
+			if next.IsZero() {
+				return nil, errors.New("cron 表达式永远不会匹配")
+			}
+			return &storage.Schedule{Number: fields[5], Text: text, Cron: spec, NextRun: next}, nil
+		}
+	}
+
+	fields, text := cutFields(args, 2)
+	if len(fields) < 2 || text == "" {
+		return nil, errors.New("参数不足")
+	}
+	at, err := parseWhen(fields[0], now)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.Schedule{Number: fields[1], Text: text, NextRun: at}, nil
+}
+
+// parseWhen 解析一次性发送的时间: 相对时间如 "30m"、"1d", 时刻 "09:30" (已过则为明天),
+// 或本地时间 "2024-01-31T09:30"
+func parseWhen(s string, now time.Time) (time.Time, error) {
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		if days, err := strconv.Atoi(n); err == nil && days > 0 {
+			return now.AddDate(0, 0, days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return now.Add(d), nil
+	}
+	if t, err := time.ParseInLocation("15:04", s, time.Local); err == nil {
+		at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, time.Local)
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		return at, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04", s, time.Local); err == nil {
+		if !t.After(now) {
+			return time.Time{}, errors.New("发送时间已经过去")
+		}
+		return t, nil
+	}
+	return time.Time{}, errors.New("无法识别的时间")
+}
+
+// cutFields 取出开头 n 个以空白分隔的参数, 剩余部分原样返回 (短信内容保留其中的空格和换行)
+func cutFields(s string, n int) ([]string, string) {
+	var fields []string
+	for len(fields) < n {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			break
+		}
+		end := strings.IndexFunc(s, unicode.IsSpace)
+		if end < 0 {
+			end = len(s)
+		}
+		fields = append(fields, s[:end])
+		s = s[end:]
+	}
+	return fields, strings.TrimLeftFunc(s, unicode.IsSpace)
+}
+
+// bindsModem 判断定时短信是否需要记住当前的调制解调器: 接有多个调制解调器时,
+// 定时短信 (如保号短信) 总是由创建时的调制解调器发出
+func bindsModem(eng engine.Engine) bool {
+	multi, ok := eng.(engine.MultiModem)
+	if !ok {
+		return false
+	}
+	modems, err := multi.ListModems()
+	return err == nil && len(modems) > 1
+}
+
+func handleSchedules(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine) {
+	store := storage.Default()
+	if store == nil {
+		reply(bot, update, "未启用数据库, 无法使用定时短信。")
+		return
+	}
+	schedules, err := store.Schedules()
+	if err != nil {
+		reply(bot, update, "读取定时短信失败: "+err.Error())
+		return
+	}
+	if len(schedules) == 0 {
+		reply(bot, update, "没有定时短信。使用 /schedulesms 添加。")
+		return
+	}
+
+	var builder strings.Builder
+	builder.WriteString("⏰ *定时短信*\n\n")
+	for _, sc := range schedules {
+		builder.WriteString(formatSchedule(sc))
+	}
+	reply(bot, update, builder.String())
+}
+
+// formatSchedule 以列表形式显示一条定时短信
+func formatSchedule(sc storage.Schedule) string {
+	rule := "🕐 一次"
+	if sc.Cron != "" {
+		rule = "🔁 " + inlineCode(sc.Cron)
+	}
+	line := fmt.Sprintf("*#%d* %s → %s", sc.ID, rule, inlineCode(sc.Number))
+	if sc.Modem != "" {
+		line += " 📟 `" + lastRunes(sc.Modem, 4) + "`"
+	}
+	if sc.NextRun.IsZero() {
+		line += "\n下次发送: 不会再发送"
+	} else {
+		line += "\n下次发送: " + sc.NextRun.Format("2006-01-02 15:04")
+	}
+	if sc.LastError != "" {
+		line += "\n❌ 上次发送失败: " + inlineCode(sc.LastError)
+	}
+
+	text := []rune(sc.Text)
+	if len(text) > scheduleTextLimit {
+		text = append(text[:scheduleTextLimit], '…')
+	}
+	return fmt.Sprintf("%s\n```\n%s\n```\n", line, strings.ReplaceAll(string(text), "`", "'"))
+}
+
+func handleUnschedule(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine) {
+	store := storage.Default()
+	if store == nil {
+		reply(bot, update, "未启用数据库, 无法使用定时短信。")
+		return
+	}
+	arg := strings.TrimPrefix(strings.TrimSpace(update.Message.CommandArguments()), "#")
+	id, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		reply(bot, update, "请提供要删除的定时短信ID.\n用法: /unschedule <ID>, ID 见 /schedules")
+		return
+	}
+	switch err := store.RemoveSchedule(id); err {
+	case nil:
+		reply(bot, update, fmt.Sprintf("✅ 定时短信 #%d 已删除。", id))
+	case storage.ErrNotFound:
+		reply(bot, update, fmt.Sprintf("定时短信 #%d 不存在。", id))
+	default:
+		reply(bot, update, "删除定时短信失败: "+err.Error())
+	}
+}
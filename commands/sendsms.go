@@ -1,36 +1,249 @@
 package commands
 
 import (
+	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"log"
 	"strings"
 	"tg_modem/engine"
+	"tg_modem/engine/pdu"
+	"tg_modem/storage"
+	"time"
 )
 
+const sendSmsUsage = "格式错误. 请使用: /sendsms [-r] [-g] [-y] <号码|联系人> <内容>\n" +
+	"-r 请求送达报告, -g 转换为 GSM-7 编码以减少条数, -y 不预览直接发送\n" +
+	"内容可以换行, 号码和内容之间也可以用换行分隔"
+
+const (
+	// maxSmsDrafts 为保留的待确认短信数, 更早的预览点击发送时提示过期
+	maxSmsDrafts = 20
+	// smsDraftTTL 为预览的有效期, 避免误点很久以前的预览
+	smsDraftTTL = time.Hour
+	// smsPreviewLimit 为预览中显示的最大字符数, 避免超出 Telegram 的消息长度
+	smsPreviewLimit = 3000
+)
+
+// smsDraft 为等待确认发送的短信, 发送和取消按钮通过 token 引用它
+type smsDraft struct {
+	eng        engine.Engine // 命令选择的调制解调器
+	recipient  string
+	text       string
+	withReport bool
+	created    time.Time
+}
+
+var smsDrafts = newTokenStore[smsDraft](maxSmsDrafts)
+
 func init() {
 	Register(Command{
 		Name:        "sendsms",
 		Handler:     handleSendSms,
 		AdminOnly:   true,
-		Description: "<号码> <内容> - 发送短信",
+		Description: "[-r] [-g] [-y] <号码|联系人> <内容> - 预览并发送短信, -r 请求送达报告",
 	})
+	RegisterCallback("sendsms", handleSendSmsCallback)
 }
 
 func handleSendSms(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine) {
-	args := strings.SplitN(update.Message.CommandArguments(), " ", 2)
-	if len(args) < 2 {
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "格式错误. 请使用: /sendsms <号码> <内容>")
-		bot.Send(msg)
+	argsText := update.Message.CommandArguments()
+	var withReport, gsm7, confirmed bool
+flags:
+	for {
+		flag, rest := cutFields(argsText, 1)
+		if len(flag) == 0 {
+			break
+		}
+		switch flag[0] {
+		case "-r":
+			withReport = true
+		case "-g":
+			gsm7 = true
+		case "-y":
+			confirmed = true
+		default:
+			break flags
+		}
+		argsText = rest
+	}
+	args, text := cutFields(argsText, 1)
+	if len(args) < 1 || strings.TrimSpace(text) == "" {
+		bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, sendSmsUsage))
 		return
 	}
-	recipient, text := args[0], args[1]
+	recipient, err := resolveRecipient(args[0])
+	if err != nil {
+		reply(bot, update, "❌ "+err.Error())
+		return
+	}
+
+	if withReport {
+		if _, ok := eng.(engine.DeliveryReporter); !ok {
+			bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "当前引擎不支持送达报告"))
+			return
+		}
+	}
 
-	err := eng.SendSms(recipient, text)
-	reply := "短信已发送成功。"
+	lost := 0
+	if gsm7 {
+		text, lost = pdu.Transliterate(text)
+	}
+	draft := smsDraft{eng: eng, recipient: recipient, text: text, withReport: withReport, created: time.Now()}
+
+	if confirmed {
+		msg, _ := bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("⏳ 正在发送短信到 %s...", recipient)))
+		sendDraft(bot, update.Message.Chat.ID, msg.MessageID, draft)
+		return
+	}
+
+	token := smsDrafts.Add(draft)
+
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, smsPreview(draft, gsm7, lost))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✅ 发送", "sendsms:"+token+":send"),
+		tgbotapi.NewInlineKeyboardButtonData("❌ 取消", "sendsms:"+token+":cancel"),
+	))
+	bot.Send(msg)
+}
+
+// smsPreview 显示短信的编码、条数和内容。预览不使用 Markdown, 内容原样显示。
+func smsPreview(d smsDraft, gsm7 bool, lost int) string {
+	info := pdu.Analyze(d.text)
+	var builder strings.Builder
+	builder.WriteString("📝 短信预览\n")
+	if name := storage.Default().ContactName(d.recipient); name != "" {
+		builder.WriteString(fmt.Sprintf("收件人: %s (%s)\n", name, d.recipient))
+	} else {
+		builder.WriteString(fmt.Sprintf("收件人: %s\n", d.recipient))
+	}
+	builder.WriteString(fmt.Sprintf("编码: %s, 共 %d 条短信 (%d 字符, 每条最多 %d)\n",
+		info.Encoding, info.Segments, info.Units, info.PerSegment))
+	if info.Extended > 0 {
+		builder.WriteString(fmt.Sprintf("含 %d 个扩展字符 (如 € [ ] { }), 每个占 2 个字符\n", info.Extended))
+	}
+	if len(info.NonGSM) > 0 {
+		chars := info.NonGSM
+		suffix := ""
+		if len(chars) > 10 {
+			chars, suffix = chars[:10], " …"
+		}
+		builder.WriteString(fmt.Sprintf("以下字符需要 UCS-2 编码: %s%s\n", strings.Join(strings.Split(string(chars), ""), " "), suffix))
+		if converted, n := pdu.Transliterate(d.text); n == 0 {
+			builder.WriteString(fmt.Sprintf("提示: 加 -g 转换为 GSM-7 后为 %d 条短信\n", pdu.Analyze(converted).Segments))
+		}
+	}
+	if gsm7 {
+		builder.WriteString("已转换为 GSM-7 编码")
+		if lost > 0 {
+			builder.WriteString(fmt.Sprintf(", %d 个字符无法转换, 已替换为 ?", lost))
+		}
+		builder.WriteString("\n")
+	}
+	if d.withReport {
+		builder.WriteString("送达报告: 已请求\n")
+	}
+
+	text := []rune(d.text)
+	if len(text) > smsPreviewLimit {
+		text = append(text[:smsPreviewLimit], '…')
+	}
+	builder.WriteString("\n")
+	builder.WriteString(string(text))
+	return builder.String()
+}
+
+// handleSendSmsCallback 处理预览的按钮, data 格式为 "<token>:<send|cancel>"
+func handleSendSmsCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine, data string) {
+	query := update.CallbackQuery
+	chatID, messageID := query.Message.Chat.ID, query.Message.MessageID
+	token, action, _ := strings.Cut(data, ":")
+
+	// 取出后即删除, 重复点击不会重复发送
+	draft, ok := smsDrafts.Take(token)
+	if !ok || time.Since(draft.created) > smsDraftTTL {
+		bot.Request(tgbotapi.NewCallback(query.ID, "预览已过期, 请重新执行命令"))
+		bot.Send(tgbotapi.NewEditMessageText(chatID, messageID, query.Message.Text+"\n\n⌛ 预览已过期, 未发送"))
+		return
+	}
+
+	bot.Request(tgbotapi.NewCallback(query.ID, ""))
+	if action != "send" {
+		bot.Send(tgbotapi.NewEditMessageText(chatID, messageID, fmt.Sprintf("🚫 已取消发送到 %s 的短信", draft.recipient)))
+		return
+	}
+	bot.Send(tgbotapi.NewEditMessageText(chatID, messageID, fmt.Sprintf("⏳ 正在发送短信到 %s...", draft.recipient)))
+	sendDraft(bot, chatID, messageID, draft)
+}
+
+// sendDraft 发送短信, 并将 messageID 的消息编辑为发送结果
+func sendDraft(bot *tgbotapi.BotAPI, chatID int64, messageID int, d smsDraft) {
+	if d.withReport {
+		sendSmsWithReport(bot, chatID, messageID, d.eng, d.eng.(engine.DeliveryReporter), d.recipient, d.text)
+		return
+	}
+
+	err := d.eng.SendSms(d.recipient, d.text)
+	storage.ArchiveSent(d.eng, d.recipient, d.text, err)
 	if err != nil {
 		log.Printf("发送短信失败: %v", err)
-		reply = "发送短信失败: " + err.Error()
+		bot.Send(tgbotapi.NewEditMessageText(chatID, messageID, "发送短信失败: "+err.Error()))
+		return
+	}
+	info := pdu.Analyze(d.text)
+	bot.Send(tgbotapi.NewEditMessageText(chatID, messageID,
+		fmt.Sprintf("✅ 短信已发送到 %s\n共 %d 条短信 (%s, %d 字符)", d.recipient, info.Segments, info.Encoding, info.Units)))
+}
+
+// sendSmsWithReport 发送短信并请求送达报告, 随投递状态的变化编辑确认消息
+func sendSmsWithReport(bot *tgbotapi.BotAPI, chatID int64, messageID int, eng engine.Engine, reporter engine.DeliveryReporter, recipient, text string) {
+	updates, err := reporter.SendSmsWithReport(recipient, text)
+	sent := storage.ArchiveSent(eng, recipient, text, err)
+	if err != nil {
+		log.Printf("发送短信失败: %v", err)
+		bot.Send(tgbotapi.NewEditMessageText(chatID, messageID, "发送短信失败: "+err.Error()))
+		return
+	}
+
+	// 送达报告可能在很久之后才到达, 在后台等待
+	go func() {
+		final := false
+		for u := range updates {
+			var status string
+			switch u.State {
+			case engine.SmsSent:
+				status = fmt.Sprintf("✅ 已发送到 %s, 等待送达报告...", recipient)
+				if u.Status >= 0 {
+					status += fmt.Sprintf("\n短信中心仍在尝试: %s (0x%02X)", u.Reason, u.Status)
+				}
+			case engine.SmsDelivered:
+				final = true
+				status = fmt.Sprintf("📬 短信已送达 %s", recipient)
+				updateSentState(sent, storage.StateDelivered, "")
+			case engine.SmsFailed:
+				final = true
+				reason := fmt.Sprintf("%s (0x%02X)", u.Reason, u.Status)
+				status = fmt.Sprintf("❌ 短信投递到 %s 失败: %s", recipient, reason)
+				updateSentState(sent, storage.StateFailed, reason)
+			}
+			bot.Send(tgbotapi.NewEditMessageText(chatID, messageID, status))
+		}
+		if !final {
+			bot.Send(tgbotapi.NewEditMessageText(chatID, messageID,
+				fmt.Sprintf("✅ 已发送到 %s\n⚠️ 未收到送达报告", recipient)))
+		}
+	}()
+}
+
+// updateSentState 根据送达报告更新归档中发出的短信
+func updateSentState(sent *storage.SmsRecord, state, reason string) {
+	if sent == nil {
+		return
+	}
+	err := storage.Default().UpdateSms(sent.ID, func(r *storage.SmsRecord) {
+		r.State = state
+		r.Error = reason
+	})
+	if err != nil {
+		log.Printf("更新短信 #%d 的投递状态失败: %v", sent.ID, err)
 	}
-	msg := tgbotapi.NewMessage(update.Message.Chat.ID, reply)
-	bot.Send(msg)
 }
@@ -1,9 +1,51 @@
 package commands
 
 import (
+	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"tg_modem/engine"
+	"tg_modem/storage"
+)
+
+const (
+	smsPageSize = 5
+	// smsTextLimit 为列表中每条短信显示的最大字符数, 避免超出 Telegram 的消息长度
+	smsTextLimit = 500
+	// maxSmsListings 为保留的短信列表数, 更早的列表点击按钮时提示过期
+	maxSmsListings = 20
+	// maxSmsReplies 为保留的回复提示数, 回复更早的提示不会发出短信
+	maxSmsReplies = 50
+)
+
+// smsListing 为一次 /sms 读取的短信, 按钮通过 token 引用它
+type smsListing struct {
+	eng      engine.Engine // 命令选择的调制解调器
+	modem    string        // 调制解调器 ID, 用于区分各调制解调器上相同 ID 的短信
+	messages []engine.SmsMessage
+	ids      map[string]string // 同 SmsListResult.Messages
+	page     int
+	confirm  string // 等待确认删除的短信 ID
+}
+
+// smsReplyTarget 为回复提示对应的号码和调制解调器
+type smsReplyTarget struct {
+	eng    engine.Engine
+	number string
+}
+
+var (
+	smsListings = newTokenStore[*smsListing](maxSmsListings)
+	// readSms 为未启用数据库时标记为已读的短信
+	readSms       = make(map[string]bool)
+	smsReplies    = make(map[string]smsReplyTarget)
+	smsReplyOrder []string
+	smsMutex      = &sync.Mutex{}
 )
 
 func init() {
@@ -11,27 +53,339 @@ func init() {
 		Name:        "sms",
 		Handler:     handleSms,
 		AdminOnly:   true,
-		Description: "读取所有短信",
+		Description: "分页查看短信, 可删除、回复或标记为已读",
 	})
+	RegisterCallback("sms", handleSmsCallback)
 }
 
 func handleSms(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine) {
-	smsResult, err := eng.ListSms()
-	var replyText string
-
+	result, err := eng.ListSms()
 	if err != nil {
 		log.Printf("读取短信失败: %v", err)
-		replyText = "读取短信失败: " + err.Error()
-	} else {
-		replyText = smsResult.DisplayText
-
-		// 缓存结果以供 /deletesms 使用
-		cacheMutex.Lock()
-		userSmsCache[update.Message.Chat.ID] = smsResult.Messages
-		cacheMutex.Unlock()
+		bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "读取短信失败: "+err.Error()))
+		return
 	}
 
-	msg := tgbotapi.NewMessage(update.Message.Chat.ID, replyText)
+	modem, _ := storage.Identify(eng)
+	listing := &smsListing{eng: eng, modem: modem}
+	listing.load(result)
+
+	token := smsListings.Add(listing)
+	smsMutex.Lock()
+	text, markup := renderSmsPage(token, listing)
+	smsMutex.Unlock()
+
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
 	msg.ParseMode = "Markdown"
+	if markup != nil {
+		msg.ReplyMarkup = *markup
+	}
 	bot.Send(msg)
 }
+
+// load 替换列表中的短信, 按时间从新到旧排序
+func (l *smsListing) load(result *engine.SmsListResult) {
+	l.messages, l.ids, l.confirm = result.Sms, result.Messages, ""
+	sort.SliceStable(l.messages, func(i, j int) bool {
+		return l.messages[i].Timestamp.After(l.messages[j].Timestamp)
+	})
+	l.clampPage()
+}
+
+func (l *smsListing) clampPage() {
+	if pages := l.pages(); l.page >= pages {
+		l.page = pages - 1
+	}
+	if l.page < 0 {
+		l.page = 0
+	}
+}
+
+func (l *smsListing) pages() int {
+	return (len(l.messages) + smsPageSize - 1) / smsPageSize
+}
+
+func (l *smsListing) find(id string) (engine.SmsMessage, bool) {
+	return smsByID(l.messages, id)
+}
+
+func smsByID(messages []engine.SmsMessage, id string) (engine.SmsMessage, bool) {
+	for _, m := range messages {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return engine.SmsMessage{}, false
+}
+
+// readKey 标识调制解调器上的一条短信; 存储序号会被新短信重用, 因此加上号码和时间
+func (l *smsListing) readKey(m engine.SmsMessage) string {
+	return fmt.Sprintf("%s|%s|%s|%d", l.modem, m.ID, m.Number, m.Timestamp.Unix())
+}
+
+// unread 判断收到的短信是否未标记为已读, 调用方需持有 smsMutex
+func (l *smsListing) unread(m engine.SmsMessage) bool {
+	if m.Outgoing {
+		return false
+	}
+	key := l.readKey(m)
+	if store := storage.Default(); store != nil {
+		return !store.SmsRead(key)
+	}
+	return !readSms[key]
+}
+
+// markRead 将短信标记为已读, 调用方需持有 smsMutex
+func (l *smsListing) markRead(m engine.SmsMessage) error {
+	key := l.readKey(m)
+	if store := storage.Default(); store != nil {
+		return store.MarkSmsRead(key)
+	}
+	readSms[key] = true
+	return nil
+}
+
+// renderSmsPage 生成当前页的内容和按钮, 调用方需持有 smsMutex
+func renderSmsPage(token string, l *smsListing) (string, *tgbotapi.InlineKeyboardMarkup) {
+	if len(l.messages) == 0 {
+		return "没有短信。", nil
+	}
+
+	unread := 0
+	for _, m := range l.messages {
+		if l.unread(m) {
+			unread++
+		}
+	}
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("📨 *短信* (%d 条, %d 条未读)\n", len(l.messages), unread))
+	if pages := l.pages(); pages > 1 {
+		builder.WriteString(fmt.Sprintf("第 %d/%d 页\n", l.page+1, pages))
+	}
+	builder.WriteString("\n")
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	data := func(action, arg string) string {
+		return fmt.Sprintf("sms:%s:%s:%s", token, action, arg)
+	}
+	start := l.page * smsPageSize
+	end := min(start+smsPageSize, len(l.messages))
+	for _, m := range l.messages[start:end] {
+		isUnread := l.unread(m)
+		builder.WriteString(formatStoredSms(m, isUnread))
+
+		if l.confirm == m.ID {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("⚠️ 确认删除 #"+m.ID, data("delete", m.ID)),
+				tgbotapi.NewInlineKeyboardButtonData("取消", data("page", strconv.Itoa(l.page))),
+			))
+			continue
+		}
+		row := tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("🗑 #"+m.ID, data("confirm", m.ID)))
+		if !m.Outgoing {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData("↩️ 回复", data("reply", m.ID)))
+		}
+		if isUnread {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData("✔️ 已读", data("read", m.ID)))
+		}
+		rows = append(rows, row)
+	}
+
+	var nav []tgbotapi.InlineKeyboardButton
+	if l.page > 0 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("⬅️ 上一页", data("page", strconv.Itoa(l.page-1))))
+	}
+	nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("🔄 刷新", data("refresh", "")))
+	if l.page+1 < l.pages() {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("下一页 ➡️", data("page", strconv.Itoa(l.page+1))))
+	}
+	rows = append(rows, nav)
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return builder.String(), &markup
+}
+
+// formatStoredSms 以列表形式显示调制解调器上的一条短信
+func formatStoredSms(m engine.SmsMessage, unread bool) string {
+	icon := "✉️"
+	switch {
+	case m.Outgoing:
+		icon = "📤"
+	case unread:
+		icon = "🆕"
+	}
+	line := fmt.Sprintf("%s *#%s* %s", icon, m.ID, storage.Default().DescribeNumber(m.Number))
+	if !m.Timestamp.IsZero() {
+		line += " " + m.Timestamp.Local().Format("2006-01-02 15:04")
+	}
+	if m.Storage != "" {
+		line += " 💾 " + engine.DescribeSmsStorage(m.Storage)
+	}
+
+	text := []rune(m.Text)
+	if len(text) > smsTextLimit {
+		text = append(text[:smsTextLimit], '…')
+	}
+	return fmt.Sprintf("%s\n```\n%s\n```\n", line, strings.ReplaceAll(string(text), "`", "'"))
+}
+
+// handleSmsCallback 处理短信列表的按钮, data 格式为 "<token>:<动作>:<参数>"
+func handleSmsCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine, data string) {
+	query := update.CallbackQuery
+	parts := strings.SplitN(data, ":", 3)
+	if len(parts) < 3 {
+		bot.Request(tgbotapi.NewCallback(query.ID, ""))
+		return
+	}
+	token, action, arg := parts[0], parts[1], parts[2]
+
+	listing, ok := smsListings.Get(token)
+	if !ok {
+		bot.Request(tgbotapi.NewCallback(query.ID, "列表已过期, 请重新执行 /sms"))
+		return
+	}
+
+	notice := ""
+	switch action {
+	case "page":
+		page, err := strconv.Atoi(arg)
+		if err != nil {
+			bot.Request(tgbotapi.NewCallback(query.ID, ""))
+			return
+		}
+		smsMutex.Lock()
+		listing.page, listing.confirm = page, ""
+		listing.clampPage()
+		smsMutex.Unlock()
+	case "refresh":
+		result, err := listing.eng.ListSms()
+		if err != nil {
+			log.Printf("读取短信失败: %v", err)
+			bot.Request(tgbotapi.NewCallback(query.ID, "读取短信失败: "+err.Error()))
+			return
+		}
+		smsMutex.Lock()
+		listing.load(result)
+		smsMutex.Unlock()
+		notice = "已刷新"
+	case "confirm":
+		smsMutex.Lock()
+		listing.confirm = arg
+		smsMutex.Unlock()
+	case "delete":
+		smsMutex.Lock()
+		shown, found := listing.find(arg)
+		smsMutex.Unlock()
+		if !found {
+			bot.Request(tgbotapi.NewCallback(query.ID, "短信已被删除"))
+			return
+		}
+		// 列表可能已过时, 而存储序号会被新短信重用, 删除前重新读取并确认仍是显示的那条
+		result, err := listing.eng.ListSms()
+		if err != nil {
+			log.Printf("读取短信失败: %v", err)
+			bot.Request(tgbotapi.NewCallback(query.ID, "读取短信失败: "+err.Error()))
+			return
+		}
+		current, found := smsByID(result.Sms, arg)
+		smsPath := result.Messages[arg]
+		if !found || smsPath == "" || current.Number != shown.Number || current.Text != shown.Text {
+			log.Printf("短信 ID %s 已不是列表中显示的短信, 取消删除", arg)
+			smsMutex.Lock()
+			listing.load(result)
+			smsMutex.Unlock()
+			notice = "短信已变化, 未删除, 请确认后重试"
+			break
+		}
+		if err := listing.eng.DeleteSms(smsPath); err != nil {
+			log.Printf("删除短信 %s (ID: %s) 失败: %v", smsPath, arg, err)
+			bot.Request(tgbotapi.NewCallback(query.ID, "删除短信失败: "+err.Error()))
+			return
+		}
+		log.Printf("成功删除短信 %s (ID: %s)", smsPath, arg)
+		smsMutex.Lock()
+		listing.load(result)
+		delete(listing.ids, arg)
+		listing.messages = slices.DeleteFunc(listing.messages, func(m engine.SmsMessage) bool { return m.ID == arg })
+		listing.confirm = ""
+		listing.clampPage()
+		smsMutex.Unlock()
+		notice = fmt.Sprintf("✅ 短信 #%s 已删除", arg)
+	case "read":
+		smsMutex.Lock()
+		m, found := listing.find(arg)
+		var err error
+		if found {
+			err = listing.markRead(m)
+		}
+		smsMutex.Unlock()
+		if !found {
+			bot.Request(tgbotapi.NewCallback(query.ID, "短信已被删除"))
+			return
+		}
+		if err != nil {
+			bot.Request(tgbotapi.NewCallback(query.ID, "标记已读失败: "+err.Error()))
+			return
+		}
+	case "reply":
+		smsMutex.Lock()
+		m, found := listing.find(arg)
+		if found {
+			listing.markRead(m)
+		}
+		smsMutex.Unlock()
+		if !found {
+			bot.Request(tgbotapi.NewCallback(query.ID, "短信已被删除"))
+			return
+		}
+		promptSmsReply(bot, query.Message.Chat.ID, listing.eng, m)
+	default:
+		bot.Request(tgbotapi.NewCallback(query.ID, ""))
+		return
+	}
+
+	bot.Request(tgbotapi.NewCallback(query.ID, notice))
+	smsMutex.Lock()
+	text, markup := renderSmsPage(token, listing)
+	smsMutex.Unlock()
+	var edit tgbotapi.EditMessageTextConfig
+	if markup != nil {
+		edit = tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, text, *markup)
+	} else {
+		edit = tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	}
+	edit.ParseMode = "Markdown"
+	bot.Send(edit)
+}
+
+// promptSmsReply 发送回复提示, 管理员回复这条提示时将内容作为短信发给该号码
+func promptSmsReply(bot *tgbotapi.BotAPI, chatID int64, eng engine.Engine, m engine.SmsMessage) {
+	to := m.Number
+	if name := storage.Default().ContactName(m.Number); name != "" {
+		to = fmt.Sprintf("%s (%s)", name, m.Number)
+	}
+	prompt := tgbotapi.NewMessage(chatID, fmt.Sprintf("↩️ 回复 %s 的短信 #%s\n请直接回复这条消息, 回复的内容将作为短信发出。", to, m.ID))
+	prompt.ReplyMarkup = tgbotapi.ForceReply{ForceReply: true, InputFieldPlaceholder: "短信内容"}
+	sent, err := bot.Send(prompt)
+	if err != nil {
+		log.Printf("发送回复提示失败: %v", err)
+		return
+	}
+
+	key := fmt.Sprintf("%d:%d", chatID, sent.MessageID)
+	smsMutex.Lock()
+	smsReplies[key] = smsReplyTarget{eng: eng, number: m.Number}
+	smsReplyOrder = append(smsReplyOrder, key)
+	if len(smsReplyOrder) > maxSmsReplies {
+		delete(smsReplies, smsReplyOrder[0])
+		smsReplyOrder = smsReplyOrder[1:]
+	}
+	smsMutex.Unlock()
+}
+
+// smsReplyFor 返回回复提示对应的号码和调制解调器
+func smsReplyFor(chatID int64, messageID int) (smsReplyTarget, bool) {
+	smsMutex.Lock()
+	defer smsMutex.Unlock()
+	target, ok := smsReplies[fmt.Sprintf("%d:%d", chatID, messageID)]
+	return target, ok
+}
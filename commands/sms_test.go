@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tg_modem/engine"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// smsStoreEngine is an engine holding a list of messages that can be
+// replaced and deleted.
+type smsStoreEngine struct {
+	engine.Engine
+	sms     []engine.SmsMessage
+	deleted []string
+}
+
+func (e *smsStoreEngine) ListSms() (*engine.SmsListResult, error) {
+	result := &engine.SmsListResult{Messages: make(map[string]string)}
+	for _, m := range e.sms {
+		result.Sms = append(result.Sms, m)
+		result.Messages[m.ID] = "/sms/" + m.ID
+	}
+	return result, nil
+}
+
+func (e *smsStoreEngine) DeleteSms(path string) error {
+	e.deleted = append(e.deleted, path)
+	return nil
+}
+
+// newCallbackBot returns a bot talking to a stub Bot API server that records
+// the text of every answered callback query.
+func newCallbackBot(t *testing.T) (*tgbotapi.BotAPI, *[]string) {
+	t.Helper()
+	var answers []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/getMe"):
+			fmt.Fprint(w, `{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"bot","username":"bot"}}`)
+		case strings.HasSuffix(r.URL.Path, "/answerCallbackQuery"):
+			r.ParseForm()
+			answers = append(answers, r.PostForm.Get("text"))
+			fmt.Fprint(w, `{"ok":true,"result":true}`)
+		default:
+			fmt.Fprint(w, `{"ok":true,"result":{"message_id":1,"chat":{"id":42}}}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("T", srv.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bot, &answers
+}
+
+func TestSmsDeleteChecksStaleListing(t *testing.T) {
+	bot, answers := newCallbackBot(t)
+	eng := &smsStoreEngine{sms: []engine.SmsMessage{{ID: "1", Number: "+8613800138000", Text: "old"}}}
+	handleSms(bot, tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: 42}}}, eng)
+	smsListings.mu.Lock()
+	token := fmt.Sprint(smsListings.seq)
+	smsListings.mu.Unlock()
+
+	callback := func(data string) {
+		handleSmsCallback(bot, tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+			ID:      "q",
+			Message: &tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: 42}},
+		}}, eng, data)
+	}
+
+	// The message was deleted elsewhere and a new one took its index.
+	eng.sms = []engine.SmsMessage{{ID: "1", Number: "10086", Text: "new"}}
+	callback(token + ":delete:1")
+	if len(eng.deleted) != 0 {
+		t.Fatalf("deleted %v although the index now holds another message", eng.deleted)
+	}
+	if last := (*answers)[len(*answers)-1]; !strings.Contains(last, "未删除") {
+		t.Errorf("answer = %q, want the deletion refused", last)
+	}
+
+	// The listing now shows the new message, which can be deleted.
+	callback(token + ":delete:1")
+	if len(eng.deleted) != 1 || eng.deleted[0] != "/sms/1" {
+		t.Fatalf("deleted %v, want [/sms/1]", eng.deleted)
+	}
+	listing, _ := smsListings.Get(token)
+	smsMutex.Lock()
+	left := len(listing.messages)
+	smsMutex.Unlock()
+	if left != 0 {
+		t.Errorf("listing keeps %d messages after the delete", left)
+	}
+}
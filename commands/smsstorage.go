@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"tg_modem/engine"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func init() {
+	Register(Command{
+		Name:        "smsstorage",
+		Handler:     handleSmsStorage,
+		AdminOnly:   true,
+		Description: "[SM|ME|MT] - 查看短信存储用量或切换收到短信的默认存储",
+	})
+}
+
+func handleSmsStorage(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine) {
+	manager, ok := eng.(engine.SmsStorageManager)
+	if !ok {
+		reply(bot, update, "当前引擎不支持管理短信存储。")
+		return
+	}
+
+	if name := strings.ToUpper(strings.TrimSpace(update.Message.CommandArguments())); name != "" {
+		if err := manager.SetDefaultSmsStorage(name); err != nil {
+			log.Printf("切换短信存储失败: %v", err)
+			reply(bot, update, "切换短信存储失败: "+inlineCode(err.Error()))
+			return
+		}
+		reply(bot, update, "✅ 收到的短信将存入 "+engine.DescribeSmsStorage(name))
+		return
+	}
+
+	storages, err := manager.SmsStorages()
+	if err != nil {
+		reply(bot, update, "获取短信存储失败: "+inlineCode(err.Error()))
+		return
+	}
+	var builder strings.Builder
+	builder.WriteString("💾 *短信存储*\n\n")
+	for _, s := range storages {
+		builder.WriteString(formatSmsStorage(s))
+		builder.WriteString("\n")
+	}
+	builder.WriteString("\n使用 `/smsstorage <名称>` 切换收到短信的默认存储")
+	reply(bot, update, builder.String())
+}
+
+// formatSmsStorage 显示一个短信存储的用量, 默认存储加粗标出
+func formatSmsStorage(s engine.SmsStorage) string {
+	line := engine.DescribeSmsStorage(s.Name) + ": "
+	switch {
+	case s.Used >= 0 && s.Total > 0:
+		line += fmt.Sprintf("%d/%d (%d%%)", s.Used, s.Total, s.Used*100/s.Total)
+	case s.Used >= 0:
+		line += fmt.Sprintf("%d 条, 容量未知", s.Used)
+	default:
+		line += "未知"
+	}
+	if s.Default {
+		return "✅ *" + line + "* ← 默认"
+	}
+	return "▫️ " + line
+}
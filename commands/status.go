@@ -1,9 +1,12 @@
 package commands
 
 import (
+	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"log"
+	"strings"
 	"tg_modem/engine"
+	"time"
 )
 
 func init() {
@@ -16,12 +19,132 @@ func init() {
 }
 
 func handleStatus(bot *tgbotapi.BotAPI, update tgbotapi.Update, eng engine.Engine) {
+	var text string
 	status, err := eng.GetStatus()
 	if err != nil {
 		log.Printf("获取状态失败: %v", err)
-		status = "获取状态失败: " + err.Error()
+		text = "获取状态失败: " + err.Error()
+	} else {
+		text = renderStatus(status)
 	}
-	msg := tgbotapi.NewMessage(update.Message.Chat.ID, status)
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
 	msg.ParseMode = "Markdown"
 	bot.Send(msg)
 }
+
+// renderStatus 将结构化状态渲染为 Telegram Markdown
+func renderStatus(s *engine.Status) string {
+	var builder strings.Builder
+
+	// --- 1. Modem State & Network Info ---
+	builder.WriteString("ℹ️ *Modem State*\n")
+	if s.Model != "" {
+		builder.WriteString(fmt.Sprintf("`Model:` %s\n", s.Model))
+	}
+	if s.State != "" {
+		builder.WriteString(fmt.Sprintf("`State:` %s\n", s.State))
+	}
+	if s.SimOperator != "" {
+		builder.WriteString(fmt.Sprintf("`SIM:` %s\n", s.SimOperator))
+	}
+	if s.Operator != "" {
+		builder.WriteString(fmt.Sprintf("`Operator:` %s\n", s.Operator))
+	}
+	if s.Registration != "" {
+		builder.WriteString(fmt.Sprintf("`Registration:` %s\n", s.Registration))
+	}
+	if s.AccessTech != "" {
+		builder.WriteString(fmt.Sprintf("`Network Type:` %s\n", s.AccessTech))
+	}
+
+	// --- 2. Signal Quality ---
+	builder.WriteString("\n📶 *Signal Quality*\n")
+	if s.SignalQuality >= 0 {
+		builder.WriteString(fmt.Sprintf("`Quality:` %d%%\n", s.SignalQuality))
+	}
+	for _, m := range s.Signals {
+		builder.WriteString(fmt.Sprintf("*%s Metrics:*\n", m.RAT))
+		writeMetric(&builder, "RSRP", m.RSRP, "dBm")
+		writeMetric(&builder, "RSRQ", m.RSRQ, "dB")
+		writeMetric(&builder, "S/N (SINR)", m.SNR, "dB")
+		writeMetric(&builder, "RSSI", m.RSSI, "dBm")
+	}
+
+	// --- 3. Data Connection ---
+	builder.WriteString("\n🌐 *Data Connection*\n")
+	connected := false
+	for _, b := range s.Bearers {
+		if b.IPv4 == "" && b.IPv6 == "" {
+			continue
+		}
+		connected = true
+		if b.Interface != "" {
+			builder.WriteString(fmt.Sprintf("`Interface:` %s\n", b.Interface))
+		}
+		if b.IPv4 != "" {
+			builder.WriteString(fmt.Sprintf("`IPv4 Address:` %s\n", b.IPv4))
+		}
+		if b.IPv6 != "" {
+			builder.WriteString(fmt.Sprintf("`IPv6 Address:` %s\n", b.IPv6))
+		}
+		if b.Duration > 0 {
+			builder.WriteString(fmt.Sprintf("`Online Duration:` %s\n", formatDuration(b.Duration)))
+		}
+		if b.RxBytes > 0 || b.TxBytes > 0 {
+			builder.WriteString(fmt.Sprintf("`Traffic:` ↓%s ↑%s\n", formatBytes(b.RxBytes), formatBytes(b.TxBytes)))
+		}
+	}
+	if !connected {
+		builder.WriteString("`Status:` Not connected or no IP assigned\n")
+	}
+
+	return builder.String()
+}
+
+func writeMetric(builder *strings.Builder, name string, value *float64, unit string) {
+	if value == nil {
+		return
+	}
+	builder.WriteString(fmt.Sprintf("`%s:` %.2f %s\n", name, *value, unit))
+}
+
+// formatDuration 将时长转换为人类可读的 Dd Hh Mm Ss 格式
+func formatDuration(duration time.Duration) string {
+	totalSeconds := int64(duration / time.Second)
+	if totalSeconds == 0 {
+		return "0s"
+	}
+	d := totalSeconds / 86400
+	h := (totalSeconds % 86400) / 3600
+	m := (totalSeconds % 3600) / 60
+	s := totalSeconds % 60
+
+	var parts []string
+	if d > 0 {
+		parts = append(parts, fmt.Sprintf("%dd", d))
+	}
+	if h > 0 {
+		parts = append(parts, fmt.Sprintf("%dh", h))
+	}
+	if m > 0 {
+		parts = append(parts, fmt.Sprintf("%dm", m))
+	}
+	if s > 0 || len(parts) == 0 {
+		parts = append(parts, fmt.Sprintf("%ds", s))
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatBytes 将字节数转换为 KB/MB/GB
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,56 @@
+package commands
+
+import (
+	"strconv"
+	"sync"
+)
+
+// tokenStore 保存按钮通过 token 引用的状态。token 为递增的序号, 只保留最近 limit 个,
+// 更早的视为过期; limit 为 0 时不限制, 由调用方删除
+type tokenStore[T any] struct {
+	mu    sync.Mutex
+	seq   int
+	limit int
+	items map[string]T
+}
+
+func newTokenStore[T any](limit int) *tokenStore[T] {
+	return &tokenStore[T]{limit: limit, items: make(map[string]T)}
+}
+
+// Add 保存 v 并返回引用它的 token
+func (s *tokenStore[T]) Add(v T) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	token := strconv.Itoa(s.seq)
+	s.items[token] = v
+	if s.limit > 0 {
+		delete(s.items, strconv.Itoa(s.seq-s.limit))
+	}
+	return token
+}
+
+// Get 返回 token 引用的状态, 已过期时 ok 为 false
+func (s *tokenStore[T]) Get(token string) (v T, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok = s.items[token]
+	return v, ok
+}
+
+// Take 取出并删除 token 引用的状态, 同一个 token 只能取出一次
+func (s *tokenStore[T]) Take(token string) (v T, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok = s.items[token]
+	delete(s.items, token)
+	return v, ok
+}
+
+// Remove 删除 token 引用的状态
+func (s *tokenStore[T]) Remove(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, token)
+}
@@ -0,0 +1,50 @@
+package commands
+
+import "testing"
+
+func TestTokenStore(t *testing.T) {
+	s := newTokenStore[string](2)
+	first := s.Add("a")
+	second := s.Add("b")
+	if first == second {
+		t.Fatalf("tokens %q and %q are not unique", first, second)
+	}
+	if v, ok := s.Get(first); !ok || v != "a" {
+		t.Errorf("Get(%q) = %q, %v", first, v, ok)
+	}
+
+	// Only the latest two are kept.
+	third := s.Add("c")
+	if _, ok := s.Get(first); ok {
+		t.Errorf("token %q kept beyond the limit", first)
+	}
+	for token, want := range map[string]string{second: "b", third: "c"} {
+		if v, ok := s.Get(token); !ok || v != want {
+			t.Errorf("Get(%q) = %q, %v, want %q", token, v, ok, want)
+		}
+	}
+
+	if v, ok := s.Take(second); !ok || v != "b" {
+		t.Errorf("Take(%q) = %q, %v", second, v, ok)
+	}
+	if _, ok := s.Take(second); ok {
+		t.Errorf("Take(%q) succeeded twice", second)
+	}
+	s.Remove(third)
+	if _, ok := s.Get(third); ok {
+		t.Errorf("token %q kept after Remove", third)
+	}
+}
+
+func TestTokenStoreUnlimited(t *testing.T) {
+	s := newTokenStore[int](0)
+	var tokens []string
+	for i := range 100 {
+		tokens = append(tokens, s.Add(i))
+	}
+	for i, token := range tokens {
+		if v, ok := s.Get(token); !ok || v != i {
+			t.Errorf("Get(%q) = %d, %v, want %d", token, v, ok, i)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package at
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// isdrAID is the application identifier of the eUICC's ISD-R (SGP.02/SGP.22).
+var isdrAID = []byte{0xA0, 0x00, 0x00, 0x05, 0x59, 0x10, 0x10, 0xFF, 0xFF, 0xFF, 0xFF, 0x89, 0x00, 0x00, 0x01, 0x00}
+
+// storeDataBlockSize keeps each STORE DATA command, and with it the AT
+// command line, well below the limits of common modem firmware.
+const storeDataBlockSize = 120
+
+// logicalChannel is an open logical channel to an application on the UICC.
+// It prefers AT+CCHO/AT+CGLA and falls back to MANAGE CHANNEL and SELECT
+// sent through AT+CSIM on modems that lack them.
+type logicalChannel struct {
+	h    *Handler
+	ctx  context.Context
+	id   int
+	csim bool
+}
+
+// openChannel opens a logical channel and selects aid on it.
+func (h *Handler) openChannel(ctx context.Context, aid []byte) (*logicalChannel, error) {
+	resp, err := h.SendCommandContext(ctx, fmt.Sprintf(`AT+CCHO="%X"`, aid))
+	if err == nil {
+		id, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(resp), "+CCHO:")))
+		if err != nil {
+			return nil, fmt.Errorf("unexpected AT+CCHO response %q", resp)
+		}
+		return &logicalChannel{h: h, ctx: ctx, id: id}, nil
+	}
+	log.Printf("AT+CCHO failed (%v), falling back to AT+CSIM", err)
+
+	// MANAGE CHANNEL (open) on the basic channel returns the new channel number.
+	c := &logicalChannel{h: h, ctx: ctx, csim: true}
+	data, sw, err := c.csimTransmit([]byte{0x00, 0x70, 0x00, 0x00, 0x01})
+	if err != nil {
+		return nil, err
+	}
+	if sw != 0x9000 || len(data) != 1 {
+		return nil, fmt.Errorf("MANAGE CHANNEL failed: SW=%04X", sw)
+	}
+	c.id = int(data[0])
+
+	selectAID := append([]byte{0x00, 0xA4, 0x04, 0x00, byte(len(aid))}, aid...)
+	if _, err := c.transmit(selectAID); err != nil {
+		c.close()
+		return nil, fmt.Errorf("SELECT ISD-R failed: %w", err)
+	}
+	return c, nil
+}
+
+// close releases the channel. Errors are only logged since there is nothing
+// useful the caller can do about them.
+func (c *logicalChannel) close() {
+	var err error
+	if c.csim {
+		_, _, err = c.csimTransmit([]byte{0x00, 0x70, 0x80, byte(c.id)})
+	} else {
+		_, err = c.h.SendCommandContext(c.ctx, fmt.Sprintf("AT+CCHC=%d", c.id))
+	}
+	if err != nil {
+		log.Printf("WARN: failed to close logical channel %d: %v", c.id, err)
+	}
+}
+
+// transmit sends a command APDU on the channel, fetching any remaining
+// response data with GET RESPONSE, and fails unless the final SW is 9000.
+func (c *logicalChannel) transmit(apdu []byte) ([]byte, error) {
+	apdu = append([]byte(nil), apdu...)
+	apdu[0] = claForChannel(apdu[0], c.id)
+
+	var out []byte
+	for {
+		data, sw, err := c.rawTransmit(apdu)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, data...)
+		switch {
+		case sw == 0x9000:
+			return out, nil
+		case sw>>8 == 0x61:
+			apdu = []byte{claForChannel(0x00, c.id), 0xC0, 0x00, 0x00, byte(sw)}
+		case sw>>8 == 0x6C:
+			apdu = withLe(apdu, byte(sw))
+		default:
+			return out, fmt.Errorf("APDU failed: SW=%04X", sw)
+		}
+	}
+}
+
+// withLe returns a copy of the short APDU with its Le set to le, replacing
+// the Le of case 2 and 4 commands and appending one to case 1 and 3 commands.
+func withLe(apdu []byte, le byte) []byte {
+	n := len(apdu)
+	hasLe := n == 5 || (n > 5 && n == 6+int(apdu[4]))
+	if hasLe {
+		n--
+	}
+	return append(apdu[:n:n], le)
+}
+
+func (c *logicalChannel) rawTransmit(apdu []byte) ([]byte, uint16, error) {
+	if c.csim {
+		return c.csimTransmit(apdu)
+	}
+	cmd := fmt.Sprintf(`AT+CGLA=%d,%d,"%X"`, c.id, len(apdu)*2, apdu)
+	resp, err := c.h.SendCommandContext(c.ctx, cmd)
+	if err != nil {
+		return nil, 0, err
+	}
+	return splitAPDUResponse(resp, "+CGLA:")
+}
+
+func (c *logicalChannel) csimTransmit(apdu []byte) ([]byte, uint16, error) {
+	cmd := fmt.Sprintf(`AT+CSIM=%d,"%X"`, len(apdu)*2, apdu)
+	resp, err := c.h.SendCommandContext(c.ctx, cmd)
+	if err != nil {
+		return nil, 0, err
+	}
+	return splitAPDUResponse(resp, "+CSIM:")
+}
+
+// storeData sends an ES10 command as a chain of GlobalPlatform STORE DATA
+// APDUs and returns the eUICC's response.
+func (c *logicalChannel) storeData(data []byte) ([]byte, error) {
+	var resp []byte
+	for block := 0; ; block++ {
+		n := min(len(data), storeDataBlockSize)
+		p1 := byte(0x11) // more blocks
+		if n == len(data) {
+			p1 = 0x91 // last block
+		}
+		apdu := append([]byte{0x80, 0xE2, p1, byte(block), byte(n)}, data[:n]...)
+		out, err := c.transmit(apdu)
+		if err != nil {
+			return nil, err
+		}
+		resp = append(resp, out...)
+		data = data[n:]
+		if len(data) == 0 {
+			return resp, nil
+		}
+	}
+}
+
+// es10 sends an ES10 request and decodes the single data object returned.
+func (c *logicalChannel) es10(req []byte) (tlv, error) {
+	resp, err := c.storeData(req)
+	if err != nil {
+		return tlv{}, err
+	}
+	t, _, err := parseTLV(resp)
+	if err != nil {
+		return tlv{}, fmt.Errorf("invalid ES10 response %X: %w", resp, err)
+	}
+	return t, nil
+}
+
+// splitAPDUResponse parses `+CGLA: <len>,"<hex>"` style responses into the
+// response data and status word.
+func splitAPDUResponse(resp, prefix string) ([]byte, uint16, error) {
+	line := strings.TrimSpace(resp)
+	if i := strings.Index(line, prefix); i >= 0 {
+		line = line[i+len(prefix):]
+	}
+	if _, after, ok := strings.Cut(line, ","); ok {
+		line = after
+	}
+	line = strings.Trim(strings.TrimSpace(line), `"`)
+
+	b, err := hex.DecodeString(line)
+	if err != nil || len(b) < 2 {
+		return nil, 0, fmt.Errorf("unexpected APDU response %q", resp)
+	}
+	n := len(b) - 2
+	return b[:n], uint16(b[n])<<8 | uint16(b[n+1]), nil
+}
+
+// claForChannel encodes a logical channel number into a class byte
+// (ISO/IEC 7816-4, 5.4.1).
+func claForChannel(cla byte, ch int) byte {
+	if ch < 4 {
+		return cla&0xBC | byte(ch)
+	}
+	return cla&0xB0 | 0x40 | byte(ch-4)&0x0F
+}
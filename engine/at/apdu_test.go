@@ -0,0 +1,58 @@
+package at
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestWithLe(t *testing.T) {
+	tests := []struct {
+		name string
+		apdu []byte
+		want []byte
+	}{
+		{"case 1", []byte{0x00, 0x70, 0x00, 0x00}, []byte{0x00, 0x70, 0x00, 0x00, 0x08}},
+		{"case 2", []byte{0x00, 0xC0, 0x00, 0x00, 0x00}, []byte{0x00, 0xC0, 0x00, 0x00, 0x08}},
+		{"case 3", []byte{0x80, 0xE2, 0x91, 0x00, 0x02, 0xBF, 0x2D}, []byte{0x80, 0xE2, 0x91, 0x00, 0x02, 0xBF, 0x2D, 0x08}},
+		{"case 4", []byte{0x80, 0xE2, 0x91, 0x00, 0x02, 0xBF, 0x2D, 0x00}, []byte{0x80, 0xE2, 0x91, 0x00, 0x02, 0xBF, 0x2D, 0x08}},
+	}
+	for _, tt := range tests {
+		apdu := append([]byte(nil), tt.apdu...)
+		if got := withLe(apdu, 0x08); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: withLe(% X) = % X, want % X", tt.name, tt.apdu, got, tt.want)
+		}
+		if !bytes.Equal(apdu, tt.apdu) {
+			t.Errorf("%s: withLe modified its input", tt.name)
+		}
+	}
+}
+
+func TestEncodeICCID(t *testing.T) {
+	tests := []struct {
+		iccid string
+		want  string
+	}{
+		{"898600000000000001", "986800000000000010FF"},
+		{"8986000000000000001", "986800000000000000F1"},
+		{"89860000000000000019", "98680000000000000091"},
+	}
+	for _, tt := range tests {
+		got, err := encodeICCID(tt.iccid)
+		if err != nil {
+			t.Errorf("encodeICCID(%q): %v", tt.iccid, err)
+			continue
+		}
+		if len(got) != 10 || fmt.Sprintf("%X", got) != tt.want {
+			t.Errorf("encodeICCID(%q) = %X, want %s", tt.iccid, got, tt.want)
+		}
+		if back := decodeICCID(got); back != tt.iccid {
+			t.Errorf("decodeICCID(encodeICCID(%q)) = %q", tt.iccid, back)
+		}
+	}
+	for _, iccid := range []string{"", "89860000000000001", "898600000000000000191", "8986000000000000001F"} {
+		if _, err := encodeICCID(iccid); err == nil {
+			t.Errorf("encodeICCID(%q) succeeded", iccid)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+// Package atsim simulates an AT command modem behind a pseudo-terminal so
+// engine/at can be exercised without hardware. The simulator answers from a
+// table of handlers, preloaded with the commands the bot sends to a Fibocom
+// FM350, and can inject URCs and simulate timeouts, garbled echo and error
+// codes.
+//
+//	sim, err := atsim.New()
+//	defer sim.Close()
+//	sim.Handle("AT+EID?", atsim.CMEError(10))
+//	h := at.NewHandler(sim.Port())
+package atsim
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Response is what the simulator prints for a command.
+type Response struct {
+	// Lines precede the final result code.
+	Lines []string
+	// Final is the result code; empty means "OK".
+	Final string
+	// Delay postpones the whole response.
+	Delay time.Duration
+	// NoReply makes the modem stay silent, as if the command hung.
+	NoReply bool
+}
+
+// OK answers with lines followed by OK.
+func OK(lines ...string) Response {
+	return Response{Lines: lines}
+}
+
+// Error answers with a plain ERROR.
+func Error() Response {
+	return Response{Final: "ERROR"}
+}
+
+// CMEError answers with "+CME ERROR: <code>".
+func CMEError(code int) Response {
+	return Response{Final: fmt.Sprintf("+CME ERROR: %d", code)}
+}
+
+// CMSError answers with "+CMS ERROR: <code>".
+func CMSError(code int) Response {
+	return Response{Final: fmt.Sprintf("+CMS ERROR: %d", code)}
+}
+
+// Timeout never answers.
+func Timeout() Response {
+	return Response{NoReply: true}
+}
+
+// HandlerFunc computes the response to cmd.
+type HandlerFunc func(cmd string) Response
+
+// PromptFunc computes the response to a command answered with the "> "
+// prompt, once payload (without the Ctrl-Z) has been received.
+type PromptFunc func(cmd, payload string) Response
+
+// EchoMode controls how commands are echoed back.
+type EchoMode int
+
+const (
+	EchoOn EchoMode = iota
+	EchoOff
+	// EchoGarbled echoes commands with some characters corrupted, as seen
+	// on noisy lines or when the modem drops bytes.
+	EchoGarbled
+)
+
+type prefixHandler struct {
+	prefix string
+	fn     HandlerFunc
+	prompt PromptFunc
+}
+
+// Modem is a simulated modem. Its methods are safe for concurrent use.
+type Modem struct {
+	master *os.File
+	slave  *os.File
+	done   chan struct{}
+
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	echo     EchoMode
+	exact    map[string]HandlerFunc
+	prefixes []prefixHandler
+	commands []string
+
+	esimCfg  string
+	sms      map[int]storedSms
+	sent     []string
+	mems     [3]string      // AT+CPMS <mem1>..<mem3>
+	capacity map[string]int // message storages and their sizes
+}
+
+type storedSms struct {
+	stat int
+	pdu  string
+	mem  string
+}
+
+// New creates a simulator answering like an FM350 with a registered SIM.
+// Use Port to connect to it.
+func New() (*Modem, error) {
+	master, slave, err := openPTY()
+	if err != nil {
+		return nil, err
+	}
+	m := &Modem{
+		master:   master,
+		slave:    slave,
+		done:     make(chan struct{}),
+		exact:    make(map[string]HandlerFunc),
+		esimCfg:  "0,0,0",
+		sms:      make(map[int]storedSms),
+		mems:     [3]string{"ME", "ME", "ME"},
+		capacity: map[string]int{"SM": 50, "ME": 255},
+	}
+	m.loadDefaults()
+	go m.serve()
+	return m, nil
+}
+
+// Port returns the device path to open, e.g. with at.NewHandler.
+func (m *Modem) Port() string {
+	return m.slave.Name()
+}
+
+// Close shuts the simulator down. Open ports see an I/O error.
+func (m *Modem) Close() error {
+	m.master.Close()
+	err := m.slave.Close()
+	<-m.done
+	return err
+}
+
+// Handle answers the exact command cmd (case-insensitive) with resp,
+// replacing any previous handler.
+func (m *Modem) Handle(cmd string, resp Response) {
+	m.HandleFunc(cmd, func(string) Response { return resp })
+}
+
+// HandleFunc answers the exact command cmd (case-insensitive) with fn.
+func (m *Modem) HandleFunc(cmd string, fn HandlerFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.exact[strings.ToUpper(cmd)] = fn
+}
+
+// HandlePrefix answers every command starting with prefix (case-insensitive)
+// that has no exact handler. Longer prefixes win.
+func (m *Modem) HandlePrefix(prefix string, fn HandlerFunc) {
+	m.addPrefix(prefixHandler{prefix: strings.ToUpper(prefix), fn: fn})
+}
+
+// HandlePrompt makes commands starting with prefix answer with the "> "
+// prompt and read a payload terminated by Ctrl-Z, like AT+CMGS.
+func (m *Modem) HandlePrompt(prefix string, fn PromptFunc) {
+	m.addPrefix(prefixHandler{prefix: strings.ToUpper(prefix), prompt: fn})
+}
+
+func (m *Modem) addPrefix(p prefixHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.prefixes {
+		if m.prefixes[i].prefix == p.prefix {
+			m.prefixes[i] = p
+			return
+		}
+	}
+	m.prefixes = append(m.prefixes, p)
+	sort.SliceStable(m.prefixes, func(i, j int) bool {
+		return len(m.prefixes[i].prefix) > len(m.prefixes[j].prefix)
+	})
+}
+
+// SetEcho changes how commands are echoed. ATE0/ATE1 change it too.
+func (m *Modem) SetEcho(mode EchoMode) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.echo = mode
+}
+
+// InjectURC prints an unsolicited result code, e.g. "RING" or
+// `+CLIP: "10086",129`.
+func (m *Modem) InjectURC(lines ...string) {
+	var sb strings.Builder
+	for _, l := range lines {
+		sb.WriteString("\r\n" + l + "\r\n")
+	}
+	m.write(sb.String())
+}
+
+// Commands returns every command received so far.
+func (m *Modem) Commands() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.commands...)
+}
+
+func (m *Modem) write(s string) {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	m.master.Write([]byte(s))
+}
+
+// serve reads commands from the port until the simulator is closed.
+func (m *Modem) serve() {
+	defer close(m.done)
+
+	var line []byte
+	var prompt *prefixHandler // set while reading a payload
+	var promptCmd string
+	buf := make([]byte, 512)
+	for {
+		n, err := m.master.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, b := range buf[:n] {
+			if prompt != nil {
+				switch b {
+				case 0x1a: // Ctrl-Z sends
+					m.respond(prompt.prompt(promptCmd, string(line)))
+					prompt, line = nil, nil
+				case 0x1b: // ESC cancels
+					m.write("\r\nOK\r\n")
+					prompt, line = nil, nil
+				default:
+					line = append(line, b)
+				}
+				continue
+			}
+
+			switch b {
+			case '\r':
+				cmd := strings.TrimSpace(string(line))
+				line = nil
+				if cmd == "" {
+					continue
+				}
+				if p := m.dispatch(cmd); p != nil {
+					prompt, promptCmd = p, cmd
+				}
+			case '\n':
+			default:
+				line = append(line, b)
+			}
+		}
+	}
+}
+
+// dispatch echoes and answers cmd. It returns the handler when the command
+// waits for a prompt payload.
+func (m *Modem) dispatch(cmd string) *prefixHandler {
+	m.mu.Lock()
+	m.commands = append(m.commands, cmd)
+	echo := m.echo
+	upper := strings.ToUpper(cmd)
+	fn, ok := m.exact[upper]
+	var prefix *prefixHandler
+	if !ok {
+		for i := range m.prefixes {
+			if strings.HasPrefix(upper, m.prefixes[i].prefix) {
+				p := m.prefixes[i]
+				prefix = &p
+				break
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	switch echo {
+	case EchoOn:
+		m.write(cmd + "\r")
+	case EchoGarbled:
+		m.write(garble(cmd) + "\r")
+	}
+
+	switch {
+	case ok:
+		m.respond(fn(cmd))
+	case prefix != nil && prefix.prompt != nil:
+		m.write("\r\n> ")
+		return prefix
+	case prefix != nil:
+		m.respond(prefix.fn(cmd))
+	default:
+		m.respond(Error())
+	}
+	return nil
+}
+
+func (m *Modem) respond(r Response) {
+	if r.NoReply {
+		return
+	}
+	if r.Delay > 0 {
+		time.Sleep(r.Delay)
+	}
+	final := r.Final
+	if final == "" {
+		final = "OK"
+	}
+	var sb strings.Builder
+	for _, l := range r.Lines {
+		sb.WriteString("\r\n" + l + "\r\n")
+	}
+	sb.WriteString("\r\n" + final + "\r\n")
+	m.write(sb.String())
+}
+
+// garble corrupts every third character of s.
+func garble(s string) string {
+	b := []byte(s)
+	for i := 2; i < len(b); i += 3 {
+		b[i] ^= 0x20
+	}
+	return string(b)
+}
@@ -0,0 +1,241 @@
+package atsim
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Identities reported by the default handlers.
+const (
+	Model = "FM350-GL"
+	IMEI  = "352906110000001"
+	ICCID = "89860000000000000001"
+	EID   = "89049032000000000000000000000001"
+)
+
+// loadDefaults installs the FM350 command table.
+func (m *Modem) loadDefaults() {
+	for cmd, resp := range map[string]Response{
+		"AT":          OK(),
+		"AT+CGMI":     OK("Fibocom Wireless Inc."),
+		"AT+CGMM":     OK(Model),
+		"AT+CGSN":     OK(IMEI),
+		"AT+CFUN?":    OK("+CFUN: 1"),
+		"AT+CCID?":    OK("+CCID: " + ICCID),
+		"AT+EID?":     OK("+EID: " + EID),
+		"AT+SIMTYPE?": OK("+SIMTYPE: 1"),
+		"AT+CSPN?":    OK(`+CSPN: "CMCC",0`),
+		"AT+COPS?":    OK(`+COPS: 0,0,"CHINA MOBILE",7`),
+		"AT+CEREG?":   OK("+CEREG: 0,1"),
+		"AT+C5GREG?":  OK("+C5GREG: 0,0"),
+		"AT+CREG?":    OK("+CREG: 0,1"),
+		"AT+CSQ":      OK("+CSQ: 20,99"),
+		"AT+CESQ":     OK("+CESQ: 99,99,255,255,20,50"),
+		"AT+CGACT?":   OK("+CGACT: 1,1"),
+		"AT+CGPADDR":  OK(`+CGPADDR: 1,"10.0.0.2","32.1.13.184.0.0.0.0.0.0.0.0.0.0.0.1"`),
+	} {
+		m.Handle(cmd, resp)
+	}
+
+	// Settings that are simply accepted.
+	for _, prefix := range []string{"AT+CMGF=", "AT+CNMI=", "AT+CLIP=", "AT+CREG=", "AT+CEREG=", "AT+COPS=", "AT+CGACT=", "AT+GTDUALSIM="} {
+		m.HandlePrefix(prefix, func(string) Response { return OK() })
+	}
+
+	m.HandleFunc("ATE0", func(string) Response { m.SetEcho(EchoOff); return OK() })
+	m.HandleFunc("ATE1", func(string) Response { m.SetEcho(EchoOn); return OK() })
+
+	m.HandleFunc("AT+GTESIMCFG?", func(string) Response {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return OK("+GTESIMCFG: " + m.esimCfg)
+	})
+	m.HandlePrefix("AT+GTESIMCFG=", func(cmd string) Response {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.esimCfg = cmd[len("AT+GTESIMCFG="):]
+		return OK()
+	})
+
+	m.HandleFunc("AT+CPMS=?", func(string) Response {
+		return OK(`+CPMS: ("SM","ME"),("SM","ME"),("SM","ME")`)
+	})
+	m.HandleFunc("AT+CPMS?", m.storageStatus)
+	m.HandlePrefix("AT+CPMS=", m.selectStorage)
+
+	m.HandleFunc("AT+CMGL=4", m.listSms)
+	m.HandlePrefix("AT+CMGR=", m.readSms)
+	m.HandlePrefix("AT+CMGD=", m.deleteSms)
+	m.HandlePrompt("AT+CMGS=", m.sendSms)
+}
+
+// DeliverSms stores a received SMS-DELIVER PDU (hex, with SMSC) and
+// announces it with +CMTI, returning its storage index.
+func (m *Modem) DeliverSms(pdu string) int {
+	index := m.StoreSms(pdu)
+	m.mu.Lock()
+	mem := m.sms[index].mem
+	m.mu.Unlock()
+
+	m.InjectURC(fmt.Sprintf(`+CMTI: "%s",%d`, mem, index))
+	return index
+}
+
+// StoreSms stores a received SMS-DELIVER PDU as unread without announcing
+// it, as if it arrived while nobody was listening, and returns its storage
+// index.
+func (m *Modem) StoreSms(pdu string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	index := 0
+	for {
+		if _, ok := m.sms[index]; !ok {
+			break
+		}
+		index++
+	}
+	m.sms[index] = storedSms{stat: 0, pdu: pdu, mem: m.mems[2]}
+	return index
+}
+
+// StoredSms returns the indexes of the messages in storage.
+func (m *Modem) StoredSms() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []int
+	for i := range m.sms {
+		out = append(out, i)
+	}
+	sort.Ints(out)
+	return out
+}
+
+// SetSmsCapacity sets the size of storage mem (e.g. "SM"), as reported
+// by AT+CPMS.
+func (m *Modem) SetSmsCapacity(mem string, total int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.capacity[mem] = total
+}
+
+// SentPDUs returns the PDUs received through AT+CMGS.
+func (m *Modem) SentPDUs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.sent...)
+}
+
+// storageUsage formats "<mem>",<used>,<total>; the caller holds m.mu.
+func (m *Modem) storageUsage(mem string, quoted bool) string {
+	used := 0
+	for _, s := range m.sms {
+		if s.mem == mem {
+			used++
+		}
+	}
+	if quoted {
+		return fmt.Sprintf(`"%s",%d,%d`, mem, used, m.capacity[mem])
+	}
+	return fmt.Sprintf("%d,%d", used, m.capacity[mem])
+}
+
+func (m *Modem) storageStatus(string) Response {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var usage []string
+	for _, mem := range m.mems {
+		usage = append(usage, m.storageUsage(mem, true))
+	}
+	return OK("+CPMS: " + strings.Join(usage, ","))
+}
+
+// selectStorage handles AT+CPMS=<mem1>[,<mem2>[,<mem3>]].
+func (m *Modem) selectStorage(cmd string) Response {
+	args := strings.Split(cmd[len("AT+CPMS="):], ",")
+	if len(args) > 3 {
+		return CMSError(302) // operation not allowed
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, arg := range args {
+		mem := strings.ToUpper(strings.Trim(arg, `"`))
+		if _, ok := m.capacity[mem]; !ok {
+			return CMSError(303) // operation not supported
+		}
+		m.mems[i] = mem
+	}
+	var usage []string
+	for _, mem := range m.mems {
+		usage = append(usage, m.storageUsage(mem, false))
+	}
+	return OK("+CPMS: " + strings.Join(usage, ","))
+}
+
+func (m *Modem) listSms(string) Response {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var indexes []int
+	for i := range m.sms {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	var lines []string
+	for _, i := range indexes {
+		s := m.sms[i]
+		lines = append(lines, fmt.Sprintf("+CMGL: %d,%d,,%d", i, s.stat, tpduLength(s.pdu)), s.pdu)
+		if s.stat == 0 {
+			s.stat = 1
+			m.sms[i] = s
+		}
+	}
+	return OK(lines...)
+}
+
+func (m *Modem) readSms(cmd string) Response {
+	index, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(cmd), "AT+CMGR="))
+	if err != nil {
+		return CMSError(321) // invalid memory index
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sms[index]
+	if !ok {
+		return CMSError(321)
+	}
+	lines := []string{fmt.Sprintf("+CMGR: %d,,%d", s.stat, tpduLength(s.pdu)), s.pdu}
+	if s.stat == 0 {
+		s.stat = 1
+		m.sms[index] = s
+	}
+	return OK(lines...)
+}
+
+func (m *Modem) deleteSms(cmd string) Response {
+	index, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(cmd), "AT+CMGD="))
+	if err != nil {
+		return CMSError(321)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sms, index)
+	return OK()
+}
+
+func (m *Modem) sendSms(cmd, payload string) Response {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, payload)
+	return OK(fmt.Sprintf("+CMGS: %d", len(m.sent)))
+}
+
+// tpduLength is the length in octets of a PDU without its SMSC field.
+func tpduLength(pdu string) int {
+	if len(pdu) < 2 {
+		return 0
+	}
+	smsc, _ := strconv.ParseUint(pdu[:2], 16, 8)
+	return len(pdu)/2 - 1 - int(smsc)
+}
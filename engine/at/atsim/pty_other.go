@@ -0,0 +1,12 @@
+//go:build !linux
+
+package atsim
+
+import (
+	"errors"
+	"os"
+)
+
+func openPTY() (master, slave *os.File, err error) {
+	return nil, nil, errors.New("atsim: pseudo-terminals are only supported on Linux")
+}
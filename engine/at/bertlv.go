@@ -0,0 +1,142 @@
+package at
+
+import (
+	"errors"
+	"fmt"
+)
+
+// tlv is a decoded BER-TLV data object as used by the eUICC (SGP.22).
+// Tags are kept in their encoded form, e.g. 0xBF2D or 0x5A.
+type tlv struct {
+	tag   uint32
+	value []byte
+	raw   []byte // the complete encoding, tag and length included
+}
+
+var errTruncatedTLV = errors.New("truncated BER-TLV")
+
+// parseTLV decodes the first data object in b and returns the remainder.
+func parseTLV(b []byte) (tlv, []byte, error) {
+	if len(b) == 0 {
+		return tlv{}, nil, errTruncatedTLV
+	}
+
+	// Tag: a low 5 bits value of 0x1F means more tag bytes follow, each with
+	// bit 8 set except the last.
+	i := 0
+	tag := uint32(b[i])
+	i++
+	if b[0]&0x1F == 0x1F {
+		for {
+			if i >= len(b) {
+				return tlv{}, nil, errTruncatedTLV
+			}
+			tag = tag<<8 | uint32(b[i])
+			i++
+			if b[i-1]&0x80 == 0 {
+				break
+			}
+		}
+	}
+
+	// Length: short form below 0x80, otherwise 0x8N followed by N bytes.
+	if i >= len(b) {
+		return tlv{}, nil, errTruncatedTLV
+	}
+	length := int(b[i])
+	i++
+	if length&0x80 != 0 {
+		n := length & 0x7F
+		if n == 0 || n > 3 {
+			return tlv{}, nil, fmt.Errorf("unsupported BER-TLV length form 0x%02X", length)
+		}
+		if i+n > len(b) {
+			return tlv{}, nil, errTruncatedTLV
+		}
+		length = 0
+		for _, c := range b[i : i+n] {
+			length = length<<8 | int(c)
+		}
+		i += n
+	}
+
+	if i+length > len(b) {
+		return tlv{}, nil, errTruncatedTLV
+	}
+	return tlv{tag: tag, value: b[i : i+length], raw: b[:i+length]}, b[i+length:], nil
+}
+
+// parseTLVs decodes a sequence of data objects.
+func parseTLVs(b []byte) ([]tlv, error) {
+	var out []tlv
+	for len(b) > 0 {
+		t, rest, err := parseTLV(b)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+		b = rest
+	}
+	return out, nil
+}
+
+// children decodes the value of a constructed data object.
+func (t tlv) children() ([]tlv, error) {
+	return parseTLVs(t.value)
+}
+
+// child returns the first direct child with the given tag.
+func (t tlv) child(tag uint32) (tlv, bool) {
+	children, err := t.children()
+	if err != nil {
+		return tlv{}, false
+	}
+	for _, c := range children {
+		if c.tag == tag {
+			return c, true
+		}
+	}
+	return tlv{}, false
+}
+
+// encodeTLV builds a data object from a tag and its value.
+func encodeTLV(tag uint32, value ...[]byte) []byte {
+	var body []byte
+	for _, v := range value {
+		body = append(body, v...)
+	}
+
+	var out []byte
+	switch {
+	case tag > 0xFFFFFF:
+		out = append(out, byte(tag>>24), byte(tag>>16), byte(tag>>8), byte(tag))
+	case tag > 0xFFFF:
+		out = append(out, byte(tag>>16), byte(tag>>8), byte(tag))
+	case tag > 0xFF:
+		out = append(out, byte(tag>>8), byte(tag))
+	default:
+		out = append(out, byte(tag))
+	}
+
+	n := len(body)
+	switch {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n <= 0xFF:
+		out = append(out, 0x81, byte(n))
+	case n <= 0xFFFF:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	default:
+		out = append(out, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(out, body...)
+}
+
+// tlvInt decodes a (small, non-negative) ASN.1 INTEGER value.
+func tlvInt(value []byte) int {
+	n := 0
+	for _, b := range value {
+		n = n<<8 | int(b)
+	}
+	return n
+}
@@ -0,0 +1,431 @@
+package at
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"tg_modem/engine"
+)
+
+// ES10b data object tags used by the download flow (SGP.22 v2.x).
+const (
+	tagGetEuiccChallenge      = 0xBF2E
+	tagEuiccInfo1             = 0xBF20
+	tagAuthenticateServer     = 0xBF38
+	tagPrepareDownload        = 0xBF21
+	tagBoundProfilePackage    = 0xBF36
+	tagInitSecureChannel      = 0xBF23
+	tagProfileInstallResult   = 0xBF37
+	tagProfileInstallData     = 0xBF27
+	tagNotificationMetadata   = 0xBF2F
+	tagStoreMetadata          = 0xBF25
+	tagCancelSession          = 0xBF41
+	tagNotificationAddress    = 0x0C
+	tagTransactionID          = 0x80
+	tagCcRequiredFlag         = 0x01
+	tagHashCc                 = 0x04
+	tagFinalResult            = 0xA2
+	tagDownloadErrorResult    = 0xA1
+	cancelReasonUndefined     = 127
+	defaultTypeAllocationCode = "35290611"
+)
+
+// ActivationCode is a parsed SGP.22 activation code.
+type ActivationCode struct {
+	SmdpAddress        string
+	MatchingID         string
+	ConfirmationNeeded bool
+}
+
+// ParseActivationCode parses "LPA:1$<SM-DP+ address>$<Matching ID>[$<OID>[$<CC flag>]]".
+func ParseActivationCode(code string) (*ActivationCode, error) {
+	code = strings.TrimSpace(code)
+	if len(code) >= 4 && strings.EqualFold(code[:4], "LPA:") {
+		code = code[4:]
+	}
+	parts := strings.Split(code, "$")
+	if len(parts) < 3 || parts[0] != "1" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid activation code %q", code)
+	}
+	return &ActivationCode{
+		SmdpAddress:        parts[1],
+		MatchingID:         parts[2],
+		ConfirmationNeeded: len(parts) > 4 && parts[4] == "1",
+	}, nil
+}
+
+// SetSMDPTransport replaces the transport used to reach SM-DP+ servers.
+func (h *Handler) SetSMDPTransport(t SMDPTransport) {
+	h.smdp = t
+}
+
+func (h *Handler) smdpTransport() SMDPTransport {
+	if h.smdp == nil {
+		return &HTTPTransport{}
+	}
+	return h.smdp
+}
+
+// DownloadProfile downloads and installs the profile described by
+// activationCode (ES9+/ES10b common mutual authentication, download and
+// installation). progress may be nil.
+func (h *Handler) DownloadProfile(ctx context.Context, activationCode, confirmationCode string, progress func(engine.DownloadProgress)) (*engine.EsimProfile, error) {
+	ac, err := ParseActivationCode(activationCode)
+	if err != nil {
+		return nil, err
+	}
+	if ac.ConfirmationNeeded && confirmationCode == "" {
+		return nil, errors.New("this activation code requires a confirmation code")
+	}
+	if progress == nil {
+		progress = func(engine.DownloadProgress) {}
+	}
+	tac := h.typeAllocationCode(ctx)
+	t := h.smdpTransport()
+
+	var profile *engine.EsimProfile
+	err = h.withISDR(ctx, func(c *logicalChannel) error {
+		d := &download{ctx: ctx, c: c, t: t, address: ac.SmdpAddress}
+		profile, err = d.run(ac.MatchingID, confirmationCode, tac, progress)
+		if err != nil && d.transactionID != "" {
+			d.cancel()
+		}
+		return err
+	})
+	return profile, err
+}
+
+// download holds the state of one RSP session.
+type download struct {
+	ctx           context.Context
+	c             *logicalChannel
+	t             SMDPTransport
+	address       string
+	transactionID string
+}
+
+func (d *download) run(matchingID, confirmationCode, tac string, progress func(engine.DownloadProgress)) (*engine.EsimProfile, error) {
+	// 1. Mutual authentication: the eUICC authenticates the server first.
+	progress(engine.DownloadProgress{Stage: engine.DownloadAuthenticateServer})
+	challenge, err := d.c.es10(encodeTLV(tagGetEuiccChallenge))
+	if err != nil {
+		return nil, fmt.Errorf("GetEUICCChallenge failed: %w", err)
+	}
+	ch, ok := challenge.child(0x80)
+	if !ok {
+		return nil, fmt.Errorf("unexpected GetEUICCChallenge response %X", challenge.raw)
+	}
+	info1, err := d.c.es10(encodeTLV(tagEuiccInfo1))
+	if err != nil {
+		return nil, fmt.Errorf("GetEUICCInfo1 failed: %w", err)
+	}
+
+	var initResp initiateAuthenticationResponse
+	err = callES9(d.ctx, d.t, d.address, "initiateAuthentication", &initiateAuthenticationRequest{
+		EuiccChallenge: b64(ch.value),
+		EuiccInfo1:     b64(info1.raw),
+		SmdpAddress:    d.address,
+	}, &initResp)
+	if err != nil {
+		return nil, err
+	}
+	d.transactionID = initResp.TransactionID
+
+	var ctxParams []byte
+	if matchingID != "" {
+		ctxParams = encodeTLV(0x80, []byte(matchingID))
+	}
+	ctxParams = encodeTLV(0xA0, ctxParams, deviceInfo(tac))
+	authReq, err := concatB64(initResp.ServerSigned1, initResp.ServerSignature1, initResp.EuiccCiPKIdToBeUsed, initResp.ServerCertificate)
+	if err != nil {
+		return nil, err
+	}
+	authResp, err := d.c.es10(encodeTLV(tagAuthenticateServer, authReq, ctxParams))
+	if err != nil {
+		return nil, fmt.Errorf("AuthenticateServer failed: %w", err)
+	}
+	if e, ok := authResp.child(0xA1); ok {
+		return nil, fmt.Errorf("AuthenticateServer rejected by eUICC: %X", e.value)
+	}
+
+	// 2. The server authenticates the eUICC and returns the profile metadata.
+	progress(engine.DownloadProgress{Stage: engine.DownloadAuthenticateClient})
+	var clientResp authenticateClientResponse
+	err = callES9(d.ctx, d.t, d.address, "authenticateClient", &authenticateClientRequest{
+		TransactionID:              d.transactionID,
+		AuthenticateServerResponse: b64(authResp.raw),
+	}, &clientResp)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := decodeProfileMetadata(clientResp.ProfileMetadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. PrepareDownload, including the hashed confirmation code if required.
+	progress(engine.DownloadProgress{Stage: engine.DownloadPrepare, Profile: profile})
+	prepReq, err := concatB64(clientResp.SmdpSigned2, clientResp.SmdpSignature2)
+	if err != nil {
+		return nil, err
+	}
+	signed2, _, err := parseTLV(prepReq)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smdpSigned2: %w", err)
+	}
+	if flag, ok := signed2.child(tagCcRequiredFlag); ok && tlvInt(flag.value) != 0 {
+		if confirmationCode == "" {
+			return nil, errors.New("the SM-DP+ requires a confirmation code")
+		}
+		txID, _ := signed2.child(tagTransactionID)
+		prepReq = append(prepReq, encodeTLV(tagHashCc, hashConfirmationCode(confirmationCode, txID.value))...)
+	}
+	cert, err := base64.StdEncoding.DecodeString(clientResp.SmdpCertificate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smdpCertificate: %w", err)
+	}
+	prepResp, err := d.c.es10(encodeTLV(tagPrepareDownload, prepReq, cert))
+	if err != nil {
+		return nil, fmt.Errorf("PrepareDownload failed: %w", err)
+	}
+	if e, ok := prepResp.child(0xA1); ok {
+		return nil, fmt.Errorf("PrepareDownload rejected by eUICC: %X", e.value)
+	}
+
+	// 4. Fetch the bound profile package for this eUICC.
+	progress(engine.DownloadProgress{Stage: engine.DownloadFetchPackage, Profile: profile})
+	var bppResp getBoundProfilePackageResponse
+	err = callES9(d.ctx, d.t, d.address, "getBoundProfilePackage", &getBoundProfilePackageRequest{
+		TransactionID:           d.transactionID,
+		PrepareDownloadResponse: b64(prepResp.raw),
+	}, &bppResp)
+	if err != nil {
+		return nil, err
+	}
+	bpp, err := base64.StdEncoding.DecodeString(bppResp.BoundProfilePackage)
+	if err != nil {
+		return nil, fmt.Errorf("invalid boundProfilePackage: %w", err)
+	}
+
+	// 5. Install it. Past this point the eUICC owns the session, so errors
+	// are reported through the installation result rather than cancelled.
+	progress(engine.DownloadProgress{Stage: engine.DownloadInstall, Profile: profile})
+	result, err := d.loadBoundProfilePackage(bpp)
+	d.transactionID = ""
+	if err != nil {
+		return nil, err
+	}
+
+	// 6. Tell the SM-DP+ how it went.
+	progress(engine.DownloadProgress{Stage: engine.DownloadNotify, Profile: profile})
+	installErr := installationError(result)
+	if err := d.notify(result); err != nil {
+		log.Printf("WARN: failed to deliver profile installation result, it stays in the notification list: %v", err)
+	}
+	if installErr != nil {
+		return nil, installErr
+	}
+	return profile, nil
+}
+
+// loadBoundProfilePackage sends the BPP to the eUICC in the segments
+// required by ES10b.LoadBoundProfilePackage and returns the
+// ProfileInstallationResult.
+func (d *download) loadBoundProfilePackage(bpp []byte) (tlv, error) {
+	pkg, _, err := parseTLV(bpp)
+	if err != nil || pkg.tag != tagBoundProfilePackage {
+		return tlv{}, fmt.Errorf("invalid bound profile package: %v", err)
+	}
+	parts, err := pkg.children()
+	if err != nil {
+		return tlv{}, err
+	}
+
+	header := func(t tlv) []byte { return t.raw[:len(t.raw)-len(t.value)] }
+
+	var segments [][]byte
+	for _, p := range parts {
+		switch p.tag {
+		case tagInitSecureChannel:
+			segments = append(segments, append(append([]byte(nil), header(pkg)...), p.raw...))
+		case 0xA0, 0xA2:
+			segments = append(segments, p.raw)
+		case 0xA1, 0xA3:
+			segments = append(segments, header(p))
+			children, err := p.children()
+			if err != nil {
+				return tlv{}, err
+			}
+			for _, c := range children {
+				segments = append(segments, c.raw)
+			}
+		}
+	}
+
+	for i, seg := range segments {
+		resp, err := d.c.storeData(seg)
+		if err != nil {
+			return tlv{}, fmt.Errorf("LoadBoundProfilePackage segment %d/%d failed: %w", i+1, len(segments), err)
+		}
+		if len(resp) == 0 {
+			continue
+		}
+		result, _, err := parseTLV(resp)
+		if err != nil {
+			return tlv{}, fmt.Errorf("invalid installation result %X: %w", resp, err)
+		}
+		// The eUICC answers early if installation fails.
+		return result, nil
+	}
+	return tlv{}, errors.New("eUICC returned no installation result")
+}
+
+// notify delivers the ProfileInstallationResult to the SM-DP+ and removes
+// it from the eUICC's pending notifications.
+func (d *download) notify(result tlv) error {
+	address := d.address
+	if addr, ok := pendingMetadata(result).child(tagNotificationAddress); ok && len(addr.value) > 0 {
+		address = string(addr.value)
+	}
+	err := callES9(d.ctx, d.t, address, "handleNotification", &handleNotificationRequest{
+		PendingNotification: b64(result.raw),
+	}, &emptyResponse{})
+	if err != nil {
+		return err
+	}
+	d.c.removeDeliveredResult(result)
+	return nil
+}
+
+// cancel aborts the RSP session on the eUICC and the SM-DP+. Best effort.
+func (d *download) cancel() {
+	txID, err := hex.DecodeString(d.transactionID)
+	if err != nil {
+		txID = []byte(d.transactionID)
+	}
+	resp, err := d.c.es10(encodeTLV(tagCancelSession,
+		encodeTLV(0x80, txID),
+		encodeTLV(0x81, []byte{cancelReasonUndefined}),
+	))
+	if err != nil {
+		log.Printf("WARN: CancelSession on eUICC failed: %v", err)
+		return
+	}
+	err = callES9(d.ctx, d.t, d.address, "cancelSession", &cancelSessionRequest{
+		TransactionID:         d.transactionID,
+		CancelSessionResponse: b64(resp.raw),
+	}, &emptyResponse{})
+	if err != nil {
+		log.Printf("WARN: ES9+ cancelSession failed: %v", err)
+	}
+}
+
+// installationError extracts the failure from a ProfileInstallationResult.
+func installationError(result tlv) error {
+	if result.tag != tagProfileInstallResult {
+		return fmt.Errorf("unexpected installation result %X", result.raw)
+	}
+	data, ok := result.child(tagProfileInstallData)
+	if !ok {
+		return fmt.Errorf("unexpected installation result %X", result.raw)
+	}
+	final, ok := data.child(tagFinalResult)
+	if !ok {
+		return fmt.Errorf("unexpected installation result %X", result.raw)
+	}
+	if e, ok := final.child(tagDownloadErrorResult); ok {
+		cmd, _ := e.child(0x80)
+		reason, _ := e.child(0x81)
+		return fmt.Errorf("profile installation failed: BPP command %d, error reason %d", tlvInt(cmd.value), tlvInt(reason.value))
+	}
+	return nil
+}
+
+// decodeProfileMetadata reads the StoreMetadataRequest sent by the SM-DP+.
+func decodeProfileMetadata(encoded string) (*engine.EsimProfile, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profileMetadata: %w", err)
+	}
+	meta, _, err := parseTLV(raw)
+	if err != nil || meta.tag != tagStoreMetadata {
+		return nil, fmt.Errorf("invalid profileMetadata %X", raw)
+	}
+	p := &engine.EsimProfile{}
+	if v, ok := meta.child(tagICCID); ok {
+		p.ICCID = decodeICCID(v.value)
+	}
+	if v, ok := meta.child(tagServiceProvider); ok {
+		p.ServiceProvider = string(v.value)
+	}
+	if v, ok := meta.child(tagProfileName); ok {
+		p.Name = string(v.value)
+	}
+	if v, ok := meta.child(tagProfileClass); ok {
+		p.Class = profileClassName(tlvInt(v.value))
+	}
+	return p, nil
+}
+
+// deviceInfo builds the DeviceInfo sent in ctxParams1. The capabilities
+// advertise support up to 3GPP release 15 on all radio access technologies.
+func deviceInfo(tac string) []byte {
+	rel15 := []byte{0x0F, 0x00, 0x00}
+	tacBytes := make([]byte, 4)
+	for i := 0; i < 4; i++ {
+		tacBytes[i] = (tac[2*i]-'0')<<4 | (tac[2*i+1] - '0')
+	}
+	return encodeTLV(0xA1,
+		encodeTLV(0x80, tacBytes),
+		encodeTLV(0xA1,
+			encodeTLV(0x80, rel15), // gsmSupportedRelease
+			encodeTLV(0x81, rel15), // utranSupportedRelease
+			encodeTLV(0x85, rel15), // eutranEpcSupportedRelease
+			encodeTLV(0x88, rel15), // nrEpcSupportedRelease
+			encodeTLV(0x89, rel15), // nr5gcSupportedRelease
+			encodeTLV(0x8A, rel15), // eutran5gcSupportedRelease
+		),
+	)
+}
+
+// typeAllocationCode returns the first 8 digits of the modem's IMEI, or a
+// generic TAC if it cannot be read.
+func (h *Handler) typeAllocationCode(ctx context.Context) string {
+	resp, err := h.SendCommandContext(ctx, "AT+CGSN")
+	if err == nil {
+		imei := strings.Trim(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(resp), "+CGSN:")), `"`)
+		if len(imei) >= 8 && strings.Trim(imei[:8], "0123456789") == "" {
+			return imei[:8]
+		}
+	}
+	return defaultTypeAllocationCode
+}
+
+// hashConfirmationCode computes SHA256(SHA256(code) | transactionId).
+func hashConfirmationCode(code string, transactionID []byte) []byte {
+	h1 := sha256.Sum256([]byte(code))
+	h2 := sha256.Sum256(append(h1[:], transactionID...))
+	return h2[:]
+}
+
+func b64(b []byte) string {
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// concatB64 decodes and concatenates base64 encoded data objects.
+func concatB64(fields ...string) ([]byte, error) {
+	var out []byte
+	for _, f := range fields {
+		b, err := base64.StdEncoding.DecodeString(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 field from SM-DP+: %w", err)
+		}
+		out = append(out, b...)
+	}
+	return out, nil
+}
@@ -0,0 +1,408 @@
+package at
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"tg_modem/engine"
+	"tg_modem/engine/at/atsim"
+)
+
+// fakeEUICC answers ES10 commands sent over AT+CGLA. STORE DATA chains are
+// reassembled before respond is called with the complete command.
+type fakeEUICC struct {
+	mu       sync.Mutex
+	chain    []byte
+	commands [][]byte
+	respond  func(cmd []byte) []byte
+}
+
+func newFakeEUICC(sim *atsim.Modem, respond func(cmd []byte) []byte) *fakeEUICC {
+	e := &fakeEUICC{respond: respond}
+	sim.Handle(fmt.Sprintf(`AT+CCHO="%X"`, isdrAID), atsim.OK("+CCHO: 1"))
+	sim.Handle("AT+CCHC=1", atsim.OK())
+	sim.HandlePrefix("AT+CGLA=", func(cmd string) atsim.Response {
+		_, arg, _ := strings.Cut(cmd, `"`)
+		apdu, err := hex.DecodeString(strings.TrimSuffix(arg, `"`))
+		if err != nil || len(apdu) < 5 || apdu[1] != 0xE2 {
+			return atsim.OK(`+CGLA: 4,"6D00"`)
+		}
+		data := []byte{0x90, 0x00}
+		if resp := e.storeData(apdu[2], apdu[5:5+int(apdu[4])]); resp != nil {
+			data = append(resp, 0x90, 0x00)
+		}
+		return atsim.OK(fmt.Sprintf(`+CGLA: %d,"%X"`, len(data)*2, data))
+	})
+	return e
+}
+
+func (e *fakeEUICC) storeData(p1 byte, block []byte) []byte {
+	e.mu.Lock()
+	e.chain = append(e.chain, block...)
+	if p1&0x80 == 0 {
+		e.mu.Unlock()
+		return nil
+	}
+	cmd := e.chain
+	e.chain = nil
+	e.commands = append(e.commands, cmd)
+	e.mu.Unlock()
+	return e.respond(cmd)
+}
+
+// received returns the complete commands received so far.
+func (e *fakeEUICC) received() [][]byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([][]byte(nil), e.commands...)
+}
+
+// es9Call is an ES9+ request received by fakeSMDP.
+type es9Call struct {
+	address  string
+	function string
+	body     map[string]string
+}
+
+// fakeSMDP is an SM-DP+ stand-in behind httptest TLS servers, reached
+// through HTTPTransport.
+type fakeSMDP struct {
+	mu      sync.Mutex
+	calls   []es9Call
+	respond func(function string, body map[string]string) any
+}
+
+// newServer starts another ES9+ endpoint and returns its address.
+func (s *fakeSMDP) newServer(t *testing.T) (string, *http.Client) {
+	t.Helper()
+	var address string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		function, ok := strings.CutPrefix(r.URL.Path, "/gsma/rsp2/es9plus/")
+		if !ok || r.Header.Get("X-Admin-Protocol") == "" {
+			http.NotFound(w, r)
+			return
+		}
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.calls = append(s.calls, es9Call{address, function, body})
+		s.mu.Unlock()
+		if resp := s.respond(function, body); resp != nil {
+			json.NewEncoder(w).Encode(resp)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	address = strings.TrimPrefix(srv.URL, "https://")
+	return address, srv.Client()
+}
+
+func (s *fakeSMDP) received() []es9Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]es9Call(nil), s.calls...)
+}
+
+func (s *fakeSMDP) functions() []string {
+	var out []string
+	for _, c := range s.received() {
+		out = append(out, c.function)
+	}
+	return out
+}
+
+// es9Status returns an ES9+ response header with the given execution status.
+func es9Status(status, subject, reason string) map[string]any {
+	return map[string]any{"header": map[string]any{"functionExecutionStatus": map[string]any{
+		"status":         status,
+		"statusCodeData": map[string]string{"subjectCode": subject, "reasonCode": reason, "message": "test"},
+	}}}
+}
+
+func es9Success(fields map[string]any) map[string]any {
+	resp := es9Status("Executed-Success", "", "")
+	for k, v := range fields {
+		resp[k] = v
+	}
+	return resp
+}
+
+// Test data objects exchanged during a download.
+var (
+	testTransactionID = []byte{0x01, 0x02, 0x03, 0x04}
+	testChallenge     = bytes.Repeat([]byte{0xC1}, 16)
+	// testBPP has one segment per InitialiseSecureChannel, the A0 and A1
+	// headers and every element of A1 and A3, some longer than a STORE DATA
+	// block.
+	testBPP = encodeTLV(tagBoundProfilePackage,
+		encodeTLV(tagInitSecureChannel, encodeTLV(0x80, testTransactionID)),
+		encodeTLV(0xA0, encodeTLV(0x87, bytes.Repeat([]byte{0xA0}, 16))),
+		encodeTLV(0xA1, encodeTLV(0x88, bytes.Repeat([]byte{0xA1}, 16))),
+		encodeTLV(0xA3,
+			encodeTLV(0x86, bytes.Repeat([]byte{0xA3}, 200)),
+			encodeTLV(0x86, bytes.Repeat([]byte{0xA4}, 30)),
+		),
+	)
+)
+
+func testBPPSegments() [][]byte {
+	pkg, _, _ := parseTLV(testBPP)
+	parts, _ := pkg.children()
+	header := func(t tlv) []byte { return t.raw[:len(t.raw)-len(t.value)] }
+	a1, _ := parts[2].children()
+	a3, _ := parts[3].children()
+	return [][]byte{
+		append(append([]byte(nil), header(pkg)...), parts[0].raw...),
+		parts[1].raw,
+		header(parts[2]), a1[0].raw,
+		header(parts[3]), a3[0].raw, a3[1].raw,
+	}
+}
+
+// installResult builds a ProfileInstallationResult for notification
+// sequence number 5 sent to notifyAddress, failed if errorReason is non-zero.
+func installResult(notifyAddress string, errorReason byte) []byte {
+	final := encodeTLV(0xA0, encodeTLV(0x4F, []byte{0xA0, 0x00}))
+	if errorReason != 0 {
+		final = encodeTLV(tagDownloadErrorResult, encodeTLV(0x80, []byte{3}), encodeTLV(0x81, []byte{errorReason}))
+	}
+	return encodeTLV(tagProfileInstallResult, encodeTLV(tagProfileInstallData,
+		encodeTLV(tagTransactionID, testTransactionID),
+		encodeTLV(tagNotificationMetadata,
+			encodeTLV(tagSeqNumber, []byte{5}),
+			encodeTLV(tagNotificationOperation, []byte{0x07, 0x80}),
+			encodeTLV(tagNotificationAddress, []byte(notifyAddress)),
+		),
+		encodeTLV(tagFinalResult, final),
+	))
+}
+
+// newDownload wires a fake eUICC and SM-DP+ to a handler. The eUICC accepts
+// every command and installs the BPP; the SM-DP+ answers every function
+// successfully. Tests override either side by wrapping respond.
+func newDownload(t *testing.T) (*Handler, *fakeEUICC, *fakeSMDP, string, string) {
+	sim, h := newSimHandler(t)
+	smdp := &fakeSMDP{}
+	address, client := smdp.newServer(t)
+	notifyAddress, _ := smdp.newServer(t)
+	h.SetSMDPTransport(&HTTPTransport{Client: client})
+
+	iccid, _ := encodeICCID("89860000000000000019")
+	metadata := encodeTLV(tagStoreMetadata,
+		encodeTLV(tagICCID, iccid),
+		encodeTLV(tagServiceProvider, []byte("China Mobile")),
+		encodeTLV(tagProfileName, []byte("CMCC")),
+		encodeTLV(tagProfileClass, []byte{2}),
+	)
+	smdp.respond = func(function string, body map[string]string) any {
+		switch function {
+		case "initiateAuthentication":
+			return es9Success(map[string]any{
+				"transactionId":       hex.EncodeToString(testTransactionID),
+				"serverSigned1":       b64(encodeTLV(0x30, encodeTLV(0x80, testTransactionID))),
+				"serverSignature1":    b64(encodeTLV(0x5F37, make([]byte, 64))),
+				"euiccCiPKIdToBeUsed": b64(encodeTLV(0x04, make([]byte, 20))),
+				"serverCertificate":   b64(encodeTLV(0x30, make([]byte, 32))),
+			})
+		case "authenticateClient":
+			return es9Success(map[string]any{
+				"transactionId":   hex.EncodeToString(testTransactionID),
+				"profileMetadata": b64(metadata),
+				"smdpSigned2":     b64(encodeTLV(0x30, encodeTLV(tagTransactionID, testTransactionID), encodeTLV(tagCcRequiredFlag, []byte{0}))),
+				"smdpSignature2":  b64(encodeTLV(0x5F37, make([]byte, 64))),
+				"smdpCertificate": b64(encodeTLV(0x30, make([]byte, 32))),
+			})
+		case "getBoundProfilePackage":
+			return es9Success(map[string]any{
+				"transactionId":       hex.EncodeToString(testTransactionID),
+				"boundProfilePackage": b64(testBPP),
+			})
+		}
+		return es9Success(nil)
+	}
+
+	segments := testBPPSegments()
+	loaded := 0
+	euicc := newFakeEUICC(sim, func(cmd []byte) []byte {
+		if loaded > 0 || bytes.HasPrefix(cmd, []byte{0xBF, 0x36}) {
+			if loaded++; loaded == len(segments) {
+				return installResult(notifyAddress, 0)
+			}
+			return nil
+		}
+		req, _, err := parseTLV(cmd)
+		if err != nil {
+			return nil
+		}
+		switch req.tag {
+		case tagGetEuiccChallenge:
+			return encodeTLV(tagGetEuiccChallenge, encodeTLV(0x80, testChallenge))
+		case tagEuiccInfo1:
+			return encodeTLV(tagEuiccInfo1, encodeTLV(0x82, []byte{2, 2, 0}))
+		case tagAuthenticateServer:
+			return encodeTLV(tagAuthenticateServer, encodeTLV(0xA0, encodeTLV(0x30, testChallenge)))
+		case tagPrepareDownload:
+			return encodeTLV(tagPrepareDownload, encodeTLV(0xA0, encodeTLV(0x30, testTransactionID)))
+		case tagCancelSession:
+			return encodeTLV(tagCancelSession, encodeTLV(0xA0, encodeTLV(0x30, testTransactionID)))
+		case tagRemoveNotification:
+			return encodeTLV(tagRemoveNotification, encodeTLV(tagResult, []byte{0}))
+		}
+		return encodeTLV(req.tag, encodeTLV(tagResult, []byte{127}))
+	})
+	return h, euicc, smdp, address, notifyAddress
+}
+
+func TestDownloadProfile(t *testing.T) {
+	h, euicc, smdp, address, notifyAddress := newDownload(t)
+
+	var stages []engine.DownloadStage
+	profile, err := h.DownloadProfile(context.Background(), "LPA:1$"+address+"$MATCHING-ID", "", func(p engine.DownloadProgress) {
+		stages = append(stages, p.Stage)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.ICCID != "89860000000000000019" || profile.ServiceProvider != "China Mobile" || profile.Name != "CMCC" || profile.Class != "operational" {
+		t.Errorf("profile = %+v", profile)
+	}
+	want := []engine.DownloadStage{
+		engine.DownloadAuthenticateServer, engine.DownloadAuthenticateClient, engine.DownloadPrepare,
+		engine.DownloadFetchPackage, engine.DownloadInstall, engine.DownloadNotify,
+	}
+	if fmt.Sprint(stages) != fmt.Sprint(want) {
+		t.Errorf("stages = %v, want %v", stages, want)
+	}
+
+	calls := smdp.received()
+	if got := smdp.functions(); strings.Join(got, ",") != "initiateAuthentication,authenticateClient,getBoundProfilePackage,handleNotification" {
+		t.Fatalf("ES9+ calls = %v", got)
+	}
+	if calls[0].body["smdpAddress"] != address || calls[0].body["euiccChallenge"] != base64.StdEncoding.EncodeToString(testChallenge) {
+		t.Errorf("initiateAuthentication request = %v", calls[0].body)
+	}
+	if calls[1].body["transactionId"] != hex.EncodeToString(testTransactionID) {
+		t.Errorf("authenticateClient request = %v", calls[1].body)
+	}
+	// The installation result goes to the address in its metadata.
+	if n := calls[3]; n.address != notifyAddress || n.body["pendingNotification"] != b64(installResult(notifyAddress, 0)) {
+		t.Errorf("handleNotification to %s with %v", n.address, n.body)
+	}
+
+	// The BPP is loaded in the segments defined by ES10b, between
+	// PrepareDownload and the removal of the delivered notification.
+	segments := testBPPSegments()
+	cmds := euicc.received()
+	var tags []uint32
+	var loaded [][]byte
+	for _, cmd := range cmds {
+		if bytes.HasPrefix(cmd, []byte{0xBF, 0x36}) || len(loaded) > 0 && len(loaded) < len(segments) {
+			loaded = append(loaded, cmd)
+			continue
+		}
+		req, _, _ := parseTLV(cmd)
+		tags = append(tags, req.tag)
+	}
+	wantTags := []uint32{tagGetEuiccChallenge, tagEuiccInfo1, tagAuthenticateServer, tagPrepareDownload, tagRemoveNotification}
+	if fmt.Sprintf("%X", tags) != fmt.Sprintf("%X", wantTags) {
+		t.Errorf("ES10 commands = %X, want %X", tags, wantTags)
+	}
+	if len(loaded) != len(segments) {
+		t.Fatalf("BPP loaded in %d segments, want %d", len(loaded), len(segments))
+	}
+	for i := range segments {
+		if !bytes.Equal(loaded[i], segments[i]) {
+			t.Errorf("segment %d = %X, want %X", i+1, loaded[i], segments[i])
+		}
+	}
+	remove := cmds[len(cmds)-1]
+	if want := encodeTLV(tagRemoveNotification, encodeTLV(tagSeqNumber, []byte{5})); !bytes.Equal(remove, want) {
+		t.Errorf("RemoveNotificationFromList = %X, want %X", remove, want)
+	}
+}
+
+func TestDownloadProfileCancelsRejectedSession(t *testing.T) {
+	h, euicc, smdp, address, _ := newDownload(t)
+	respond := euicc.respond
+	euicc.respond = func(cmd []byte) []byte {
+		if bytes.HasPrefix(cmd, []byte{0xBF, 0x21}) {
+			return encodeTLV(tagPrepareDownload, encodeTLV(0xA1, encodeTLV(0x80, testTransactionID), encodeTLV(0x02, []byte{2})))
+		}
+		return respond(cmd)
+	}
+
+	_, err := h.DownloadProfile(context.Background(), "LPA:1$"+address+"$MATCHING-ID", "", nil)
+	if err == nil || !strings.Contains(err.Error(), "PrepareDownload rejected") {
+		t.Fatalf("err = %v, want PrepareDownload rejected", err)
+	}
+
+	// The session is cancelled on the eUICC, then on the SM-DP+.
+	cmds := euicc.received()
+	cancel, _, _ := parseTLV(cmds[len(cmds)-1])
+	txID, _ := cancel.child(0x80)
+	reason, _ := cancel.child(0x81)
+	if cancel.tag != tagCancelSession || !bytes.Equal(txID.value, testTransactionID) || tlvInt(reason.value) != cancelReasonUndefined {
+		t.Errorf("last ES10 command = %X, want CancelSession", cmds[len(cmds)-1])
+	}
+	calls := smdp.received()
+	if got := smdp.functions(); strings.Join(got, ",") != "initiateAuthentication,authenticateClient,cancelSession" {
+		t.Fatalf("ES9+ calls = %v", got)
+	}
+	wantResp := b64(encodeTLV(tagCancelSession, encodeTLV(0xA0, encodeTLV(0x30, testTransactionID))))
+	if body := calls[2].body; body["transactionId"] != hex.EncodeToString(testTransactionID) || body["cancelSessionResponse"] != wantResp {
+		t.Errorf("cancelSession request = %v", body)
+	}
+}
+
+func TestDownloadProfileES9Error(t *testing.T) {
+	h, euicc, smdp, address, _ := newDownload(t)
+	smdp.respond = func(function string, body map[string]string) any {
+		return es9Status("Failed", "8.2.6", "3.8")
+	}
+
+	_, err := h.DownloadProfile(context.Background(), "LPA:1$"+address+"$BAD-ID", "", nil)
+	if err == nil || !strings.Contains(err.Error(), "subject 8.2.6, reason 3.8") {
+		t.Fatalf("err = %v, want the ES9+ status", err)
+	}
+	// Without a transaction there is no session to cancel.
+	if got := smdp.functions(); len(got) != 1 || got[0] != "initiateAuthentication" {
+		t.Errorf("ES9+ calls = %v", got)
+	}
+	for _, cmd := range euicc.received() {
+		if bytes.HasPrefix(cmd, []byte{0xBF, 0x41}) {
+			t.Errorf("CancelSession sent without a transaction")
+		}
+	}
+}
+
+func TestDownloadProfileInstallationFailure(t *testing.T) {
+	h, euicc, smdp, address, notifyAddress := newDownload(t)
+	respond := euicc.respond
+	euicc.respond = func(cmd []byte) []byte {
+		resp := respond(cmd)
+		if bytes.HasPrefix(resp, []byte{0xBF, 0x37}) {
+			return installResult(notifyAddress, 8)
+		}
+		return resp
+	}
+
+	_, err := h.DownloadProfile(context.Background(), "LPA:1$"+address+"$MATCHING-ID", "", nil)
+	if err == nil || !strings.Contains(err.Error(), "BPP command 3, error reason 8") {
+		t.Fatalf("err = %v, want the installation error", err)
+	}
+	// The failed result is still reported to the SM-DP+.
+	if got := smdp.functions(); got[len(got)-1] != "handleNotification" {
+		t.Errorf("ES9+ calls = %v, want the result notified", got)
+	}
+}
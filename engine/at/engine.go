@@ -1,70 +1,218 @@
 package at
 
 import (
-	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"go.bug.st/serial"
 )
 
 // Handler manages AT command communication over a serial port.
+//
+// The handler owns a single long-lived connection to the port. A background
+// goroutine keeps the port open (reopening it when the device disappears, e.g.
+// after a modem reset) and reads every line the modem prints. Commands are
+// serialized so concurrent callers never interleave on the wire.
 type Handler struct {
 	portName string
+	timeout  time.Duration
+
+	startOnce sync.Once
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	// queue is a one-slot semaphore serializing commands.
+	queue chan struct{}
+
+	mu     sync.Mutex
+	port   serial.Port
+	ready  chan struct{} // closed once port is open
+	active *pendingCommand
+
+	subMu sync.Mutex
+	subs  map[chan Event]struct{}
+
+	// smdp reaches SM-DP+ servers for eSIM downloads; nil means HTTPS.
+	smdp SMDPTransport
+
+	// pendingCDS holds a +CDS header until its PDU line arrives.
+	// Only touched by the reader goroutine.
+	pendingCDS string
+
+	// stale is set when a command timed out, so its late reply may still
+	// arrive. Only touched while holding the queue slot.
+	stale bool
 }
 
 // NewHandler creates a new AT command handler.
 func NewHandler(portName string) *Handler {
-	return &Handler{portName: portName}
+	return &Handler{
+		portName: portName,
+		timeout:  defaultCommandTimeout,
+		closed:   make(chan struct{}),
+		queue:    make(chan struct{}, 1),
+		ready:    make(chan struct{}),
+	}
+}
+
+// PortName returns the serial device the handler talks to, e.g. /dev/wwan0at0.
+func (h *Handler) PortName() string {
+	return h.portName
 }
 
 // SendCommand sends an AT command and waits for a final response ("OK" or "ERROR").
 func (h *Handler) SendCommand(cmd string) (string, error) {
+	return h.SendCommandContext(context.Background(), cmd)
+}
+
+// SendCommandContext is like SendCommand but aborts when ctx is done. If ctx
+// has no deadline the handler's default command timeout applies.
+func (h *Handler) SendCommandContext(ctx context.Context, cmd string) (string, error) {
+	return h.exec(ctx, cmd, "")
+}
+
+// SendWithPrompt sends a command that answers with the "> " prompt, such as
+// AT+CMGS, writes payload terminated by Ctrl-Z and waits for the final result.
+func (h *Handler) SendWithPrompt(ctx context.Context, cmd, payload string) (string, error) {
+	return h.exec(ctx, cmd, payload)
+}
+
+func (h *Handler) exec(ctx context.Context, cmd, payload string) (string, error) {
 	if h == nil {
 		return "", errors.New("AT handler is not initialized")
 	}
+	h.start()
 
-	port, err := serial.Open(h.portName, &serial.Mode{
-		BaudRate: 115200,
-	})
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	select {
+	case h.queue <- struct{}{}:
+	case <-ctx.Done():
+		return "", fmt.Errorf("AT command %s not sent: %w", cmd, ctx.Err())
+	case <-h.closed:
+		return "", ErrClosed
+	}
+	defer func() { <-h.queue }()
+
+	port, err := h.waitPort(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to open serial port %s: %w", h.portName, err)
+		return "", err
+	}
+	if h.stale {
+		if err := h.resync(ctx, port); err != nil {
+			return "", fmt.Errorf("AT command %s not sent, port out of sync: %w", cmd, err)
+		}
+		h.stale = false
 	}
-	defer port.Close()
 
-	// Set a read timeout
-	port.SetReadTimeout(5 * time.Second)
+	p := h.activate(cmd)
+	defer h.release(p)
 
+	// Commands answered with a prompt must end with CR alone, otherwise
+	// the LF is taken as the first character of the payload.
+	terminator := "\r\n"
+	if payload != "" {
+		terminator = "\r"
+	}
 	log.Printf("AT > %s", cmd)
-	_, err = port.Write([]byte(cmd + "\r\n"))
-	if err != nil {
+	if _, err := port.Write([]byte(cmd + terminator)); err != nil {
 		return "", fmt.Errorf("failed to write to serial port: %w", err)
 	}
 
 	var responseBuilder strings.Builder
-	scanner := bufio.NewScanner(port)
-	for scanner.Scan() {
-		line := scanner.Text()
-		log.Printf("AT < %s", line)
-		if line == "OK" {
-			return responseBuilder.String(), nil
-		}
-		if strings.Contains(line, "ERROR") {
-			return responseBuilder.String(), fmt.Errorf("AT command failed: %s", line)
-		}
-		if line != "" && line != cmd { // Exclude empty lines and command echo
-			responseBuilder.WriteString(line + "\n")
+	prompted := false
+	for {
+		select {
+		case line := <-p.lines:
+			if line == "OK" {
+				return responseBuilder.String(), nil
+			}
+			if strings.Contains(line, "ERROR") {
+				return responseBuilder.String(), fmt.Errorf("AT command failed: %s", line)
+			}
+			if line == ">" && payload != "" && !prompted {
+				prompted = true
+				log.Printf("AT > %s<Ctrl-Z>", payload)
+				if _, err := port.Write([]byte(payload + "\x1a")); err != nil {
+					return "", fmt.Errorf("failed to write to serial port: %w", err)
+				}
+				continue
+			}
+			if !isEcho(line, cmd) && strings.TrimRight(line, "\x1a") != payload { // Exclude command and payload echo
+				responseBuilder.WriteString(line + "\n")
+			}
+		case err := <-p.failed:
+			return responseBuilder.String(), fmt.Errorf("error reading from serial port: %w", err)
+		case <-ctx.Done():
+			// The modem may still answer; make the next command resync first.
+			h.stale = true
+			if payload != "" && !prompted {
+				port.Write([]byte{0x1b}) // ESC leaves the prompt without sending
+			}
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return responseBuilder.String(), errors.New("AT command timed out, no OK/ERROR received")
+			}
+			return responseBuilder.String(), ctx.Err()
 		}
 	}
+}
+
+// isEcho reports whether line is the echo of cmd. On noisy lines the echo
+// comes back with corrupted characters, so any line of the same length
+// starting with "AT" counts; responses never start with "AT".
+func isEcho(line, cmd string) bool {
+	return line == cmd || (len(line) == len(cmd) && strings.HasPrefix(strings.ToUpper(line), "AT"))
+}
 
-	if err := scanner.Err(); err != nil {
-		return responseBuilder.String(), fmt.Errorf("error reading from serial port: %w", err)
+// resync discards the late reply of a timed-out command. It sends a bare AT
+// and waits until an OK is followed by resyncQuietPeriod of silence: the
+// first OK may belong to the timed-out command, in which case the one for AT
+// arrives within the quiet period and is dropped as well.
+func (h *Handler) resync(ctx context.Context, port serial.Port) error {
+	p := h.activate("AT")
+	defer h.release(p)
+
+	log.Printf("AT > AT (resync after timeout)")
+	if _, err := port.Write([]byte("AT\r\n")); err != nil {
+		return fmt.Errorf("failed to write to serial port: %w", err)
 	}
+	gotOK := false
+	for {
+		select {
+		case line := <-p.lines:
+			if line == "OK" {
+				gotOK = true
+			} else {
+				log.Printf("AT: discarding stale line: %s", line)
+			}
+		case <-time.After(resyncQuietPeriod):
+			if gotOK {
+				return nil
+			}
+		case err := <-p.failed:
+			return fmt.Errorf("error reading from serial port: %w", err)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
 
-	return responseBuilder.String(), errors.New("AT command timed out, no OK/ERROR received")
+// GetIMEI retrieves the IMEI using the AT+CGSN command.
+func (h *Handler) GetIMEI() (string, error) {
+	response, err := h.SendCommand("AT+CGSN")
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(response), "+CGSN:")), `"`), nil
 }
 
 // GetICCID retrieves the ICCID using the AT+CCID? command.
@@ -99,6 +247,29 @@ func (h *Handler) GetEsimPower() (string, error) {
 	return strings.TrimSpace(strings.TrimPrefix(response, "+GTESIMCFG: ")), nil
 }
 
+// DescribeEsimPower renders a +GTESIMCFG response ("<disable>,<sku>,<imsi>")
+// for display.
+func DescribeEsimPower(power string) string {
+	powerList := strings.Split(power, ",")
+	var build strings.Builder
+	if powerList[0] == "0" {
+		build.WriteString("ESIM模块启用")
+	} else if powerList[0] == "1" {
+		build.WriteString("ESIM模块禁用")
+	}
+	if len(powerList) > 1 && powerList[1] == "0" {
+		build.WriteString(",SKU_based 0")
+	} else if len(powerList) > 1 && powerList[1] == "1" {
+		build.WriteString(",SKU_based 1")
+	}
+	if len(powerList) > 2 && powerList[2] == "0" {
+		build.WriteString(",IMSI_based 0")
+	} else if len(powerList) > 2 && powerList[2] == "1" {
+		build.WriteString(",IMSI_based 1")
+	}
+	return strings.TrimSpace(build.String())
+}
+
 func (h *Handler) SetEsimPower(power bool) (string, error) {
 	if power == false { //关闭ESIM
 		response, err := h.SendCommand("AT+GTESIMCFG=1,0,0")
@@ -0,0 +1,333 @@
+package at
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"tg_modem/engine/at/atsim"
+)
+
+// newSimHandler starts a simulated FM350 and a handler connected to it.
+func newSimHandler(t *testing.T) (*atsim.Modem, *Handler) {
+	t.Helper()
+	sim, err := atsim.New()
+	if err != nil {
+		t.Skipf("atsim unavailable: %v", err)
+	}
+	h := NewHandler(sim.Port())
+	t.Cleanup(func() {
+		h.Close()
+		sim.Close()
+	})
+	return sim, h
+}
+
+func TestLateReplyAfterTimeoutIsDiscarded(t *testing.T) {
+	sim, h := newSimHandler(t)
+	sim.Handle("AT+COPS=?", atsim.Response{
+		Lines: []string{`+COPS: (2,"CHINA MOBILE","CMCC","46000",7)`},
+		Delay: 500 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := h.SendCommandContext(ctx, "AT+COPS=?"); err == nil {
+		t.Fatal("AT+COPS=? succeeded, want timeout")
+	}
+
+	resp, err := h.SendCommand("AT+CSQ")
+	if err != nil {
+		t.Fatalf("AT+CSQ: %v", err)
+	}
+	if strings.TrimSpace(resp) != "+CSQ: 20,99" {
+		t.Errorf("AT+CSQ = %q, want its own reply", resp)
+	}
+	resp, err = h.SendCommand("AT+CGMM")
+	if err != nil || strings.TrimSpace(resp) != atsim.Model {
+		t.Errorf("AT+CGMM = %q, %v, want %q", resp, err, atsim.Model)
+	}
+}
+
+func TestCMEErrorIsReturned(t *testing.T) {
+	sim, h := newSimHandler(t)
+	sim.Handle("AT+EID?", atsim.CMEError(10)) // SIM not inserted
+
+	if _, err := h.GetEID(); err == nil || !strings.Contains(err.Error(), "+CME ERROR: 10") {
+		t.Fatalf("GetEID error = %v, want +CME ERROR: 10", err)
+	}
+	if iccid, err := h.GetICCID(); err != nil || iccid != atsim.ICCID {
+		t.Errorf("GetICCID after the error = %q, %v", iccid, err)
+	}
+}
+
+func TestCMSErrorFailsSendSms(t *testing.T) {
+	sim, h := newSimHandler(t)
+	sim.HandlePrompt("AT+CMGS=", func(cmd, payload string) atsim.Response {
+		return atsim.CMSError(500) // unknown error
+	})
+
+	_, err := h.SendSms(context.Background(), "+8613800138000", "hello")
+	if err == nil || !strings.Contains(err.Error(), "+CMS ERROR: 500") {
+		t.Fatalf("SendSms error = %v, want +CMS ERROR: 500", err)
+	}
+}
+
+func TestGarbledEchoIsDropped(t *testing.T) {
+	sim, h := newSimHandler(t)
+	sim.SetEcho(atsim.EchoGarbled)
+
+	if imei, err := h.GetIMEI(); err != nil || imei != atsim.IMEI {
+		t.Errorf("GetIMEI = %q, %v, want %q", imei, err, atsim.IMEI)
+	}
+	if eid, err := h.GetEID(); err != nil || eid != atsim.EID {
+		t.Errorf("GetEID = %q, %v, want %q", eid, err, atsim.EID)
+	}
+}
+
+func TestURCDuringCommand(t *testing.T) {
+	sim, h := newSimHandler(t)
+	events, cancel := h.Subscribe()
+	defer cancel()
+	sim.Handle("AT+COPS?", atsim.Response{
+		Lines: []string{`+COPS: 0,0,"CHINA MOBILE",7`},
+		Delay: 300 * time.Millisecond,
+	})
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		sim.InjectURC(`+CMTI: "ME",3`, "RING")
+	}()
+	resp, err := h.SendCommand("AT+COPS?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(resp) != `+COPS: 0,0,"CHINA MOBILE",7` {
+		t.Errorf("AT+COPS? = %q, want only its own reply", resp)
+	}
+
+	var got []Event
+	timeout := time.After(2 * time.Second)
+	for len(got) < 2 {
+		select {
+		case ev := <-events:
+			got = append(got, ev)
+		case <-timeout:
+			t.Fatalf("got events %v, want +CMTI and RING", got)
+		}
+	}
+	if cmti, ok := got[0].(NewSmsEvent); !ok || cmti.Storage != "ME" || cmti.Index != 3 {
+		t.Errorf("first event = %#v, want +CMTI for ME,3", got[0])
+	}
+	if _, ok := got[1].(RingEvent); !ok {
+		t.Errorf("second event = %#v, want RING", got[1])
+	}
+}
+
+func TestLongResponseIsNotDropped(t *testing.T) {
+	h := NewHandler("/dev/null")
+	p := h.activate("AT+CMGL=4")
+
+	// The reader delivers far more lines than are buffered before the
+	// command gets to read any of them.
+	const n = 200
+	go func() {
+		for i := 0; i < n; i++ {
+			h.dispatchLine(fmt.Sprintf("+CMGL: %d,1,,22", i))
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < n; i++ {
+		select {
+		case line := <-p.lines:
+			if want := fmt.Sprintf("+CMGL: %d,1,,22", i); line != want {
+				t.Fatalf("line %d = %q, want %q", i, line, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("got %d lines, want %d", i, n)
+		}
+	}
+
+	// Once the command is done the reader no longer waits for it.
+	p = h.activate("AT")
+	dispatched := make(chan struct{})
+	go func() {
+		for i := 0; i < n; i++ {
+			h.dispatchLine("0123456789")
+		}
+		close(dispatched)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	h.release(p)
+	select {
+	case <-dispatched:
+	case <-time.After(time.Second):
+		t.Fatal("reader still blocked after the command finished")
+	}
+}
+
+func TestStatusReportNeedsItsPDU(t *testing.T) {
+	sim, h := newSimHandler(t)
+	events, cancel := h.Subscribe()
+	defer cancel()
+	// Start the port so that the URCs are read.
+	if _, err := h.SendCommand("AT"); err != nil {
+		t.Fatal(err)
+	}
+
+	const report = "07911326040000F0062A0B911346610089F6208062917314082080629173240800"
+	sim.InjectURC("+CDS: 25", report)
+	// A header whose PDU never arrives must not swallow the next line.
+	sim.InjectURC("+CDS: 25", `+CMTI: "ME",3`)
+	sim.InjectURC("+CDS: 24", report)
+
+	var got []Event
+	timeout := time.After(2 * time.Second)
+	for len(got) < 2 {
+		select {
+		case ev := <-events:
+			got = append(got, ev)
+		case <-timeout:
+			t.Fatalf("got events %v, want a status report and +CMTI", got)
+		}
+	}
+	if cds, ok := got[0].(StatusReportEvent); !ok || cds.Length != 25 || cds.PDU != report {
+		t.Errorf("first event = %#v, want the status report", got[0])
+	}
+	if cmti, ok := got[1].(NewSmsEvent); !ok || cmti.Index != 3 {
+		t.Errorf("second event = %#v, want +CMTI for ME,3", got[1])
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected event %#v for a PDU of the wrong length", ev)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	sim, h := newSimHandler(t)
+	sim.Handle("AT+CFUN=1,1", atsim.Timeout())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := h.SendCommandContext(ctx, "AT+CFUN=1,1")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("AT+CFUN=1,1 error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("timeout took %s", elapsed)
+	}
+	if model, err := h.SendCommand("AT+CGMM"); err != nil || strings.TrimSpace(model) != atsim.Model {
+		t.Errorf("AT+CGMM after the timeout = %q, %v", model, err)
+	}
+}
+
+func TestEsimCommands(t *testing.T) {
+	_, h := newSimHandler(t)
+
+	power, err := h.GetEsimPower()
+	if err != nil || power != "0,0,0" {
+		t.Fatalf("GetEsimPower = %q, %v", power, err)
+	}
+	if got := DescribeEsimPower(power); got != "ESIM模块启用,SKU_based 0,IMSI_based 0" {
+		t.Errorf("DescribeEsimPower(%q) = %q", power, got)
+	}
+	if _, err := h.SetEsimPower(false); err != nil {
+		t.Fatal(err)
+	}
+	if power, err := h.GetEsimPower(); err != nil || power != "1,0,0" {
+		t.Errorf("GetEsimPower after disabling = %q, %v", power, err)
+	}
+	if eid, err := h.GetEID(); err != nil || eid != atsim.EID {
+		t.Errorf("GetEID = %q, %v", eid, err)
+	}
+	if simType, err := h.GetStatus(); err != nil || simType != "1" {
+		t.Errorf("GetStatus = %q, %v, want SIM type 1", simType, err)
+	}
+}
+
+func TestListProfilesOverCGLA(t *testing.T) {
+	sim, h := newSimHandler(t)
+	iccid, _ := encodeICCID("8986000000000000001")
+	profiles := encodeTLV(tagProfileInfoList, encodeTLV(0xA0, encodeTLV(tagProfileInfo,
+		encodeTLV(tagICCID, iccid),
+		encodeTLV(tagProfileState, []byte{1}),
+		encodeTLV(tagServiceProvider, []byte("China Mobile")),
+		encodeTLV(tagProfileName, []byte("CMCC")),
+		encodeTLV(tagProfileClass, []byte{2}),
+	)))
+	// The eUICC answers in two chunks, the second fetched with GET RESPONSE.
+	first, rest := profiles[:10], profiles[10:]
+
+	sim.Handle(fmt.Sprintf(`AT+CCHO="%X"`, isdrAID), atsim.OK("+CCHO: 1"))
+	sim.Handle("AT+CCHC=1", atsim.OK())
+	sim.HandlePrefix("AT+CGLA=", func(cmd string) atsim.Response {
+		_, apdu, _ := strings.Cut(cmd, `"`)
+		apdu = strings.TrimSuffix(apdu, `"`)
+		var data []byte
+		switch apdu {
+		case "81E2910003BF2D00":
+			data = append(append([]byte(nil), first...), 0x61, byte(len(rest)))
+		case fmt.Sprintf("01C00000%02X", len(rest)):
+			data = append(append([]byte(nil), rest...), 0x90, 0x00)
+		default:
+			data = []byte{0x6D, 0x00}
+		}
+		return atsim.OK(fmt.Sprintf(`+CGLA: %d,"%X"`, len(data)*2, data))
+	})
+
+	got, err := h.ListProfiles(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d profiles, want 1", len(got))
+	}
+	p := got[0]
+	if p.ICCID != "8986000000000000001" || !p.Enabled || p.ServiceProvider != "China Mobile" || p.Name != "CMCC" || p.Class != "operational" {
+		t.Errorf("profile = %+v", p)
+	}
+	if cmds := sim.Commands(); cmds[len(cmds)-1] != "AT+CCHC=1" {
+		t.Errorf("last command = %q, want the channel closed", cmds[len(cmds)-1])
+	}
+}
+
+func TestSmsStoragesDoNotReselect(t *testing.T) {
+	sim, h := newSimHandler(t)
+	sim.StoreSms("07911326040000F0040B911346610089F60000208062917314080CC8F71D14969741F977FD07")
+	e := &ModemEngine{handler: h}
+
+	storages, err := e.SmsStorages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(storages) != 2 {
+		t.Fatalf("storages = %+v", storages)
+	}
+	// Only ME is selected, so the usage of SM is unknown.
+	if sm := storages[0]; sm.Name != "SM" || sm.Used != -1 || sm.Total != -1 || sm.Default {
+		t.Errorf("SM = %+v, want unknown usage", sm)
+	}
+	if me := storages[1]; me.Name != "ME" || me.Used != 1 || me.Total != 255 || !me.Default {
+		t.Errorf("ME = %+v", me)
+	}
+
+	if _, err := h.SendCommand(`AT+CPMS="SM","SM","ME"`); err != nil {
+		t.Fatal(err)
+	}
+	before := len(sim.Commands())
+	if storages, err = e.SmsStorages(); err != nil {
+		t.Fatal(err)
+	}
+	if sm := storages[0]; sm.Used != 0 || sm.Total != 50 {
+		t.Errorf("SM = %+v, want 0/50", sm)
+	}
+	for _, cmd := range sim.Commands()[before:] {
+		if strings.HasPrefix(cmd, "AT+CPMS=") && cmd != "AT+CPMS=?" {
+			t.Errorf("SmsStorages sent %q", cmd)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package at
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// SMDPTransport carries ES9+ function calls from the LPA to an SM-DP+ server.
+// The default is HTTPTransport; tests can substitute a local stand-in.
+type SMDPTransport interface {
+	// Call invokes the ES9+ function (e.g. "initiateAuthentication") on the
+	// server at address, sending req as JSON and decoding the reply into
+	// resp. resp is nil for functions without a response body.
+	Call(ctx context.Context, address, function string, req, resp any) error
+}
+
+// HTTPTransport implements SMDPTransport over HTTPS as specified in SGP.22.
+type HTTPTransport struct {
+	// Client is used for requests; nil means a client with a 60s timeout.
+	Client *http.Client
+}
+
+var defaultSMDPClient = &http.Client{Timeout: 60 * time.Second}
+
+// Call implements SMDPTransport.
+func (t *HTTPTransport) Call(ctx context.Context, address, function string, req, resp any) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("https://%s/gsma/rsp2/es9plus/%s", address, function)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json;charset=UTF-8")
+	httpReq.Header.Set("X-Admin-Protocol", "gsma/rsp/v2.2.0")
+	httpReq.Header.Set("User-Agent", "gsma-rsp-lpad")
+
+	client := t.Client
+	if client == nil {
+		client = defaultSMDPClient
+	}
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("ES9+ %s: %w", function, err)
+	}
+	defer httpResp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(httpResp.Body, 4<<20))
+	if err != nil {
+		return fmt.Errorf("ES9+ %s: %w", function, err)
+	}
+	if httpResp.StatusCode/100 != 2 {
+		return fmt.Errorf("ES9+ %s: HTTP %s", function, httpResp.Status)
+	}
+	if resp == nil || len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return fmt.Errorf("ES9+ %s: invalid response: %w", function, err)
+	}
+	return nil
+}
+
+// es9Header is the header carried by every ES9+ response.
+type es9Header struct {
+	Header struct {
+		FunctionExecutionStatus struct {
+			Status         string `json:"status"`
+			StatusCodeData struct {
+				SubjectCode string `json:"subjectCode"`
+				ReasonCode  string `json:"reasonCode"`
+				Message     string `json:"message"`
+			} `json:"statusCodeData"`
+		} `json:"functionExecutionStatus"`
+	} `json:"header"`
+}
+
+func (h *es9Header) err() error {
+	s := h.Header.FunctionExecutionStatus
+	if s.Status == "" || s.Status == "Executed-Success" || s.Status == "Executed-WithWarning" {
+		return nil
+	}
+	d := s.StatusCodeData
+	return fmt.Errorf("SM-DP+ returned %s (subject %s, reason %s): %s", s.Status, d.SubjectCode, d.ReasonCode, d.Message)
+}
+
+type es9Response interface {
+	err() error
+}
+
+// callES9 invokes function and checks the execution status in the reply.
+func callES9(ctx context.Context, t SMDPTransport, address, function string, req any, resp es9Response) error {
+	if err := t.Call(ctx, address, function, req, resp); err != nil {
+		return err
+	}
+	return resp.err()
+}
+
+// ES9+ request and response bodies. Binary fields hold base64 encoded ASN.1.
+
+type initiateAuthenticationRequest struct {
+	EuiccChallenge string `json:"euiccChallenge"`
+	EuiccInfo1     string `json:"euiccInfo1"`
+	SmdpAddress    string `json:"smdpAddress"`
+}
+
+type initiateAuthenticationResponse struct {
+	es9Header
+	TransactionID       string `json:"transactionId"`
+	ServerSigned1       string `json:"serverSigned1"`
+	ServerSignature1    string `json:"serverSignature1"`
+	EuiccCiPKIdToBeUsed string `json:"euiccCiPKIdToBeUsed"`
+	ServerCertificate   string `json:"serverCertificate"`
+}
+
+type authenticateClientRequest struct {
+	TransactionID              string `json:"transactionId"`
+	AuthenticateServerResponse string `json:"authenticateServerResponse"`
+}
+
+type authenticateClientResponse struct {
+	es9Header
+	TransactionID   string `json:"transactionId"`
+	ProfileMetadata string `json:"profileMetadata"`
+	SmdpSigned2     string `json:"smdpSigned2"`
+	SmdpSignature2  string `json:"smdpSignature2"`
+	SmdpCertificate string `json:"smdpCertificate"`
+}
+
+type getBoundProfilePackageRequest struct {
+	TransactionID           string `json:"transactionId"`
+	PrepareDownloadResponse string `json:"prepareDownloadResponse"`
+}
+
+type getBoundProfilePackageResponse struct {
+	es9Header
+	TransactionID       string `json:"transactionId"`
+	BoundProfilePackage string `json:"boundProfilePackage"`
+}
+
+type handleNotificationRequest struct {
+	PendingNotification string `json:"pendingNotification"`
+}
+
+type cancelSessionRequest struct {
+	TransactionID         string `json:"transactionId"`
+	CancelSessionResponse string `json:"cancelSessionResponse"`
+}
+
+type emptyResponse struct {
+	es9Header
+}
@@ -0,0 +1,300 @@
+package at
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"tg_modem/engine"
+)
+
+// ES10 data object tags (SGP.22 v2.x).
+const (
+	tagProfileInfoList     = 0xBF2D
+	tagEnableProfile       = 0xBF31
+	tagDisableProfile      = 0xBF32
+	tagDeleteProfile       = 0xBF33
+	tagSetNickname         = 0xBF29
+	tagGetEuiccData        = 0xBF3E
+	tagEuiccInfo2          = 0xBF22
+	tagProfileInfo         = 0xE3
+	tagICCID               = 0x5A
+	tagISDPAID             = 0x4F
+	tagProfileState        = 0x9F70
+	tagProfileNickname     = 0x90
+	tagServiceProvider     = 0x91
+	tagProfileName         = 0x92
+	tagProfileClass        = 0x95
+	tagTaggedListSelector  = 0x5C
+	tagResult              = 0x80
+	tagRefreshFlag         = 0x81
+	tagProfileIdentifierA0 = 0xA0
+)
+
+// profileOpResults maps the result codes shared by the ES10c profile
+// operations to readable text. Code 2 depends on the operation.
+var profileOpResults = map[int]string{
+	1:   "ICCID or AID not found",
+	3:   "disallowed by policy",
+	4:   "wrong profile re-enabling",
+	5:   "CAT busy",
+	127: "undefined error",
+}
+
+// withISDR runs fn with a logical channel to the ISD-R.
+func (h *Handler) withISDR(ctx context.Context, fn func(c *logicalChannel) error) error {
+	if h == nil {
+		return errors.New("AT handler is not initialized")
+	}
+	c, err := h.openChannel(ctx, isdrAID)
+	if err != nil {
+		return fmt.Errorf("failed to open ISD-R channel: %w", err)
+	}
+	defer c.close()
+	return fn(c)
+}
+
+// ListProfiles returns all profiles installed on the eUICC (ES10c.GetProfilesInfo).
+func (h *Handler) ListProfiles(ctx context.Context) ([]engine.EsimProfile, error) {
+	var resp tlv
+	err := h.withISDR(ctx, func(c *logicalChannel) (err error) {
+		resp, err = c.es10(encodeTLV(tagProfileInfoList))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list, ok := resp.child(0xA0)
+	if !ok {
+		if code, ok := resp.child(tagResult); ok {
+			return nil, fmt.Errorf("GetProfilesInfo failed with error %d", tlvInt(code.value))
+		}
+		return nil, fmt.Errorf("unexpected GetProfilesInfo response %X", resp.raw)
+	}
+	infos, err := list.children()
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make([]engine.EsimProfile, 0, len(infos))
+	for _, info := range infos {
+		if info.tag != tagProfileInfo {
+			continue
+		}
+		var p engine.EsimProfile
+		fields, err := info.children()
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range fields {
+			switch f.tag {
+			case tagICCID:
+				p.ICCID = decodeICCID(f.value)
+			case tagISDPAID:
+				p.ISDPAID = fmt.Sprintf("%X", f.value)
+			case tagProfileState:
+				p.Enabled = tlvInt(f.value) == 1
+			case tagProfileNickname:
+				p.Nickname = string(f.value)
+			case tagServiceProvider:
+				p.ServiceProvider = string(f.value)
+			case tagProfileName:
+				p.Name = string(f.value)
+			case tagProfileClass:
+				p.Class = profileClassName(tlvInt(f.value))
+			}
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+// EnableProfile enables the profile with the given ICCID (ES10c.EnableProfile).
+// The eUICC refreshes afterwards, so the modem may briefly lose the SIM.
+func (h *Handler) EnableProfile(ctx context.Context, iccid string) error {
+	id, err := encodeICCID(iccid)
+	if err != nil {
+		return err
+	}
+	req := encodeTLV(tagEnableProfile,
+		encodeTLV(tagProfileIdentifierA0, encodeTLV(tagICCID, id)),
+		encodeTLV(tagRefreshFlag, []byte{0xFF}),
+	)
+	return h.profileOperation(ctx, "EnableProfile", req, "profile not in disabled state")
+}
+
+// DisableProfile disables the profile with the given ICCID (ES10c.DisableProfile).
+func (h *Handler) DisableProfile(ctx context.Context, iccid string) error {
+	id, err := encodeICCID(iccid)
+	if err != nil {
+		return err
+	}
+	req := encodeTLV(tagDisableProfile,
+		encodeTLV(tagProfileIdentifierA0, encodeTLV(tagICCID, id)),
+		encodeTLV(tagRefreshFlag, []byte{0xFF}),
+	)
+	return h.profileOperation(ctx, "DisableProfile", req, "profile not in enabled state")
+}
+
+// DeleteProfile deletes the disabled profile with the given ICCID (ES10c.DeleteProfile).
+func (h *Handler) DeleteProfile(ctx context.Context, iccid string) error {
+	id, err := encodeICCID(iccid)
+	if err != nil {
+		return err
+	}
+	req := encodeTLV(tagDeleteProfile, encodeTLV(tagICCID, id))
+	return h.profileOperation(ctx, "DeleteProfile", req, "profile not in disabled state")
+}
+
+// SetNickname sets the nickname of the profile with the given ICCID (ES10c.SetNickname).
+func (h *Handler) SetNickname(ctx context.Context, iccid, nickname string) error {
+	id, err := encodeICCID(iccid)
+	if err != nil {
+		return err
+	}
+	if len(nickname) > 64 {
+		return errors.New("nickname must be at most 64 bytes")
+	}
+	req := encodeTLV(tagSetNickname, encodeTLV(tagICCID, id), encodeTLV(tagProfileNickname, []byte(nickname)))
+	return h.profileOperation(ctx, "SetNickname", req, "")
+}
+
+func (h *Handler) profileOperation(ctx context.Context, name string, req []byte, code2 string) error {
+	var resp tlv
+	err := h.withISDR(ctx, func(c *logicalChannel) (err error) {
+		resp, err = c.es10(req)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	result, ok := resp.child(tagResult)
+	if !ok {
+		return fmt.Errorf("unexpected %s response %X", name, resp.raw)
+	}
+	code := tlvInt(result.value)
+	if code == 0 {
+		return nil
+	}
+	text, ok := profileOpResults[code]
+	if code == 2 && code2 != "" {
+		text, ok = code2, true
+	}
+	if !ok {
+		text = "unknown error"
+	}
+	return fmt.Errorf("%s failed: %s (%d)", name, text, code)
+}
+
+// GetEuiccInfo returns the EID (ES10c.GetEID) and the chip details reported
+// by ES10b.GetEUICCInfo (EUICCInfo2).
+func (h *Handler) GetEuiccInfo(ctx context.Context) (*engine.EuiccInfo, error) {
+	info := &engine.EuiccInfo{InstalledApplications: -1, FreeNonVolatileMemory: -1, FreeVolatileMemory: -1}
+	err := h.withISDR(ctx, func(c *logicalChannel) error {
+		resp, err := c.es10(encodeTLV(tagGetEuiccData, encodeTLV(tagTaggedListSelector, []byte{tagICCID})))
+		if err != nil {
+			return fmt.Errorf("GetEID failed: %w", err)
+		}
+		if eid, ok := resp.child(tagICCID); ok {
+			info.EID = fmt.Sprintf("%X", eid.value)
+		}
+
+		resp, err = c.es10(encodeTLV(tagEuiccInfo2))
+		if err != nil {
+			return fmt.Errorf("GetEUICCInfo failed: %w", err)
+		}
+		fields, err := resp.children()
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			switch f.tag {
+			case 0x81:
+				info.ProfileVersion = versionString(f.value)
+			case 0x82:
+				info.SVN = versionString(f.value)
+			case 0x83:
+				info.FirmwareVersion = versionString(f.value)
+			case 0x84:
+				// extCardResource is itself a list of simple TLVs.
+				resources, err := parseTLVs(f.value)
+				if err != nil {
+					continue
+				}
+				for _, r := range resources {
+					switch r.tag {
+					case 0x81:
+						info.InstalledApplications = tlvInt(r.value)
+					case 0x82:
+						info.FreeNonVolatileMemory = tlvInt(r.value)
+					case 0x83:
+						info.FreeVolatileMemory = tlvInt(r.value)
+					}
+				}
+			case 0x0C:
+				info.SasAccreditationNumber = string(f.value)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// decodeICCID converts the nibble swapped BCD encoding of an ICCID to digits.
+func decodeICCID(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		for _, nibble := range []byte{c & 0x0F, c >> 4} {
+			if nibble == 0x0F {
+				continue
+			}
+			sb.WriteByte('0' + nibble)
+		}
+	}
+	return sb.String()
+}
+
+// encodeICCID is the inverse of decodeICCID. ICCIDs shorter than 20 digits
+// are padded with F so the result always fills the 10 byte ICCID field.
+func encodeICCID(iccid string) ([]byte, error) {
+	iccid = strings.TrimSpace(iccid)
+	if len(iccid) < 18 || len(iccid) > 20 || strings.Trim(iccid, "0123456789") != "" {
+		return nil, fmt.Errorf("invalid ICCID %q", iccid)
+	}
+	iccid += strings.Repeat("F", 20-len(iccid))
+	swapped := make([]byte, len(iccid))
+	for i := 0; i < len(iccid); i += 2 {
+		swapped[i], swapped[i+1] = iccid[i+1], iccid[i]
+	}
+	b, err := hex.DecodeString(string(swapped))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ICCID %q", iccid)
+	}
+	return b, nil
+}
+
+func versionString(b []byte) string {
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = fmt.Sprint(c)
+	}
+	return strings.Join(parts, ".")
+}
+
+func profileClassName(class int) string {
+	switch class {
+	case 0:
+		return "test"
+	case 1:
+		return "provisioning"
+	case 2:
+		return "operational"
+	}
+	return fmt.Sprint(class)
+}
@@ -0,0 +1,439 @@
+package at
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tg_modem/engine"
+	"tg_modem/engine/pdu"
+)
+
+// setDataTimeout bounds AT+CGACT, which waits for the network to set up or
+// tear down the PDP context.
+const setDataTimeout = 30 * time.Second
+
+// deliveryReportTimeout is how long SendSmsWithReport waits for status
+// reports; an SC keeps retrying a switched off phone for days.
+const deliveryReportTimeout = 48 * time.Hour
+
+func init() {
+	engine.Register("at", &ModemEngine{})
+}
+
+// ModemEngine controls the modem with plain AT commands on its serial port,
+// for systems that do not run ModemManager.
+type ModemEngine struct {
+	handler *Handler
+}
+
+func (e *ModemEngine) SetATHandler(handler interface{}) {
+	e.handler = handler.(*Handler)
+}
+
+// Handler returns the AT handler the engine talks through.
+func (e *ModemEngine) Handler() *Handler {
+	return e.handler
+}
+
+// Init checks that the modem answers and switches SMS to PDU mode.
+func (e *ModemEngine) Init() error {
+	if e.handler == nil {
+		return errors.New("AT engine requires an AT port")
+	}
+	if _, err := e.handler.SendCommand("AT"); err != nil {
+		return fmt.Errorf("modem not responding on %s: %w", e.handler.portName, err)
+	}
+	if _, err := e.handler.SendCommand("AT+CMGF=0"); err != nil {
+		return fmt.Errorf("failed to select SMS PDU mode: %w", err)
+	}
+	return nil
+}
+
+var registrationStateNames = map[int]string{0: "Idle", 1: "Home", 2: "Searching", 3: "Denied", 4: "Unknown", 5: "Roaming"}
+
+// accessTechNames maps the <AcT> of +COPS and +CxREG (27.007) to the names
+// the D-Bus engine reports.
+var accessTechNames = map[int]string{
+	0: "2G (GSM)", 1: "2G (GSM)", 3: "2G (EDGE)",
+	2: "3G (UMTS)", 4: "3G (HSDPA)", 5: "3G (HSUPA)", 6: "3G (HSPA+)",
+	7: "4G (LTE)", 9: "4G (LTE)", 10: "4G (LTE)",
+	11: "5G", 12: "5G", 13: "5G",
+}
+
+// signalRATNames maps the same <AcT> values to the RAT shown with signal metrics.
+var signalRATNames = map[int]string{
+	0: "GSM", 1: "GSM", 3: "GSM",
+	2: "UMTS", 4: "UMTS", 5: "UMTS", 6: "UMTS",
+	7: "LTE", 9: "LTE", 10: "LTE",
+	11: "5G NR", 12: "5G NR", 13: "5G NR",
+}
+
+// GetStatus collects the modem status from AT+CGMM, AT+COPS?, AT+CEREG?,
+// AT+CSQ, AT+CESQ and the PDP context commands.
+func (e *ModemEngine) GetStatus() (*engine.Status, error) {
+	if e.handler == nil {
+		return nil, errors.New("AT engine not initialized")
+	}
+	ctx := context.Background()
+	status := &engine.Status{SignalQuality: -1}
+
+	resp, err := e.handler.SendCommandContext(ctx, "AT+CGMM")
+	if err != nil {
+		return nil, err
+	}
+	status.Model = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(resp), "+CGMM:"))
+	if params := e.query(ctx, "AT+CSPN?", "+CSPN:"); params != nil {
+		status.SimOperator = param(params, 0)
+	}
+
+	// Operator in long alphanumeric format
+	act := -1
+	e.handler.SendCommandContext(ctx, "AT+COPS=3,0")
+	if params := e.query(ctx, "AT+COPS?", "+COPS:"); params != nil {
+		status.Operator = param(params, 2)
+		act = intParam(params, 3, -1)
+	}
+
+	// Registration: EPS first, then 5GS and CS; the first registered domain wins.
+	stat := -1
+	for _, cmd := range []string{"AT+CEREG?", "AT+C5GREG?", "AT+CREG?"} {
+		params := e.query(ctx, cmd, "+"+strings.TrimSuffix(strings.TrimPrefix(cmd, "AT+"), "?")+":")
+		s := intParam(params, 1, -1)
+		if s < 0 {
+			continue
+		}
+		if stat < 0 || s == 1 || s == 5 {
+			stat = s
+			if act < 0 {
+				act = intParam(params, 4, -1)
+			}
+		}
+		if s == 1 || s == 5 {
+			break
+		}
+	}
+	status.Registration = registrationStateNames[stat]
+	status.AccessTech = accessTechNames[act]
+
+	// Signal quality: <rssi> 0-31, 99 unknown
+	metrics := engine.SignalMetrics{RAT: signalRATNames[act]}
+	if params := e.query(ctx, "AT+CSQ", "+CSQ:"); params != nil {
+		if rssi := intParam(params, 0, 99); rssi >= 0 && rssi <= 31 {
+			status.SignalQuality = rssi * 100 / 31
+			metrics.RSSI = floatPtr(float64(-113 + 2*rssi))
+		}
+	}
+	// +CESQ: <rxlev>,<ber>,<rscp>,<ecno>,<rsrq>,<rsrp>, 255 unknown
+	if params := e.query(ctx, "AT+CESQ", "+CESQ:"); params != nil {
+		if rsrq := intParam(params, 4, 255); rsrq <= 34 {
+			metrics.RSRQ = floatPtr(-20 + float64(rsrq)/2)
+		}
+		if rsrp := intParam(params, 5, 255); rsrp <= 97 {
+			metrics.RSRP = floatPtr(float64(-141 + rsrp))
+		}
+	}
+	if metrics.RAT == "" && (metrics.RSRP != nil || metrics.RSRQ != nil) {
+		metrics.RAT = "LTE"
+	}
+	if metrics.RAT != "" && (metrics.RSSI != nil || metrics.RSRP != nil || metrics.RSRQ != nil) {
+		status.Signals = append(status.Signals, metrics)
+	}
+
+	status.Bearers = e.findBearers(ctx)
+
+	switch {
+	case e.funLevel(ctx) == 0:
+		status.State = "Disabled"
+	case len(status.Bearers) > 0:
+		status.State = "Connected"
+	case stat == 1 || stat == 5:
+		status.State = "Registered"
+	case stat == 2:
+		status.State = "Searching"
+	default:
+		status.State = "Enabled"
+	}
+	return status, nil
+}
+
+// query sends cmd and returns the parameters of the first response line
+// starting with prefix, or nil if the command failed or had no such line.
+func (e *ModemEngine) query(ctx context.Context, cmd, prefix string) []string {
+	lines := e.queryAll(ctx, cmd, prefix)
+	if len(lines) == 0 {
+		return nil
+	}
+	return lines[0]
+}
+
+func (e *ModemEngine) queryAll(ctx context.Context, cmd, prefix string) [][]string {
+	resp, err := e.handler.SendCommandContext(ctx, cmd)
+	if err != nil {
+		return nil
+	}
+	var out [][]string
+	for _, line := range strings.Split(resp, "\n") {
+		if strings.HasPrefix(line, prefix) {
+			out = append(out, splitParams(line))
+		}
+	}
+	return out
+}
+
+func (e *ModemEngine) funLevel(ctx context.Context) int {
+	return intParam(e.query(ctx, "AT+CFUN?", "+CFUN:"), 0, -1)
+}
+
+// findBearers reports every active PDP context with its addresses.
+func (e *ModemEngine) findBearers(ctx context.Context) []engine.Bearer {
+	active := make(map[int]bool)
+	for _, params := range e.queryAll(ctx, "AT+CGACT?", "+CGACT:") {
+		if intParam(params, 1, 0) == 1 {
+			active[intParam(params, 0, -1)] = true
+		}
+	}
+	if len(active) == 0 {
+		return nil
+	}
+
+	var cids []int
+	bearers := make(map[int]*engine.Bearer)
+	for _, params := range e.queryAll(ctx, "AT+CGPADDR", "+CGPADDR:") {
+		cid := intParam(params, 0, -1)
+		if !active[cid] {
+			continue
+		}
+		b := &engine.Bearer{Interface: "cid " + strconv.Itoa(cid), Connected: true}
+		for _, addr := range params[1:] {
+			ip := parsePDPAddress(addr)
+			switch {
+			case ip == nil || ip.IsUnspecified():
+			case ip.To4() != nil:
+				b.IPv4 = ip.String()
+			default:
+				b.IPv6 = ip.String()
+			}
+		}
+		bearers[cid] = b
+		cids = append(cids, cid)
+	}
+	sort.Ints(cids)
+
+	out := make([]engine.Bearer, 0, len(cids))
+	for _, cid := range cids {
+		out = append(out, *bearers[cid])
+	}
+	return out
+}
+
+// parsePDPAddress parses a +CGPADDR address, which some modems report for
+// IPv6 as 16 dot-separated decimal octets.
+func parsePDPAddress(addr string) net.IP {
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip
+	}
+	octets := strings.Split(addr, ".")
+	if len(octets) != net.IPv6len {
+		return nil
+	}
+	ip := make(net.IP, net.IPv6len)
+	for i, o := range octets {
+		n, err := strconv.Atoi(o)
+		if err != nil || n < 0 || n > 255 {
+			return nil
+		}
+		ip[i] = byte(n)
+	}
+	return ip
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+// ListSms lists the received messages in the modem's preferred storage.
+// Stored outgoing messages are skipped. IDs are storage indexes.
+func (e *ModemEngine) ListSms() (*engine.SmsListResult, error) {
+	list, err := e.handler.ListSms(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list SMS: %w", err)
+	}
+
+	result := &engine.SmsListResult{
+		Messages: make(map[string]string),
+	}
+
+	// AT+CMGL lists <mem1>.
+	storage := ""
+	if mems, err := e.handler.SmsStorageStatus(context.Background()); err == nil {
+		storage = mems[0].Name
+	}
+
+	for _, sms := range list {
+		if sms.Message == nil {
+			continue
+		}
+		id := strconv.Itoa(sms.Index)
+		result.Messages[id] = id
+		result.Sms = append(result.Sms, engine.SmsMessage{
+			ID:        id,
+			Number:    sms.Message.Sender,
+			Text:      sms.Message.Text,
+			Timestamp: sms.Message.Timestamp,
+			Storage:   storage,
+		})
+	}
+	return result, nil
+}
+
+// SendSms sends a text message in PDU mode, split into parts if needed.
+func (e *ModemEngine) SendSms(recipient, text string) error {
+	_, err := e.handler.SendSms(context.Background(), recipient, text)
+	return err
+}
+
+// SendSmsWithReport sends a text message with status reports requested and
+// follows the +CDS reports for its parts. The message counts as delivered
+// once every part is, and as failed as soon as one part fails.
+func (e *ModemEngine) SendSmsWithReport(recipient, text string) (<-chan engine.SmsDeliveryUpdate, error) {
+	// Subscribe first: a report can arrive right after +CMGS.
+	events, cancel := e.handler.Subscribe()
+	refs, err := e.handler.SendSmsWithReport(context.Background(), recipient, text)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	updates := make(chan engine.SmsDeliveryUpdate, 4)
+	go func() {
+		defer close(updates)
+		defer cancel()
+		updates <- engine.SmsDeliveryUpdate{State: engine.SmsSent, Status: -1}
+
+		pending := make(map[int]bool, len(refs))
+		for _, ref := range refs {
+			pending[ref] = true
+		}
+		timeout := time.NewTimer(deliveryReportTimeout)
+		defer timeout.Stop()
+		for {
+			select {
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				cds, ok := ev.(StatusReportEvent)
+				if !ok {
+					continue
+				}
+				report, err := pdu.DecodeStatusReport(cds.PDU, true)
+				if err != nil || !pending[report.Reference] {
+					continue
+				}
+				update := engine.DeliveryUpdate(report.Status)
+				switch update.State {
+				case engine.SmsDelivered:
+					delete(pending, report.Reference)
+					if len(pending) > 0 {
+						continue
+					}
+				case engine.SmsFailed:
+					updates <- update
+					return
+				}
+				updates <- update
+				if len(pending) == 0 {
+					return
+				}
+			case <-timeout.C:
+				return
+			}
+		}
+	}()
+	return updates, nil
+}
+
+// SmsStorages lists the storages received messages can go to, with their
+// usage. The default storage is <mem3> of AT+CPMS.
+func (e *ModemEngine) SmsStorages() ([]engine.SmsStorage, error) {
+	ctx := context.Background()
+	names, err := e.handler.SupportedSmsStorages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list SMS storages: %w", err)
+	}
+	mems, err := e.handler.SmsStorageStatus(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query SMS storage: %w", err)
+	}
+	receive := mems[len(mems)-1].Name
+
+	storages := make([]engine.SmsStorage, 0, len(names))
+	for _, name := range names {
+		usage, _ := FindSmsStorage(mems, name)
+		storages = append(storages, engine.SmsStorage{Name: name, Used: usage.Used, Total: usage.Total, Default: name == receive})
+	}
+	return storages, nil
+}
+
+// SetDefaultSmsStorage selects name for all of <mem1>..<mem3>, so that
+// ListSms shows the storage new messages are received into.
+func (e *ModemEngine) SetDefaultSmsStorage(name string) error {
+	ctx := context.Background()
+	names, err := e.handler.SupportedSmsStorages(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list SMS storages: %w", err)
+	}
+	if !slices.Contains(names, name) {
+		return fmt.Errorf("unsupported SMS storage %q, supported: %s", name, strings.Join(names, ", "))
+	}
+	return e.handler.SetSmsStorage(ctx, name)
+}
+
+// DeleteSms deletes a message; id is its storage index.
+func (e *ModemEngine) DeleteSms(id string) error {
+	index, err := strconv.Atoi(id)
+	if err != nil {
+		return fmt.Errorf("invalid SMS index %q", id)
+	}
+	return e.handler.DeleteSms(context.Background(), index)
+}
+
+// Identity returns the modem's IMEI and the ICCID of its SIM. A SIM that
+// cannot be read is reported as empty.
+func (e *ModemEngine) Identity() (string, string, error) {
+	imei, err := e.handler.GetIMEI()
+	if err != nil {
+		return "", "", err
+	}
+	iccid, _ := e.handler.GetICCID()
+	return imei, iccid, nil
+}
+
+// SwitchSim selects the SIM slot (starting at 1) with the Fibocom
+// AT+GTDUALSIM command.
+func (e *ModemEngine) SwitchSim(slot uint32) error {
+	if slot == 0 {
+		return errors.New("SIM slots start at 1")
+	}
+	_, err := e.handler.SendCommand(fmt.Sprintf("AT+GTDUALSIM=%d", slot-1))
+	return err
+}
+
+// SetData activates or deactivates the default PDP context (cid 1).
+func (e *ModemEngine) SetData(enable bool) error {
+	state := 0
+	if enable {
+		state = 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), setDataTimeout)
+	defer cancel()
+	_, err := e.handler.SendCommandContext(ctx, fmt.Sprintf("AT+CGACT=%d,1", state))
+	return err
+}
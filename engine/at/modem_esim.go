@@ -0,0 +1,86 @@
+package at
+
+import (
+	"context"
+
+	"tg_modem/engine"
+)
+
+// The eSIM features of ModemEngine go straight to its handler.
+
+func (e *ModemEngine) GetEsimICCID() (string, error) {
+	return e.handler.GetICCID()
+}
+
+func (e *ModemEngine) GetEsimEID() (string, error) {
+	return e.handler.GetEID()
+}
+
+func (e *ModemEngine) GetEsimPower() (string, error) {
+	power, err := e.handler.GetEsimPower()
+	if err != nil {
+		return "", err
+	}
+	return DescribeEsimPower(power), nil
+}
+
+func (e *ModemEngine) SetEsimPower(power bool) (string, error) {
+	return e.handler.SetEsimPower(power)
+}
+
+func (e *ModemEngine) GetEsimStatus() (string, error) {
+	status, err := e.handler.GetStatus()
+	if status == "1" {
+		return "ESIM已启用", err
+	}
+	return "ESIM未启用或未知", err
+}
+
+func (e *ModemEngine) GetEuiccInfo() (*engine.EuiccInfo, error) {
+	return e.handler.GetEuiccInfo(context.Background())
+}
+
+func (e *ModemEngine) ListEsimProfiles() ([]engine.EsimProfile, error) {
+	return e.handler.ListProfiles(context.Background())
+}
+
+func (e *ModemEngine) EnableEsimProfile(iccid string) error {
+	return e.handler.EnableProfile(context.Background(), iccid)
+}
+
+func (e *ModemEngine) DisableEsimProfile(iccid string) error {
+	return e.handler.DisableProfile(context.Background(), iccid)
+}
+
+func (e *ModemEngine) DeleteEsimProfile(iccid string) error {
+	return e.handler.DeleteProfile(context.Background(), iccid)
+}
+
+func (e *ModemEngine) SetEsimNickname(iccid, nickname string) error {
+	return e.handler.SetNickname(context.Background(), iccid, nickname)
+}
+
+func (e *ModemEngine) DownloadEsimProfile(ctx context.Context, activationCode, confirmationCode string, progress func(engine.DownloadProgress)) (*engine.EsimProfile, error) {
+	return e.handler.DownloadProfile(ctx, activationCode, confirmationCode, progress)
+}
+
+func (e *ModemEngine) ListEsimNotifications() ([]engine.EsimNotification, error) {
+	return e.handler.ListNotifications(context.Background())
+}
+
+func (e *ModemEngine) ProcessEsimNotification(ctx context.Context, seq int) error {
+	return e.handler.ProcessNotification(ctx, seq)
+}
+
+func (e *ModemEngine) RemoveEsimNotification(seq int) error {
+	return e.handler.RemoveNotification(context.Background(), seq)
+}
+
+var (
+	_ engine.Engine            = (*ModemEngine)(nil)
+	_ engine.ATEngine          = (*ModemEngine)(nil)
+	_ engine.ATSetter          = (*ModemEngine)(nil)
+	_ engine.Identifier        = (*ModemEngine)(nil)
+	_ engine.DeliveryReporter  = (*ModemEngine)(nil)
+	_ engine.SmsStorageManager = (*ModemEngine)(nil)
+)
@@ -0,0 +1,189 @@
+package at
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"tg_modem/engine"
+)
+
+// ES10b notification data object tags (SGP.22 v2.x).
+const (
+	tagListNotification         = 0xBF28
+	tagRetrieveNotificationList = 0xBF2B
+	tagRemoveNotification       = 0xBF30
+	tagSeqNumber                = 0x80
+	tagNotificationOperation    = 0x81
+)
+
+// notificationOperations names the bits of NotificationEvent, MSB first.
+var notificationOperations = []string{"install", "enable", "disable", "delete"}
+
+// ListNotifications returns the notifications pending on the eUICC
+// (ES10b.ListNotification).
+func (h *Handler) ListNotifications(ctx context.Context) ([]engine.EsimNotification, error) {
+	var list []engine.EsimNotification
+	err := h.withISDR(ctx, func(c *logicalChannel) error {
+		resp, err := c.es10(encodeTLV(tagListNotification))
+		if err != nil {
+			return fmt.Errorf("ListNotification failed: %w", err)
+		}
+		metas, ok := resp.child(0xA0)
+		if !ok {
+			return fmt.Errorf("unexpected ListNotification response %X", resp.raw)
+		}
+		children, err := metas.children()
+		if err != nil {
+			return err
+		}
+		for _, m := range children {
+			if m.tag == tagNotificationMetadata {
+				list = append(list, decodeNotificationMetadata(m))
+			}
+		}
+		return nil
+	})
+	return list, err
+}
+
+// ProcessNotification delivers the notification with sequence number seq to
+// its SM-DP+ and removes it from the eUICC once the server accepted it.
+func (h *Handler) ProcessNotification(ctx context.Context, seq int) error {
+	t := h.smdpTransport()
+	return h.withISDR(ctx, func(c *logicalChannel) error {
+		pending, err := c.retrieveNotification(seq)
+		if err != nil {
+			return err
+		}
+		meta := decodeNotificationMetadata(pendingMetadata(pending))
+		if meta.Address == "" {
+			return fmt.Errorf("notification %d has no SM-DP+ address", seq)
+		}
+		err = callES9(ctx, t, meta.Address, "handleNotification", &handleNotificationRequest{
+			PendingNotification: b64(pending.raw),
+		}, &emptyResponse{})
+		if err != nil {
+			return fmt.Errorf("failed to deliver notification %d: %w", seq, err)
+		}
+		return c.removeNotification(seq)
+	})
+}
+
+// RemoveNotification deletes the notification with sequence number seq from
+// the eUICC without delivering it (ES10b.RemoveNotificationFromList).
+func (h *Handler) RemoveNotification(ctx context.Context, seq int) error {
+	return h.withISDR(ctx, func(c *logicalChannel) error {
+		return c.removeNotification(seq)
+	})
+}
+
+// retrieveNotification fetches the signed notification with sequence number
+// seq (ES10b.RetrieveNotificationsList).
+func (c *logicalChannel) retrieveNotification(seq int) (tlv, error) {
+	req := encodeTLV(tagRetrieveNotificationList, encodeTLV(0xA0, encodeTLV(tagSeqNumber, encodeInt(seq))))
+	resp, err := c.es10(req)
+	if err != nil {
+		return tlv{}, fmt.Errorf("RetrieveNotificationsList failed: %w", err)
+	}
+	list, ok := resp.child(0xA0)
+	if !ok {
+		if code, ok := resp.child(0x81); ok {
+			return tlv{}, fmt.Errorf("RetrieveNotificationsList failed with error %d", tlvInt(code.value))
+		}
+		return tlv{}, fmt.Errorf("unexpected RetrieveNotificationsList response %X", resp.raw)
+	}
+	children, err := list.children()
+	if err != nil {
+		return tlv{}, err
+	}
+	if len(children) == 0 {
+		return tlv{}, fmt.Errorf("notification %d not found", seq)
+	}
+	return children[0], nil
+}
+
+func (c *logicalChannel) removeNotification(seq int) error {
+	resp, err := c.es10(encodeTLV(tagRemoveNotification, encodeTLV(tagSeqNumber, encodeInt(seq))))
+	if err != nil {
+		return fmt.Errorf("RemoveNotificationFromList failed: %w", err)
+	}
+	result, ok := resp.child(tagResult)
+	if !ok {
+		return fmt.Errorf("unexpected RemoveNotificationFromList response %X", resp.raw)
+	}
+	switch code := tlvInt(result.value); code {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("notification %d not found", seq)
+	default:
+		return fmt.Errorf("RemoveNotificationFromList failed with error %d", code)
+	}
+}
+
+// removeDeliveredResult removes the notification of a profile installation
+// result once it has been delivered by the download flow.
+func (c *logicalChannel) removeDeliveredResult(result tlv) {
+	meta := pendingMetadata(result)
+	seq, ok := meta.child(tagSeqNumber)
+	if !ok {
+		return
+	}
+	if err := c.removeNotification(tlvInt(seq.value)); err != nil {
+		log.Printf("WARN: failed to remove delivered installation result: %v", err)
+	}
+}
+
+// pendingMetadata finds the NotificationMetadata in a PendingNotification,
+// which is either a ProfileInstallationResult or an OtherSignedNotification.
+func pendingMetadata(pending tlv) tlv {
+	if data, ok := pending.child(tagProfileInstallData); ok {
+		pending = data
+	}
+	meta, _ := pending.child(tagNotificationMetadata)
+	return meta
+}
+
+func decodeNotificationMetadata(m tlv) engine.EsimNotification {
+	var n engine.EsimNotification
+	fields, _ := m.children()
+	for _, f := range fields {
+		switch f.tag {
+		case tagSeqNumber:
+			n.Seq = tlvInt(f.value)
+		case tagNotificationOperation:
+			n.Operation = decodeNotificationOperation(f.value)
+		case tagNotificationAddress:
+			n.Address = string(f.value)
+		case tagICCID:
+			n.ICCID = decodeICCID(f.value)
+		}
+	}
+	return n
+}
+
+// decodeNotificationOperation decodes the NotificationEvent BIT STRING,
+// whose first octet is the number of unused bits.
+func decodeNotificationOperation(b []byte) string {
+	if len(b) < 2 {
+		return ""
+	}
+	for i, name := range notificationOperations {
+		if b[1]&(0x80>>i) != 0 {
+			return name
+		}
+	}
+	return ""
+}
+
+// encodeInt encodes a non-negative ASN.1 INTEGER value.
+func encodeInt(n int) []byte {
+	b := []byte{byte(n)}
+	for n >>= 8; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
@@ -0,0 +1,234 @@
+package at
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+const (
+	defaultCommandTimeout = 5 * time.Second
+	minReopenDelay        = 1 * time.Second
+	maxReopenDelay        = 30 * time.Second
+	// resyncQuietPeriod is how long the port must stay silent after an OK
+	// before a resync after a timed-out command is considered complete.
+	resyncQuietPeriod = 200 * time.Millisecond
+)
+
+// ErrClosed is returned for commands issued after Close.
+var ErrClosed = errors.New("AT handler is closed")
+
+// pendingCommand is the command currently waiting for its final result code.
+// The reader goroutine routes every solicited line into lines, waiting for
+// the command to take it rather than dropping lines of long responses.
+type pendingCommand struct {
+	cmd    string
+	lines  chan string
+	failed chan error
+	done   chan struct{} // closed once the command stops reading lines
+}
+
+// start launches the port supervisor on first use.
+func (h *Handler) start() {
+	h.startOnce.Do(func() {
+		go h.run()
+	})
+}
+
+// Close stops the port supervisor and releases the serial port.
+// Commands issued afterwards fail with ErrClosed.
+func (h *Handler) Close() error {
+	if h == nil {
+		return nil
+	}
+	var err error
+	h.closeOnce.Do(func() {
+		close(h.closed)
+		h.mu.Lock()
+		port := h.port
+		h.mu.Unlock()
+		if port != nil {
+			err = port.Close()
+		}
+	})
+	return err
+}
+
+// run keeps the port open for the lifetime of the handler. Whenever the port
+// fails (device removed, modem reset) it is closed and reopened with backoff.
+func (h *Handler) run() {
+	delay := minReopenDelay
+	for {
+		select {
+		case <-h.closed:
+			return
+		default:
+		}
+
+		port, err := serial.Open(h.portName, &serial.Mode{
+			BaudRate: 115200,
+		})
+		if err != nil {
+			log.Printf("WARN: failed to open serial port %s: %v, retrying in %s", h.portName, err, delay)
+			select {
+			case <-time.After(delay):
+			case <-h.closed:
+				return
+			}
+			delay = min(delay*2, maxReopenDelay)
+			continue
+		}
+		delay = minReopenDelay
+		log.Printf("AT port %s opened", h.portName)
+
+		h.mu.Lock()
+		select {
+		case <-h.closed:
+			h.mu.Unlock()
+			port.Close()
+			return
+		default:
+		}
+		h.port = port
+		close(h.ready)
+		h.mu.Unlock()
+		if h.hasSubscribers() {
+			go h.enableURCs()
+		}
+
+		err = h.readLoop(port)
+
+		h.mu.Lock()
+		h.port = nil
+		h.ready = make(chan struct{})
+		if h.active != nil {
+			select {
+			case h.active.failed <- err:
+			default:
+			}
+		}
+		h.mu.Unlock()
+		port.Close()
+
+		select {
+		case <-h.closed:
+			return
+		default:
+			log.Printf("WARN: AT port %s lost: %v, reopening", h.portName, err)
+		}
+	}
+}
+
+// readLoop reads lines from port until it fails and routes each one.
+func (h *Handler) readLoop(port serial.Port) error {
+	scanner := bufio.NewScanner(port)
+	scanner.Split(scanLines)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		log.Printf("AT < %s", line)
+		h.dispatchLine(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("port closed")
+}
+
+// dispatchLine publishes URCs and hands everything else to the active
+// command, if any.
+func (h *Handler) dispatchLine(line string) {
+	h.mu.Lock()
+	p := h.active
+	h.mu.Unlock()
+
+	if h.pendingCDS != "" {
+		if matchesCDS(h.pendingCDS, line) {
+			h.completeCDS(line)
+			return
+		}
+		log.Printf("WARN: %s not followed by its PDU, got: %s", h.pendingCDS, line)
+		h.pendingCDS = ""
+	}
+	if name, ok := urcName(line); ok && (p == nil || !p.solicits(name)) {
+		h.handleURC(name, line)
+		return
+	}
+	if p == nil {
+		log.Printf("AT: unsolicited line ignored: %s", line)
+		return
+	}
+	select {
+	case p.lines <- line:
+	case <-p.done:
+		log.Printf("AT: line after the end of %s ignored: %s", p.cmd, line)
+	case <-h.closed:
+	}
+}
+
+// waitPort blocks until the supervisor has the port open.
+func (h *Handler) waitPort(ctx context.Context) (serial.Port, error) {
+	for {
+		h.mu.Lock()
+		port, ready := h.port, h.ready
+		h.mu.Unlock()
+		if port != nil {
+			return port, nil
+		}
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("serial port %s unavailable: %w", h.portName, ctx.Err())
+		case <-h.closed:
+			return nil, ErrClosed
+		}
+	}
+}
+
+// activate makes cmd the command receiving solicited lines.
+func (h *Handler) activate(cmd string) *pendingCommand {
+	p := &pendingCommand{cmd: cmd, lines: make(chan string, 32), failed: make(chan error, 1), done: make(chan struct{})}
+	h.mu.Lock()
+	h.active = p
+	h.mu.Unlock()
+	return p
+}
+
+// release stops routing lines to p, unblocking the reader if it waits on it.
+func (h *Handler) release(p *pendingCommand) {
+	h.mu.Lock()
+	if h.active == p {
+		h.active = nil
+	}
+	h.mu.Unlock()
+	close(p.done)
+}
+
+// scanLines is a bufio.SplitFunc that treats both CR and LF as line
+// terminators, since modems are inconsistent about which they send. The
+// "> " prompt of AT+CMGS is not followed by a newline and becomes ">".
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if len(data) >= 2 && data[0] == '>' && data[1] == ' ' {
+		return 2, data[:1], nil
+	}
+	for i, b := range data {
+		if b == '\r' || b == '\n' {
+			return i + 1, data[:i], nil
+		}
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,229 @@
+package at
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"tg_modem/engine/pdu"
+)
+
+// sendSmsTimeout bounds AT+CMGS, which only returns once the network has
+// accepted the message.
+const sendSmsTimeout = 60 * time.Second
+
+// Message status values of AT+CMGL/AT+CMGR (27.005, PDU mode).
+const (
+	SmsReceivedUnread = 0
+	SmsReceivedRead   = 1
+	SmsStoredUnsent   = 2
+	SmsStoredSent     = 3
+)
+
+// StoredSms is a message in the modem's preferred storage.
+type StoredSms struct {
+	Index  int
+	Status int
+	// Message is nil for stored outgoing messages, which are SMS-SUBMITs.
+	Message *pdu.Message
+}
+
+// concatRef numbers the parts of long outgoing messages.
+var concatRef atomic.Uint32
+
+// ListSms returns every message in the preferred storage using PDU mode.
+func (h *Handler) ListSms(ctx context.Context) ([]StoredSms, error) {
+	if _, err := h.SendCommandContext(ctx, "AT+CMGF=0"); err != nil {
+		return nil, err
+	}
+	response, err := h.SendCommandContext(ctx, "AT+CMGL=4")
+	if err != nil {
+		return nil, err
+	}
+
+	var list []StoredSms
+	lines := strings.Split(response, "\n")
+	for i := 0; i < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], "+CMGL:") {
+			continue
+		}
+		params := splitParams(lines[i])
+		sms := StoredSms{Index: intParam(params, 0, -1), Status: intParam(params, 1, -1)}
+		if i+1 < len(lines) && !strings.HasPrefix(lines[i+1], "+CMGL:") {
+			i++
+			if sms.Status == SmsReceivedUnread || sms.Status == SmsReceivedRead {
+				msg, err := pdu.Decode(lines[i])
+				if err != nil {
+					return nil, fmt.Errorf("message %d: %w", sms.Index, err)
+				}
+				sms.Message = msg
+			}
+		}
+		list = append(list, sms)
+	}
+	return list, nil
+}
+
+// ReadSms reads the received message stored at index.
+func (h *Handler) ReadSms(ctx context.Context, index int) (*pdu.Message, error) {
+	if _, err := h.SendCommandContext(ctx, "AT+CMGF=0"); err != nil {
+		return nil, err
+	}
+	response, err := h.SendCommandContext(ctx, fmt.Sprintf("AT+CMGR=%d", index))
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(response), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "+CMGR:") && i+1 < len(lines) {
+			return pdu.Decode(lines[i+1])
+		}
+	}
+	return nil, fmt.Errorf("no message at index %d", index)
+}
+
+// DeleteSms deletes the message stored at index.
+func (h *Handler) DeleteSms(ctx context.Context, index int) error {
+	_, err := h.SendCommandContext(ctx, fmt.Sprintf("AT+CMGD=%d", index))
+	return err
+}
+
+// SendSms sends text to number, split into as many parts as needed, and
+// returns the message reference the network assigned to each part.
+func (h *Handler) SendSms(ctx context.Context, number, text string) ([]int, error) {
+	return h.sendSms(ctx, number, text, false)
+}
+
+// SendSmsWithReport is SendSms with a status report requested for every
+// part. The reports arrive as StatusReportEvent carrying the same
+// references.
+func (h *Handler) SendSmsWithReport(ctx context.Context, number, text string) ([]int, error) {
+	return h.sendSms(ctx, number, text, true)
+}
+
+func (h *Handler) sendSms(ctx context.Context, number, text string, statusReport bool) ([]int, error) {
+	parts, err := pdu.EncodeSubmit(number, text, byte(concatRef.Add(1)), statusReport)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := h.SendCommandContext(ctx, "AT+CMGF=0"); err != nil {
+		return nil, err
+	}
+
+	var refs []int
+	for i, part := range parts {
+		sendCtx, cancel := context.WithTimeout(ctx, sendSmsTimeout)
+		response, err := h.SendWithPrompt(sendCtx, fmt.Sprintf("AT+CMGS=%d", part.Length), part.Hex)
+		cancel()
+		if err != nil {
+			if len(parts) > 1 {
+				return refs, fmt.Errorf("part %d/%d: %w", i+1, len(parts), err)
+			}
+			return refs, err
+		}
+		ref := -1
+		for _, line := range strings.Split(response, "\n") {
+			if strings.HasPrefix(line, "+CMGS:") {
+				ref = intParam(splitParams(line), 0, -1)
+			}
+		}
+		if ref < 0 {
+			return refs, errors.New("no message reference in +CMGS response")
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// SmsStorageUsage is the usage of one message storage (27.005 AT+CPMS).
+type SmsStorageUsage struct {
+	Name  string // e.g. SM (SIM), ME (modem), MT (both)
+	Used  int
+	Total int
+}
+
+// SupportedSmsStorages returns the storages received messages can be
+// stored in (<mem3> of AT+CPMS=?).
+func (h *Handler) SupportedSmsStorages(ctx context.Context) ([]string, error) {
+	response, err := h.SendCommandContext(ctx, "AT+CPMS=?")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(response, "\n") {
+		if !strings.HasPrefix(line, "+CPMS:") {
+			continue
+		}
+		// +CPMS: ("SM","ME","MT"),("SM","ME","MT"),("SM","ME","MT")
+		var groups []string
+		for rest := line; ; {
+			start := strings.Index(rest, "(")
+			end := strings.Index(rest, ")")
+			if start < 0 || end < start {
+				break
+			}
+			groups = append(groups, rest[start+1:end])
+			rest = rest[end+1:]
+		}
+		if len(groups) == 0 {
+			break
+		}
+		var names []string
+		for _, name := range strings.Split(groups[len(groups)-1], ",") {
+			if name = strings.Trim(strings.TrimSpace(name), `"`); name != "" {
+				names = append(names, name)
+			}
+		}
+		return names, nil
+	}
+	return nil, errors.New("no +CPMS in response")
+}
+
+// SmsStorageStatus returns <mem1> (read and delete), <mem2> (write and
+// send) and <mem3> (receive) with their usage (AT+CPMS?).
+func (h *Handler) SmsStorageStatus(ctx context.Context) ([]SmsStorageUsage, error) {
+	response, err := h.SendCommandContext(ctx, "AT+CPMS?")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(response, "\n") {
+		if !strings.HasPrefix(line, "+CPMS:") {
+			continue
+		}
+		params := splitParams(line)
+		var mems []SmsStorageUsage
+		for i := 0; i+2 < len(params); i += 3 {
+			mems = append(mems, SmsStorageUsage{
+				Name:  params[i],
+				Used:  intParam(params, i+1, -1),
+				Total: intParam(params, i+2, -1),
+			})
+		}
+		if len(mems) == 0 {
+			break
+		}
+		return mems, nil
+	}
+	return nil, errors.New("no +CPMS in response")
+}
+
+// FindSmsStorage returns the usage of storage name from the result of
+// SmsStorageStatus. AT+CPMS? only reports the selected storages; the usage
+// of others is unknown, since selecting them would disturb ModemManager or
+// whoever else reads messages from the current <mem1>.
+func FindSmsStorage(mems []SmsStorageUsage, name string) (SmsStorageUsage, bool) {
+	for _, m := range mems {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return SmsStorageUsage{Name: name, Used: -1, Total: -1}, false
+}
+
+// SetSmsStorage selects name for reading, writing and receiving messages.
+func (h *Handler) SetSmsStorage(ctx context.Context, name string) error {
+	_, err := h.SendCommandContext(ctx, fmt.Sprintf(`AT+CPMS="%s","%s","%s"`, name, name, name))
+	return err
+}
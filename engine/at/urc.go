@@ -0,0 +1,270 @@
+package at
+
+import (
+	"encoding/hex"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Event is an unsolicited result code (URC) printed by the modem outside of
+// any command response. Subscribers type-switch on the concrete event types.
+type Event interface {
+	// Line returns the raw text the event was decoded from.
+	Line() string
+}
+
+type urcLine string
+
+func (l urcLine) Line() string { return string(l) }
+
+// NewSmsEvent is reported by +CMTI when a message is stored on the modem.
+type NewSmsEvent struct {
+	urcLine
+	Storage string
+	Index   int
+}
+
+// RingEvent is reported by RING for every ring of an incoming call.
+type RingEvent struct {
+	urcLine
+}
+
+// CallerIDEvent is reported by +CLIP during an incoming call.
+type CallerIDEvent struct {
+	urcLine
+	Number string
+	Type   int
+}
+
+// RegistrationEvent is reported by +CREG (circuit switched) and +CEREG (EPS)
+// when the registration state changes. Stat follows 3GPP TS 27.007, e.g.
+// 1 = registered (home), 5 = registered (roaming).
+type RegistrationEvent struct {
+	urcLine
+	Domain string // "CREG" or "CEREG"
+	Stat   int
+	Lac    string
+	CellID string
+	AcT    int // -1 when not reported
+}
+
+// UssdEvent is reported by +CUSD for network initiated USSD or a USSD reply.
+type UssdEvent struct {
+	urcLine
+	Status int
+	Text   string
+	DCS    int
+}
+
+// StatusReportEvent is reported by +CDS; PDU holds the hex encoded
+// SMS-STATUS-REPORT that follows on the next line.
+type StatusReportEvent struct {
+	urcLine
+	Length int
+	PDU    string
+}
+
+// VendorEvent is any vendor specific (+GT...) line.
+type VendorEvent struct {
+	urcLine
+	Name   string
+	Params []string
+}
+
+// urcSetupCommands make the modem report the URCs decoded above.
+var urcSetupCommands = []string{
+	"AT+CLIP=1",
+	"AT+CREG=2",
+	"AT+CEREG=2",
+	"AT+CNMI=2,1,0,1,0",
+}
+
+// Subscribe returns a channel receiving every URC from now on and a function
+// that cancels the subscription. Slow subscribers miss events rather than
+// stall the port.
+func (h *Handler) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 32)
+
+	h.subMu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[chan Event]struct{})
+	}
+	first := len(h.subs) == 0
+	h.subs[ch] = struct{}{}
+	h.subMu.Unlock()
+
+	h.start()
+	// If the port is not open yet, run enables URCs once it is.
+	h.mu.Lock()
+	open := h.port != nil
+	h.mu.Unlock()
+	if first && open {
+		go h.enableURCs()
+	}
+
+	return ch, func() {
+		h.subMu.Lock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+		h.subMu.Unlock()
+	}
+}
+
+func (h *Handler) hasSubscribers() bool {
+	h.subMu.Lock()
+	defer h.subMu.Unlock()
+	return len(h.subs) > 0
+}
+
+// enableURCs asks the modem to report URCs. It runs whenever the port is
+// (re)opened while someone is subscribed, as a modem reset clears the settings.
+func (h *Handler) enableURCs() {
+	for _, cmd := range urcSetupCommands {
+		if _, err := h.SendCommand(cmd); err != nil {
+			log.Printf("WARN: failed to enable URC with %s: %v", cmd, err)
+		}
+	}
+}
+
+func (h *Handler) publish(ev Event) {
+	h.subMu.Lock()
+	defer h.subMu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+			log.Printf("WARN: URC subscriber is full, dropping: %s", ev.Line())
+		}
+	}
+}
+
+// urcName returns the name of the result code in line ("+CMTI", "RING", ...)
+// if it is one we treat as unsolicited.
+func urcName(line string) (string, bool) {
+	if line == "RING" {
+		return line, true
+	}
+	name, _, ok := strings.Cut(line, ":")
+	if !ok {
+		return "", false
+	}
+	switch name {
+	case "+CMTI", "+CLIP", "+CREG", "+CEREG", "+CUSD", "+CDS":
+		return name, true
+	}
+	if strings.HasPrefix(name, "+GT") {
+		return name, true
+	}
+	return "", false
+}
+
+// solicits reports whether the command expects name as part of its response,
+// e.g. "AT+CREG?" answers with a "+CREG:" line that is not a URC.
+func (p *pendingCommand) solicits(name string) bool {
+	return strings.HasPrefix(name, "+") && strings.Contains(strings.ToUpper(p.cmd), name)
+}
+
+// handleURC decodes line and publishes it. Called from the reader goroutine
+// only; +CDS spans two lines so its header is kept until the PDU arrives.
+func (h *Handler) handleURC(name, line string) {
+	params := splitParams(line)
+	raw := urcLine(line)
+
+	var ev Event
+	switch name {
+	case "RING":
+		ev = RingEvent{urcLine: raw}
+	case "+CMTI":
+		ev = NewSmsEvent{urcLine: raw, Storage: param(params, 0), Index: intParam(params, 1, -1)}
+	case "+CLIP":
+		ev = CallerIDEvent{urcLine: raw, Number: param(params, 0), Type: intParam(params, 1, 0)}
+	case "+CREG", "+CEREG":
+		ev = RegistrationEvent{
+			urcLine: raw,
+			Domain:  strings.TrimPrefix(name, "+"),
+			Stat:    intParam(params, 0, -1),
+			Lac:     param(params, 1),
+			CellID:  param(params, 2),
+			AcT:     intParam(params, 3, -1),
+		}
+	case "+CUSD":
+		ev = UssdEvent{urcLine: raw, Status: intParam(params, 0, -1), Text: param(params, 1), DCS: intParam(params, 2, 0)}
+	case "+CDS":
+		h.pendingCDS = line
+		return
+	default:
+		ev = VendorEvent{urcLine: raw, Name: name, Params: params}
+	}
+	h.publish(ev)
+}
+
+// matchesCDS reports whether line is the PDU announced by the +CDS header:
+// hex encoding an SMSC address followed by a TPDU of the announced length.
+func matchesCDS(header, line string) bool {
+	params := splitParams(header)
+	length := intParam(params, len(params)-1, -1)
+	data, err := hex.DecodeString(line)
+	if err != nil || len(data) == 0 || length <= 0 {
+		return false
+	}
+	return len(data) == 1+int(data[0])+length
+}
+
+// completeCDS finishes a +CDS report with its PDU line.
+func (h *Handler) completeCDS(pdu string) {
+	header := h.pendingCDS
+	h.pendingCDS = ""
+	params := splitParams(header)
+	h.publish(StatusReportEvent{
+		urcLine: urcLine(header + "\n" + pdu),
+		Length:  intParam(params, len(params)-1, 0),
+		PDU:     pdu,
+	})
+}
+
+// splitParams splits the parameters after "NAME: " on commas outside of
+// quotes and strips the quotes.
+func splitParams(line string) []string {
+	_, rest, ok := strings.Cut(line, ":")
+	if !ok {
+		return nil
+	}
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return nil
+	}
+
+	var params []string
+	var cur strings.Builder
+	inQuotes := false
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case r == ',' && !inQuotes:
+			params = append(params, strings.TrimSpace(cur.String()))
+			cur.Reset()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	return append(params, strings.TrimSpace(cur.String()))
+}
+
+func param(params []string, i int) string {
+	if i < 0 || i >= len(params) {
+		return ""
+	}
+	return params[i]
+}
+
+func intParam(params []string, i int, def int) int {
+	n, err := strconv.Atoi(param(params, i))
+	if err != nil {
+		return def
+	}
+	return n
+}
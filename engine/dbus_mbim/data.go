@@ -13,7 +13,7 @@ const bearerIface = "org.freedesktop.ModemManager1.Bearer"
 
 // SetData 开启或关闭移动数据
 func (e *DBusMBIMEngine) SetData(enable bool) error {
-	modemObj := e.Conn.Object(mmService, e.modemPath)
+	modemObj := e.Conn.Object(mmService, e.currentModem())
 
 	var status map[string]dbus.Variant
 	err := modemObj.Call(simpleIface+".GetStatus", 0).Store(&status)
@@ -58,7 +58,7 @@ func (e *DBusMBIMEngine) SetData(enable bool) error {
 }
 
 func (e *DBusMBIMEngine) findActiveBearerForDisconnect() dbus.ObjectPath {
-	modemObj := e.Conn.Object(mmService, e.modemPath)
+	modemObj := e.Conn.Object(mmService, e.currentModem())
 	var status map[string]dbus.Variant
 	err := modemObj.Call(simpleIface+".GetStatus", 0).Store(&status)
 	if err == nil {
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"tg_modem/engine"
 	"tg_modem/engine/at"
 
@@ -25,8 +26,12 @@ func init() {
 // DBusMBIMEngine 通过 D-Bus 与 ModemManager 交互
 type DBusMBIMEngine struct {
 	Conn      *dbus.Conn
-	modemPath dbus.ObjectPath
 	atHandler *at.Handler
+
+	mu        sync.RWMutex
+	modemPath dbus.ObjectPath
+	// known 记录见过的调制解调器, 以便在 InterfacesRemoved 时给出其信息
+	known map[dbus.ObjectPath]engine.ModemInfo
 }
 
 func (e *DBusMBIMEngine) SetATHandler(handler interface{}) {
@@ -34,14 +39,14 @@ func (e *DBusMBIMEngine) SetATHandler(handler interface{}) {
 }
 
 // Init 初始化 D-Bus 连接并查找第一个可用的调制解调器
+// 若 Conn 已预先设置 (如测试中的私有总线), 则直接使用该连接
 func (e *DBusMBIMEngine) Init() error {
 	var err error
-	e.Conn, err = dbus.SystemBus()
-	if err != nil {
-		return fmt.Errorf("无法连接到系统 D-Bus: %w", err)
-	}
-	if err := e.setupSignalPolling(); err != nil {
-		log.Printf("WARN: Could not setup signal polling: %v. Detailed signal info may be unavailable.", err)
+	if e.Conn == nil {
+		e.Conn, err = dbus.SystemBus()
+		if err != nil {
+			return fmt.Errorf("无法连接到系统 D-Bus: %w", err)
+		}
 	}
 
 	// findModem 会执行查找逻辑，包含错误修正
@@ -50,8 +55,12 @@ func (e *DBusMBIMEngine) Init() error {
 		return fmt.Errorf("引擎初始化失败: %w", err)
 	}
 
-	e.modemPath = modemPath
-	fmt.Printf("使用调制解调器: %s\n", e.modemPath)
+	e.setModem(modemPath)
+	fmt.Printf("使用调制解调器: %s\n", modemPath)
+
+	if err := e.setupSignalPolling(); err != nil {
+		log.Printf("WARN: Could not setup signal polling: %v. Detailed signal info may be unavailable.", err)
+	}
 	return nil
 }
 
@@ -75,29 +84,49 @@ func (e *DBusMBIMEngine) findModem() (dbus.ObjectPath, error) {
 	return "", errors.New("未找到任何调制解调器")
 }
 func (e *DBusMBIMEngine) GetModemPath() dbus.ObjectPath {
+	return e.currentModem()
+}
+
+// currentModem 返回当前操作的调制解调器, 调制解调器被移除后到重新出现前为空
+func (e *DBusMBIMEngine) currentModem() dbus.ObjectPath {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	return e.modemPath
 }
 
-func (e *DBusMBIMEngine) findActiveModem() (dbus.ObjectPath, error) {
+func (e *DBusMBIMEngine) setModem(path dbus.ObjectPath) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.modemPath = path
+}
+
+// managedObjects 返回 ModemManager 导出的所有对象
+func (e *DBusMBIMEngine) managedObjects() (map[dbus.ObjectPath]map[string]map[string]dbus.Variant, error) {
 	obj := e.Conn.Object(mmService, mmPath)
 	var managedObjects map[dbus.ObjectPath]map[string]map[string]dbus.Variant
 	err := obj.Call(objectManagerIface+".GetManagedObjects", 0).Store(&managedObjects)
 	if err != nil {
-		return "", fmt.Errorf("调用 GetManagedObjects 失败: %w", err)
+		return nil, fmt.Errorf("调用 GetManagedObjects 失败: %w", err)
+	}
+	return managedObjects, nil
+}
+
+func (e *DBusMBIMEngine) findActiveModem() (dbus.ObjectPath, error) {
+	managedObjects, err := e.managedObjects()
+	if err != nil {
+		return "", err
 	}
 
 	log.Println("正在扫描所有调制解调器以查找活动设备...")
-	for path, interfaces := range managedObjects {
-		// 检查这是否是一个 Modem 对象
-		if modemData, ok := interfaces[modemIface]; ok {
-			// 检查 Modem 的状态
-			if stateVar, ok := modemData["State"]; ok {
-				if state, ok := stateVar.Value().(int32); ok {
-					// 状态 8 (Registered) 和 11 (Connected) 是我们想要的
-					log.Printf("发现 Modem: %s, 状态: %d", path, state)
-					if state == 8 || state == 11 {
-						return path, nil // 找到了！
-					}
+	for _, path := range sortedModemPaths(managedObjects) {
+		modemData := managedObjects[path][modemIface]
+		// 检查 Modem 的状态
+		if stateVar, ok := modemData["State"]; ok {
+			if state, ok := stateVar.Value().(int32); ok {
+				// 状态 8 (Registered) 和 11 (Connected) 是我们想要的
+				log.Printf("发现 Modem: %s, 状态: %d", path, state)
+				if state == 8 || state == 11 {
+					return path, nil // 找到了！
 				}
 			}
 		}
@@ -106,20 +135,29 @@ func (e *DBusMBIMEngine) findActiveModem() (dbus.ObjectPath, error) {
 	return "", errors.New("未找到任何已连接或已注册的调制解调器")
 }
 
+// setupSignalPolling 为每个调制解调器开启详细信号信息的轮询, 某个调制解调器失败时继续设置其余的,
+// 并返回所有失败
 func (e *DBusMBIMEngine) setupSignalPolling() error {
-	obj := e.Conn.Object(mmService, mmPath)
-	var modemPaths []dbus.ObjectPath
-	// We need to find *any* modem path to call Setup on its Signal interface.
-	// It seems to be a global setting for the modem hardware.
-	err := obj.Call(objectManagerIface+".GetManagedObjects", 0).Store(make(map[dbus.ObjectPath]map[string]map[string]dbus.Variant))
+	managedObjects, err := e.managedObjects()
 	if err != nil {
 		return err
 	}
-
-	// Use the first modem found just for this call
-	if len(modemPaths) > 0 {
-		modemObj := e.Conn.Object(mmService, modemPaths[0])
-		return modemObj.Call("org.freedesktop.ModemManager1.Modem.Signal.Setup", 0, uint32(1)).Store()
+	modemPaths := sortedModemPaths(managedObjects)
+	if len(modemPaths) == 0 {
+		return errors.New("no modems found to setup signal polling")
 	}
-	return errors.New("no modems found to setup signal polling")
+	var errs []error
+	for _, path := range modemPaths {
+		if err := e.setupSignal(path); err != nil {
+			log.Printf("为调制解调器 %s 开启信号轮询失败: %v", path, err)
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setupSignal 让调制解调器每秒刷新一次 Modem.Signal 上的详细信号信息
+func (e *DBusMBIMEngine) setupSignal(path dbus.ObjectPath) error {
+	modemObj := e.Conn.Object(mmService, path)
+	return modemObj.Call(modemIface+".Signal.Setup", 0, uint32(1)).Store()
 }
@@ -0,0 +1,286 @@
+package dbus_mbim
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"tg_modem/engine/at"
+	"tg_modem/engine/dbus_mbim/fakemm"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// newFakeEngine starts a fake ModemManager with one modem and an engine
+// initialized against it. It skips the test when dbus-daemon is missing.
+func newFakeEngine(t *testing.T, cfg fakemm.ModemConfig) (*fakemm.ModemManager, *fakemm.Modem, *DBusMBIMEngine) {
+	t.Helper()
+	bus, err := fakemm.StartBus()
+	if errors.Is(err, fakemm.ErrNoDaemon) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bus.Close() })
+
+	mm, err := fakemm.Start(bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mm.Close() })
+	modem := mm.AddModem(cfg)
+
+	conn, err := bus.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	eng := &DBusMBIMEngine{Conn: conn}
+	if err := eng.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return mm, modem, eng
+}
+
+func TestInitPicksRegisteredModem(t *testing.T) {
+	bus, err := fakemm.StartBus()
+	if errors.Is(err, fakemm.ErrNoDaemon) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+	mm, err := fakemm.Start(bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mm.Close()
+	mm.AddModem(fakemm.ModemConfig{Model: "disabled", State: 3}) // Disabled
+	want := mm.AddModem(fakemm.ModemConfig{Model: "FM350-GL"})
+
+	conn, err := bus.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	eng := &DBusMBIMEngine{Conn: conn}
+	if err := eng.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if got := eng.GetModemPath(); got != want.Path {
+		t.Errorf("Init picked %s, want %s", got, want.Path)
+	}
+	if rate := want.SignalRate(); rate == 0 {
+		t.Error("Init did not set up signal polling")
+	}
+}
+
+func TestSignalPollingContinuesAfterFailure(t *testing.T) {
+	mm, first, eng := newFakeEngine(t, fakemm.ModemConfig{})
+	second := mm.AddModem(fakemm.ModemConfig{})
+	mm.Fail(fakemm.SignalIface+".Setup", dbus.NewError("org.freedesktop.ModemManager1.Error.Core.Unsupported", []interface{}{"no signal"}))
+
+	err := eng.setupSignalPolling()
+	if err == nil || !strings.Contains(err.Error(), string(first.Path)) {
+		t.Fatalf("err = %v, want the failure of %s", err, first.Path)
+	}
+	if rate := second.SignalRate(); rate != 1 {
+		t.Errorf("signal rate of the second modem = %d, want 1", rate)
+	}
+}
+
+func TestInitWithoutModem(t *testing.T) {
+	bus, err := fakemm.StartBus()
+	if errors.Is(err, fakemm.ErrNoDaemon) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+	mm, err := fakemm.Start(bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mm.Close()
+
+	conn, err := bus.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	eng := &DBusMBIMEngine{Conn: conn}
+	if err := eng.Init(); err == nil {
+		t.Fatal("Init succeeded without any modem")
+	}
+}
+
+func TestListSms(t *testing.T) {
+	_, modem, eng := newFakeEngine(t, fakemm.ModemConfig{})
+	ts := time.Date(2024, 5, 1, 12, 30, 0, 0, time.FixedZone("", 8*3600))
+	in := modem.AddSmsAt("+8613800138000", "你好", ts)
+	if err := eng.SendSms("10086", "CXLL"); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := eng.ListSms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Sms) != 2 {
+		t.Fatalf("got %d messages, want 2", len(list.Sms))
+	}
+	got := list.Sms[0]
+	if got.Number != "+8613800138000" || got.Text != "你好" || got.Outgoing {
+		t.Errorf("received message = %+v", got)
+	}
+	if !got.Timestamp.Equal(ts) {
+		t.Errorf("timestamp = %v, want %v", got.Timestamp, ts)
+	}
+	if got.Storage != "ME" {
+		t.Errorf("storage = %q, want ME", got.Storage)
+	}
+	if list.Messages[got.ID] != string(in) {
+		t.Errorf("Messages[%s] = %q, want %q", got.ID, list.Messages[got.ID], in)
+	}
+	if sent := list.Sms[1]; sent.Number != "10086" || !sent.Outgoing {
+		t.Errorf("sent message = %+v", sent)
+	}
+}
+
+func TestListSmsFailure(t *testing.T) {
+	mm, _, eng := newFakeEngine(t, fakemm.ModemConfig{})
+	mm.Fail(fakemm.MessagingIface+".List", dbus.NewError("org.freedesktop.ModemManager1.Error.Core.Failed", []interface{}{"boom"}))
+	if _, err := eng.ListSms(); err == nil {
+		t.Fatal("ListSms succeeded although Messaging.List failed")
+	}
+}
+
+func TestSendSms(t *testing.T) {
+	mm, modem, eng := newFakeEngine(t, fakemm.ModemConfig{})
+	if err := eng.SendSms("+8613800138000", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	sent := modem.Sent()
+	if len(sent) != 1 || sent[0].Number != "+8613800138000" || sent[0].Text != "hello" {
+		t.Fatalf("sent = %+v", sent)
+	}
+
+	mm.Fail(fakemm.SmsIface+".Send", dbus.NewError("org.freedesktop.ModemManager1.Error.Core.Failed", []interface{}{"no network"}))
+	if err := eng.SendSms("+8613800138000", "again"); err == nil {
+		t.Fatal("SendSms succeeded although Sms.Send failed")
+	}
+	if n := len(modem.Sent()); n != 1 {
+		t.Errorf("%d messages sent, want 1", n)
+	}
+}
+
+func TestDeleteSms(t *testing.T) {
+	_, modem, eng := newFakeEngine(t, fakemm.ModemConfig{})
+	keep := modem.AddSms("10086", "keep")
+	drop := modem.AddSms("10086", "drop")
+
+	list, err := eng.ListSms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id string
+	for _, sms := range list.Sms {
+		if sms.Text == "drop" {
+			id = list.Messages[sms.ID]
+		}
+	}
+	if id != string(drop) {
+		t.Fatalf("D-Bus path of the message = %q, want %q", id, drop)
+	}
+	if err := eng.DeleteSms(id); err != nil {
+		t.Fatal(err)
+	}
+	if got := modem.Messages(); len(got) != 1 || got[0] != keep {
+		t.Errorf("messages after delete = %v, want [%s]", got, keep)
+	}
+	if err := eng.DeleteSms(id); err == nil {
+		t.Error("deleting a missing message succeeded")
+	}
+}
+
+func TestGetStatus(t *testing.T) {
+	_, modem, eng := newFakeEngine(t, fakemm.ModemConfig{
+		Model:           "FM350-GL",
+		SignalQuality:   75,
+		OperatorName:    "CHN-UNICOM",
+		SimOperatorName: "中国联通",
+	})
+	modem.SetSignal("Lte", map[string]float64{"rssi": -65, "rsrp": -95, "rsrq": -10, "snr": 12})
+
+	status, err := eng.GetStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Model != "FM350-GL" || status.State != "Registered" {
+		t.Errorf("model/state = %q/%q", status.Model, status.State)
+	}
+	if status.Operator != "CHN-UNICOM" || status.SimOperator != "中国联通" {
+		t.Errorf("operator = %q, SIM operator = %q", status.Operator, status.SimOperator)
+	}
+	if status.Registration != "Home" || status.AccessTech != "4G (LTE)" {
+		t.Errorf("registration = %q, access tech = %q", status.Registration, status.AccessTech)
+	}
+	if status.SignalQuality != 75 {
+		t.Errorf("signal quality = %d, want 75", status.SignalQuality)
+	}
+	if len(status.Signals) != 1 || status.Signals[0].RAT != "LTE" || status.Signals[0].RSRP == nil || *status.Signals[0].RSRP != -95 {
+		t.Errorf("signals = %+v", status.Signals)
+	}
+}
+
+func TestForModemSharesATPortWithOwnerOnly(t *testing.T) {
+	mm, owner, eng := newFakeEngine(t, fakemm.ModemConfig{
+		EquipmentIdentifier: "350000000000001",
+		Ports:               []fakemm.Port{{Name: "cdc-wdm0", Type: fakemm.PortTypeMbim}, {Name: "wwan0at0", Type: fakemm.PortTypeAt}},
+	})
+	other := mm.AddModem(fakemm.ModemConfig{
+		EquipmentIdentifier: "350000000000002",
+		Ports:               []fakemm.Port{{Name: "cdc-wdm1", Type: fakemm.PortTypeMbim}, {Name: "wwan1at0", Type: fakemm.PortTypeAt}},
+	})
+	handler := at.NewHandler("/dev/wwan0at0")
+	defer handler.Close()
+	eng.SetATHandler(handler)
+
+	scoped, err := eng.ForModem("350000000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := scoped.(*DBusMBIMEngine); got.GetModemPath() != owner.Path || got.atHandler != handler {
+		t.Errorf("engine for the port owner = %s with AT handler %p, want %s with %p", got.GetModemPath(), got.atHandler, owner.Path, handler)
+	}
+
+	scoped, err = eng.ForModem("350000000000002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := scoped.(*DBusMBIMEngine); got.GetModemPath() != other.Path || got.atHandler != nil {
+		t.Errorf("engine for the other modem = %s with AT handler %p, want %s without", got.GetModemPath(), got.atHandler, other.Path)
+	}
+	if _, err := scoped.(*DBusMBIMEngine).GetEsimEID(); err == nil {
+		t.Error("GetEsimEID on a modem without the AT port succeeded")
+	}
+}
+
+func TestForModemSingleModemWithoutPorts(t *testing.T) {
+	_, modem, eng := newFakeEngine(t, fakemm.ModemConfig{})
+	handler := at.NewHandler("/dev/wwan0at0")
+	defer handler.Close()
+	eng.SetATHandler(handler)
+
+	scoped, err := eng.ForModem("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := scoped.(*DBusMBIMEngine); got.GetModemPath() != modem.Path || got.atHandler != handler {
+		t.Errorf("engine = %s with AT handler %p, want %s with %p", got.GetModemPath(), got.atHandler, modem.Path, handler)
+	}
+}
@@ -1,8 +1,10 @@
 package dbus_mbim
 
 import (
+	"context"
 	"errors"
-	"strings"
+	"tg_modem/engine"
+	"tg_modem/engine/at"
 )
 
 // GetEsimICCID retrieves the eSIM ICCID via AT commands.
@@ -28,24 +30,7 @@ func (e *DBusMBIMEngine) GetEsimPower() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	powerList := strings.Split(power, ",")
-	var build strings.Builder
-	if powerList[0] == "0" {
-		build.WriteString("ESIM模块启用")
-	} else if powerList[0] == "1" {
-		build.WriteString("ESIM模块禁用")
-	}
-	if powerList[1] == "0" {
-		build.WriteString(",SKU_based 0")
-	} else if powerList[1] == "1" {
-		build.WriteString(",SKU_based 1")
-	}
-	if powerList[2] == "0" {
-		build.WriteString(",IMSI_based 0")
-	} else if powerList[2] == "1" {
-		build.WriteString(",IMSI_based 1")
-	}
-	return strings.TrimSpace(build.String()), nil
+	return at.DescribeEsimPower(power), nil
 }
 func (e *DBusMBIMEngine) SetEsimPower(power bool) (string, error) {
 	if e.atHandler == nil {
@@ -64,3 +49,73 @@ func (e *DBusMBIMEngine) GetEsimStatus() (string, error) {
 		return "ESIM未启用或未知", err
 	}
 }
+
+func (e *DBusMBIMEngine) GetEuiccInfo() (*engine.EuiccInfo, error) {
+	if e.atHandler == nil {
+		return nil, errors.New("AT command handler not configured for this engine")
+	}
+	return e.atHandler.GetEuiccInfo(context.Background())
+}
+
+func (e *DBusMBIMEngine) ListEsimProfiles() ([]engine.EsimProfile, error) {
+	if e.atHandler == nil {
+		return nil, errors.New("AT command handler not configured for this engine")
+	}
+	return e.atHandler.ListProfiles(context.Background())
+}
+
+func (e *DBusMBIMEngine) EnableEsimProfile(iccid string) error {
+	if e.atHandler == nil {
+		return errors.New("AT command handler not configured for this engine")
+	}
+	return e.atHandler.EnableProfile(context.Background(), iccid)
+}
+
+func (e *DBusMBIMEngine) DisableEsimProfile(iccid string) error {
+	if e.atHandler == nil {
+		return errors.New("AT command handler not configured for this engine")
+	}
+	return e.atHandler.DisableProfile(context.Background(), iccid)
+}
+
+func (e *DBusMBIMEngine) DeleteEsimProfile(iccid string) error {
+	if e.atHandler == nil {
+		return errors.New("AT command handler not configured for this engine")
+	}
+	return e.atHandler.DeleteProfile(context.Background(), iccid)
+}
+
+func (e *DBusMBIMEngine) SetEsimNickname(iccid, nickname string) error {
+	if e.atHandler == nil {
+		return errors.New("AT command handler not configured for this engine")
+	}
+	return e.atHandler.SetNickname(context.Background(), iccid, nickname)
+}
+
+func (e *DBusMBIMEngine) DownloadEsimProfile(ctx context.Context, activationCode, confirmationCode string, progress func(engine.DownloadProgress)) (*engine.EsimProfile, error) {
+	if e.atHandler == nil {
+		return nil, errors.New("AT command handler not configured for this engine")
+	}
+	return e.atHandler.DownloadProfile(ctx, activationCode, confirmationCode, progress)
+}
+
+func (e *DBusMBIMEngine) ListEsimNotifications() ([]engine.EsimNotification, error) {
+	if e.atHandler == nil {
+		return nil, errors.New("AT command handler not configured for this engine")
+	}
+	return e.atHandler.ListNotifications(context.Background())
+}
+
+func (e *DBusMBIMEngine) ProcessEsimNotification(ctx context.Context, seq int) error {
+	if e.atHandler == nil {
+		return errors.New("AT command handler not configured for this engine")
+	}
+	return e.atHandler.ProcessNotification(ctx, seq)
+}
+
+func (e *DBusMBIMEngine) RemoveEsimNotification(seq int) error {
+	if e.atHandler == nil {
+		return errors.New("AT command handler not configured for this engine")
+	}
+	return e.atHandler.RemoveNotification(context.Background(), seq)
+}
@@ -0,0 +1,535 @@
+package fakemm
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Values of the ModemManager enums the fake uses.
+const (
+	ModemStateRegistered = 8
+	ModemStateConnected  = 11
+
+	RegistrationHome = 1
+
+	AccessTechLTE = 1 << 14
+
+	SmsStateStored   = 1
+	SmsStateReceived = 3
+	SmsStateSent     = 5
+
+	PortTypeNet  = 2
+	PortTypeAt   = 3
+	PortTypeMbim = 7
+
+	SmsPduTypeDeliver = 1
+	SmsPduTypeSubmit  = 2
+
+	// SmsDeliveryStateUnknown means no status report has arrived yet; other
+	// delivery states are TP-Status values.
+	SmsDeliveryStateUnknown = 0x100
+
+	// MMSmsStorage values
+	SmsStorageSM = 1
+	SmsStorageME = 2
+	SmsStorageMT = 3
+)
+
+// ModemConfig sets the initial properties of a modem. Zero State,
+// RegistrationState and AccessTechnologies default to a registered LTE modem.
+type ModemConfig struct {
+	Model               string
+	Manufacturer        string
+	EquipmentIdentifier string
+	State               int32
+	RegistrationState   uint32
+	AccessTechnologies  uint32
+	SignalQuality       uint32
+	OperatorName        string // network, Modem3gpp.OperatorName
+	SimOperatorName     string // Sim.OperatorName
+	SimIdentifier       string // Sim.SimIdentifier, the ICCID
+	Ports               []Port // Modem.Ports
+}
+
+// Port is an entry of Modem.Ports, e.g. {Name: "wwan0at0", Type: PortTypeAt}.
+type Port struct {
+	Name string
+	Type uint32
+}
+
+// SentSms is a message sent through Messaging.Create and Sms.Send.
+type SentSms struct {
+	Path   dbus.ObjectPath
+	Number string
+	Text   string
+}
+
+// Modem is a fake modem object with its SIM, messages, calls and bearers.
+type Modem struct {
+	Path    dbus.ObjectPath
+	SimPath dbus.ObjectPath
+
+	mm *ModemManager
+
+	mu         sync.Mutex
+	messages   []dbus.ObjectPath
+	calls      []dbus.ObjectPath
+	bearers    []dbus.ObjectPath
+	sent       []SentSms
+	slots      []uint32
+	signalRate uint32
+	reference  uint32
+}
+
+// signalQuality is the (ub) tuple of Modem.SignalQuality.
+type signalQuality struct {
+	Quality uint32
+	Recent  bool
+}
+
+// AddModem exports a new modem and announces it with InterfacesAdded.
+func (mm *ModemManager) AddModem(cfg ModemConfig) *Modem {
+	if cfg.State == 0 {
+		cfg.State = ModemStateRegistered
+	}
+	if cfg.RegistrationState == 0 {
+		cfg.RegistrationState = RegistrationHome
+	}
+	if cfg.AccessTechnologies == 0 {
+		cfg.AccessTechnologies = AccessTechLTE
+	}
+
+	mm.mu.Lock()
+	m := &Modem{mm: mm, Path: mm.nextPath("Modem"), SimPath: mm.nextPath("SIM")}
+	if cfg.EquipmentIdentifier == "" {
+		cfg.EquipmentIdentifier = string(m.Path)
+	}
+
+	mm.addObject(m.SimPath, map[string]map[string]dbus.Variant{
+		SimIface: {
+			"OperatorName":  dbus.MakeVariant(cfg.SimOperatorName),
+			"SimIdentifier": dbus.MakeVariant(cfg.SimIdentifier),
+			"Imsi":          dbus.MakeVariant(""),
+		},
+	})
+	mm.addObject(m.Path, map[string]map[string]dbus.Variant{
+		ModemIface: {
+			"Model":               dbus.MakeVariant(cfg.Model),
+			"Manufacturer":        dbus.MakeVariant(cfg.Manufacturer),
+			"EquipmentIdentifier": dbus.MakeVariant(cfg.EquipmentIdentifier),
+			"State":               dbus.MakeVariant(cfg.State),
+			"AccessTechnologies":  dbus.MakeVariant(cfg.AccessTechnologies),
+			"SignalQuality":       dbus.MakeVariant(signalQuality{cfg.SignalQuality, true}),
+			"Sim":                 dbus.MakeVariant(m.SimPath),
+			"Bearers":             dbus.MakeVariant([]dbus.ObjectPath{}),
+			"Ports":               dbus.MakeVariant(append([]Port{}, cfg.Ports...)),
+		},
+		Modem3gppIface: {
+			"Imei":              dbus.MakeVariant(cfg.EquipmentIdentifier),
+			"OperatorName":      dbus.MakeVariant(cfg.OperatorName),
+			"RegistrationState": dbus.MakeVariant(cfg.RegistrationState),
+		},
+		SimpleIface: {},
+		MessagingIface: {
+			"Messages":          dbus.MakeVariant([]dbus.ObjectPath{}),
+			"SupportedStorages": dbus.MakeVariant([]uint32{SmsStorageSM, SmsStorageME}),
+			"DefaultStorage":    dbus.MakeVariant(uint32(SmsStorageME)),
+		},
+		VoiceIface: {
+			"Calls": dbus.MakeVariant([]dbus.ObjectPath{}),
+		},
+		SignalIface: {
+			"Rate": dbus.MakeVariant(uint32(0)),
+			"Lte":  dbus.MakeVariant(map[string]dbus.Variant{}),
+		},
+	})
+	mm.conn.Export(modemMethods{m}, m.Path, ModemIface)
+	mm.conn.Export(simpleMethods{m}, m.Path, SimpleIface)
+	mm.conn.Export(messagingMethods{m}, m.Path, MessagingIface)
+	mm.conn.Export(voiceMethods{m}, m.Path, VoiceIface)
+	mm.conn.Export(signalMethods{m}, m.Path, SignalIface)
+	mm.modems[m.Path] = m
+	added := mm.snapshot(m.Path)
+	mm.mu.Unlock()
+
+	mm.emit(Path, ObjectManagerIface+".InterfacesAdded", m.Path, added)
+	return m
+}
+
+// RemoveModem unexports a modem and its objects and announces it with
+// InterfacesRemoved, as when the device is unplugged.
+func (mm *ModemManager) RemoveModem(m *Modem) {
+	mm.mu.Lock()
+	var ifaces []string
+	for iface := range mm.objects[m.Path] {
+		ifaces = append(ifaces, iface)
+	}
+	m.mu.Lock()
+	for _, p := range append(append(append([]dbus.ObjectPath{}, m.messages...), m.calls...), m.bearers...) {
+		mm.removeObject(p)
+	}
+	m.mu.Unlock()
+	mm.removeObject(m.SimPath)
+	mm.removeObject(m.Path)
+	delete(mm.modems, m.Path)
+	mm.mu.Unlock()
+
+	mm.emit(Path, ObjectManagerIface+".InterfacesRemoved", m.Path, ifaces)
+}
+
+// Set changes a property of the modem and emits PropertiesChanged.
+func (m *Modem) Set(iface, name string, value interface{}) {
+	m.mm.set(m.Path, iface, name, value)
+}
+
+// Get returns a property of the modem, or nil if it does not exist.
+func (m *Modem) Get(iface, name string) interface{} {
+	return m.mm.get(m.Path, iface, name)
+}
+
+// SetSignal sets the detailed metrics of one technology on the Signal
+// interface, e.g. SetSignal("Lte", map[string]float64{"rsrp": -95}).
+func (m *Modem) SetSignal(rat string, values map[string]float64) {
+	metrics := make(map[string]dbus.Variant, len(values))
+	for k, v := range values {
+		metrics[k] = dbus.MakeVariant(v)
+	}
+	m.Set(SignalIface, rat, metrics)
+}
+
+// AddSms stores a received message and emits Messaging.Added.
+func (m *Modem) AddSms(number, text string) dbus.ObjectPath {
+	return m.addSms(number, text, time.Now(), SmsStateReceived, SmsPduTypeDeliver, true)
+}
+
+// AddSmsAt is like AddSms with an explicit timestamp.
+func (m *Modem) AddSmsAt(number, text string, ts time.Time) dbus.ObjectPath {
+	return m.addSms(number, text, ts, SmsStateReceived, SmsPduTypeDeliver, true)
+}
+
+func (m *Modem) addSms(number, text string, ts time.Time, state, pduType uint32, received bool) dbus.ObjectPath {
+	mm := m.mm
+	storage, _ := m.Get(MessagingIface, "DefaultStorage").(uint32)
+	mm.mu.Lock()
+	path := mm.nextPath("SMS")
+	mm.addObject(path, map[string]map[string]dbus.Variant{
+		SmsIface: {
+			"Number":                dbus.MakeVariant(number),
+			"Text":                  dbus.MakeVariant(text),
+			"Timestamp":             dbus.MakeVariant(ts.Format(time.RFC3339)),
+			"State":                 dbus.MakeVariant(state),
+			"PduType":               dbus.MakeVariant(pduType),
+			"Storage":               dbus.MakeVariant(storage),
+			"SMSC":                  dbus.MakeVariant(""),
+			"MessageReference":      dbus.MakeVariant(uint32(0)),
+			"DeliveryReportRequest": dbus.MakeVariant(false),
+			"DeliveryState":         dbus.MakeVariant(uint32(SmsDeliveryStateUnknown)),
+		},
+	})
+	mm.conn.Export(smsMethods{m, path}, path, SmsIface)
+	mm.mu.Unlock()
+
+	m.mu.Lock()
+	m.messages = append(m.messages, path)
+	messages := append([]dbus.ObjectPath{}, m.messages...)
+	m.mu.Unlock()
+
+	m.Set(MessagingIface, "Messages", messages)
+	mm.emit(m.Path, MessagingIface+".Added", path, received)
+	return path
+}
+
+// SetDeliveryState sets the DeliveryState of a sent message, as when a
+// status report arrives.
+func (m *Modem) SetDeliveryState(sms dbus.ObjectPath, state uint32) {
+	m.mm.set(sms, SmsIface, "DeliveryState", state)
+}
+
+// AddCall creates an incoming call and emits Voice.CallAdded.
+func (m *Modem) AddCall(number string) dbus.ObjectPath {
+	mm := m.mm
+	mm.mu.Lock()
+	path := mm.nextPath("Call")
+	mm.addObject(path, map[string]map[string]dbus.Variant{
+		CallIface: {
+			"Number":    dbus.MakeVariant(number),
+			"State":     dbus.MakeVariant(int32(3)), // ringing in
+			"Direction": dbus.MakeVariant(int32(1)), // incoming
+		},
+	})
+	mm.mu.Unlock()
+
+	m.mu.Lock()
+	m.calls = append(m.calls, path)
+	calls := append([]dbus.ObjectPath{}, m.calls...)
+	m.mu.Unlock()
+
+	m.Set(VoiceIface, "Calls", calls)
+	mm.emit(m.Path, VoiceIface+".CallAdded", path)
+	return path
+}
+
+// BearerConfig describes a data bearer added with AddBearer.
+type BearerConfig struct {
+	Interface string
+	Connected bool
+	IPv4      string
+	IPv4Gw    string
+	IPv6      string
+	Duration  time.Duration
+	RxBytes   uint64
+	TxBytes   uint64
+}
+
+// AddBearer creates a bearer and lists it in Modem.Bearers.
+func (m *Modem) AddBearer(cfg BearerConfig) dbus.ObjectPath {
+	mm := m.mm
+	mm.mu.Lock()
+	path := mm.nextPath("Bearer")
+	ip4 := map[string]dbus.Variant{}
+	if cfg.IPv4 != "" {
+		ip4["address"] = dbus.MakeVariant(cfg.IPv4)
+		ip4["gateway"] = dbus.MakeVariant(cfg.IPv4Gw)
+	}
+	ip6 := map[string]dbus.Variant{}
+	if cfg.IPv6 != "" {
+		ip6["address"] = dbus.MakeVariant(cfg.IPv6)
+	}
+	mm.addObject(path, map[string]map[string]dbus.Variant{
+		BearerIface: {
+			"Interface": dbus.MakeVariant(cfg.Interface),
+			"Connected": dbus.MakeVariant(cfg.Connected),
+			"Ip4Config": dbus.MakeVariant(ip4),
+			"Ip6Config": dbus.MakeVariant(ip6),
+			"Stats": dbus.MakeVariant(map[string]dbus.Variant{
+				"duration": dbus.MakeVariant(uint32(cfg.Duration / time.Second)),
+				"rx-bytes": dbus.MakeVariant(cfg.RxBytes),
+				"tx-bytes": dbus.MakeVariant(cfg.TxBytes),
+			}),
+		},
+	})
+	mm.mu.Unlock()
+
+	m.mu.Lock()
+	m.bearers = append(m.bearers, path)
+	bearers := append([]dbus.ObjectPath{}, m.bearers...)
+	m.mu.Unlock()
+
+	m.Set(ModemIface, "Bearers", bearers)
+	return path
+}
+
+// Messages returns the paths listed by Messaging.List.
+func (m *Modem) Messages() []dbus.ObjectPath {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]dbus.ObjectPath{}, m.messages...)
+}
+
+// Sent returns the messages sent so far.
+func (m *Modem) Sent() []SentSms {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]SentSms{}, m.sent...)
+}
+
+// Slots returns the argument of the last SetCurrentSlots call.
+func (m *Modem) Slots() []uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.slots
+}
+
+// SignalRate returns the rate passed to Signal.Setup, 0 if never called.
+func (m *Modem) SignalRate() uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.signalRate
+}
+
+func (m *Modem) state() int32 {
+	s, _ := m.Get(ModemIface, "State").(int32)
+	return s
+}
+
+// modemMethods implements org.freedesktop.ModemManager1.Modem.
+type modemMethods struct{ m *Modem }
+
+func (x modemMethods) SetCurrentSlots(slots []uint32) *dbus.Error {
+	if err := x.m.mm.failure(ModemIface + ".SetCurrentSlots"); err != nil {
+		return err
+	}
+	x.m.mu.Lock()
+	x.m.slots = slots
+	x.m.mu.Unlock()
+	return nil
+}
+
+func (x modemMethods) Enable(enable bool) *dbus.Error {
+	if err := x.m.mm.failure(ModemIface + ".Enable"); err != nil {
+		return err
+	}
+	state := int32(3) // disabled
+	if enable {
+		state = ModemStateRegistered
+	}
+	x.m.Set(ModemIface, "State", state)
+	return nil
+}
+
+// simpleMethods implements org.freedesktop.ModemManager1.Modem.Simple.
+type simpleMethods struct{ m *Modem }
+
+func (x simpleMethods) Connect(props map[string]dbus.Variant) (dbus.ObjectPath, *dbus.Error) {
+	if err := x.m.mm.failure(SimpleIface + ".Connect"); err != nil {
+		return "", err
+	}
+	path := x.m.AddBearer(BearerConfig{Interface: "wwan0", Connected: true, IPv4: "10.0.0.2", IPv4Gw: "10.0.0.1"})
+	x.m.Set(ModemIface, "State", int32(ModemStateConnected))
+	return path, nil
+}
+
+func (x simpleMethods) Disconnect(bearer dbus.ObjectPath) *dbus.Error {
+	if err := x.m.mm.failure(SimpleIface + ".Disconnect"); err != nil {
+		return err
+	}
+	x.m.mu.Lock()
+	bearers := append([]dbus.ObjectPath{}, x.m.bearers...)
+	x.m.mu.Unlock()
+	for _, p := range bearers {
+		if bearer == "/" || bearer == p {
+			x.m.mm.set(p, BearerIface, "Connected", false)
+		}
+	}
+	x.m.Set(ModemIface, "State", int32(ModemStateRegistered))
+	return nil
+}
+
+func (x simpleMethods) GetStatus() (map[string]dbus.Variant, *dbus.Error) {
+	if err := x.m.mm.failure(SimpleIface + ".GetStatus"); err != nil {
+		return nil, err
+	}
+	quality, _ := x.m.Get(ModemIface, "SignalQuality").(signalQuality)
+	return map[string]dbus.Variant{
+		"state":                    dbus.MakeVariant(uint32(x.m.state())),
+		"signal-quality":           dbus.MakeVariant(quality),
+		"access-technologies":      dbus.MakeVariant(x.m.Get(ModemIface, "AccessTechnologies")),
+		"m3gpp-registration-state": dbus.MakeVariant(x.m.Get(Modem3gppIface, "RegistrationState")),
+		"m3gpp-operator-name":      dbus.MakeVariant(x.m.Get(Modem3gppIface, "OperatorName")),
+	}, nil
+}
+
+// messagingMethods implements org.freedesktop.ModemManager1.Modem.Messaging.
+type messagingMethods struct{ m *Modem }
+
+func (x messagingMethods) List() ([]dbus.ObjectPath, *dbus.Error) {
+	if err := x.m.mm.failure(MessagingIface + ".List"); err != nil {
+		return nil, err
+	}
+	return x.m.Messages(), nil
+}
+
+func (x messagingMethods) Create(props map[string]dbus.Variant) (dbus.ObjectPath, *dbus.Error) {
+	if err := x.m.mm.failure(MessagingIface + ".Create"); err != nil {
+		return "", err
+	}
+	number, _ := props["Number"].Value().(string)
+	text, _ := props["Text"].Value().(string)
+	if number == "" {
+		return "", dbus.NewError("org.freedesktop.ModemManager1.Error.Core.InvalidArgs", []interface{}{"missing Number"})
+	}
+	path := x.m.addSms(number, text, time.Now(), SmsStateStored, SmsPduTypeSubmit, false)
+	if v, ok := props["DeliveryReportRequest"]; ok {
+		x.m.mm.set(path, SmsIface, "DeliveryReportRequest", v.Value())
+	}
+	return path, nil
+}
+
+func (x messagingMethods) SetDefaultStorage(storage uint32) *dbus.Error {
+	if err := x.m.mm.failure(MessagingIface + ".SetDefaultStorage"); err != nil {
+		return err
+	}
+	supported, _ := x.m.Get(MessagingIface, "SupportedStorages").([]uint32)
+	if !slices.Contains(supported, storage) {
+		return dbus.NewError("org.freedesktop.ModemManager1.Error.Core.Unsupported", []interface{}{"storage not supported"})
+	}
+	x.m.Set(MessagingIface, "DefaultStorage", storage)
+	return nil
+}
+
+func (x messagingMethods) Delete(path dbus.ObjectPath) *dbus.Error {
+	if err := x.m.mm.failure(MessagingIface + ".Delete"); err != nil {
+		return err
+	}
+	x.m.mu.Lock()
+	found := false
+	for i, p := range x.m.messages {
+		if p == path {
+			x.m.messages = append(x.m.messages[:i], x.m.messages[i+1:]...)
+			found = true
+			break
+		}
+	}
+	messages := append([]dbus.ObjectPath{}, x.m.messages...)
+	x.m.mu.Unlock()
+	if !found {
+		return dbus.NewError("org.freedesktop.ModemManager1.Error.Core.NotFound", []interface{}{"no such SMS"})
+	}
+
+	x.m.mm.mu.Lock()
+	x.m.mm.removeObject(path)
+	x.m.mm.mu.Unlock()
+	x.m.Set(MessagingIface, "Messages", messages)
+	x.m.mm.emit(x.m.Path, MessagingIface+".Deleted", path)
+	return nil
+}
+
+// voiceMethods implements org.freedesktop.ModemManager1.Modem.Voice.
+type voiceMethods struct{ m *Modem }
+
+func (x voiceMethods) ListCalls() ([]dbus.ObjectPath, *dbus.Error) {
+	x.m.mu.Lock()
+	defer x.m.mu.Unlock()
+	return append([]dbus.ObjectPath{}, x.m.calls...), nil
+}
+
+// signalMethods implements org.freedesktop.ModemManager1.Modem.Signal.
+type signalMethods struct{ m *Modem }
+
+func (x signalMethods) Setup(rate uint32) *dbus.Error {
+	if err := x.m.mm.failure(SignalIface + ".Setup"); err != nil {
+		return err
+	}
+	x.m.mu.Lock()
+	x.m.signalRate = rate
+	x.m.mu.Unlock()
+	x.m.Set(SignalIface, "Rate", rate)
+	return nil
+}
+
+// smsMethods implements org.freedesktop.ModemManager1.Sms.
+type smsMethods struct {
+	m    *Modem
+	path dbus.ObjectPath
+}
+
+func (x smsMethods) Send() *dbus.Error {
+	if err := x.m.mm.failure(SmsIface + ".Send"); err != nil {
+		return err
+	}
+	number, _ := x.m.mm.get(x.path, SmsIface, "Number").(string)
+	text, _ := x.m.mm.get(x.path, SmsIface, "Text").(string)
+
+	x.m.mu.Lock()
+	x.m.reference++
+	ref := x.m.reference
+	x.m.sent = append(x.m.sent, SentSms{Path: x.path, Number: number, Text: text})
+	x.m.mu.Unlock()
+
+	x.m.mm.set(x.path, SmsIface, "MessageReference", ref)
+	x.m.mm.set(x.path, SmsIface, "State", uint32(SmsStateSent))
+	return nil
+}
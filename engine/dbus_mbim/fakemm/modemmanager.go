@@ -0,0 +1,235 @@
+package fakemm
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// D-Bus names of the ModemManager API.
+const (
+	Service            = "org.freedesktop.ModemManager1"
+	Path               = "/org/freedesktop/ModemManager1"
+	ObjectManagerIface = "org.freedesktop.DBus.ObjectManager"
+	PropertiesIface    = "org.freedesktop.DBus.Properties"
+
+	ModemIface     = Service + ".Modem"
+	Modem3gppIface = ModemIface + ".Modem3gpp"
+	SimpleIface    = ModemIface + ".Simple"
+	MessagingIface = ModemIface + ".Messaging"
+	VoiceIface     = ModemIface + ".Voice"
+	SignalIface    = ModemIface + ".Signal"
+	SmsIface       = Service + ".Sms"
+	CallIface      = Service + ".Call"
+	BearerIface    = Service + ".Bearer"
+	SimIface       = Service + ".Sim"
+)
+
+// ModemManager owns the org.freedesktop.ModemManager1 name on a bus and
+// serves the objects of every modem added to it.
+type ModemManager struct {
+	conn *dbus.Conn
+
+	mu       sync.Mutex
+	objects  map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+	modems   map[dbus.ObjectPath]*Modem
+	failures map[string]*dbus.Error
+	counters map[string]int
+}
+
+// Start connects to bus, claims the ModemManager name and exports the
+// object manager. The fake has no modems until AddModem is called.
+func Start(bus *Bus) (*ModemManager, error) {
+	conn, err := bus.Connect()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.RequestName(Service, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		conn.Close()
+		return nil, fmt.Errorf("%s is already owned", Service)
+	}
+
+	mm := &ModemManager{
+		conn:     conn,
+		objects:  make(map[dbus.ObjectPath]map[string]map[string]dbus.Variant),
+		modems:   make(map[dbus.ObjectPath]*Modem),
+		failures: make(map[string]*dbus.Error),
+		counters: make(map[string]int),
+	}
+	if err := conn.Export(objectManager{mm}, Path, ObjectManagerIface); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return mm, nil
+}
+
+// Close releases the service name, as if ModemManager had exited.
+func (mm *ModemManager) Close() error {
+	return mm.conn.Close()
+}
+
+// Fail makes the next call of method (e.g. "org.freedesktop.ModemManager1.Sms.Send")
+// return err instead of running.
+func (mm *ModemManager) Fail(method string, err *dbus.Error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.failures[method] = err
+}
+
+// failure consumes the error scheduled by Fail for method.
+func (mm *ModemManager) failure(method string) *dbus.Error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	err, ok := mm.failures[method]
+	if ok {
+		delete(mm.failures, method)
+	}
+	return err
+}
+
+// Modems returns the modems currently exported, ordered by path.
+func (mm *ModemManager) Modems() []*Modem {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	var out []*Modem
+	for _, m := range mm.modems {
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+// nextPath allocates the next object path of a kind, e.g. "Modem" or "SMS".
+// mm.mu must be held.
+func (mm *ModemManager) nextPath(kind string) dbus.ObjectPath {
+	n := mm.counters[kind]
+	mm.counters[kind] = n + 1
+	return dbus.ObjectPath(fmt.Sprintf("%s/%s/%d", Path, kind, n))
+}
+
+// addObject registers an object's properties and exports the Properties
+// interface on it. mm.mu must be held.
+func (mm *ModemManager) addObject(path dbus.ObjectPath, props map[string]map[string]dbus.Variant) {
+	mm.objects[path] = props
+	mm.conn.Export(properties{mm, path}, path, PropertiesIface)
+}
+
+// removeObject unexports every interface of an object. mm.mu must be held.
+func (mm *ModemManager) removeObject(path dbus.ObjectPath) {
+	for iface := range mm.objects[path] {
+		mm.conn.Export(nil, path, iface)
+	}
+	mm.conn.Export(nil, path, PropertiesIface)
+	delete(mm.objects, path)
+}
+
+// get returns a property value, or nil if it does not exist.
+func (mm *ModemManager) get(path dbus.ObjectPath, iface, name string) interface{} {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	v, ok := mm.objects[path][iface][name]
+	if !ok {
+		return nil
+	}
+	return v.Value()
+}
+
+// set changes a property and emits PropertiesChanged.
+func (mm *ModemManager) set(path dbus.ObjectPath, iface, name string, value interface{}) {
+	v := dbus.MakeVariant(value)
+	mm.mu.Lock()
+	props, ok := mm.objects[path]
+	if ok {
+		if props[iface] == nil {
+			props[iface] = make(map[string]dbus.Variant)
+		}
+		props[iface][name] = v
+	}
+	mm.mu.Unlock()
+	if ok {
+		mm.conn.Emit(path, PropertiesIface+".PropertiesChanged", iface, map[string]dbus.Variant{name: v}, []string{})
+	}
+}
+
+func (mm *ModemManager) emit(path dbus.ObjectPath, signal string, args ...interface{}) {
+	mm.conn.Emit(path, signal, args...)
+}
+
+// snapshot copies the interfaces of an object for GetManagedObjects and
+// InterfacesAdded. mm.mu must be held.
+func (mm *ModemManager) snapshot(path dbus.ObjectPath) map[string]map[string]dbus.Variant {
+	out := make(map[string]map[string]dbus.Variant)
+	for iface, props := range mm.objects[path] {
+		out[iface] = make(map[string]dbus.Variant, len(props))
+		for k, v := range props {
+			out[iface][k] = v
+		}
+	}
+	return out
+}
+
+// objectManager implements org.freedesktop.DBus.ObjectManager. Like the real
+// daemon it only reports modem objects.
+type objectManager struct{ mm *ModemManager }
+
+func (o objectManager) GetManagedObjects() (map[dbus.ObjectPath]map[string]map[string]dbus.Variant, *dbus.Error) {
+	if err := o.mm.failure(ObjectManagerIface + ".GetManagedObjects"); err != nil {
+		return nil, err
+	}
+	o.mm.mu.Lock()
+	defer o.mm.mu.Unlock()
+	out := make(map[dbus.ObjectPath]map[string]map[string]dbus.Variant)
+	for path := range o.mm.modems {
+		out[path] = o.mm.snapshot(path)
+	}
+	return out, nil
+}
+
+// properties implements org.freedesktop.DBus.Properties for one object.
+type properties struct {
+	mm   *ModemManager
+	path dbus.ObjectPath
+}
+
+func (p properties) Get(iface, name string) (dbus.Variant, *dbus.Error) {
+	p.mm.mu.Lock()
+	defer p.mm.mu.Unlock()
+	props, ok := p.mm.objects[p.path][iface]
+	if !ok {
+		return dbus.Variant{}, dbus.NewError("org.freedesktop.DBus.Error.UnknownInterface", []interface{}{iface})
+	}
+	v, ok := props[name]
+	if !ok {
+		return dbus.Variant{}, dbus.NewError("org.freedesktop.DBus.Error.UnknownProperty", []interface{}{name})
+	}
+	return v, nil
+}
+
+func (p properties) GetAll(iface string) (map[string]dbus.Variant, *dbus.Error) {
+	p.mm.mu.Lock()
+	defer p.mm.mu.Unlock()
+	props, ok := p.mm.objects[p.path][iface]
+	if !ok {
+		return nil, dbus.NewError("org.freedesktop.DBus.Error.UnknownInterface", []interface{}{iface})
+	}
+	out := make(map[string]dbus.Variant, len(props))
+	for k, v := range props {
+		out[k] = v
+	}
+	return out, nil
+}
+
+func (p properties) Set(iface, name string, value dbus.Variant) *dbus.Error {
+	if _, err := p.Get(iface, name); err != nil {
+		return err
+	}
+	p.mm.set(p.path, iface, name, value.Value())
+	return nil
+}
@@ -2,12 +2,30 @@ package dbus_mbim
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/godbus/dbus/v5"
 )
 
+// timestampLayouts 为 ModemManager 短信时间戳可能的格式, 依版本不同时区为 +08:00、+08 或 +0800
+var timestampLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05-07",
+	"2006-01-02T15:04:05-0700",
+}
+
+// ParseTimestamp 解析 ModemManager 的 ISO 8601 短信时间戳 (Sms.Timestamp)
+func ParseTimestamp(s string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("无法解析短信时间戳 %q", s)
+}
+
 // getModemProperty 获取 modem 的一个属性
 func (e *DBusMBIMEngine) getModemProperty(iface, propName string) (dbus.Variant, error) {
-	modemObj := e.Conn.Object(mmService, e.modemPath)
+	modemObj := e.Conn.Object(mmService, e.currentModem())
 	return modemObj.GetProperty(fmt.Sprintf("%s.%s", iface, propName))
 }
@@ -0,0 +1,36 @@
+package dbus_mbim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	want := time.Date(2024, 5, 1, 12, 30, 15, 0, time.FixedZone("", 8*3600))
+	for _, ts := range []string{
+		"2024-05-01T12:30:15+08:00",
+		"2024-05-01T12:30:15+08",
+		"2024-05-01T12:30:15+0800",
+		"2024-05-01T04:30:15Z",
+	} {
+		got, err := ParseTimestamp(ts)
+		if err != nil {
+			t.Errorf("ParseTimestamp(%q): %v", ts, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("ParseTimestamp(%q) = %v, want %v", ts, got, want)
+		}
+	}
+
+	india, err := ParseTimestamp("2024-05-01T10:00:15+05:30")
+	if err != nil || !india.Equal(want) {
+		t.Errorf("ParseTimestamp with +05:30 = %v, %v, want %v", india, err, want)
+	}
+
+	for _, ts := range []string{"", "yesterday", "2024-05-01 12:30"} {
+		if got, err := ParseTimestamp(ts); err == nil {
+			t.Errorf("ParseTimestamp(%q) = %v, want an error", ts, got)
+		}
+	}
+}
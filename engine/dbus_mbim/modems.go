@@ -0,0 +1,158 @@
+package dbus_mbim
+
+import (
+	"errors"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"tg_modem/engine"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	modem3gppIface = modemIface + ".Modem3gpp"
+	simIface       = "org.freedesktop.ModemManager1.Sim"
+)
+
+// ListModems 列出 ModemManager 管理的所有调制解调器, 按对象路径排序
+func (e *DBusMBIMEngine) ListModems() ([]engine.ModemInfo, error) {
+	managedObjects, err := e.managedObjects()
+	if err != nil {
+		return nil, err
+	}
+	return e.modemList(managedObjects), nil
+}
+
+func (e *DBusMBIMEngine) modemList(managedObjects map[dbus.ObjectPath]map[string]map[string]dbus.Variant) []engine.ModemInfo {
+	current := e.currentModem()
+	var modems []engine.ModemInfo
+	for _, p := range sortedModemPaths(managedObjects) {
+		info := modemInfo(p, managedObjects[p])
+		info.Current = p == current
+		modems = append(modems, info)
+	}
+	return modems
+}
+
+// modemInfo 从对象的接口属性中提取调制解调器信息
+func modemInfo(p dbus.ObjectPath, ifaces map[string]map[string]dbus.Variant) engine.ModemInfo {
+	modemData := ifaces[modemIface]
+	info := engine.ModemInfo{
+		ID:           modemID(p, ifaces),
+		Path:         string(p),
+		Model:        variantString(modemData["Model"]),
+		Manufacturer: variantString(modemData["Manufacturer"]),
+		Operator:     variantString(ifaces[modem3gppIface]["OperatorName"]),
+	}
+	if state, ok := modemData["State"].Value().(int32); ok {
+		info.State = modemStateNames[state]
+	}
+	return info
+}
+
+// ForModem 返回只操作指定调制解调器的引擎, 与原引擎共享 D-Bus 连接。
+// AT 端口只交给其 Ports 中有该端口的调制解调器, 其他调制解调器的引擎没有 AT 端口。
+func (e *DBusMBIMEngine) ForModem(key string) (engine.Engine, error) {
+	managedObjects, err := e.managedObjects()
+	if err != nil {
+		return nil, err
+	}
+	modems := e.modemList(managedObjects)
+	m, err := engine.FindModem(modems, key)
+	if err != nil {
+		return nil, err
+	}
+
+	scoped := &DBusMBIMEngine{
+		Conn:      e.Conn,
+		modemPath: dbus.ObjectPath(m.Path),
+	}
+	if e.atHandler != nil {
+		ports := portNames(managedObjects[dbus.ObjectPath(m.Path)])
+		// 未上报 Ports 时 (如旧版 ModemManager) 只有一个调制解调器才能确定端口的归属
+		if slices.Contains(ports, path.Base(e.atHandler.PortName())) || (len(ports) == 0 && len(modems) == 1) {
+			scoped.atHandler = e.atHandler
+		}
+	}
+	return scoped, nil
+}
+
+// portNames 返回调制解调器 Ports 属性中的端口名称, 如 wwan0at0、cdc-wdm0
+func portNames(ifaces map[string]map[string]dbus.Variant) []string {
+	ports, _ := ifaces[modemIface]["Ports"].Value().([][]interface{})
+	var names []string
+	for _, p := range ports {
+		if len(p) > 0 {
+			if name, ok := p[0].(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// modemID 返回调制解调器的标识: EquipmentIdentifier (通常为 IMEI),
+// 其次为 Modem3gpp.Imei, 都没有时使用对象路径的最后一段
+func modemID(p dbus.ObjectPath, ifaces map[string]map[string]dbus.Variant) string {
+	if id := variantString(ifaces[modemIface]["EquipmentIdentifier"]); id != "" {
+		return id
+	}
+	if imei := variantString(ifaces[modem3gppIface]["Imei"]); imei != "" {
+		return imei
+	}
+	return path.Base(string(p))
+}
+
+// sortedModemPaths 返回实现了 Modem 接口的对象路径, 按路径末尾的序号排序
+func sortedModemPaths(managedObjects map[dbus.ObjectPath]map[string]map[string]dbus.Variant) []dbus.ObjectPath {
+	var paths []dbus.ObjectPath
+	for p, ifaces := range managedObjects {
+		if _, ok := ifaces[modemIface]; ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		a, errA := strconv.Atoi(path.Base(string(paths[i])))
+		b, errB := strconv.Atoi(path.Base(string(paths[j])))
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return paths[i] < paths[j]
+	})
+	return paths
+}
+
+func variantString(v dbus.Variant) string {
+	s, _ := v.Value().(string)
+	return s
+}
+
+// Identity 返回当前调制解调器的 ID 和其 SIM 卡的 ICCID
+func (e *DBusMBIMEngine) Identity() (string, string, error) {
+	modemPath := e.currentModem()
+	if !modemPath.IsValid() {
+		return "", "", errors.New("引擎未初始化或 modem path is invalid")
+	}
+	modemObj := e.Conn.Object(mmService, modemPath)
+
+	modemID := path.Base(string(modemPath))
+	if v, err := modemObj.GetProperty(modemIface + ".EquipmentIdentifier"); err == nil && variantString(v) != "" {
+		modemID = variantString(v)
+	}
+
+	simPathVar, err := modemObj.GetProperty(modemIface + ".Sim")
+	if err != nil {
+		return modemID, "", nil
+	}
+	simPath, _ := simPathVar.Value().(dbus.ObjectPath)
+	if !simPath.IsValid() || simPath == "/" {
+		return modemID, "", nil
+	}
+	simID, err := e.Conn.Object(mmService, simPath).GetProperty(simIface + ".SimIdentifier")
+	if err != nil {
+		return modemID, "", nil
+	}
+	return modemID, variantString(simID), nil
+}
@@ -2,10 +2,9 @@ package dbus_mbim
 
 import (
 	"fmt"
+	"log"
 	"path"
-	"strings"
 	"tg_modem/engine"
-	"time"
 
 	"github.com/godbus/dbus/v5"
 )
@@ -13,9 +12,12 @@ import (
 const messagingIface = "org.freedesktop.ModemManager1.Modem.Messaging"
 const smsIface = "org.freedesktop.ModemManager1.Sms"
 
+// mmSmsPduTypeSubmit 为 MMSmsPduType 中发出的短信
+const mmSmsPduTypeSubmit = 2
+
 // ListSms 读取所有短信
 func (e *DBusMBIMEngine) ListSms() (*engine.SmsListResult, error) {
-	modemObj := e.Conn.Object(mmService, e.modemPath)
+	modemObj := e.Conn.Object(mmService, e.currentModem())
 
 	var smsPaths []dbus.ObjectPath
 	err := modemObj.Call(messagingIface+".List", 0).Store(&smsPaths)
@@ -24,43 +26,44 @@ func (e *DBusMBIMEngine) ListSms() (*engine.SmsListResult, error) {
 	}
 
 	result := &engine.SmsListResult{
-		Messages: make(map[string]dbus.ObjectPath),
-	}
-
-	if len(smsPaths) == 0 {
-		result.DisplayText = "没有短信。"
-		return result, nil
+		Messages: make(map[string]string),
 	}
-
-	var builder strings.Builder
 	for _, smsPath := range smsPaths {
 		// 从路径中提取ID (e.g., /org/.../SMS/5 -> "5")
 		id := path.Base(string(smsPath))
-		result.Messages[id] = smsPath
+		result.Messages[id] = string(smsPath)
 
 		smsObj := e.Conn.Object(mmService, smsPath)
-
-		numberVar, _ := smsObj.GetProperty(smsIface + ".Number")
-		textVar, _ := smsObj.GetProperty(smsIface + ".Text")
-		timestampVar, _ := smsObj.GetProperty(smsIface + ".Timestamp")
-
-		ts, _ := time.Parse(time.RFC3339, timestampVar.Value().(string))
-
-		// 在每条短信前加上ID
-		builder.WriteString(fmt.Sprintf("✉️ *[ID: %s]* 来自: `%s` (%s)\n",
-			id,
-			numberVar.Value().(string),
-			ts.Local().Format("2006-01-02 15:04"),
-		))
-		builder.WriteString(fmt.Sprintf("```\n%s\n```\n\n", textVar.Value().(string)))
+		sms := engine.SmsMessage{ID: id}
+		if v, err := smsObj.GetProperty(smsIface + ".Number"); err == nil {
+			sms.Number, _ = v.Value().(string)
+		}
+		if v, err := smsObj.GetProperty(smsIface + ".Text"); err == nil {
+			sms.Text, _ = v.Value().(string)
+		}
+		if v, err := smsObj.GetProperty(smsIface + ".Timestamp"); err == nil {
+			ts, _ := v.Value().(string)
+			if sms.Timestamp, err = ParseTimestamp(ts); err != nil && ts != "" {
+				log.Printf("短信 %s: %v", smsPath, err)
+			}
+		}
+		if v, err := smsObj.GetProperty(smsIface + ".Storage"); err == nil {
+			if st, ok := v.Value().(uint32); ok && st != 0 {
+				sms.Storage = storageName(st)
+			}
+		}
+		if v, err := smsObj.GetProperty(smsIface + ".PduType"); err == nil {
+			pduType, _ := v.Value().(uint32)
+			sms.Outgoing = pduType == mmSmsPduTypeSubmit
+		}
+		result.Sms = append(result.Sms, sms)
 	}
-	result.DisplayText = builder.String()
 	return result, nil
 }
 
 // SendSms 发送短信
 func (e *DBusMBIMEngine) SendSms(recipient, text string) error {
-	modemObj := e.Conn.Object(mmService, e.modemPath)
+	modemObj := e.Conn.Object(mmService, e.currentModem())
 
 	props := map[string]dbus.Variant{
 		"Text":   dbus.MakeVariant(text),
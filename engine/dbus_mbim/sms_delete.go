@@ -2,7 +2,8 @@ package dbus_mbim
 
 import "github.com/godbus/dbus/v5"
 
-func (e *DBusMBIMEngine) DeleteSms(path dbus.ObjectPath) error {
-	modemObj := e.Conn.Object(mmService, e.modemPath)
-	return modemObj.Call(messagingIface+".Delete", 0, path).Store()
+// DeleteSms 删除短信, id 为短信的 D-Bus 路径
+func (e *DBusMBIMEngine) DeleteSms(id string) error {
+	modemObj := e.Conn.Object(mmService, e.currentModem())
+	return modemObj.Call(messagingIface+".Delete", 0, dbus.ObjectPath(id)).Store()
 }
@@ -0,0 +1,84 @@
+package dbus_mbim
+
+import (
+	"fmt"
+	"log"
+	"tg_modem/engine"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	mmSmsStateSent         = 5     // MM_SMS_STATE_SENT
+	mmDeliveryStateUnknown = 0x100 // MM_SMS_DELIVERY_STATE_UNKNOWN, 尚未收到状态报告
+
+	// 状态报告可能在对方开机后才到达, 轮询间隔从 deliveryPollMin 逐步增加到 deliveryPollMax
+	deliveryPollMin       = 5 * time.Second
+	deliveryPollMax       = 5 * time.Minute
+	deliveryReportTimeout = 48 * time.Hour
+)
+
+// SendSmsWithReport 发送短信并请求状态报告 (DeliveryReportRequest),
+// 之后轮询短信对象的 State 和 DeliveryState 跟踪投递结果
+func (e *DBusMBIMEngine) SendSmsWithReport(recipient, text string) (<-chan engine.SmsDeliveryUpdate, error) {
+	modemObj := e.Conn.Object(mmService, e.currentModem())
+
+	props := map[string]dbus.Variant{
+		"Text":                  dbus.MakeVariant(text),
+		"Number":                dbus.MakeVariant(recipient),
+		"DeliveryReportRequest": dbus.MakeVariant(true),
+	}
+
+	var smsPath dbus.ObjectPath
+	err := modemObj.Call(messagingIface+".Create", 0, props).Store(&smsPath)
+	if err != nil {
+		return nil, fmt.Errorf("无法创建短信对象: %w", err)
+	}
+	smsObj := e.Conn.Object(mmService, smsPath)
+	if err := smsObj.Call(smsIface+".Send", 0).Store(); err != nil {
+		return nil, err
+	}
+
+	updates := make(chan engine.SmsDeliveryUpdate, 4)
+	go e.trackDelivery(smsPath, updates)
+	return updates, nil
+}
+
+// trackDelivery 在 DeliveryState 变化时上报, 直到送达、失败或超时
+func (e *DBusMBIMEngine) trackDelivery(smsPath dbus.ObjectPath, updates chan<- engine.SmsDeliveryUpdate) {
+	defer close(updates)
+	smsObj := e.Conn.Object(mmService, smsPath)
+
+	if stateVar, err := smsObj.GetProperty(smsIface + ".State"); err == nil {
+		if state, _ := stateVar.Value().(uint32); state != mmSmsStateSent {
+			log.Printf("WARN: 短信 %s 发送后的状态为 %d", smsPath, state)
+		}
+	}
+	updates <- engine.SmsDeliveryUpdate{State: engine.SmsSent, Status: -1}
+
+	last := uint32(mmDeliveryStateUnknown)
+	interval := deliveryPollMin
+	deadline := time.Now().Add(deliveryReportTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		interval = min(interval*2, deliveryPollMax)
+
+		v, err := smsObj.GetProperty(smsIface + ".DeliveryState")
+		if err != nil {
+			log.Printf("无法获取短信 %s 的投递状态, 停止跟踪: %v", smsPath, err)
+			return
+		}
+		state, _ := v.Value().(uint32)
+		if state == last || state >= mmDeliveryStateUnknown {
+			continue
+		}
+		last = state
+		update := engine.DeliveryUpdate(int(state))
+		updates <- update
+		if update.State != engine.SmsSent {
+			return
+		}
+	}
+	log.Printf("短信 %s 在 %v 内未收到状态报告", smsPath, deliveryReportTimeout)
+}
@@ -0,0 +1,103 @@
+package dbus_mbim
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"tg_modem/engine"
+	"tg_modem/engine/at"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// smsStorageTimeout 限制通过 AT 端口读取存储容量的时间
+const smsStorageTimeout = 10 * time.Second
+
+// mmSmsStorages 为 MMSmsStorage 各值对应的 27.005 存储名称
+var mmSmsStorages = []string{"", "SM", "ME", "MT", "SR", "BM", "TA"}
+
+func storageName(v uint32) string {
+	if int(v) < len(mmSmsStorages) && mmSmsStorages[v] != "" {
+		return mmSmsStorages[v]
+	}
+	return fmt.Sprintf("未知 (%d)", v)
+}
+
+// SmsStorages 返回调制解调器支持的短信存储。ModemManager 不提供存储容量, 已存条数按导出的短信统计;
+// 只有一个调制解调器且配置了 AT 端口时从 AT+CPMS? 读取当前所选存储的条数和容量。
+func (e *DBusMBIMEngine) SmsStorages() ([]engine.SmsStorage, error) {
+	modemObj := e.Conn.Object(mmService, e.currentModem())
+
+	supportedVar, err := modemObj.GetProperty(messagingIface + ".SupportedStorages")
+	if err != nil {
+		return nil, fmt.Errorf("无法获取支持的短信存储: %w", err)
+	}
+	supported, _ := supportedVar.Value().([]uint32)
+	defaultVar, err := modemObj.GetProperty(messagingIface + ".DefaultStorage")
+	if err != nil {
+		return nil, fmt.Errorf("无法获取默认短信存储: %w", err)
+	}
+	defaultStorage, _ := defaultVar.Value().(uint32)
+
+	var smsPaths []dbus.ObjectPath
+	if err := modemObj.Call(messagingIface+".List", 0).Store(&smsPaths); err != nil {
+		return nil, fmt.Errorf("无法列出短信: %w", err)
+	}
+	used := make(map[uint32]int)
+	for _, smsPath := range smsPaths {
+		if v, err := e.Conn.Object(mmService, smsPath).GetProperty(smsIface + ".Storage"); err == nil {
+			if st, ok := v.Value().(uint32); ok {
+				used[st]++
+			}
+		}
+	}
+
+	// AT 端口只属于一个调制解调器, 有多个调制解调器时无法确定容量属于哪一个。
+	// 只读取 AT+CPMS? 报告的当前存储, 切换存储会影响 ModemManager 读取短信
+	var mems []at.SmsStorageUsage
+	if e.atHandler != nil {
+		if modems, err := e.ListModems(); err == nil && len(modems) == 1 {
+			ctx, cancel := context.WithTimeout(context.Background(), smsStorageTimeout)
+			mems, err = e.atHandler.SmsStorageStatus(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("通过 AT 端口读取短信存储容量失败: %v", err)
+			}
+		}
+	}
+
+	storages := make([]engine.SmsStorage, 0, len(supported))
+	for _, st := range supported {
+		s := engine.SmsStorage{
+			Name:    storageName(st),
+			Used:    used[st],
+			Total:   -1,
+			Default: st == defaultStorage,
+		}
+		if usage, ok := at.FindSmsStorage(mems, s.Name); ok {
+			s.Used, s.Total = usage.Used, usage.Total
+		}
+		storages = append(storages, s)
+	}
+	return storages, nil
+}
+
+// SetDefaultSmsStorage 设置收到的短信存入的存储 (Messaging.SetDefaultStorage)
+func (e *DBusMBIMEngine) SetDefaultSmsStorage(name string) error {
+	st := slices.Index(mmSmsStorages, name)
+	if name == "" || st < 0 {
+		return fmt.Errorf("未知的短信存储 %q", name)
+	}
+	modemObj := e.Conn.Object(mmService, e.currentModem())
+	supportedVar, err := modemObj.GetProperty(messagingIface + ".SupportedStorages")
+	if err != nil {
+		return fmt.Errorf("无法获取支持的短信存储: %w", err)
+	}
+	supported, _ := supportedVar.Value().([]uint32)
+	if !slices.Contains(supported, uint32(st)) {
+		return fmt.Errorf("调制解调器不支持短信存储 %s", name)
+	}
+	return modemObj.Call(messagingIface+".SetDefaultStorage", 0, uint32(st)).Store()
+}
@@ -2,216 +2,196 @@ package dbus_mbim
 
 import (
 	"errors"
-	"fmt"
 	"log"
-	"strings"
+	"tg_modem/engine"
+	"time"
 
 	"github.com/godbus/dbus/v5"
 )
 
-// formatDuration 将秒数转换为人类可读的 Dd Hh Mm Ss 格式
-func formatDuration(totalSeconds uint32) string {
-	if totalSeconds == 0 {
-		return "0s"
-	}
-	d := totalSeconds / 86400
-	h := (totalSeconds % 86400) / 3600
-	m := (totalSeconds % 3600) / 60
-	s := totalSeconds % 60
+const signalIface = modemIface + ".Signal"
 
-	var parts []string
-	if d > 0 {
-		parts = append(parts, fmt.Sprintf("%dd", d))
-	}
-	if h > 0 {
-		parts = append(parts, fmt.Sprintf("%dh", h))
-	}
-	if m > 0 {
-		parts = append(parts, fmt.Sprintf("%dm", m))
-	}
-	if s > 0 || len(parts) == 0 {
-		parts = append(parts, fmt.Sprintf("%ds", s))
-	}
-	return strings.Join(parts, " ")
+var modemStateNames = map[int32]string{
+	-1: "Failed", 0: "Unknown", 1: "Initializing", 2: "Locked", 3: "Disabled",
+	4: "Disabling", 5: "Enabling", 6: "Enabled", 7: "Searching", 8: "Registered",
+	9: "Disconnecting", 10: "Connecting", 11: "Connected",
+}
+
+var registrationStateNames = map[uint32]string{0: "Idle", 1: "Home", 2: "Searching", 3: "Denied", 4: "Unknown", 5: "Roaming"}
+
+// signalRATs maps the Modem.Signal properties to display names.
+var signalRATs = []struct{ prop, name string }{
+	{"Gsm", "GSM"},
+	{"Umts", "UMTS"},
+	{"Lte", "LTE"},
+	{"Nr5g", "5G NR"},
 }
 
 // GetStatus queries the modem for detailed status information.
-func (e *DBusMBIMEngine) GetStatus() (string, error) {
-	if !e.modemPath.IsValid() {
-		return "", errors.New("引擎未初始化或 modem path is invalid")
+func (e *DBusMBIMEngine) GetStatus() (*engine.Status, error) {
+	modemPath := e.currentModem()
+	if !modemPath.IsValid() {
+		return nil, errors.New("引擎未初始化或 modem path is invalid")
 	}
 
-	modemObj := e.Conn.Object(mmService, e.modemPath)
-	var builder strings.Builder
+	modemObj := e.Conn.Object(mmService, modemPath)
+	status := &engine.Status{SignalQuality: -1}
 
 	// --- 1. Modem State & Network Info ---
-	builder.WriteString("ℹ️ *Modem State*\n")
-
-	// Get basic state from the main Modem interface
+	if modelVar, err := modemObj.GetProperty(modemIface + ".Model"); err == nil {
+		status.Model, _ = modelVar.Value().(string)
+	}
 	stateVar, _ := modemObj.GetProperty(modemIface + ".State")
 	if state, ok := stateVar.Value().(int32); ok {
-		stateMap := map[int32]string{
-			-1: "Failed", 0: "Unknown", 1: "Initializing", 2: "Locked", 3: "Disabled",
-			4: "Disabling", 5: "Enabling", 6: "Enabled", 7: "Searching", 8: "Registered",
-			9: "Disconnecting", 10: "Connecting", 11: "Connected",
-		}
-		builder.WriteString(fmt.Sprintf("`State:` %s\n", stateMap[state]))
+		status.State = modemStateNames[state]
 	}
-	// Get eSIM EID from SIM object
+
+	// Operator name stored on the (e)SIM
 	simPathVar, err := modemObj.GetProperty(modemIface + ".Sim")
-	if err != nil || !simPathVar.Value().(dbus.ObjectPath).IsValid() {
+	simPath, _ := simPathVar.Value().(dbus.ObjectPath)
+	if err != nil || !simPath.IsValid() || simPath == "/" {
 		log.Printf("WARN: Could not get active SIM path from modem: %v", err)
 	} else {
-		simPath := simPathVar.Value().(dbus.ObjectPath)
-		// 2. 创建 SIM 对象
 		simObj := e.Conn.Object(mmService, simPath)
-		// 3. 从 SIM 对象获取 OperatorName
 		opNameVar, err := simObj.GetProperty("org.freedesktop.ModemManager1.Sim.OperatorName")
 		if err != nil {
 			log.Printf("DEBUG: Could not get OperatorName from SIM object: %v", err)
 		}
-		// Get operator name and registration state from the Modem3gpp interface
-		if opname, ok := opNameVar.Value().(string); ok && opname != "" {
-			builder.WriteString(fmt.Sprintf("Esim: %s\n", opname))
-		}
-	}
-	opNameVar1, _ := modemObj.GetProperty("org.freedesktop.ModemManager1.Modem.Modem3gpp.OperatorName")
-	if opName, ok := opNameVar1.Value().(string); ok && opName != "" {
-		builder.WriteString(fmt.Sprintf("`Operator:` %s\n", opName))
+		status.SimOperator, _ = opNameVar.Value().(string)
 	}
+
+	// Operator name and registration state from the Modem3gpp interface
+	opNameVar, _ := modemObj.GetProperty("org.freedesktop.ModemManager1.Modem.Modem3gpp.OperatorName")
+	status.Operator, _ = opNameVar.Value().(string)
 	regStateVar, _ := modemObj.GetProperty("org.freedesktop.ModemManager1.Modem.Modem3gpp.RegistrationState")
 	if regState, ok := regStateVar.Value().(uint32); ok {
-		regStateMap := map[uint32]string{0: "Idle", 1: "Home", 2: "Searching", 3: "Denied", 4: "Unknown", 5: "Roaming"}
-		builder.WriteString(fmt.Sprintf("`Registration:` %s\n", regStateMap[regState]))
+		status.Registration = registrationStateNames[regState]
 	}
 
-	// Get access technology from the Modem object
 	techVar, _ := modemObj.GetProperty(modemIface + ".AccessTechnologies")
 	if tech, ok := techVar.Value().(uint32); ok {
-		if techStr := accessTechToString(tech); techStr != "" {
-			builder.WriteString(fmt.Sprintf("`Network Type:` %s\n", techStr))
-		}
+		status.AccessTech = accessTechToString(tech)
 	}
 
 	// --- 2. Signal Quality ---
-	builder.WriteString("\n📶 *Signal Quality*\n")
 	signalVar, _ := modemObj.GetProperty(modemIface + ".SignalQuality")
 	if qualityTuple, ok := signalVar.Value().([]interface{}); ok && len(qualityTuple) > 0 {
 		if quality, ok := qualityTuple[0].(uint32); ok {
-			builder.WriteString(fmt.Sprintf("`Quality:` %d%%\n", quality))
+			status.SignalQuality = int(quality)
 		}
 	}
 
-	// Detailed Signal Metrics (robustly check if available)
-	signalDetailsIface := modemIface + ".Signal"
-	if lteVar, err := modemObj.GetProperty(signalDetailsIface + ".Lte"); err == nil && lteVar.Value() != nil {
-		if lteMap, ok := lteVar.Value().(map[string]dbus.Variant); ok && len(lteMap) > 0 {
-			builder.WriteString("*LTE Metrics:*\n")
-			if v, ok := lteMap["rsrp"]; ok {
-				builder.WriteString(fmt.Sprintf("`RSRP:` %.2f dBm\n", v.Value().(float64)))
-			}
-			if v, ok := lteMap["rsrq"]; ok {
-				builder.WriteString(fmt.Sprintf("`RSRQ:` %.2f dB\n", v.Value().(float64)))
-			}
-			if v, ok := lteMap["sinr"]; ok {
-				builder.WriteString(fmt.Sprintf("`SINR:` %.2f dB\n", v.Value().(float64)))
-			}
-			if v, ok := lteMap["rssi"]; ok {
-				builder.WriteString(fmt.Sprintf("`RSSI:` %.2f dB\n", v.Value().(float64)))
-			}
+	// Detailed signal metrics are only available once Signal.Setup was called.
+	for _, rat := range signalRATs {
+		v, err := modemObj.GetProperty(signalIface + "." + rat.prop)
+		if err != nil {
+			continue
 		}
-	}
-
-	if nrVar, err := modemObj.GetProperty(signalDetailsIface + ".Nr5g"); err == nil && nrVar.Value() != nil {
-		if nrMap, ok := nrVar.Value().(map[string]dbus.Variant); ok && len(nrMap) > 0 {
-			builder.WriteString("*5G NR Metrics:*\n")
-			if v, ok := nrMap["rsrp"]; ok {
-				builder.WriteString(fmt.Sprintf("`RSRP:` %.2f dBm\n", v.Value().(float64)))
-			}
-			if v, ok := nrMap["rsrq"]; ok {
-				builder.WriteString(fmt.Sprintf("`RSRQ:` %.2f dB\n", v.Value().(float64)))
-			}
-			if v, ok := nrMap["sinr"]; ok {
-				builder.WriteString(fmt.Sprintf("`SINR:` %.2f dB\n", v.Value().(float64)))
-			}
-			if v, ok := nrMap["rssi"]; ok {
-				builder.WriteString(fmt.Sprintf("`RSSI:` %.2f dB\n", v.Value().(float64)))
-			}
+		m, ok := v.Value().(map[string]dbus.Variant)
+		if !ok || len(m) == 0 {
+			continue
 		}
-	}
-
-	// Try to get detailed LTE signal metrics
-	lteVar, err := modemObj.GetProperty("org.freedesktop.ModemManager1.Modem.Signal.Lte")
-	if err == nil {
-		if lteMap, ok := lteVar.Value().(map[string]dbus.Variant); ok && len(lteMap) > 0 {
-			// The key for S/N is "snr", not "sinr" based on mmcli output.
-			if v, ok := lteMap["snr"]; ok {
-				if snr, ok := v.Value().(float64); ok {
-					builder.WriteString(fmt.Sprintf("`S/N (SINR):` %.2f dB\n", snr))
-				}
-			}
+		metrics := engine.SignalMetrics{
+			RAT:  rat.name,
+			RSSI: floatValue(m, "rssi"),
+			RSRP: floatValue(m, "rsrp"),
+			RSRQ: floatValue(m, "rsrq"),
+			SNR:  floatValue(m, "snr"),
+		}
+		if metrics.SNR == nil {
+			metrics.SNR = floatValue(m, "sinr")
+		}
+		if metrics.RSSI != nil || metrics.RSRP != nil || metrics.RSRQ != nil || metrics.SNR != nil {
+			status.Signals = append(status.Signals, metrics)
 		}
 	}
 
 	// --- 3. Data Connection ---
-	builder.WriteString("\n🌐 *Data Connection*\n")
-	ipAddress, onlineDuration := e.findBearerInfo()
-	if ipAddress != "" {
-		builder.WriteString(fmt.Sprintf("`IPv4 Address:` %s\n", ipAddress))
-		if onlineDuration != "" {
-			builder.WriteString(fmt.Sprintf("`Online Duration:` %s\n", onlineDuration))
-		}
-	} else {
-		builder.WriteString("`Status:` Not connected or no IP assigned\n")
-	}
+	status.Bearers = e.findBearers()
 
-	return builder.String(), nil
+	return status, nil
 }
 
-func (e *DBusMBIMEngine) findBearerInfo() (string, string) {
-	modemObj := e.Conn.Object(mmService, e.modemPath)
+// findBearers collects every bearer of the modem with its IP configuration
+// and statistics.
+func (e *DBusMBIMEngine) findBearers() []engine.Bearer {
+	modemObj := e.Conn.Object(mmService, e.currentModem())
 	bearersVar, err := modemObj.GetProperty(modemIface + ".Bearers")
 	if err != nil {
 		log.Printf("ERROR: Could not get bearers list: %v", err)
-		return "", ""
+		return nil
 	}
 
 	bearerPaths, ok := bearersVar.Value().([]dbus.ObjectPath)
-	if !ok || len(bearerPaths) == 0 {
-		return "", ""
+	if !ok {
+		return nil
 	}
 
+	var bearers []engine.Bearer
 	for _, bearerPath := range bearerPaths {
 		if !bearerPath.IsValid() {
 			continue
 		}
 		bearerObj := e.Conn.Object(mmService, bearerPath)
-		ip4ConfigVar, err := bearerObj.GetProperty(bearerIface + ".Ip4Config")
-		if err != nil || ip4ConfigVar.Value() == nil {
-			continue
+		var b engine.Bearer
+
+		if v, err := bearerObj.GetProperty(bearerIface + ".Interface"); err == nil {
+			b.Interface, _ = v.Value().(string)
 		}
-		var ipAddress, onlineDuration string
-		if ip4Map, ok := ip4ConfigVar.Value().(map[string]dbus.Variant); ok {
-			if addrVar, ok := ip4Map["address"]; ok {
-				if ip := addrVar.Value().(string); ip != "" {
-					ipAddress, ok = addrVar.Value().(string)
-					if !ok {
-						fmt.Printf("Get IP error!")
-					}
-				}
+		if v, err := bearerObj.GetProperty(bearerIface + ".Connected"); err == nil {
+			b.Connected, _ = v.Value().(bool)
+		}
+		if v, err := bearerObj.GetProperty(bearerIface + ".Ip4Config"); err == nil {
+			if ip4, ok := v.Value().(map[string]dbus.Variant); ok {
+				b.IPv4 = stringValue(ip4, "address")
+				b.IPv4Gw = stringValue(ip4, "gateway")
+				b.DNS = appendDNS(b.DNS, ip4)
+			}
+		}
+		if v, err := bearerObj.GetProperty(bearerIface + ".Ip6Config"); err == nil {
+			if ip6, ok := v.Value().(map[string]dbus.Variant); ok {
+				b.IPv6 = stringValue(ip6, "address")
+				b.DNS = appendDNS(b.DNS, ip6)
 			}
 		}
-		if ipAddress != "" {
-			statsVar, _ := bearerObj.GetProperty(bearerIface + ".Stats")
-			if statsMap, ok := statsVar.Value().(map[string]dbus.Variant); ok {
-				if durationVar, ok := statsMap["duration"]; ok {
-					onlineDuration = formatDuration(durationVar.Value().(uint32))
+		if v, err := bearerObj.GetProperty(bearerIface + ".Stats"); err == nil {
+			if stats, ok := v.Value().(map[string]dbus.Variant); ok {
+				if d, ok := stats["duration"].Value().(uint32); ok {
+					b.Duration = time.Duration(d) * time.Second
 				}
+				b.RxBytes, _ = stats["rx-bytes"].Value().(uint64)
+				b.TxBytes, _ = stats["tx-bytes"].Value().(uint64)
 			}
-			return ipAddress, onlineDuration // Return the first valid bearer's info
+		}
+		bearers = append(bearers, b)
+	}
+	return bearers
+}
+
+func floatValue(m map[string]dbus.Variant, key string) *float64 {
+	v, ok := m[key]
+	if !ok {
+		return nil
+	}
+	f, ok := v.Value().(float64)
+	if !ok {
+		return nil
+	}
+	return &f
+}
+
+func stringValue(m map[string]dbus.Variant, key string) string {
+	s, _ := m[key].Value().(string)
+	return s
+}
+
+func appendDNS(dns []string, config map[string]dbus.Variant) []string {
+	for _, key := range []string{"dns1", "dns2", "dns3"} {
+		if s := stringValue(config, key); s != "" {
+			dns = append(dns, s)
 		}
 	}
-	return "", ""
+	return dns
 }
 
 func accessTechToString(tech uint32) string {
@@ -0,0 +1,168 @@
+package dbus_mbim
+
+import (
+	"fmt"
+	"log"
+	"tg_modem/engine"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	dbusService = "org.freedesktop.DBus"
+	dbusIface   = "org.freedesktop.DBus"
+)
+
+// WatchModems 监听调制解调器的插拔 (ObjectManager 的 InterfacesAdded/InterfacesRemoved)
+// 和 ModemManager 的退出与重启 (NameOwnerChanged)。当前调制解调器消失后引擎会
+// 自动切换到重新出现的调制解调器。
+func (e *DBusMBIMEngine) WatchModems() (<-chan engine.ModemEvent, error) {
+	err := e.Conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(mmPath),
+		dbus.WithMatchInterface(objectManagerIface),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("无法添加 D-Bus 信号匹配规则 (ObjectManager): %w", err)
+	}
+	err = e.Conn.AddMatchSignal(
+		dbus.WithMatchSender(dbusService),
+		dbus.WithMatchInterface(dbusIface),
+		dbus.WithMatchMember("NameOwnerChanged"),
+		dbus.WithMatchArg(0, mmService),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("无法添加 D-Bus 信号匹配规则 (NameOwnerChanged): %w", err)
+	}
+
+	// 启动时已存在的调制解调器不产生事件
+	if modems, err := e.ListModems(); err == nil {
+		e.mu.Lock()
+		e.known = make(map[dbus.ObjectPath]engine.ModemInfo)
+		for _, m := range modems {
+			e.known[dbus.ObjectPath(m.Path)] = m
+		}
+		e.mu.Unlock()
+	}
+
+	sigChan := make(chan *dbus.Signal, 10)
+	e.Conn.Signal(sigChan)
+	events := make(chan engine.ModemEvent, 10)
+
+	go func() {
+		defer close(events)
+		for sig := range sigChan {
+			switch sig.Name {
+			case objectManagerIface + ".InterfacesAdded":
+				if len(sig.Body) < 2 {
+					continue
+				}
+				path, _ := sig.Body[0].(dbus.ObjectPath)
+				ifaces, _ := sig.Body[1].(map[string]map[string]dbus.Variant)
+				if _, ok := ifaces[modemIface]; ok {
+					e.modemAdded(path, ifaces, events)
+				}
+			case objectManagerIface + ".InterfacesRemoved":
+				if len(sig.Body) < 2 {
+					continue
+				}
+				path, _ := sig.Body[0].(dbus.ObjectPath)
+				ifaces, _ := sig.Body[1].([]string)
+				for _, iface := range ifaces {
+					if iface == modemIface {
+						e.modemRemoved(path, events)
+						break
+					}
+				}
+			case dbusIface + ".NameOwnerChanged":
+				if len(sig.Body) < 3 {
+					continue
+				}
+				if name, _ := sig.Body[0].(string); name != mmService {
+					continue
+				}
+				if newOwner, _ := sig.Body[2].(string); newOwner == "" {
+					e.managerStopped(events)
+				} else {
+					e.managerStarted(events)
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+func (e *DBusMBIMEngine) modemAdded(path dbus.ObjectPath, ifaces map[string]map[string]dbus.Variant, events chan<- engine.ModemEvent) {
+	info := modemInfo(path, ifaces)
+
+	e.mu.Lock()
+	if e.known == nil {
+		e.known = make(map[dbus.ObjectPath]engine.ModemInfo)
+	}
+	if _, seen := e.known[path]; seen {
+		e.mu.Unlock()
+		return
+	}
+	e.known[path] = info
+	if e.modemPath == "" {
+		e.modemPath = path
+		log.Printf("调制解调器重新出现, 切换到: %s", path)
+	}
+	info.Current = e.modemPath == path
+	e.mu.Unlock()
+
+	log.Printf("检测到调制解调器接入: %s (%s)", path, info.ID)
+	if err := e.setupSignal(path); err != nil {
+		log.Printf("WARN: Could not setup signal polling on %s: %v", path, err)
+	}
+	events <- engine.ModemEvent{Type: engine.ModemAdded, Modem: info}
+}
+
+func (e *DBusMBIMEngine) modemRemoved(path dbus.ObjectPath, events chan<- engine.ModemEvent) {
+	e.mu.Lock()
+	info, ok := e.known[path]
+	if !ok {
+		info = engine.ModemInfo{ID: string(path), Path: string(path)}
+	}
+	delete(e.known, path)
+	wasCurrent := e.modemPath == path
+	if wasCurrent {
+		e.modemPath = ""
+	}
+	e.mu.Unlock()
+	info.Current = wasCurrent
+
+	log.Printf("检测到调制解调器移除: %s (%s)", path, info.ID)
+	if wasCurrent {
+		// 还有其他可用的调制解调器时立即切换, 否则等待其重新出现
+		if next, err := e.findActiveModem(); err == nil {
+			e.setModem(next)
+			log.Printf("切换到调制解调器: %s", next)
+		}
+	}
+	events <- engine.ModemEvent{Type: engine.ModemRemoved, Modem: info}
+}
+
+func (e *DBusMBIMEngine) managerStopped(events chan<- engine.ModemEvent) {
+	log.Println("ModemManager 服务已退出")
+	e.mu.Lock()
+	e.modemPath = ""
+	e.known = make(map[dbus.ObjectPath]engine.ModemInfo)
+	e.mu.Unlock()
+	events <- engine.ModemEvent{Type: engine.ManagerStopped}
+}
+
+// managerStarted 在服务重启后重新扫描, 已导出的调制解调器不会再收到 InterfacesAdded
+func (e *DBusMBIMEngine) managerStarted(events chan<- engine.ModemEvent) {
+	log.Println("ModemManager 服务已启动")
+	events <- engine.ModemEvent{Type: engine.ManagerStarted}
+
+	managedObjects, err := e.managedObjects()
+	if err != nil {
+		log.Printf("重新扫描调制解调器失败: %v", err)
+		return
+	}
+	for _, path := range sortedModemPaths(managedObjects) {
+		e.modemAdded(path, managedObjects[path], events)
+	}
+}
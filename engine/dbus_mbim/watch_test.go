@@ -0,0 +1,132 @@
+package dbus_mbim
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"tg_modem/engine"
+	"tg_modem/engine/dbus_mbim/fakemm"
+)
+
+// nextEvent waits for the next modem event.
+func nextEvent(t *testing.T, events <-chan engine.ModemEvent) engine.ModemEvent {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no modem event")
+		return engine.ModemEvent{}
+	}
+}
+
+func TestWatchModemsSwitchesToRemainingModem(t *testing.T) {
+	mm, first, eng := newFakeEngine(t, fakemm.ModemConfig{Model: "first"})
+	events, err := eng.WatchModems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := mm.AddModem(fakemm.ModemConfig{Model: "second"})
+	if ev := nextEvent(t, events); ev.Type != engine.ModemAdded || ev.Modem.Path != string(second.Path) || ev.Modem.Current {
+		t.Fatalf("event = %+v, want the second modem added", ev)
+	}
+
+	mm.RemoveModem(first)
+	if ev := nextEvent(t, events); ev.Type != engine.ModemRemoved || ev.Modem.Path != string(first.Path) || !ev.Modem.Current {
+		t.Fatalf("event = %+v, want the current modem removed", ev)
+	}
+	if got := eng.GetModemPath(); got != second.Path {
+		t.Errorf("engine uses %s, want %s", got, second.Path)
+	}
+}
+
+func TestWatchModemsReadded(t *testing.T) {
+	mm, modem, eng := newFakeEngine(t, fakemm.ModemConfig{Model: "FM350-GL", EquipmentIdentifier: "350000000000001"})
+	events, err := eng.WatchModems()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mm.RemoveModem(modem)
+	if ev := nextEvent(t, events); ev.Type != engine.ModemRemoved || ev.Modem.ID != "350000000000001" {
+		t.Fatalf("event = %+v, want the modem removed", ev)
+	}
+	if got := eng.GetModemPath(); got != "" {
+		t.Errorf("engine uses %s without any modem", got)
+	}
+
+	// ModemManager exports the modem again under a new path.
+	again := mm.AddModem(fakemm.ModemConfig{Model: "FM350-GL", EquipmentIdentifier: "350000000000001"})
+	ev := nextEvent(t, events)
+	if ev.Type != engine.ModemAdded || ev.Modem.Path != string(again.Path) || !ev.Modem.Current || ev.Modem.Model != "FM350-GL" {
+		t.Fatalf("event = %+v, want the modem added as current", ev)
+	}
+	if got := eng.GetModemPath(); got != again.Path {
+		t.Errorf("engine uses %s, want %s", got, again.Path)
+	}
+	if rate := again.SignalRate(); rate != 1 {
+		t.Errorf("signal rate = %d, want polling set up again", rate)
+	}
+}
+
+func TestWatchModemsManagerRestart(t *testing.T) {
+	bus, err := fakemm.StartBus()
+	if errors.Is(err, fakemm.ErrNoDaemon) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+	mm, err := fakemm.Start(bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm.AddModem(fakemm.ModemConfig{})
+
+	conn, err := bus.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	eng := &DBusMBIMEngine{Conn: conn}
+	if err := eng.Init(); err != nil {
+		t.Fatal(err)
+	}
+	events, err := eng.WatchModems()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mm.Close()
+	if ev := nextEvent(t, events); ev.Type != engine.ManagerStopped {
+		t.Fatalf("event = %+v, want ManagerStopped", ev)
+	}
+	if got := eng.GetModemPath(); got != "" {
+		t.Errorf("engine uses %s after ModemManager exited", got)
+	}
+
+	// The restart triggers a rescan; the modem it finds is reported once even
+	// though InterfacesAdded announces it as well.
+	mm, err = fakemm.Start(bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mm.Close()
+	modem := mm.AddModem(fakemm.ModemConfig{})
+	if ev := nextEvent(t, events); ev.Type != engine.ManagerStarted {
+		t.Fatalf("event = %+v, want ManagerStarted", ev)
+	}
+	if ev := nextEvent(t, events); ev.Type != engine.ModemAdded || ev.Modem.Path != string(modem.Path) || !ev.Modem.Current {
+		t.Fatalf("event = %+v, want the modem added as current", ev)
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected event %+v, the modem was reported twice", ev)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if got := eng.GetModemPath(); got != modem.Path {
+		t.Errorf("engine uses %s, want %s", got, modem.Path)
+	}
+}
@@ -1,22 +1,221 @@
 package engine
 
-import "github.com/godbus/dbus/v5"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"tg_modem/engine/pdu"
+	"time"
+)
 
+// SmsListResult 为调制解调器上存储的短信, 展示交给调用方
 type SmsListResult struct {
-	DisplayText string
-	// Key: 用户看到的ID (e.g., "1", "2"), Value: 对应的D-Bus路径
-	Messages map[string]dbus.ObjectPath
+	// Key: 用户看到的ID (e.g., "1", "2"), Value: 引擎内部的短信标识 (D-Bus 路径或存储序号)
+	Messages map[string]string
+	// Sms 为列出的短信, 按存储顺序
+	Sms []SmsMessage
+}
+
+// SmsMessage 为调制解调器上存储的一条短信
+type SmsMessage struct {
+	ID        string // 用户看到的ID, 同 SmsListResult.Messages 的 key
+	Number    string // 收到的短信为发件人, 发出的为收件人
+	Text      string
+	Timestamp time.Time
+	Outgoing  bool   // 存储在调制解调器上的发出的短信
+	Storage   string // 存储名称, 如 SM、ME, 未知时为空
+}
+
+// Status 为调制解调器的结构化状态, 由各引擎填充, 展示交给调用方
+type Status struct {
+	Model         string
+	State         string // 如 Registered, Connected
+	SimOperator   string // SIM 卡中记录的运营商名称
+	Operator      string // 当前注册的网络
+	Registration  string // 如 Home, Roaming
+	AccessTech    string // 如 4G (LTE), 5G
+	SignalQuality int    // 百分比, -1 表示未知
+	Signals       []SignalMetrics
+	Bearers       []Bearer
+}
+
+// SignalMetrics 为某一接入技术的详细信号指标, 未上报的指标为 nil
+type SignalMetrics struct {
+	RAT  string // 如 LTE, 5G NR
+	RSSI *float64
+	RSRP *float64
+	RSRQ *float64
+	SNR  *float64
+}
+
+// Bearer 为一条数据连接
+type Bearer struct {
+	Interface string
+	Connected bool
+	IPv4      string
+	IPv4Gw    string
+	IPv6      string
+	DNS       []string
+	Duration  time.Duration
+	RxBytes   uint64
+	TxBytes   uint64
 }
 
 // Engine 定义了调制解调器控制引擎必须实现的方法
 type Engine interface {
 	Init() error
-	GetStatus() (string, error)
+	GetStatus() (*Status, error)
 	ListSms() (*SmsListResult, error)
 	SendSms(recipient, text string) error
 	SwitchSim(slot uint32) error
 	SetData(enable bool) error
-	DeleteSms(path dbus.ObjectPath) error
+	DeleteSms(id string) error
+}
+
+// SmsDeliveryState 为发出短信的投递状态
+type SmsDeliveryState int
+
+const (
+	SmsSent      SmsDeliveryState = iota + 1 // 已提交给短信中心, 等待送达
+	SmsDelivered                             // 对方已收到
+	SmsFailed                                // 短信中心放弃投递
+)
+
+// SmsDeliveryUpdate 为发出短信的一次状态变化
+type SmsDeliveryUpdate struct {
+	State  SmsDeliveryState
+	Status int    // 网络给出的 TP-Status, 没有时为 -1
+	Reason string // Status 的说明
+}
+
+// DeliveryReporter 为能够请求状态报告并跟踪投递结果的引擎
+type DeliveryReporter interface {
+	// SendSmsWithReport 发送短信并请求状态报告。发送成功后返回的通道依次收到状态变化,
+	// 在送达、失败或等待超时后关闭。
+	SendSmsWithReport(recipient, text string) (<-chan SmsDeliveryUpdate, error)
+}
+
+// DeliveryUpdate 将状态报告中的 TP-Status 转换为状态变化
+func DeliveryUpdate(status int) SmsDeliveryUpdate {
+	update := SmsDeliveryUpdate{State: SmsFailed, Status: status, Reason: pdu.StatusText(status)}
+	switch {
+	case status < 0x20:
+		update.State = SmsDelivered
+	case pdu.StatusPending(status):
+		update.State = SmsSent
+	}
+	return update
+}
+
+// SmsStorage 为一个短信存储及其使用情况, 名称同 3GPP TS 27.005 (AT+CPMS)
+type SmsStorage struct {
+	Name    string // 如 SM (SIM 卡)、ME (调制解调器)、MT (两者)
+	Used    int    // 已存的短信条数, -1 表示未知
+	Total   int    // 容量, -1 表示未知
+	Default bool   // 收到的短信存入此存储
+}
+
+// SmsStorageManager 为能够查看和切换短信存储的引擎
+type SmsStorageManager interface {
+	// SmsStorages 返回调制解调器支持的所有短信存储
+	SmsStorages() ([]SmsStorage, error)
+	// SetDefaultSmsStorage 设置收到的短信存入的存储
+	SetDefaultSmsStorage(name string) error
+}
+
+// smsStorageNames 为短信存储的说明
+var smsStorageNames = map[string]string{
+	"SM": "SIM 卡",
+	"ME": "调制解调器",
+	"MT": "SIM 卡和调制解调器",
+	"SR": "状态报告",
+	"BM": "小区广播",
+	"TA": "终端适配器",
+}
+
+// DescribeSmsStorage 返回短信存储的说明, 如 "SIM 卡 (SM)"
+func DescribeSmsStorage(name string) string {
+	if desc, ok := smsStorageNames[name]; ok {
+		return fmt.Sprintf("%s (%s)", desc, name)
+	}
+	return name
+}
+
+// ModemInfo 描述引擎可见的一个调制解调器
+type ModemInfo struct {
+	ID           string // EquipmentIdentifier, 通常为 IMEI
+	Path         string
+	Model        string
+	Manufacturer string
+	State        string
+	Operator     string
+	Current      bool // 是否为该引擎实例操作的调制解调器
+}
+
+// MultiModem 为能够同时管理多个调制解调器的引擎
+type MultiModem interface {
+	// ListModems 按固定顺序返回所有调制解调器
+	ListModems() ([]ModemInfo, error)
+	// ForModem 返回只操作指定调制解调器的引擎, key 的格式见 FindModem
+	ForModem(key string) (Engine, error)
+}
+
+// ModemEventType 为调制解调器事件的类型
+type ModemEventType int
+
+const (
+	ModemAdded ModemEventType = iota + 1
+	ModemRemoved
+	ManagerStopped // ModemManager 服务退出
+	ManagerStarted // ModemManager 服务重新出现
+)
+
+// ModemEvent 为调制解调器热插拔或服务重启事件, 服务事件的 Modem 为空
+type ModemEvent struct {
+	Type  ModemEventType
+	Modem ModemInfo
+}
+
+// ModemWatcher 为能够上报调制解调器增减的引擎
+type ModemWatcher interface {
+	WatchModems() (<-chan ModemEvent, error)
+}
+
+// Identifier 为能够报告调制解调器和当前 SIM 卡标识的引擎, 用于归档短信
+type Identifier interface {
+	// Identity 返回调制解调器 ID (同 ModemInfo.ID) 和 SIM 卡的 ICCID, 未知的部分为空
+	Identity() (modemID, simID string, err error)
+}
+
+// FindModem 按 ID、序号 (从 1 开始) 或 ID 的唯一后缀查找调制解调器
+func FindModem(modems []ModemInfo, key string) (*ModemInfo, error) {
+	key = strings.TrimPrefix(strings.TrimSpace(key), "@")
+	if key == "" {
+		return nil, errors.New("未指定调制解调器")
+	}
+	for i := range modems {
+		if modems[i].ID == key {
+			return &modems[i], nil
+		}
+	}
+	if n, err := strconv.Atoi(key); err == nil && n >= 1 && n <= len(modems) && len(key) <= 2 {
+		return &modems[n-1], nil
+	}
+	var found *ModemInfo
+	for i := range modems {
+		if strings.HasSuffix(modems[i].ID, key) {
+			if found != nil {
+				return nil, fmt.Errorf("%s 匹配到多个调制解调器, 请输入更完整的 ID", key)
+			}
+			found = &modems[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("未找到调制解调器 %s", key)
+	}
+	return found, nil
 }
 
 // 全局引擎注册表
@@ -42,7 +241,71 @@ type ATEngine interface {
 	GetEsimEID() (string, error)
 	GetEsimPower() (string, error)
 	SetEsimPower(power bool) (string, error)
-	// TODO  Find out other AT-based eSIM commands
+
+	// 以下通过 ISD-R 上的 ES10 APDU 管理 eSIM Profile, Profile 以 ICCID 标识
+	GetEuiccInfo() (*EuiccInfo, error)
+	ListEsimProfiles() ([]EsimProfile, error)
+	EnableEsimProfile(iccid string) error
+	DisableEsimProfile(iccid string) error
+	DeleteEsimProfile(iccid string) error
+	SetEsimNickname(iccid, nickname string) error
+	// DownloadEsimProfile 通过激活码 (LPA:1$<SM-DP+>$<MatchingID>) 下载并安装 Profile,
+	// 确认码可为空, progress 可为 nil
+	DownloadEsimProfile(ctx context.Context, activationCode, confirmationCode string, progress func(DownloadProgress)) (*EsimProfile, error)
+
+	// eUICC 在 Profile 操作后保留的待发送通知
+	ListEsimNotifications() ([]EsimNotification, error)
+	ProcessEsimNotification(ctx context.Context, seq int) error
+	RemoveEsimNotification(seq int) error
+}
+
+// EsimNotification 为 eUICC 上一条待发送给 SM-DP+ 的通知
+type EsimNotification struct {
+	Seq       int
+	Operation string // install, enable, disable 或 delete
+	Address   string
+	ICCID     string
+}
+
+// DownloadStage 为 Profile 下载流程中的各个阶段
+type DownloadStage int
+
+const (
+	DownloadAuthenticateServer DownloadStage = iota + 1
+	DownloadAuthenticateClient
+	DownloadPrepare
+	DownloadFetchPackage
+	DownloadInstall
+	DownloadNotify
+)
+
+// DownloadProgress 报告 Profile 下载进度, Profile 在 SM-DP+ 返回元数据后可用
+type DownloadProgress struct {
+	Stage   DownloadStage
+	Profile *EsimProfile
+}
+
+// EsimProfile 描述 eUICC 上安装的一个 Profile
+type EsimProfile struct {
+	ICCID           string
+	ISDPAID         string
+	Enabled         bool
+	Nickname        string
+	ServiceProvider string
+	Name            string
+	Class           string // test, provisioning 或 operational
+}
+
+// EuiccInfo 描述 eUICC 芯片本身, 大小未知时为 -1
+type EuiccInfo struct {
+	EID                    string
+	ProfileVersion         string
+	SVN                    string
+	FirmwareVersion        string
+	InstalledApplications  int
+	FreeNonVolatileMemory  int
+	FreeVolatileMemory     int
+	SasAccreditationNumber string
 }
 
 // ATSetter is an interface for engines that can accept an AT handler.
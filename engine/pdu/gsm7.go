@@ -0,0 +1,102 @@
+package pdu
+
+// GSM 03.38 default alphabet, indexed by septet value.
+var gsm7Alphabet = []rune{
+	'@', '£', '$', '¥', 'è', 'é', 'ù', 'ì', 'ò', 'Ç', '\n', 'Ø', 'ø', '\r', 'Å', 'å',
+	'Δ', '_', 'Φ', 'Γ', 'Λ', 'Ω', 'Π', 'Ψ', 'Σ', 'Θ', 'Ξ', '\x1b', 'Æ', 'æ', 'ß', 'É',
+	' ', '!', '"', '#', '¤', '%', '&', '\'', '(', ')', '*', '+', ',', '-', '.', '/',
+	'0', '1', '2', '3', '4', '5', '6', '7', '8', '9', ':', ';', '<', '=', '>', '?',
+	'¡', 'A', 'B', 'C', 'D', 'E', 'F', 'G', 'H', 'I', 'J', 'K', 'L', 'M', 'N', 'O',
+	'P', 'Q', 'R', 'S', 'T', 'U', 'V', 'W', 'X', 'Y', 'Z', 'Ä', 'Ö', 'Ñ', 'Ü', '§',
+	'¿', 'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o',
+	'p', 'q', 'r', 's', 't', 'u', 'v', 'w', 'x', 'y', 'z', 'ä', 'ö', 'ñ', 'ü', 'à',
+}
+
+// GSM 03.38 extension table, reached through the escape septet 0x1B.
+var gsm7Extension = map[byte]rune{
+	0x0A: '\f', 0x14: '^', 0x28: '{', 0x29: '}', 0x2F: '\\',
+	0x3C: '[', 0x3D: '~', 0x3E: ']', 0x40: '|', 0x65: '€',
+}
+
+const gsm7Escape = 0x1B
+
+var (
+	gsm7Index    = make(map[rune]byte)
+	gsm7ExtIndex = make(map[rune]byte)
+)
+
+func init() {
+	for i, r := range gsm7Alphabet {
+		if i != gsm7Escape {
+			gsm7Index[r] = byte(i)
+		}
+	}
+	for b, r := range gsm7Extension {
+		gsm7ExtIndex[r] = b
+	}
+}
+
+// gsm7Septets returns the septets encoding r, which take two septets for
+// extension table characters, or false if r is not in the GSM alphabet.
+func gsm7Septets(r rune) ([]byte, bool) {
+	if b, ok := gsm7Index[r]; ok {
+		return []byte{b}, true
+	}
+	if b, ok := gsm7ExtIndex[r]; ok {
+		return []byte{gsm7Escape, b}, true
+	}
+	return nil, false
+}
+
+// decodeGSM7 converts septets to text.
+func decodeGSM7(septets []byte) string {
+	out := make([]rune, 0, len(septets))
+	for i := 0; i < len(septets); i++ {
+		s := septets[i] & 0x7F
+		if s == gsm7Escape && i+1 < len(septets) {
+			i++
+			if r, ok := gsm7Extension[septets[i]&0x7F]; ok {
+				out = append(out, r)
+			} else {
+				out = append(out, ' ')
+			}
+			continue
+		}
+		out = append(out, gsm7Alphabet[s])
+	}
+	return string(out)
+}
+
+// packSeptets packs septets into octets, leaving the first skip septets
+// zero so a user data header can be placed in front of the text.
+func packSeptets(septets []byte, skip int) []byte {
+	total := skip + len(septets)
+	out := make([]byte, (total*7+7)/8)
+	for i, s := range septets {
+		bit := (skip + i) * 7
+		idx, shift := bit/8, bit%8
+		out[idx] |= (s & 0x7F) << shift
+		if shift > 1 {
+			out[idx+1] |= (s & 0x7F) >> (8 - shift)
+		}
+	}
+	return out
+}
+
+// unpackSeptets extracts count septets from packed octets.
+func unpackSeptets(data []byte, count int) []byte {
+	out := make([]byte, 0, count)
+	for i := 0; i < count; i++ {
+		bit := i * 7
+		idx, shift := bit/8, bit%8
+		if idx >= len(data) {
+			break
+		}
+		s := data[idx] >> shift
+		if shift > 1 && idx+1 < len(data) {
+			s |= data[idx+1] << (8 - shift)
+		}
+		out = append(out, s&0x7F)
+	}
+	return out
+}
@@ -0,0 +1,300 @@
+// Package pdu encodes and decodes SMS TPDUs (3GPP TS 23.040) as exchanged
+// with modems in PDU mode (AT+CMGF=0).
+package pdu
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Encoding is the alphabet used for the user data of a message.
+type Encoding int
+
+const (
+	GSM7 Encoding = iota
+	Binary8
+	UCS2
+)
+
+func (e Encoding) String() string {
+	switch e {
+	case GSM7:
+		return "GSM-7"
+	case Binary8:
+		return "8-bit"
+	case UCS2:
+		return "UCS-2"
+	}
+	return "unknown"
+}
+
+// Segment limits for a single and for a concatenated message part.
+const (
+	gsm7Single   = 160
+	gsm7Multi    = 153
+	ucs2Single   = 70
+	ucs2Multi    = 67
+	maxSegments  = 255
+	concatIE8Bit = 0x00
+	concatIE16   = 0x08
+)
+
+// Concat describes the position of a message part in a concatenated SMS.
+type Concat struct {
+	Ref   int
+	Total int
+	Seq   int
+}
+
+// Message is a decoded SMS-DELIVER.
+type Message struct {
+	SMSC      string
+	Sender    string
+	Text      string
+	Timestamp time.Time
+	Encoding  Encoding
+	Concat    *Concat // nil for single part messages
+}
+
+// StatusReport is a decoded SMS-STATUS-REPORT.
+type StatusReport struct {
+	Reference  int
+	Recipient  string
+	Timestamp  time.Time // when the SMSC received the message
+	Discharged time.Time // when the final status was reached
+	Status     int       // TP-ST, 0x00-0x1F means delivered
+}
+
+// Delivered reports whether the status indicates a successful delivery.
+func (r *StatusReport) Delivered() bool {
+	return r.Status < 0x20
+}
+
+// Failed reports whether the SMSC gave up on the message.
+func (r *StatusReport) Failed() bool {
+	return r.Status >= 0x40
+}
+
+// reader walks the octets of a PDU.
+type reader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if r.pos+n > len(r.b) {
+		r.err = errors.New("PDU truncated")
+		return nil
+	}
+	out := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return out
+}
+
+func (r *reader) byte() byte {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+// Decode decodes a hex encoded SMS-DELIVER including the leading SMSC
+// address, as returned by AT+CMGR and AT+CMGL.
+func Decode(pduHex string) (*Message, error) {
+	data, err := hex.DecodeString(strings.TrimSpace(pduHex))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PDU hex: %w", err)
+	}
+	r := &reader{b: data}
+
+	msg := &Message{}
+	msg.SMSC = readSMSC(r)
+
+	first := r.byte()
+	if first&0x03 != 0x00 {
+		return nil, fmt.Errorf("not an SMS-DELIVER (first octet 0x%02X)", first)
+	}
+	udhi := first&0x40 != 0
+
+	msg.Sender = readAddress(r)
+	r.byte() // TP-PID
+	dcs := r.byte()
+	msg.Timestamp = readTimestamp(r)
+	udl := int(r.byte())
+	ud := r.b[min(r.pos, len(r.b)):]
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	msg.Encoding = dcsEncoding(dcs)
+	text, concat, err := decodeUserData(ud, udl, udhi, msg.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	msg.Text, msg.Concat = text, concat
+	return msg, nil
+}
+
+// DecodeStatusReport decodes a hex encoded SMS-STATUS-REPORT as delivered by
+// the +CDS URC. withSMSC tells whether the PDU starts with an SMSC address.
+func DecodeStatusReport(pduHex string, withSMSC bool) (*StatusReport, error) {
+	data, err := hex.DecodeString(strings.TrimSpace(pduHex))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PDU hex: %w", err)
+	}
+	r := &reader{b: data}
+	if withSMSC {
+		readSMSC(r)
+	}
+	first := r.byte()
+	if first&0x03 != 0x02 {
+		return nil, fmt.Errorf("not an SMS-STATUS-REPORT (first octet 0x%02X)", first)
+	}
+	rep := &StatusReport{}
+	rep.Reference = int(r.byte())
+	rep.Recipient = readAddress(r)
+	rep.Timestamp = readTimestamp(r)
+	rep.Discharged = readTimestamp(r)
+	rep.Status = int(r.byte())
+	if r.err != nil {
+		return nil, r.err
+	}
+	return rep, nil
+}
+
+func readSMSC(r *reader) string {
+	n := int(r.byte())
+	if n == 0 {
+		return ""
+	}
+	b := r.next(n)
+	if b == nil {
+		return ""
+	}
+	return decodeNumber(b[0], b[1:], (n-1)*2)
+}
+
+func readAddress(r *reader) string {
+	digits := int(r.byte())
+	toa := r.byte()
+	b := r.next((digits + 1) / 2)
+	if b == nil {
+		return ""
+	}
+	return decodeNumber(toa, b, digits)
+}
+
+func decodeNumber(toa byte, b []byte, digits int) string {
+	if toa&0x70 == 0x50 { // alphanumeric, GSM 7-bit packed
+		return decodeGSM7(unpackSeptets(b, digits*4/7))
+	}
+	var sb strings.Builder
+	if toa&0x70 == 0x10 {
+		sb.WriteByte('+')
+	}
+	n := 0
+	for _, c := range b {
+		for _, nibble := range []byte{c & 0x0F, c >> 4} {
+			if nibble == 0x0F || n >= digits {
+				continue
+			}
+			sb.WriteByte("0123456789*#abc"[nibble])
+			n++
+		}
+	}
+	return sb.String()
+}
+
+func readTimestamp(r *reader) time.Time {
+	b := r.next(7)
+	if b == nil {
+		return time.Time{}
+	}
+	bcd := func(c byte) int { return int(c&0x0F)*10 + int(c>>4) }
+	tz := int(b[6]&0x07)*10 + int(b[6]>>4) // quarter hours
+	if b[6]&0x08 != 0 {
+		tz = -tz
+	}
+	loc := time.FixedZone("", tz*15*60)
+	return time.Date(2000+bcd(b[0]), time.Month(bcd(b[1])), bcd(b[2]), bcd(b[3]), bcd(b[4]), bcd(b[5]), 0, loc)
+}
+
+// dcsEncoding extracts the alphabet from a data coding scheme (TS 23.038).
+func dcsEncoding(dcs byte) Encoding {
+	switch {
+	case dcs&0xC0 == 0x00, dcs&0xC0 == 0x40: // general data coding
+		switch dcs & 0x0C {
+		case 0x04:
+			return Binary8
+		case 0x08:
+			return UCS2
+		}
+	case dcs&0xF0 == 0xE0: // message waiting, UCS2
+		return UCS2
+	case dcs&0xF0 == 0xF0: // data coding / message class
+		if dcs&0x04 != 0 {
+			return Binary8
+		}
+	}
+	return GSM7
+}
+
+func decodeUserData(ud []byte, udl int, udhi bool, enc Encoding) (string, *Concat, error) {
+	var concat *Concat
+	headerOctets := 0
+	if udhi {
+		if len(ud) == 0 {
+			return "", nil, errors.New("missing user data header")
+		}
+		headerOctets = int(ud[0]) + 1
+		if headerOctets > len(ud) {
+			return "", nil, errors.New("user data header truncated")
+		}
+		concat = parseConcat(ud[1:headerOctets])
+	}
+
+	end := max(headerOctets, min(udl, len(ud)))
+	switch enc {
+	case GSM7:
+		skip := (headerOctets*8 + 6) / 7
+		septets := unpackSeptets(ud, udl)
+		if skip > len(septets) {
+			skip = len(septets)
+		}
+		return decodeGSM7(septets[skip:]), concat, nil
+	case UCS2:
+		body := ud[headerOctets:end]
+		units := make([]uint16, len(body)/2)
+		for i := range units {
+			units[i] = uint16(body[2*i])<<8 | uint16(body[2*i+1])
+		}
+		return string(utf16.Decode(units)), concat, nil
+	default:
+		return hex.EncodeToString(ud[headerOctets:end]), concat, nil
+	}
+}
+
+// parseConcat finds the concatenation information element in a UDH.
+func parseConcat(udh []byte) *Concat {
+	for i := 0; i+1 < len(udh); {
+		iei, n := udh[i], int(udh[i+1])
+		ie := udh[i+2 : min(i+2+n, len(udh))]
+		switch {
+		case iei == concatIE8Bit && len(ie) == 3:
+			return &Concat{Ref: int(ie[0]), Total: int(ie[1]), Seq: int(ie[2])}
+		case iei == concatIE16 && len(ie) == 4:
+			return &Concat{Ref: int(ie[0])<<8 | int(ie[1]), Total: int(ie[2]), Seq: int(ie[3])}
+		}
+		i += 2 + n
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package pdu
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name   string
+		pdu    string
+		smsc   string
+		sender string
+		text   string
+		enc    Encoding
+		ts     time.Time
+		concat *Concat
+	}{
+		{
+			name:   "GSM-7",
+			pdu:    "07911326040000F0040B911346610089F60000208062917314080CC8F71D14969741F977FD07",
+			smsc:   "+31624000000",
+			sender: "+31641600986",
+			text:   "How are you?",
+			enc:    GSM7,
+			ts:     time.Date(2002, 8, 26, 19, 37, 41, 0, time.UTC),
+		},
+		{
+			name:   "UCS-2 part with concatenation header",
+			pdu:    "0044058101" + "80F6" + "0008" + "20806291731408" + "0A" + "0500032A0201" + "4F60597D",
+			sender: "10086",
+			text:   "你好",
+			enc:    UCS2,
+			ts:     time.Date(2002, 8, 26, 19, 37, 41, 0, time.UTC),
+			concat: &Concat{Ref: 0x2A, Total: 2, Seq: 1},
+		},
+		{
+			name:   "alphanumeric sender",
+			pdu:    "0004" + "07D0" + "D4F29C0E" + "0000" + "20806291731408" + "02" + "E834",
+			sender: "Test",
+			text:   "hi",
+			enc:    GSM7,
+			ts:     time.Date(2002, 8, 26, 19, 37, 41, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		msg, err := Decode(tt.pdu)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if msg.SMSC != tt.smsc || msg.Sender != tt.sender || msg.Text != tt.text || msg.Encoding != tt.enc {
+			t.Errorf("%s: got SMSC %q, sender %q, text %q, encoding %v", tt.name, msg.SMSC, msg.Sender, msg.Text, msg.Encoding)
+		}
+		if !msg.Timestamp.Equal(tt.ts) {
+			t.Errorf("%s: timestamp = %v, want %v", tt.name, msg.Timestamp, tt.ts)
+		}
+		switch {
+		case tt.concat == nil && msg.Concat != nil:
+			t.Errorf("%s: concat = %+v, want none", tt.name, *msg.Concat)
+		case tt.concat != nil && (msg.Concat == nil || *msg.Concat != *tt.concat):
+			t.Errorf("%s: concat = %v, want %+v", tt.name, msg.Concat, *tt.concat)
+		}
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	for _, pdu := range []string{
+		"zz",
+		"07911326040000F0040B911346610089F6000020806291",        // truncated
+		"07911326040000F0010B911346610089F60000208062917314080", // odd hex
+		"0001000581" + "0180F6" + "0000" + "00",                 // SMS-SUBMIT
+	} {
+		if _, err := Decode(pdu); err == nil {
+			t.Errorf("Decode(%q) succeeded", pdu)
+		}
+	}
+}
+
+// submitUDL returns the TP-UDL of an encoded SMS-SUBMIT and checks that its
+// Length matches the TPDU.
+func submitUDL(t *testing.T, s Submit) int {
+	t.Helper()
+	data, err := hex.DecodeString(s.Hex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != 0x00 {
+		t.Fatalf("PDU %s does not start with an empty SMSC field", s.Hex)
+	}
+	tpdu := data[1:]
+	if s.Length != len(tpdu) {
+		t.Errorf("Length = %d, want %d", s.Length, len(tpdu))
+	}
+	digits := int(tpdu[2])
+	return int(tpdu[4+(digits+1)/2+2])
+}
+
+func TestEncodeSubmit(t *testing.T) {
+	tests := []struct {
+		name   string
+		number string
+		text   string
+		ref    byte
+		report bool
+		want   []string
+	}{
+		{
+			name:   "GSM-7",
+			number: "+31641600986",
+			text:   "How are you?",
+			want:   []string{"0001000B911346610089F600000CC8F71D14969741F977FD07"},
+		},
+		{
+			name:   "GSM-7 with status report",
+			number: "10086",
+			text:   "CXLL",
+			report: true,
+			want:   []string{"0021000581" + "0180F6" + "0000" + "04" + "432C9309"},
+		},
+		{
+			name:   "UCS-2",
+			number: "10086",
+			text:   "你好",
+			want:   []string{"0001000581" + "0180F6" + "0008" + "04" + "4F60597D"},
+		},
+		{
+			name:   "UCS-2 concatenated",
+			number: "10086",
+			text:   strings.Repeat("你", 71),
+			ref:    0x2A,
+			want: []string{
+				"0041000581" + "0180F6" + "0008" + "8C" + "0500032A0201" + strings.Repeat("4F60", 67),
+				"0041000581" + "0180F6" + "0008" + "0E" + "0500032A0202" + strings.Repeat("4F60", 4),
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, err := EncodeSubmit(tt.number, tt.text, tt.ref, tt.report)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %d parts, want %d", tt.name, len(got), len(tt.want))
+			continue
+		}
+		for i, s := range got {
+			if s.Hex != tt.want[i] {
+				t.Errorf("%s: part %d = %s, want %s", tt.name, i+1, s.Hex, tt.want[i])
+			}
+			submitUDL(t, s)
+		}
+	}
+}
+
+func TestEncodeSubmitSplitsGSM7(t *testing.T) {
+	text := strings.Repeat("0123456789", 17)
+	parts, err := EncodeSubmit("+8613800138000", text, 7, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("got %d parts, want 2", len(parts))
+	}
+	// Seven septets of every part are taken by the concatenation header.
+	for i, want := range []int{0xA0, 7 + 17} {
+		if udl := submitUDL(t, parts[i]); udl != want {
+			t.Errorf("part %d: UDL = %#x, want %#x", i+1, udl, want)
+		}
+		if udh := parts[i].Hex[2*(1+4+7+3) : 2*(1+4+7+3+6)]; udh != "05000307020"+string(rune('1'+i)) {
+			t.Errorf("part %d: UDH = %s", i+1, udh)
+		}
+	}
+}
+
+func TestEncodeSubmitRejectsNumber(t *testing.T) {
+	for _, number := range []string{"", "+", "10086a", "+86 138"} {
+		if _, err := EncodeSubmit(number, "hi", 0, false); err == nil {
+			t.Errorf("EncodeSubmit(%q) succeeded", number)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want Info
+	}{
+		{"empty", "", Info{Encoding: GSM7, Segments: 1, PerSegment: 160}},
+		{"single GSM-7", strings.Repeat("a", 160), Info{Encoding: GSM7, Units: 160, Segments: 1, PerSegment: 160}},
+		{"two GSM-7 segments", strings.Repeat("a", 170), Info{Encoding: GSM7, Units: 170, Segments: 2, PerSegment: 153}},
+		{"extension characters count twice", "€{}", Info{Encoding: GSM7, Units: 6, Segments: 1, PerSegment: 160, Extended: 3}},
+		{"extension characters fill a segment", strings.Repeat("€", 80), Info{Encoding: GSM7, Units: 160, Segments: 1, PerSegment: 160, Extended: 80}},
+		{"extension characters overflow a segment", strings.Repeat("€", 81), Info{Encoding: GSM7, Units: 162, Segments: 2, PerSegment: 153, Extended: 81}},
+		{"UCS-2", "你好你", Info{Encoding: UCS2, Units: 3, Segments: 1, PerSegment: 70, NonGSM: []rune{'你', '好'}}},
+		{"two UCS-2 segments", strings.Repeat("你", 71), Info{Encoding: UCS2, Units: 71, Segments: 2, PerSegment: 67, NonGSM: []rune{'你'}}},
+		{"surrogate pairs", "😀", Info{Encoding: UCS2, Units: 2, Segments: 1, PerSegment: 70, NonGSM: []rune{'😀'}}},
+	}
+	for _, tt := range tests {
+		got := Analyze(tt.text)
+		if got.Encoding != tt.want.Encoding || got.Units != tt.want.Units || got.Segments != tt.want.Segments ||
+			got.PerSegment != tt.want.PerSegment || got.Extended != tt.want.Extended || string(got.NonGSM) != string(tt.want.NonGSM) {
+			t.Errorf("%s: Analyze = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSplitKeepsEscapeWithCharacter(t *testing.T) {
+	septets, ok := toSeptets(strings.Repeat("a", 152) + "€" + strings.Repeat("a", 10))
+	if !ok {
+		t.Fatal("text is not GSM-7")
+	}
+	parts := splitSeptets(septets, gsm7Single, gsm7Multi)
+	if len(parts) != 2 || len(parts[0]) != 152 || len(parts[1]) != 12 {
+		t.Fatalf("split into %d parts of %d and %d septets", len(parts), len(parts[0]), len(parts[len(parts)-1]))
+	}
+	if decodeGSM7(parts[1])[0] != "€"[0] {
+		t.Errorf("second part starts with %q, want the escaped €", decodeGSM7(parts[1]))
+	}
+}
+
+func TestGSM7RoundTrip(t *testing.T) {
+	for _, text := range []string{"", "@", "How are you?", "1234567", "12345678", "[x] ~ {y} € ^|\\", "Ñandu ß Δ"} {
+		septets, ok := toSeptets(text)
+		if !ok {
+			t.Errorf("%q is not GSM-7", text)
+			continue
+		}
+		for _, skip := range []int{0, 7} {
+			packed := packSeptets(septets, skip)
+			if got := decodeGSM7(unpackSeptets(packed, skip+len(septets))[skip:]); got != text {
+				t.Errorf("round trip of %q with %d septets skipped = %q", text, skip, got)
+			}
+		}
+	}
+}
+
+func TestDecodeStatusReport(t *testing.T) {
+	tests := []struct {
+		name      string
+		pdu       string
+		withSMSC  bool
+		delivered bool
+		failed    bool
+		status    int
+	}{
+		{"delivered", "07911326040000F0" + "062A" + "0B911346610089F6" + "20806291731408" + "20806291732408" + "00", true, true, false, 0x00},
+		{"pending", "062A" + "0B911346610089F6" + "20806291731408" + "20806291732408" + "20", false, false, false, 0x20},
+		{"failed", "00" + "062A" + "0B911346610089F6" + "20806291731408" + "20806291732408" + "41", true, false, true, 0x41},
+	}
+	for _, tt := range tests {
+		rep, err := DecodeStatusReport(tt.pdu, tt.withSMSC)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if rep.Reference != 0x2A || rep.Recipient != "+31641600986" || rep.Status != tt.status {
+			t.Errorf("%s: report = %+v", tt.name, rep)
+		}
+		if rep.Delivered() != tt.delivered || rep.Failed() != tt.failed {
+			t.Errorf("%s: Delivered = %v, Failed = %v", tt.name, rep.Delivered(), rep.Failed())
+		}
+		if want := time.Date(2002, 8, 26, 19, 37, 42, 0, time.UTC); !rep.Discharged.Equal(want) {
+			t.Errorf("%s: discharged = %v, want %v", tt.name, rep.Discharged, want)
+		}
+	}
+
+	// An SMS-DELIVER is not a status report.
+	if _, err := DecodeStatusReport("07911326040000F0040B911346610089F60000208062917314080CC8F71D14969741F977FD07", true); err == nil {
+		t.Error("DecodeStatusReport accepted an SMS-DELIVER")
+	}
+}
+
+func TestStatusText(t *testing.T) {
+	for _, status := range []int{0x00, 0x20, 0x41, 0x64, 0x7F} {
+		if StatusText(status) == "" {
+			t.Errorf("StatusText(%#x) is empty", status)
+		}
+	}
+	if !StatusPending(0x20) || StatusPending(0x00) || StatusPending(0x41) {
+		t.Error("StatusPending misclassifies statuses")
+	}
+}
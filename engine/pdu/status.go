@@ -0,0 +1,58 @@
+package pdu
+
+import "fmt"
+
+// statusTexts describes the TP-Status values defined in 3GPP TS 23.040
+// 9.2.3.15. ModemManager reports the same values as DeliveryState.
+var statusTexts = map[int]string{
+	0x00: "received by the recipient",
+	0x01: "forwarded, delivery not confirmed",
+	0x02: "replaced by the SC",
+
+	0x20: "congestion, still trying",
+	0x21: "recipient busy, still trying",
+	0x22: "no response from recipient, still trying",
+	0x23: "service rejected, still trying",
+	0x24: "quality of service not available, still trying",
+	0x25: "error in recipient, still trying",
+
+	0x40: "remote procedure error",
+	0x41: "incompatible destination",
+	0x42: "connection rejected by recipient",
+	0x43: "not obtainable",
+	0x44: "quality of service not available",
+	0x45: "no interworking available",
+	0x46: "validity period expired",
+	0x47: "deleted by sender",
+	0x48: "deleted by SC administration",
+	0x49: "message does not exist",
+
+	0x60: "congestion",
+	0x61: "recipient busy",
+	0x62: "no response from recipient",
+	0x63: "service rejected",
+	0x64: "quality of service not available",
+	0x65: "error in recipient",
+}
+
+// StatusText describes a TP-Status value, falling back to its class for
+// reserved and SC specific values.
+func StatusText(status int) string {
+	if text, ok := statusTexts[status]; ok {
+		return text
+	}
+	switch {
+	case status < 0x20:
+		return fmt.Sprintf("delivered (0x%02X)", status)
+	case status < 0x40:
+		return fmt.Sprintf("temporary error, still trying (0x%02X)", status)
+	default:
+		return fmt.Sprintf("delivery failed (0x%02X)", status)
+	}
+}
+
+// StatusPending reports whether the SC is still trying to deliver the
+// message after a temporary error.
+func StatusPending(status int) bool {
+	return status >= 0x20 && status < 0x40
+}
@@ -0,0 +1,228 @@
+package pdu
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// Submit is one encoded SMS-SUBMIT ready for AT+CMGS.
+type Submit struct {
+	// Hex is the PDU including an empty SMSC field ("00...").
+	Hex string
+	// Length is the TPDU length in octets, excluding the SMSC field, as
+	// expected by AT+CMGS=<length>.
+	Length int
+}
+
+// Info describes how a text would be sent.
+type Info struct {
+	Encoding Encoding
+	// Units is the length of the text in septets (GSM-7, extension table
+	// characters count twice) or UTF-16 code units (UCS-2).
+	Units    int
+	Segments int
+	// PerSegment is the capacity of each segment in the same units.
+	PerSegment int
+	// Extended is the number of GSM-7 extension table characters, such
+	// as '€' or '{', in a GSM-7 text.
+	Extended int
+	// NonGSM lists the distinct characters that make a text UCS-2, in
+	// order of appearance.
+	NonGSM []rune
+}
+
+// Analyze reports the encoding and number of segments needed for text.
+func Analyze(text string) Info {
+	if septets, ok := toSeptets(text); ok {
+		info := Info{Encoding: GSM7, Units: len(septets), PerSegment: gsm7Single}
+		info.Segments = len(splitSeptets(septets, gsm7Single, gsm7Multi))
+		if info.Segments > 1 {
+			info.PerSegment = gsm7Multi
+		}
+		for _, r := range text {
+			if _, ok := gsm7ExtIndex[r]; ok {
+				info.Extended++
+			}
+		}
+		return info
+	}
+	units := utf16.Encode([]rune(text))
+	info := Info{Encoding: UCS2, Units: len(units), PerSegment: ucs2Single}
+	seen := make(map[rune]bool)
+	for _, r := range text {
+		if _, ok := gsm7Septets(r); !ok && !seen[r] {
+			seen[r] = true
+			info.NonGSM = append(info.NonGSM, r)
+		}
+	}
+	info.Segments = len(splitUCS2(units, ucs2Single, ucs2Multi))
+	if info.Segments > 1 {
+		info.PerSegment = ucs2Multi
+	}
+	return info
+}
+
+// EncodeSubmit encodes text to number as one or more SMS-SUBMIT PDUs, using
+// GSM-7 when possible and UCS-2 otherwise. ref is the concatenation
+// reference shared by all parts of a long message.
+func EncodeSubmit(number, text string, ref byte, statusReport bool) ([]Submit, error) {
+	addr, err := encodeAddress(number)
+	if err != nil {
+		return nil, err
+	}
+
+	var parts [][]byte // user data of each part, without header
+	var dcs byte
+	var udLen []int // UDL of each part, without header
+	if septets, ok := toSeptets(text); ok {
+		for _, p := range splitSeptets(septets, gsm7Single, gsm7Multi) {
+			parts = append(parts, p)
+			udLen = append(udLen, len(p))
+		}
+	} else {
+		dcs = 0x08
+		for _, p := range splitUCS2(utf16.Encode([]rune(text)), ucs2Single, ucs2Multi) {
+			b := make([]byte, 0, len(p)*2)
+			for _, u := range p {
+				b = append(b, byte(u>>8), byte(u))
+			}
+			parts = append(parts, b)
+			udLen = append(udLen, len(b))
+		}
+	}
+	if len(parts) > maxSegments {
+		return nil, fmt.Errorf("message too long: %d segments", len(parts))
+	}
+
+	out := make([]Submit, 0, len(parts))
+	for i, part := range parts {
+		first := byte(0x01) // SMS-SUBMIT, no validity period
+		if statusReport {
+			first |= 0x20
+		}
+		var udh []byte
+		if len(parts) > 1 {
+			first |= 0x40
+			udh = []byte{0x05, concatIE8Bit, 0x03, ref, byte(len(parts)), byte(i + 1)}
+		}
+
+		tpdu := []byte{first, 0x00} // TP-MR is assigned by the modem
+		tpdu = append(tpdu, addr...)
+		tpdu = append(tpdu, 0x00, dcs) // TP-PID, TP-DCS
+
+		if dcs == 0x00 {
+			skip := (len(udh)*8 + 6) / 7
+			ud := packSeptets(part, skip)
+			copy(ud, udh)
+			tpdu = append(tpdu, byte(skip+udLen[i]))
+			tpdu = append(tpdu, ud...)
+		} else {
+			tpdu = append(tpdu, byte(len(udh)+udLen[i]))
+			tpdu = append(tpdu, udh...)
+			tpdu = append(tpdu, part...)
+		}
+
+		out = append(out, Submit{
+			Hex:    "00" + strings.ToUpper(hex.EncodeToString(tpdu)),
+			Length: len(tpdu),
+		})
+	}
+	return out, nil
+}
+
+// toSeptets converts text to GSM-7 septets, or reports false if it contains
+// characters outside the default alphabet and its extension table.
+func toSeptets(text string) ([]byte, bool) {
+	out := make([]byte, 0, len(text))
+	for _, r := range text {
+		s, ok := gsm7Septets(r)
+		if !ok {
+			return nil, false
+		}
+		out = append(out, s...)
+	}
+	return out, true
+}
+
+// splitSeptets splits septets into segments without separating an escape
+// septet from the character that follows it.
+func splitSeptets(septets []byte, single, multi int) [][]byte {
+	if len(septets) <= single {
+		return [][]byte{septets}
+	}
+	var out [][]byte
+	for len(septets) > 0 {
+		n := min(multi, len(septets))
+		if n < len(septets) && septets[n-1] == gsm7Escape && !escapedAt(septets, n-1) {
+			n--
+		}
+		out = append(out, septets[:n])
+		septets = septets[n:]
+	}
+	return out
+}
+
+// escapedAt reports whether septets[i] is the character following an escape
+// rather than an escape itself.
+func escapedAt(septets []byte, i int) bool {
+	n := 0
+	for j := i - 1; j >= 0 && septets[j] == gsm7Escape; j-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// splitUCS2 splits UTF-16 code units into segments without separating
+// surrogate pairs.
+func splitUCS2(units []uint16, single, multi int) [][]uint16 {
+	if len(units) <= single {
+		return [][]uint16{units}
+	}
+	var out [][]uint16
+	for len(units) > 0 {
+		n := min(multi, len(units))
+		if n < len(units) && utf16.IsSurrogate(rune(units[n-1])) && units[n-1] < 0xDC00 {
+			n--
+		}
+		out = append(out, units[:n])
+		units = units[n:]
+	}
+	return out
+}
+
+// encodeAddress encodes a destination address (TP-DA).
+func encodeAddress(number string) ([]byte, error) {
+	number = strings.TrimSpace(number)
+	toa := byte(0x81) // unknown numbering plan
+	if strings.HasPrefix(number, "+") {
+		toa = 0x91 // international
+		number = number[1:]
+	}
+	if number == "" || strings.Trim(number, "0123456789*#") != "" {
+		return nil, errors.New("invalid phone number")
+	}
+	digits := []byte(number)
+	out := []byte{byte(len(digits)), toa}
+	for i := 0; i < len(digits); i += 2 {
+		lo := bcdDigit(digits[i])
+		hi := byte(0x0F)
+		if i+1 < len(digits) {
+			hi = bcdDigit(digits[i+1])
+		}
+		out = append(out, hi<<4|lo)
+	}
+	return out, nil
+}
+
+func bcdDigit(c byte) byte {
+	switch c {
+	case '*':
+		return 0x0A
+	case '#':
+		return 0x0B
+	}
+	return c - '0'
+}
@@ -0,0 +1,54 @@
+package pdu
+
+import "strings"
+
+// gsm7Substitutes maps common characters outside the GSM 03.38 alphabet to
+// GSM-7 text that looks alike.
+var gsm7Substitutes = map[rune]string{
+	// Typographic punctuation.
+	'‘': "'", '’': "'", '‚': "'", '′': "'", '`': "'", '´': "'",
+	'“': `"`, '”': `"`, '„': `"`, '″': `"`, '«': `"`, '»': `"`,
+	'‐': "-", '‑': "-", '‒': "-", '–': "-", '—': "-", '―': "-", '−': "-",
+	'…': "...", '•': "*", '·': ".", '\t': " ", '\u00a0': " ", '\u2009': " ",
+	'\u200b': "", '\ufeff': "", // zero width space, byte order mark
+	// Full-width CJK punctuation.
+	'，': ",", '。': ".", '、': ",", '：': ":", '；': ";", '！': "!", '？': "?",
+	'（': "(", '）': ")", '【': "[", '】': "]", '《': "<", '》': ">",
+	'「': `"`, '」': `"`, '『': `"`, '』': `"`, '～': "~", '　': " ",
+	// Latin letters with diacritics missing from the alphabet.
+	'á': "a", 'â': "a", 'ã': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'Á': "A", 'À': "A", 'Â': "A", 'Ã': "A", 'Ā': "A", 'Ă': "A", 'Ą': "A",
+	'ç': "Ç", 'ć': "c", 'č': "c", 'Ć': "C", 'Č': "C",
+	'ď': "d", 'Ď': "D", 'đ': "d", 'Đ': "D",
+	'ê': "e", 'ë': "e", 'ē': "e", 'ę': "e", 'ě': "e",
+	'È': "E", 'Ê': "E", 'Ë': "E", 'Ē': "E", 'Ę': "E", 'Ě': "E",
+	'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'Í': "I", 'Ì': "I", 'Î': "I", 'Ï': "I", 'Ī': "I",
+	'ł': "l", 'Ł': "L", 'ń': "n", 'ň': "n", 'Ń': "N", 'Ň': "N",
+	'ó': "o", 'ô': "o", 'õ': "o", 'ō': "o", 'ő': "o",
+	'Ó': "O", 'Ò': "O", 'Ô': "O", 'Õ': "O", 'Ō': "O", 'Ő': "O",
+	'ř': "r", 'Ř': "R", 'ś': "s", 'š': "s", 'ş': "s", 'Ś': "S", 'Š': "S", 'Ş': "S",
+	'ť': "t", 'Ť': "T", 'ú': "u", 'û': "u", 'ū': "u", 'ů': "u", 'ű': "u",
+	'Ú': "U", 'Ù': "U", 'Û': "U", 'Ū': "U", 'Ů': "U", 'Ű': "U",
+	'ý': "y", 'ÿ': "y", 'Ý': "Y", 'ź': "z", 'ż': "z", 'ž': "z", 'Ź': "Z", 'Ż': "Z", 'Ž': "Z",
+}
+
+// Transliterate rewrites text so that it can be sent as GSM-7: characters
+// outside the alphabet are replaced by look-alikes and those without one by
+// '?'. It returns the new text and the number of characters replaced by '?'.
+func Transliterate(text string) (string, int) {
+	var b strings.Builder
+	lost := 0
+	for _, r := range text {
+		if _, ok := gsm7Septets(r); ok {
+			b.WriteRune(r)
+			continue
+		}
+		if sub, ok := gsm7Substitutes[r]; ok {
+			b.WriteString(sub)
+			continue
+		}
+		b.WriteByte('?')
+		lost++
+	}
+	return b.String(), lost
+}
@@ -6,9 +6,8 @@ require (
 	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
 	github.com/godbus/dbus/v5 v5.1.0
 	go.bug.st/serial v1.6.4
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/sys v0.29.0
 )
 
-require (
-	github.com/creack/goselect v0.1.2 // indirect
-	golang.org/x/sys v0.19.0 // indirect
-)
+require github.com/creack/goselect v0.1.2 // indirect
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// contactBucket 以 E.164 格式的号码为 key 保存联系人
+var contactBucket = []byte("contacts")
+
+// DefaultCountryCode 为默认的国家或地区电话区号 (如 86), 用于将本地号码转换为 E.164 格式。
+// 为空时只转换带国际前缀的号码。
+var DefaultCountryCode string
+
+// minNationalNumber 为本地号码的最小位数, 更短的号码 (如 10086、95588) 为服务号码, 不加区号
+const minNationalNumber = 7
+
+// Contact 为通讯录中的一个联系人
+type Contact struct {
+	Name    string    `json:"name"`
+	Number  string    `json:"number"` // E.164 格式, 服务号码和字母发件人保持原样
+	Created time.Time `json:"created"`
+}
+
+// NormalizeNumber 将号码转换为 E.164 格式: 去掉空格和分隔符, 00 前缀改为 +, 本地号码去掉开头的 0
+// 并加上 DefaultCountryCode。服务号码和字母发件人原样返回。
+func NormalizeNumber(number string) string {
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(number) {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && b.Len() == 0:
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+		default:
+			return strings.TrimSpace(number)
+		}
+	}
+	digits, cc := b.String(), DefaultCountryCode
+	switch {
+	case digits == "" || digits == "+":
+		return strings.TrimSpace(number)
+	case strings.HasPrefix(digits, "+"):
+		return digits
+	case strings.HasPrefix(digits, "00"):
+		return "+" + digits[2:]
+	case cc == "" || len(digits) < minNationalNumber:
+		return digits
+	case strings.HasPrefix(digits, "0"):
+		return "+" + cc + digits[1:]
+	case strings.HasPrefix(digits, cc) && len(digits) > 10:
+		// 已带区号但没有 +, 如 8613800138000
+		return "+" + digits
+	}
+	return "+" + cc + digits
+}
+
+// SetContacts 保存联系人, 号码转换为 E.164 格式, 同一号码的联系人会被替换
+func (s *Store) SetContacts(contacts []Contact) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(contactBucket)
+		for i := range contacts {
+			c := &contacts[i]
+			c.Number = NormalizeNumber(c.Number)
+			if c.Created.IsZero() {
+				c.Created = time.Now()
+			}
+			data, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(c.Number), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Contacts 按名称顺序返回所有联系人
+func (s *Store) Contacts() ([]Contact, error) {
+	var contacts []Contact
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(contactBucket).ForEach(func(k, v []byte) error {
+			var c Contact
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			contacts = append(contacts, c)
+			return nil
+		})
+	})
+	sort.SliceStable(contacts, func(i, j int) bool {
+		return strings.ToLower(contacts[i].Name) < strings.ToLower(contacts[j].Name)
+	})
+	return contacts, err
+}
+
+// ContactName 返回号码对应的联系人名称, 没有时为空
+func (s *Store) ContactName(number string) string {
+	if s == nil || number == "" {
+		return ""
+	}
+	var c Contact
+	s.db.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket(contactBucket).Get([]byte(NormalizeNumber(number))); data != nil {
+			json.Unmarshal(data, &c)
+		}
+		return nil
+	})
+	return c.Name
+}
+
+// markdownEscaper 转义 Telegram Markdown 中有特殊含义的字符
+var markdownEscaper = strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[")
+
+// DescribeNumber 以 Markdown 显示号码, 有联系人时在前面加上名称, 如 "张三 `+8613800138000`"
+func (s *Store) DescribeNumber(number string) string {
+	code := "`" + strings.ReplaceAll(number, "`", "'") + "`"
+	if name := s.ContactName(number); name != "" {
+		return markdownEscaper.Replace(name) + " " + code
+	}
+	return code
+}
+
+// FindContacts 按名称查找联系人 (不区分大小写): 有完全相同的名称时只返回这些联系人,
+// 否则返回名称以 name 开头的联系人
+func (s *Store) FindContacts(name string) ([]Contact, error) {
+	contacts, err := s.Contacts()
+	if err != nil {
+		return nil, err
+	}
+	var exact, prefix []Contact
+	for _, c := range contacts {
+		switch {
+		case strings.EqualFold(c.Name, name):
+			exact = append(exact, c)
+		case strings.HasPrefix(strings.ToLower(c.Name), strings.ToLower(name)):
+			prefix = append(prefix, c)
+		}
+	}
+	if len(exact) > 0 {
+		return exact, nil
+	}
+	return prefix, nil
+}
+
+// RemoveContact 删除号码对应的联系人, 不存在时返回 ErrNotFound
+func (s *Store) RemoveContact(number string) error {
+	key := []byte(NormalizeNumber(number))
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(contactBucket)
+		if b.Get(key) == nil {
+			return ErrNotFound
+		}
+		return b.Delete(key)
+	})
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeNumber(t *testing.T) {
+	defer func(cc string) { DefaultCountryCode = cc }(DefaultCountryCode)
+
+	tests := []struct {
+		cc, number, want string
+	}{
+		// International numbers.
+		{"86", "+8613800138000", "+8613800138000"},
+		{"86", "+86 138-0013-8000", "+8613800138000"},
+		{"86", "008613800138000", "+8613800138000"},
+		{"86", "0044 20 7946 0958", "+442079460958"},
+		{"86", "8613800138000", "+8613800138000"},
+		// National numbers.
+		{"86", "13800138000", "+8613800138000"},
+		{"86", "138.0013.8000", "+8613800138000"},
+		{"86", "010-12345678", "+861012345678"},
+		{"86", "(021) 5555 1234", "+862155551234"},
+		{"44", "020 7946 0958", "+442079460958"},
+		// Short service numbers.
+		{"86", "10086", "10086"},
+		{"86", "95588", "95588"},
+		{"86", "12306", "12306"},
+		{"86", " 10086 ", "10086"},
+		// Alphanumeric senders and other text.
+		{"86", "Alipay", "Alipay"},
+		{"86", "China Mobile", "China Mobile"},
+		{"86", "1+2", "1+2"},
+		{"86", "+", "+"},
+		{"86", "", ""},
+		// Without a default country code only international prefixes are converted.
+		{"", "13800138000", "13800138000"},
+		{"", "0138 0013 8000", "013800138000"},
+		{"", "008613800138000", "+8613800138000"},
+		{"", "+86 138 0013 8000", "+8613800138000"},
+	}
+	for _, tt := range tests {
+		DefaultCountryCode = tt.cc
+		if got := NormalizeNumber(tt.number); got != tt.want {
+			t.Errorf("NormalizeNumber(%q) with country code %q = %q, want %q", tt.number, tt.cc, got, tt.want)
+		}
+	}
+}
+
+func TestDescribeNumber(t *testing.T) {
+	var none *Store
+	if got := none.DescribeNumber("+8613800138000"); got != "`+8613800138000`" {
+		t.Errorf("DescribeNumber without a store = %q", got)
+	}
+
+	s, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.SetContacts([]Contact{{Name: "张_三*", Number: "+8613800138000"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := s.DescribeNumber("+8613800138000"), "张\\_三\\* `+8613800138000`"; got != want {
+		t.Errorf("DescribeNumber = %q, want %q", got, want)
+	}
+	if got := s.DescribeNumber("10086"); got != "`10086`" {
+		t.Errorf("DescribeNumber without a contact = %q", got)
+	}
+	if got := s.DescribeNumber("a`b"); got != "`a'b`" {
+		t.Errorf("DescribeNumber with a backtick = %q", got)
+	}
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// groupBucket 以组名为 key 保存群发短信的收件人组
+var groupBucket = []byte("groups")
+
+// Group 为一组群发短信的收件人
+type Group struct {
+	Name    string    `json:"name"`
+	Members []string  `json:"members"`
+	Created time.Time `json:"created"`
+}
+
+// SetGroup 保存收件人组, 同名的组会被替换
+func (s *Store) SetGroup(g *Group) error {
+	if g.Created.IsZero() {
+		g.Created = time.Now()
+	}
+	data, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(groupBucket).Put([]byte(g.Name), data)
+	})
+}
+
+// Group 返回指定名称的收件人组, 不存在时返回 ErrNotFound
+func (s *Store) Group(name string) (*Group, error) {
+	var g Group
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(groupBucket).Get([]byte(name))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &g)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// Groups 按名称顺序返回所有收件人组
+func (s *Store) Groups() ([]Group, error) {
+	var groups []Group
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(groupBucket).ForEach(func(k, v []byte) error {
+			var g Group
+			if err := json.Unmarshal(v, &g); err != nil {
+				return err
+			}
+			groups = append(groups, g)
+			return nil
+		})
+	})
+	return groups, err
+}
+
+// RemoveGroup 删除收件人组, 不存在时返回 ErrNotFound
+func (s *Store) RemoveGroup(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(groupBucket)
+		if b.Get([]byte(name)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete([]byte(name))
+	})
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"encoding/binary"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// messageBucket 记录 Telegram 消息对应的归档短信, 用于回复通知时找到对方号码
+var messageBucket = []byte("messages")
+
+// LinkMessage 记录聊天中的一条 Telegram 消息对应的归档短信
+func (s *Store) LinkMessage(chatID int64, messageID int, smsID uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(messageBucket).Put(messageKey(chatID, messageID), itob(smsID))
+	})
+}
+
+// LinkedSms 返回 Telegram 消息对应的归档短信
+func (s *Store) LinkedSms(chatID int64, messageID int) (*SmsRecord, error) {
+	var id uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(messageBucket).Get(messageKey(chatID, messageID))
+		if v == nil {
+			return ErrNotFound
+		}
+		id = binary.BigEndian.Uint64(v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetSms(id)
+}
+
+func messageKey(chatID int64, messageID int) []byte {
+	k := make([]byte, 16)
+	binary.BigEndian.PutUint64(k, uint64(chatID))
+	binary.BigEndian.PutUint64(k[8:], uint64(messageID))
+	return k
+}
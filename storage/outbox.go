@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var outboxBucket = []byte("outbox")
+
+// OutboxItem 为一条等待推送到 Telegram, 或推送后等待从调制解调器上删除的短信
+type OutboxItem struct {
+	ID          uint64    `json:"id"`   // 同归档短信的 ID
+	Text        string    `json:"text"` // 通知内容 (Markdown)
+	Plain       bool      `json:"plain,omitempty"`
+	Silent      bool      `json:"silent,omitempty"`     // 静默推送
+	Otp         bool      `json:"otp,omitempty"`        // 通知含验证码, 推送后按配置的时间删除
+	Chats       []int64   `json:"chats,omitempty"`      // 尚未推送的聊天, 为空时推送给管理员
+	ModemPath   string    `json:"modem_path,omitempty"` // D-Bus 引擎下调制解调器的对象路径
+	SmsRefs     []string  `json:"sms_refs"`             // 调制解调器上的短信 (长短信为各分段): D-Bus 路径或 AT 存储序号
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+	Delivered   bool      `json:"delivered"`
+	// DeleteAt 为推送成功后删除调制解调器上短信的时间
+	DeleteAt time.Time `json:"delete_at,omitempty"`
+}
+
+// Due 判断该条目是否到了推送或删除的时间
+func (o *OutboxItem) Due(now time.Time) bool {
+	if o.Delivered {
+		return !o.DeleteAt.After(now)
+	}
+	return !o.NextAttempt.After(now)
+}
+
+// PutOutbox 新增或更新一个条目
+func (s *Store) PutOutbox(item *OutboxItem) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(outboxBucket).Put(itob(item.ID), data)
+	})
+}
+
+// UpdateOutbox 修改一个条目, 读取和写回在同一事务中完成
+func (s *Store) UpdateOutbox(id uint64, update func(item *OutboxItem)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(outboxBucket)
+		data := b.Get(itob(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		var item OutboxItem
+		if err := json.Unmarshal(data, &item); err != nil {
+			return err
+		}
+		update(&item)
+		item.ID = id
+		data, err := json.Marshal(&item)
+		if err != nil {
+			return err
+		}
+		return b.Put(itob(id), data)
+	})
+}
+
+// RemoveOutbox 删除一个条目
+func (s *Store) RemoveOutbox(id uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).Delete(itob(id))
+	})
+}
+
+// DueOutbox 按 ID 顺序返回到期的条目
+func (s *Store) DueOutbox(now time.Time) ([]OutboxItem, error) {
+	var items []OutboxItem
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).ForEach(func(k, v []byte) error {
+			var item OutboxItem
+			if err := json.Unmarshal(v, &item); err != nil {
+				return err
+			}
+			if item.Due(now) {
+				items = append(items, item)
+			}
+			return nil
+		})
+	})
+	return items, err
+}
@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// readBucket 记录在 /sms 列表中标记为已读的调制解调器短信, value 为标记的时间
+var readBucket = []byte("read")
+
+// MarkSmsRead 将短信标记为已读, key 由调用方生成, 能够区分调制解调器和短信
+func (s *Store) MarkSmsRead(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data, err := time.Now().MarshalText()
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(readBucket).Put([]byte(key), data)
+	})
+}
+
+// SmsRead 判断短信是否已标记为已读
+func (s *Store) SmsRead(key string) bool {
+	read := false
+	s.db.View(func(tx *bolt.Tx) error {
+		read = tx.Bucket(readBucket).Get([]byte(key)) != nil
+		return nil
+	})
+	return read
+}
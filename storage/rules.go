@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var ruleBucket = []byte("rules")
+
+// Rule 为一条收到短信时的处理规则。所有条件都满足时执行其中的动作, 空条件总是满足。
+type Rule struct {
+	ID uint64 `json:"id"`
+
+	// 条件
+	From   string `json:"from,omitempty"`   // 发件人的正则表达式
+	Text   string `json:"text,omitempty"`   // 内容的正则表达式
+	Modem  string `json:"modem,omitempty"`  // 调制解调器 ID 或 SIM 卡 ICCID (可只写结尾部分)
+	Window string `json:"window,omitempty"` // 本地时间段, 如 "22:00-07:00"
+
+	// 动作
+	Forward []int64  `json:"forward,omitempty"` // 额外推送到这些聊天
+	Drop    bool     `json:"drop,omitempty"`    // 不推送给管理员
+	Reply   string   `json:"reply,omitempty"`   // 自动回复的短信内容
+	Tags    []string `json:"tags,omitempty"`
+	Mute    bool     `json:"mute,omitempty"`    // 静默推送, 不响铃
+	Webhook string   `json:"webhook,omitempty"` // 以 POST 请求推送短信的 URL
+
+	Created time.Time `json:"created"`
+}
+
+// AddRule 保存一条新规则并为其分配 ID
+func (s *Store) AddRule(r *Rule) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ruleBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		r.ID = id
+		if r.Created.IsZero() {
+			r.Created = time.Now()
+		}
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return b.Put(itob(id), data)
+	})
+}
+
+// Rules 按 ID 顺序 (即规则的执行顺序) 返回所有规则
+func (s *Store) Rules() ([]Rule, error) {
+	var rules []Rule
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(ruleBucket).ForEach(func(k, v []byte) error {
+			var r Rule
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			rules = append(rules, r)
+			return nil
+		})
+	})
+	return rules, err
+}
+
+// RemoveRule 删除一条规则, 不存在时返回 ErrNotFound
+func (s *Store) RemoveRule(id uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(ruleBucket)
+		if b.Get(itob(id)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete(itob(id))
+	})
+}
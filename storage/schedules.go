@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var scheduleBucket = []byte("schedules")
+
+// Schedule 为一条定时发送的短信
+type Schedule struct {
+	ID     uint64 `json:"id"`
+	Number string `json:"number"`
+	Text   string `json:"text"`
+	// Cron 为重复发送的 cron 表达式, 为空时只在 NextRun 发送一次
+	Cron    string    `json:"cron,omitempty"`
+	NextRun time.Time `json:"next_run"`
+	// Modem 和 SIM 为创建时使用的调制解调器和 SIM 卡, 发送时仍使用该调制解调器
+	Modem     string    `json:"modem,omitempty"`
+	SIM       string    `json:"sim,omitempty"`
+	Created   time.Time `json:"created"`
+	LastRun   time.Time `json:"last_run,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// AddSchedule 保存一条新的定时短信并为其分配 ID
+func (s *Store) AddSchedule(sc *Schedule) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(scheduleBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		sc.ID = id
+		if sc.Created.IsZero() {
+			sc.Created = time.Now()
+		}
+		data, err := json.Marshal(sc)
+		if err != nil {
+			return err
+		}
+		return b.Put(itob(id), data)
+	})
+}
+
+// Schedules 按 ID 顺序返回所有定时短信
+func (s *Store) Schedules() ([]Schedule, error) {
+	var schedules []Schedule
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(scheduleBucket).ForEach(func(k, v []byte) error {
+			var sc Schedule
+			if err := json.Unmarshal(v, &sc); err != nil {
+				return err
+			}
+			schedules = append(schedules, sc)
+			return nil
+		})
+	})
+	return schedules, err
+}
+
+// UpdateSchedule 修改一条定时短信, 读取和写回在同一事务中完成
+func (s *Store) UpdateSchedule(id uint64, update func(sc *Schedule)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(scheduleBucket)
+		data := b.Get(itob(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		var sc Schedule
+		if err := json.Unmarshal(data, &sc); err != nil {
+			return err
+		}
+		update(&sc)
+		sc.ID = id
+		data, err := json.Marshal(&sc)
+		if err != nil {
+			return err
+		}
+		return b.Put(itob(id), data)
+	})
+}
+
+// RemoveSchedule 删除一条定时短信, 不存在时返回 ErrNotFound
+func (s *Store) RemoveSchedule(id uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(scheduleBucket)
+		if b.Get(itob(id)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete(itob(id))
+	})
+}
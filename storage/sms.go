@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"tg_modem/engine"
+	"time"
+	"unicode"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	smsBucket = []byte("sms")
+	// smsIndexBucket 以 incomingKey 索引收到的短信, 避免同一条短信被重复归档
+	smsIndexBucket = []byte("sms_index")
+)
+
+// ErrNotFound 表示记录不存在
+var ErrNotFound = errors.New("记录不存在")
+
+// Direction 为短信的方向
+type Direction string
+
+const (
+	Incoming Direction = "in"
+	Outgoing Direction = "out"
+)
+
+// 短信的投递状态
+const (
+	StateReceived = "received"
+	StateSent     = "sent"
+	StateFailed   = "failed"
+	// StateDelivered 为收到送达报告的发出短信, 投递失败的报告记为 StateFailed
+	StateDelivered = "delivered"
+)
+
+// SmsRecord 为归档的一条短信
+type SmsRecord struct {
+	ID        uint64    `json:"id"`
+	Direction Direction `json:"direction"`
+	Number    string    `json:"number"` // 收到的短信为发件人, 发出的为收件人
+	Text      string    `json:"text"`
+	Timestamp time.Time `json:"timestamp"` // 短信中心时间戳, 发出的短信为发送时间
+	Archived  time.Time `json:"archived"`
+	SMSC      string    `json:"smsc,omitempty"`
+	Modem     string    `json:"modem,omitempty"` // 收发短信的调制解调器 ID
+	SIM       string    `json:"sim,omitempty"`   // SIM 卡的 ICCID
+	State     string    `json:"state"`
+	Error     string    `json:"error,omitempty"` // 发送失败的原因
+	Tags      []string  `json:"tags,omitempty"`  // 规则添加的标签
+}
+
+// SmsQuery 为查询归档短信的条件, 空条件不参与筛选
+type SmsQuery struct {
+	Number string    // 号码包含此字符串, 忽略号码中的空格和符号
+	Text   string    // 内容包含此字符串, 不区分大小写
+	Since  time.Time // 不早于此时间
+	Offset int
+	Limit  int // 为 0 时返回所有结果
+}
+
+// AddSms 归档一条短信并为其分配 ID
+func (s *Store) AddSms(r *SmsRecord) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return addSms(tx, r)
+	})
+}
+
+// AddIncomingSms 归档一条收到的短信。调制解调器重新出现或 ModemManager 重启后
+// 同一条短信会被再次上报, 这时不重复归档, 返回已有记录的 ID 和 false。
+// 没有短信中心时间戳的短信无法可靠地识别, 总是归档: 宁可重复也不误丢短信。
+func (s *Store) AddIncomingSms(r *SmsRecord) (uint64, bool, error) {
+	if r.Timestamp.IsZero() {
+		if err := s.AddSms(r); err != nil {
+			return 0, false, err
+		}
+		return r.ID, true, nil
+	}
+	key := incomingKey(r)
+	var (
+		existing uint64
+		added    bool
+	)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		index := tx.Bucket(smsIndexBucket)
+		if v := index.Get(key); v != nil {
+			existing = binary.BigEndian.Uint64(v)
+			return nil
+		}
+		if err := addSms(tx, r); err != nil {
+			return err
+		}
+		added = true
+		return index.Put(key, itob(r.ID))
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	if !added {
+		return existing, false, nil
+	}
+	return r.ID, true, nil
+}
+
+func addSms(tx *bolt.Tx, r *SmsRecord) error {
+	if r.Archived.IsZero() {
+		r.Archived = time.Now()
+	}
+	if r.Timestamp.IsZero() {
+		r.Timestamp = r.Archived
+	}
+	b := tx.Bucket(smsBucket)
+	id, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	r.ID = id
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return b.Put(itob(id), data)
+}
+
+// incomingKey 由调制解调器、SIM 卡、发件人、短信中心时间戳和内容确定一条收到的短信
+func incomingKey(r *SmsRecord) []byte {
+	h := sha256.New()
+	for _, field := range []string{r.Modem, r.SIM, r.Number, r.Timestamp.UTC().Format(time.RFC3339), r.Text} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return h.Sum(nil)
+}
+
+// GetSms 按 ID 读取一条归档短信
+func (s *Store) GetSms(id uint64) (*SmsRecord, error) {
+	var r SmsRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(smsBucket).Get(itob(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// UpdateSms 修改一条归档短信, 如更新其投递状态
+func (s *Store) UpdateSms(id uint64, update func(r *SmsRecord)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(smsBucket)
+		data := b.Get(itob(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		var r SmsRecord
+		if err := json.Unmarshal(data, &r); err != nil {
+			return err
+		}
+		update(&r)
+		r.ID = id
+		data, err := json.Marshal(&r)
+		if err != nil {
+			return err
+		}
+		return b.Put(itob(id), data)
+	})
+}
+
+// QuerySms 按从新到旧的顺序返回符合条件的短信, 以及符合条件的总数
+func (s *Store) QuerySms(q SmsQuery) ([]SmsRecord, int, error) {
+	var (
+		records []SmsRecord
+		total   int
+	)
+	numberDigits := digits(q.Number)
+	text := strings.ToLower(q.Text)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(smsBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var r SmsRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("记录 %x 已损坏: %w", k, err)
+			}
+			if !q.Since.IsZero() && r.Timestamp.Before(q.Since) {
+				continue
+			}
+			if q.Number != "" && !matchNumber(r.Number, q.Number, numberDigits) {
+				continue
+			}
+			if text != "" && !strings.Contains(strings.ToLower(r.Text), text) {
+				continue
+			}
+			total++
+			if total <= q.Offset || (q.Limit > 0 && len(records) >= q.Limit) {
+				continue
+			}
+			records = append(records, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return records, total, nil
+}
+
+// matchNumber 判断号码是否包含查询的号码; 查询中有数字时只比较数字,
+// 使 "+86 138-0013-8000" 与 "13800138000" 能够匹配, 否则按字母数字发件人比较
+func matchNumber(number, query, queryDigits string) bool {
+	if queryDigits != "" {
+		return strings.Contains(digits(number), queryDigits)
+	}
+	return strings.Contains(strings.ToLower(number), strings.ToLower(query))
+}
+
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// Identify 返回引擎当前调制解调器的 ID 和 SIM 卡的 ICCID, 不支持或获取失败时为空
+func Identify(eng engine.Engine) (modemID, simID string) {
+	id, ok := eng.(engine.Identifier)
+	if !ok {
+		return "", ""
+	}
+	modemID, simID, err := id.Identity()
+	if err != nil {
+		log.Printf("无法获取调制解调器标识: %v", err)
+	}
+	return modemID, simID
+}
+
+// ArchiveSent 归档一条通过 eng 发出的短信, sendErr 为发送结果。
+// 未启用持久化时不做任何事, 返回 nil。
+func ArchiveSent(eng engine.Engine, number, text string, sendErr error) *SmsRecord {
+	s := Default()
+	if s == nil {
+		return nil
+	}
+	r := &SmsRecord{
+		Direction: Outgoing,
+		Number:    number,
+		Text:      text,
+		State:     StateSent,
+	}
+	r.Modem, r.SIM = Identify(eng)
+	if sendErr != nil {
+		r.State = StateFailed
+		r.Error = sendErr.Error()
+	}
+	if err := s.AddSms(r); err != nil {
+		log.Printf("归档发出的短信失败: %v", err)
+		return nil
+	}
+	return r
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestAddIncomingSmsDedupes(t *testing.T) {
+	s := openTestStore(t)
+	ts := time.Date(2024, 5, 1, 12, 30, 0, 0, time.FixedZone("", 8*3600))
+	record := func() *SmsRecord {
+		return &SmsRecord{Direction: Incoming, Number: "+8613800138000", Text: "hello", Timestamp: ts, Modem: "350000000000001", State: StateReceived}
+	}
+
+	id, added, err := s.AddIncomingSms(record())
+	if err != nil || !added {
+		t.Fatalf("first AddIncomingSms = %d, %v, %v", id, added, err)
+	}
+	again, added, err := s.AddIncomingSms(record())
+	if err != nil || added || again != id {
+		t.Errorf("repeated AddIncomingSms = %d, %v, %v, want %d, false", again, added, err, id)
+	}
+
+	other := record()
+	other.Timestamp = ts.Add(time.Minute)
+	if _, added, err := s.AddIncomingSms(other); err != nil || !added {
+		t.Errorf("AddIncomingSms with another timestamp = %v, %v, want it added", added, err)
+	}
+}
+
+func TestAddIncomingSmsWithoutTimestamp(t *testing.T) {
+	s := openTestStore(t)
+	record := func() *SmsRecord {
+		return &SmsRecord{Direction: Incoming, Number: "10086", Text: "余额不足", State: StateReceived}
+	}
+
+	first, added, err := s.AddIncomingSms(record())
+	if err != nil || !added {
+		t.Fatalf("first AddIncomingSms = %d, %v, %v", first, added, err)
+	}
+	second, added, err := s.AddIncomingSms(record())
+	if err != nil || !added || second == first {
+		t.Errorf("second AddIncomingSms = %d, %v, %v, want a new record", second, added, err)
+	}
+
+	r, err := s.GetSms(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Timestamp.IsZero() {
+		t.Error("archived record has no timestamp, want the archive time")
+	}
+}
@@ -0,0 +1,71 @@
+// Package storage 使用嵌入式数据库 (bbolt) 在本地持久化机器人的数据
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store 为一个打开的数据库文件
+type Store struct {
+	db *bolt.DB
+}
+
+var (
+	defaultStore *Store
+	defaultMu    sync.RWMutex
+)
+
+// Open 打开 (不存在时创建) 数据库文件并初始化所有 bucket
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("无法打开数据库 %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("无法初始化数据库: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close 关闭数据库
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// SetDefault 设置命令和自动化任务共用的数据库
+func SetDefault(s *Store) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultStore = s
+}
+
+// Default 返回共用的数据库, 未启用持久化时为 nil
+func Default() *Store {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultStore
+}
+
+// buckets 为数据库中的所有 bucket
+var buckets = [][]byte{smsBucket, smsIndexBucket, outboxBucket, messageBucket, scheduleBucket, ruleBucket, groupBucket, contactBucket, readBucket}
+
+// itob 将自增 ID 编码为大端序的 key, 使遍历顺序与插入顺序一致
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}